@@ -15,7 +15,9 @@ type Process struct {
 func GetProcess(pid int) (process *Process, err error) {
 	var processes []os_utils.Process
 	var pidIndexMap map[int]int
-	if processes, pidIndexMap, err = os_utils.GetProcesses("/", false); err != nil {
+	// 子プロセスを辿るためのPPidと、Cmdsのみが必要なのでschedstatやioは読まない
+	option := os_utils.ProcessOption{Fields: os_utils.ProcessFieldCmdline | os_utils.ProcessFieldStatus}
+	if processes, pidIndexMap, err = os_utils.GetProcessesWithOption("/", &option); err != nil {
 		return
 	}
 
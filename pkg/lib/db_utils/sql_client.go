@@ -10,8 +10,8 @@ import (
 )
 
 type Config struct {
-	Driver     string
-	Connection string
+	Driver     string `json:",omitempty"`
+	Connection string `json:",omitempty"`
 }
 
 var conf = Config{
@@ -25,9 +25,11 @@ type SqlClient struct {
 }
 
 func NewSqlClient(conf2 *Config) (client *SqlClient) {
-	struct_utils.MergeStruct(conf, conf2)
+	// デフォルト値を共有しないように、コピーに指定された値をマージする
+	mergedConf := conf
+	struct_utils.MergeStruct(&mergedConf, conf2)
 	client = &SqlClient{
-		conf: conf,
+		conf: mergedConf,
 	}
 	return
 }
@@ -0,0 +1,245 @@
+package dhcp_utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// DHCPv4のメッセージ(RFC 2131)
+// BOOTPの固定長のヘッダに、マジッククッキーとオプションが続く
+
+const (
+	OpRequest = 1
+	OpReply   = 2
+
+	htypeEthernet = 1
+	hlenEthernet  = 6
+
+	headerLen     = 236
+	minPacketLen  = 300 // BOOTPの最小長、短いと受け付けないクライアントがある
+	flagBroadcast = 0x8000
+)
+
+var magicCookie = []byte{99, 130, 83, 99}
+
+// MessageType は、オプション53の値
+type MessageType uint8
+
+const (
+	MessageTypeDiscover MessageType = 1
+	MessageTypeOffer    MessageType = 2
+	MessageTypeRequest  MessageType = 3
+	MessageTypeDecline  MessageType = 4
+	MessageTypeAck      MessageType = 5
+	MessageTypeNak      MessageType = 6
+	MessageTypeRelease  MessageType = 7
+	MessageTypeInform   MessageType = 8
+)
+
+func (self MessageType) String() string {
+	switch self {
+	case MessageTypeDiscover:
+		return "DHCPDISCOVER"
+	case MessageTypeOffer:
+		return "DHCPOFFER"
+	case MessageTypeRequest:
+		return "DHCPREQUEST"
+	case MessageTypeDecline:
+		return "DHCPDECLINE"
+	case MessageTypeAck:
+		return "DHCPACK"
+	case MessageTypeNak:
+		return "DHCPNAK"
+	case MessageTypeRelease:
+		return "DHCPRELEASE"
+	case MessageTypeInform:
+		return "DHCPINFORM"
+	}
+	return fmt.Sprintf("Unknown(%d)", uint8(self))
+}
+
+// オプションのコード(RFC 2132)
+const (
+	OptionPad              = 0
+	OptionSubnetMask       = 1
+	OptionRouter           = 3
+	OptionDomainNameServer = 6
+	OptionHostName         = 12
+	OptionDomainName       = 15
+	OptionInterfaceMtu     = 26
+	OptionBroadcastAddress = 28
+	OptionRequestedIp      = 50
+	OptionLeaseTime        = 51
+	OptionMessageType      = 53
+	OptionServerId         = 54
+	OptionParameterList    = 55
+	OptionRenewalTime      = 58
+	OptionRebindingTime    = 59
+	OptionClientId         = 61
+	OptionEnd              = 255
+)
+
+type Packet struct {
+	Op     uint8
+	Hops   uint8
+	Xid    uint32
+	Secs   uint16
+	Flags  uint16
+	Ciaddr net.IP // クライアントが既に利用しているIP(更新時)
+	Yiaddr net.IP // サーバが割り当てるIP
+	Siaddr net.IP
+	Giaddr net.IP // リレーエージェントのIP
+	Chaddr net.HardwareAddr
+	// Optionsは、コードごとの値(同じコードが複数ある場合は連結する)
+	Options map[uint8][]byte
+}
+
+// ParsePacket は、DHCPのメッセージを解析する
+func ParsePacket(buf []byte) (packet *Packet, err error) {
+	if len(buf) < headerLen+len(magicCookie) {
+		err = fmt.Errorf("Invalid packet: len=%d", len(buf))
+		return
+	}
+	if string(buf[headerLen:headerLen+len(magicCookie)]) != string(magicCookie) {
+		err = fmt.Errorf("Invalid packet: magic cookie is not found")
+		return
+	}
+	hlen := int(buf[2])
+	if buf[1] != htypeEthernet || hlen != hlenEthernet {
+		err = fmt.Errorf("Invalid packet: htype=%d, hlen=%d", buf[1], hlen)
+		return
+	}
+
+	packet = &Packet{
+		Op:      buf[0],
+		Hops:    buf[3],
+		Xid:     binary.BigEndian.Uint32(buf[4:8]),
+		Secs:    binary.BigEndian.Uint16(buf[8:10]),
+		Flags:   binary.BigEndian.Uint16(buf[10:12]),
+		Ciaddr:  copyIp(buf[12:16]),
+		Yiaddr:  copyIp(buf[16:20]),
+		Siaddr:  copyIp(buf[20:24]),
+		Giaddr:  copyIp(buf[24:28]),
+		Chaddr:  net.HardwareAddr(append([]byte{}, buf[28:28+hlen]...)),
+		Options: map[uint8][]byte{},
+	}
+
+	options := buf[headerLen+len(magicCookie):]
+	for i := 0; i < len(options); {
+		code := options[i]
+		if code == OptionEnd {
+			break
+		}
+		if code == OptionPad {
+			i++
+			continue
+		}
+		if i+1 >= len(options) || i+2+int(options[i+1]) > len(options) {
+			err = fmt.Errorf("Invalid packet: option is truncated: code=%d", code)
+			packet = nil
+			return
+		}
+		l := int(options[i+1])
+		packet.Options[code] = append(packet.Options[code], options[i+2:i+2+l]...)
+		i += 2 + l
+	}
+	return
+}
+
+// Marshal は、DHCPのメッセージを作成する
+// オプションはコードの昇順とし、メッセージタイプのみ先頭に置く
+func (self *Packet) Marshal() []byte {
+	buf := make([]byte, headerLen, minPacketLen)
+	buf[0] = self.Op
+	buf[1] = htypeEthernet
+	buf[2] = hlenEthernet
+	buf[3] = self.Hops
+	binary.BigEndian.PutUint32(buf[4:8], self.Xid)
+	binary.BigEndian.PutUint16(buf[8:10], self.Secs)
+	binary.BigEndian.PutUint16(buf[10:12], self.Flags)
+	putIp(buf[12:16], self.Ciaddr)
+	putIp(buf[16:20], self.Yiaddr)
+	putIp(buf[20:24], self.Siaddr)
+	putIp(buf[24:28], self.Giaddr)
+	copy(buf[28:44], self.Chaddr)
+
+	buf = append(buf, magicCookie...)
+	if value, ok := self.Options[OptionMessageType]; ok {
+		buf = appendOption(buf, OptionMessageType, value)
+	}
+	for code := 1; code < OptionEnd; code++ {
+		if code == OptionMessageType {
+			continue
+		}
+		if value, ok := self.Options[uint8(code)]; ok {
+			buf = appendOption(buf, uint8(code), value)
+		}
+	}
+	buf = append(buf, OptionEnd)
+	for len(buf) < minPacketLen {
+		buf = append(buf, OptionPad)
+	}
+	return buf
+}
+
+// appendOption は、255バイトを超える値を同じコードの複数のオプションに分割する(RFC 3396)
+func appendOption(buf []byte, code uint8, value []byte) []byte {
+	for {
+		l := len(value)
+		if l > 255 {
+			l = 255
+		}
+		buf = append(buf, code, uint8(l))
+		buf = append(buf, value[:l]...)
+		value = value[l:]
+		if len(value) == 0 {
+			return buf
+		}
+	}
+}
+
+func (self *Packet) MessageType() MessageType {
+	if value := self.Options[OptionMessageType]; len(value) == 1 {
+		return MessageType(value[0])
+	}
+	return 0
+}
+
+// OptionIp は、IPのオプションの値を返す、存在しない場合はnilを返す
+func (self *Packet) OptionIp(code uint8) net.IP {
+	if value := self.Options[code]; len(value) == net.IPv4len {
+		return copyIp(value)
+	}
+	return nil
+}
+
+func (self *Packet) SetOptionIps(code uint8, ips ...net.IP) {
+	value := []byte{}
+	for _, ip := range ips {
+		value = append(value, ip.To4()...)
+	}
+	self.Options[code] = value
+}
+
+func (self *Packet) SetOptionUint32(code uint8, v uint32) {
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, v)
+	self.Options[code] = value
+}
+
+func (self *Packet) SetOptionUint16(code uint8, v uint16) {
+	value := make([]byte, 2)
+	binary.BigEndian.PutUint16(value, v)
+	self.Options[code] = value
+}
+
+func copyIp(buf []byte) net.IP {
+	return net.IPv4(buf[0], buf[1], buf[2], buf[3]).To4()
+}
+
+func putIp(buf []byte, ip net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		copy(buf, ip4)
+	}
+}
@@ -0,0 +1,193 @@
+package dhcp_utils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/syunkitada/goapp2/pkg/lib/logger"
+)
+
+const (
+	ServerPort = 67
+	ClientPort = 68
+
+	DefaultLeaseTime = 24 * time.Hour
+)
+
+// Lease は、MACアドレスに割り当てるIPとネットワークの設定
+type Lease struct {
+	Ip        net.IP
+	Mask      net.IPMask
+	Router    net.IP
+	Dns       []net.IP
+	Mtu       int // 0の場合は渡さない
+	Hostname  string
+	LeaseTime time.Duration // 0の場合はDefaultLeaseTime
+}
+
+// LeaseFunc は、MACアドレスのLeaseを返す、割り当てがない場合はnilを返す
+// サーバは割り当てを持たず、問い合わせのたびに呼び出す
+type LeaseFunc func(mac net.HardwareAddr) (lease *Lease, err error)
+
+// Server は、LeaseFuncが返すMACアドレスにのみ応答するDHCPサーバ
+// 割り当ては静的なので、DISCOVERとREQUESTのどちらにも同じIPを返す
+type Server struct {
+	ServerIp  net.IP // Server Identifierとして返す、通常はゲートウェイのIP
+	LeaseFunc LeaseFunc
+}
+
+// ListenInterface は、ifnameのデバイスでDHCPのリクエストを受信するソケットを作成する
+// IPの割り当てのないクライアントはブロードキャストで送信するので、0.0.0.0にbindし、デバイスを限定する
+func ListenInterface(ifname string) (conn net.PacketConn, err error) {
+	listenConfig := net.ListenConfig{
+		Control: func(network string, address string, rawConn syscall.RawConn) (err error) {
+			if tmpErr := rawConn.Control(func(fd uintptr) {
+				if err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+					return
+				}
+				if err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1); err != nil {
+					return
+				}
+				err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, ifname)
+			}); tmpErr != nil {
+				return tmpErr
+			}
+			return
+		},
+	}
+	conn, err = listenConfig.ListenPacket(context.Background(), "udp4", "0.0.0.0:"+strconv.Itoa(ServerPort))
+	return
+}
+
+// Serve は、connで受信したリクエストに応答する、connを閉じると終了する
+func (self *Server) Serve(tctx *logger.TraceContext, conn net.PacketConn) (err error) {
+	buf := make([]byte, 1500)
+	for {
+		var n int
+		var addr net.Addr
+		if n, addr, err = conn.ReadFrom(buf); err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				err = nil
+			}
+			return
+		}
+		request, tmpErr := ParsePacket(buf[:n])
+		if tmpErr != nil {
+			logger.Warnf(tctx, "Failed parse dhcp packet: addr=%s, err=%s", addr.String(), tmpErr.Error())
+			continue
+		}
+		reply, tmpErr := self.Handle(request)
+		if tmpErr != nil {
+			logger.Warnf(tctx, "Failed handle dhcp packet: mac=%s, type=%s, err=%s",
+				request.Chaddr.String(), request.MessageType().String(), tmpErr.Error())
+			continue
+		}
+		if reply == nil {
+			continue
+		}
+		if _, tmpErr = conn.WriteTo(reply.Marshal(), replyAddr(addr)); tmpErr != nil {
+			logger.Warnf(tctx, "Failed send dhcp packet: mac=%s, err=%s", request.Chaddr.String(), tmpErr.Error())
+			continue
+		}
+		logger.Infof(tctx, "dhcp: mac=%s, request=%s, reply=%s, ip=%s",
+			request.Chaddr.String(), request.MessageType().String(), reply.MessageType().String(), reply.Yiaddr.String())
+	}
+}
+
+// replyAddr は、応答の送信先を返す
+// IPを持たないクライアント(0.0.0.0から送信)にはブロードキャストし、IPを持つクライアントには送信元に返す
+func replyAddr(addr net.Addr) net.Addr {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok && !udpAddr.IP.IsUnspecified() {
+		return udpAddr
+	}
+	return &net.UDPAddr{IP: net.IPv4bcast, Port: ClientPort}
+}
+
+// Handle は、リクエストに対する応答を返す、応答しない場合はnilを返す
+func (self *Server) Handle(request *Packet) (reply *Packet, err error) {
+	if request.Op != OpRequest {
+		return
+	}
+
+	var lease *Lease
+	if lease, err = self.LeaseFunc(request.Chaddr); err != nil || lease == nil {
+		return
+	}
+
+	switch request.MessageType() {
+	case MessageTypeDiscover:
+		reply = self.newReply(request, MessageTypeOffer, lease)
+	case MessageTypeRequest:
+		// 他のサーバを選択したクライアントには応答しない
+		if serverId := request.OptionIp(OptionServerId); serverId != nil && !serverId.Equal(self.ServerIp) {
+			return
+		}
+		requestedIp := request.OptionIp(OptionRequestedIp)
+		if requestedIp == nil {
+			requestedIp = request.Ciaddr
+		}
+		if requestedIp == nil || !requestedIp.Equal(lease.Ip) {
+			reply = self.newReply(request, MessageTypeNak, nil)
+			return
+		}
+		reply = self.newReply(request, MessageTypeAck, lease)
+	case MessageTypeInform:
+		// IPは設定済みなので、設定のみを返す
+		reply = self.newReply(request, MessageTypeAck, lease)
+		reply.Yiaddr = nil
+		delete(reply.Options, OptionLeaseTime)
+		delete(reply.Options, OptionRenewalTime)
+		delete(reply.Options, OptionRebindingTime)
+	}
+	return
+}
+
+func (self *Server) newReply(request *Packet, messageType MessageType, lease *Lease) (reply *Packet) {
+	reply = &Packet{
+		Op:      OpReply,
+		Xid:     request.Xid,
+		Flags:   request.Flags,
+		Giaddr:  request.Giaddr,
+		Chaddr:  request.Chaddr,
+		Options: map[uint8][]byte{},
+	}
+	reply.Options[OptionMessageType] = []byte{uint8(messageType)}
+	reply.SetOptionIps(OptionServerId, self.ServerIp)
+	if lease == nil {
+		return
+	}
+
+	reply.Yiaddr = lease.Ip
+	leaseTime := lease.LeaseTime
+	if leaseTime == 0 {
+		leaseTime = DefaultLeaseTime
+	}
+	reply.SetOptionUint32(OptionLeaseTime, uint32(leaseTime/time.Second))
+	reply.SetOptionUint32(OptionRenewalTime, uint32(leaseTime/2/time.Second))
+	reply.SetOptionUint32(OptionRebindingTime, uint32(leaseTime*7/8/time.Second))
+	if lease.Mask != nil {
+		reply.Options[OptionSubnetMask] = []byte(lease.Mask)
+		broadcast := make(net.IP, net.IPv4len)
+		for i, b := range lease.Ip.To4() {
+			broadcast[i] = b | ^lease.Mask[i]
+		}
+		reply.SetOptionIps(OptionBroadcastAddress, broadcast)
+	}
+	if lease.Router != nil {
+		reply.SetOptionIps(OptionRouter, lease.Router)
+	}
+	if len(lease.Dns) > 0 {
+		reply.SetOptionIps(OptionDomainNameServer, lease.Dns...)
+	}
+	if lease.Mtu > 0 {
+		reply.SetOptionUint16(OptionInterfaceMtu, uint16(lease.Mtu))
+	}
+	if lease.Hostname != "" {
+		reply.Options[OptionHostName] = []byte(lease.Hostname)
+	}
+	return
+}
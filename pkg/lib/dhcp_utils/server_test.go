@@ -0,0 +1,181 @@
+package dhcp_utils
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/syunkitada/goapp2/pkg/lib/logger"
+)
+
+func TestServer(t *testing.T) {
+	a := assert.New(t)
+	logger.Init(&logger.Config{})
+	tctx := logger.NewTraceContext()
+
+	knownMac, _ := net.ParseMAC("52:54:00:00:00:01")
+	unknownMac, _ := net.ParseMAC("52:54:00:00:00:02")
+	server := &Server{
+		ServerIp: net.ParseIP("192.168.100.1"),
+		LeaseFunc: func(mac net.HardwareAddr) (lease *Lease, err error) {
+			if mac.String() != knownMac.String() {
+				return
+			}
+			lease = &Lease{
+				Ip:       net.ParseIP("192.168.100.2"),
+				Mask:     net.CIDRMask(24, 32),
+				Router:   net.ParseIP("192.168.100.1"),
+				Dns:      []net.IP{net.ParseIP("192.168.10.1"), net.ParseIP("192.168.10.2")},
+				Mtu:      1450,
+				Hostname: "vm1",
+			}
+			return
+		},
+	}
+
+	serverConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	a.NoError(err)
+	doneCh := make(chan error, 1)
+	go func() {
+		doneCh <- server.Serve(tctx, serverConn)
+	}()
+
+	clientConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	a.NoError(err)
+	defer clientConn.Close()
+
+	// exchange は、リクエストを送信して応答を返す、応答がない場合はnilを返す
+	exchange := func(request *Packet) *Packet {
+		_, err := clientConn.WriteTo(request.Marshal(), serverConn.LocalAddr())
+		a.NoError(err)
+		a.NoError(clientConn.SetReadDeadline(time.Now().Add(300 * time.Millisecond)))
+		buf := make([]byte, 1500)
+		n, _, err := clientConn.ReadFrom(buf)
+		if err != nil {
+			return nil
+		}
+		a.GreaterOrEqual(n, minPacketLen)
+		reply, err := ParsePacket(buf[:n])
+		a.NoError(err)
+		return reply
+	}
+	newRequest := func(mac net.HardwareAddr, messageType MessageType) *Packet {
+		request := &Packet{
+			Op:      OpRequest,
+			Xid:     0x12345678,
+			Chaddr:  mac,
+			Options: map[uint8][]byte{OptionMessageType: {uint8(messageType)}},
+		}
+		return request
+	}
+
+	// DISCOVERには、割り当てたIPとネットワークの設定を返す
+	offer := exchange(newRequest(knownMac, MessageTypeDiscover))
+	a.NotNil(offer)
+	a.Equal(uint8(OpReply), offer.Op)
+	a.Equal(MessageTypeOffer, offer.MessageType())
+	a.Equal(uint32(0x12345678), offer.Xid)
+	a.Equal(knownMac, offer.Chaddr)
+	a.Equal("192.168.100.2", offer.Yiaddr.String())
+	a.Equal("192.168.100.1", offer.OptionIp(OptionServerId).String())
+	a.Equal("192.168.100.1", offer.OptionIp(OptionRouter).String())
+	a.Equal("255.255.255.0", offer.OptionIp(OptionSubnetMask).String())
+	a.Equal("192.168.100.255", offer.OptionIp(OptionBroadcastAddress).String())
+	a.Equal([]byte{192, 168, 10, 1, 192, 168, 10, 2}, offer.Options[OptionDomainNameServer])
+	a.Equal([]byte{0x05, 0xaa}, offer.Options[OptionInterfaceMtu])
+	a.Equal([]byte("vm1"), offer.Options[OptionHostName])
+	a.Equal([]byte{0, 1, 0x51, 0x80}, offer.Options[OptionLeaseTime])
+
+	// REQUEST
+	request := newRequest(knownMac, MessageTypeRequest)
+	request.SetOptionIps(OptionRequestedIp, net.ParseIP("192.168.100.2"))
+	request.SetOptionIps(OptionServerId, net.ParseIP("192.168.100.1"))
+	ack := exchange(request)
+	a.NotNil(ack)
+	a.Equal(MessageTypeAck, ack.MessageType())
+	a.Equal("192.168.100.2", ack.Yiaddr.String())
+
+	// 更新時は、ciaddrで要求する
+	request = newRequest(knownMac, MessageTypeRequest)
+	request.Ciaddr = net.ParseIP("192.168.100.2")
+	ack = exchange(request)
+	a.NotNil(ack)
+	a.Equal(MessageTypeAck, ack.MessageType())
+
+	{
+		// 割り当てと異なるIPの要求には、NAKを返す
+		request := newRequest(knownMac, MessageTypeRequest)
+		request.SetOptionIps(OptionRequestedIp, net.ParseIP("192.168.100.3"))
+		nak := exchange(request)
+		a.NotNil(nak)
+		a.Equal(MessageTypeNak, nak.MessageType())
+		a.Nil(nak.OptionIp(OptionRouter))
+	}
+
+	{
+		// 他のサーバへのREQUESTには応答しない
+		request := newRequest(knownMac, MessageTypeRequest)
+		request.SetOptionIps(OptionRequestedIp, net.ParseIP("192.168.100.2"))
+		request.SetOptionIps(OptionServerId, net.ParseIP("192.168.100.254"))
+		a.Nil(exchange(request))
+	}
+
+	{
+		// 割り当てのないMACアドレスには応答しない
+		a.Nil(exchange(newRequest(unknownMac, MessageTypeDiscover)))
+	}
+
+	{
+		// 不正なパケットは無視して、次のリクエストを処理する
+		_, err := clientConn.WriteTo([]byte("invalid"), serverConn.LocalAddr())
+		a.NoError(err)
+		a.NotNil(exchange(newRequest(knownMac, MessageTypeDiscover)))
+	}
+
+	// INFORMには、IPとリース時間を除いた設定を返す
+	inform := exchange(newRequest(knownMac, MessageTypeInform))
+	a.NotNil(inform)
+	a.Equal(MessageTypeAck, inform.MessageType())
+	a.Equal("0.0.0.0", inform.Yiaddr.String())
+	a.Nil(inform.Options[OptionLeaseTime])
+	a.NotNil(inform.Options[OptionDomainNameServer])
+
+	a.NoError(serverConn.Close())
+	a.NoError(<-doneCh)
+}
+
+func TestParsePacket(t *testing.T) {
+	a := assert.New(t)
+	mac, _ := net.ParseMAC("52:54:00:00:00:01")
+	packet := &Packet{
+		Op:      OpRequest,
+		Xid:     1,
+		Chaddr:  mac,
+		Options: map[uint8][]byte{OptionMessageType: {uint8(MessageTypeDiscover)}},
+	}
+	// 255バイトを超える値は分割され、解析時に連結される
+	longValue := make([]byte, 300)
+	for i := range longValue {
+		longValue[i] = byte(i)
+	}
+	packet.Options[OptionHostName] = longValue
+	buf := packet.Marshal()
+	parsed, err := ParsePacket(buf)
+	a.NoError(err)
+	a.Equal(longValue, parsed.Options[OptionHostName])
+	a.Equal(MessageTypeDiscover, parsed.MessageType())
+
+	{
+		// 短いパケット
+		_, err := ParsePacket(buf[:100])
+		a.Error(err)
+	}
+	{
+		// オプションが途中で切れている
+		truncated := append([]byte{}, buf[:headerLen+len(magicCookie)]...)
+		truncated = append(truncated, OptionHostName, 10, 'a')
+		_, err := ParsePacket(truncated)
+		a.Error(err)
+	}
+}
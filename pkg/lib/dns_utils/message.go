@@ -0,0 +1,178 @@
+package dns_utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// DNSのメッセージ(RFC 1035)のうち、問い合わせの解析とAレコードの応答の作成のみを扱う
+
+const (
+	headerLen = 12
+
+	TypeA    = 1
+	TypeAAAA = 28
+	ClassIN  = 1
+
+	flagQR       = 1 << 15
+	flagAA       = 1 << 10
+	flagTC       = 1 << 9
+	flagRD       = 1 << 8
+	flagRA       = 1 << 7
+	opcodeMask   = 0xf << 11
+	opcodeQuery  = 0
+	rcodeMask    = 0xf
+	namePointer  = 0xc0
+	maxLabelLen  = 63
+	maxNameLen   = 255
+	maxUdpLen    = 512
+	questionName = 0xc00c // 応答のレコード名は、ヘッダ直後の質問の名前を参照する
+)
+
+// Rcode は、応答のステータス
+type Rcode uint16
+
+const (
+	RcodeSuccess        Rcode = 0
+	RcodeFormatError    Rcode = 1
+	RcodeServerFailure  Rcode = 2
+	RcodeNameError      Rcode = 3 // NXDOMAIN
+	RcodeNotImplemented Rcode = 4
+	RcodeRefused        Rcode = 5
+)
+
+// Query は、問い合わせのヘッダと最初の質問
+type Query struct {
+	Id     uint16
+	Flags  uint16
+	Name   string // 小文字で末尾の.を除いたもの
+	Type   uint16
+	Class  uint16
+	Opcode uint16
+	// question は、応答にそのまま含める質問部分
+	question []byte
+}
+
+// ParseQuery は、問い合わせを解析する
+// 質問が一つでない場合は、エラーとする
+func ParseQuery(buf []byte) (query *Query, err error) {
+	if len(buf) < headerLen {
+		err = fmt.Errorf("Invalid query: len=%d", len(buf))
+		return
+	}
+	flags := binary.BigEndian.Uint16(buf[2:4])
+	if flags&flagQR != 0 {
+		err = fmt.Errorf("Invalid query: message is response")
+		return
+	}
+	query = &Query{
+		Id:     binary.BigEndian.Uint16(buf[0:2]),
+		Flags:  flags,
+		Opcode: (flags & opcodeMask) >> 11,
+	}
+	if qdcount := binary.BigEndian.Uint16(buf[4:6]); qdcount != 1 {
+		err = fmt.Errorf("Invalid query: qdcount=%d", qdcount)
+		return
+	}
+
+	labels := []string{}
+	offset := headerLen
+	nameLen := 0
+	for {
+		if offset >= len(buf) {
+			err = fmt.Errorf("Invalid query: name is truncated")
+			return
+		}
+		l := int(buf[offset])
+		offset++
+		if l == 0 {
+			break
+		}
+		// 問い合わせの質問では、圧縮は使われない
+		if l&namePointer != 0 || l > maxLabelLen {
+			err = fmt.Errorf("Invalid query: label=%d", l)
+			return
+		}
+		if offset+l > len(buf) {
+			err = fmt.Errorf("Invalid query: name is truncated")
+			return
+		}
+		nameLen += l + 1
+		if nameLen > maxNameLen {
+			err = fmt.Errorf("Invalid query: name is too long")
+			return
+		}
+		labels = append(labels, string(buf[offset:offset+l]))
+		offset += l
+	}
+	if offset+4 > len(buf) {
+		err = fmt.Errorf("Invalid query: question is truncated")
+		return
+	}
+	query.Name = strings.ToLower(strings.Join(labels, "."))
+	query.Type = binary.BigEndian.Uint16(buf[offset : offset+2])
+	query.Class = binary.BigEndian.Uint16(buf[offset+2 : offset+4])
+	query.question = append([]byte{}, buf[headerLen:offset+4]...)
+	return
+}
+
+// NewResponse は、問い合わせに対する応答を作成する
+// ipsは、Aレコードとして応答する(IPv4以外は除く)
+func (self *Query) NewResponse(rcode Rcode, authoritative bool, ips []net.IP, ttl time.Duration) []byte {
+	answers := []net.IP{}
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			answers = append(answers, ip4)
+		}
+	}
+
+	flags := uint16(flagQR|flagRA) | self.Flags&(opcodeMask|flagRD) | uint16(rcode)&rcodeMask
+	if authoritative {
+		flags |= flagAA
+	}
+	buf := make([]byte, headerLen, headerLen+len(self.question)+len(answers)*16)
+	binary.BigEndian.PutUint16(buf[0:2], self.Id)
+	binary.BigEndian.PutUint16(buf[4:6], 1)
+	buf = append(buf, self.question...)
+
+	// UDPで送信できる長さに収まらない場合は、切り詰めてTCを立てる
+	ancount := 0
+	for _, ip := range answers {
+		if len(buf)+16 > maxUdpLen {
+			flags |= flagTC
+			break
+		}
+		record := make([]byte, 16)
+		binary.BigEndian.PutUint16(record[0:2], questionName)
+		binary.BigEndian.PutUint16(record[2:4], TypeA)
+		binary.BigEndian.PutUint16(record[4:6], ClassIN)
+		binary.BigEndian.PutUint32(record[6:10], uint32(ttl/time.Second))
+		binary.BigEndian.PutUint16(record[10:12], net.IPv4len)
+		copy(record[12:16], ip)
+		buf = append(buf, record...)
+		ancount++
+	}
+	binary.BigEndian.PutUint16(buf[2:4], flags)
+	binary.BigEndian.PutUint16(buf[6:8], uint16(ancount))
+	return buf
+}
+
+// isResponse は、メッセージが応答(QRが立っている)かを返す
+func isResponse(buf []byte) bool {
+	return len(buf) >= headerLen && binary.BigEndian.Uint16(buf[2:4])&flagQR != 0
+}
+
+// NewErrorResponse は、質問を解析できなかった問い合わせに対する、質問を含まない応答を作成する
+func NewErrorResponse(buf []byte, rcode Rcode) []byte {
+	if len(buf) < headerLen {
+		return nil
+	}
+	res := make([]byte, headerLen)
+	copy(res[0:2], buf[0:2])
+	flags := uint16(flagQR|flagRA) | binary.BigEndian.Uint16(buf[2:4])&(opcodeMask|flagRD) | uint16(rcode)&rcodeMask
+	binary.BigEndian.PutUint16(res[2:4], flags)
+	return res
+}
@@ -0,0 +1,184 @@
+package dns_utils
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syunkitada/goapp2/pkg/lib/logger"
+)
+
+const (
+	Port = 53
+
+	DefaultTtl            = 30 * time.Second
+	DefaultForwardTimeout = 2 * time.Second
+)
+
+// Zone は、サーバが応答するSuffix配下のレコード
+// 複数のServerで共有し、SetRecordsでレコードを入れ替える
+type Zone struct {
+	Suffix  string
+	Ttl     time.Duration
+	mutex   sync.RWMutex
+	records map[string][]net.IP
+}
+
+func NewZone(suffix string, ttl time.Duration) *Zone {
+	if ttl == 0 {
+		ttl = DefaultTtl
+	}
+	return &Zone{
+		Suffix:  strings.ToLower(strings.Trim(suffix, ".")),
+		Ttl:     ttl,
+		records: map[string][]net.IP{},
+	}
+}
+
+// SetRecords は、レコードを入れ替える、キーは末尾の.を除いた名前
+// 変更があった場合は、changed=trueを返す
+func (self *Zone) SetRecords(records map[string][]net.IP) (changed bool) {
+	normalized := map[string][]net.IP{}
+	for name, ips := range records {
+		normalized[strings.ToLower(strings.Trim(name, "."))] = ips
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if reflect.DeepEqual(self.records, normalized) {
+		return
+	}
+	self.records = normalized
+	changed = true
+	return
+}
+
+// Lookup は、nameのIPを返す
+// nameがSuffix配下でない場合は、inZone=falseを返す
+func (self *Zone) Lookup(name string) (ips []net.IP, inZone bool) {
+	name = strings.ToLower(strings.Trim(name, "."))
+	if name != self.Suffix && !strings.HasSuffix(name, "."+self.Suffix) {
+		return
+	}
+	inZone = true
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	ips = self.records[name]
+	return
+}
+
+// Server は、Zoneの名前に応答し、それ以外の問い合わせをForwardersに転送するDNSサーバ
+// Forwardersなどは、Serveの開始後に変更してはならない(ハンドラのgoroutineがロックなしで参照する)
+type Server struct {
+	Zone           *Zone
+	Forwarders     []string // ip、またはip:port
+	ForwardTimeout time.Duration
+}
+
+// Serve は、connで受信した問い合わせに応答する、connを閉じると終了する
+// 転送は時間がかかるので、問い合わせごとにgoroutineで処理する
+func (self *Server) Serve(tctx *logger.TraceContext, conn net.PacketConn) (err error) {
+	for {
+		buf := make([]byte, maxUdpLen*8)
+		var n int
+		var addr net.Addr
+		if n, addr, err = conn.ReadFrom(buf); err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				err = nil
+			}
+			return
+		}
+		go func(req []byte, addr net.Addr) {
+			res := self.Handle(tctx, req)
+			if res == nil {
+				return
+			}
+			if _, tmpErr := conn.WriteTo(res, addr); tmpErr != nil {
+				logger.Warnf(tctx, "Failed send dns response: addr=%s, err=%s", addr.String(), tmpErr.Error())
+			}
+		}(buf[:n], addr)
+	}
+}
+
+// Handle は、問い合わせに対する応答を返す、応答しない場合はnilを返す
+func (self *Server) Handle(tctx *logger.TraceContext, req []byte) (res []byte) {
+	// 応答(QRが立っているもの)には応答しない(RFC 1035)
+	// エラーを返すと、サーバ同士や偽装されたパケットで応答を送り合い続けることになる
+	if isResponse(req) {
+		return nil
+	}
+	query, err := ParseQuery(req)
+	if err != nil {
+		return NewErrorResponse(req, RcodeFormatError)
+	}
+	if query.Opcode != opcodeQuery {
+		return query.NewResponse(RcodeNotImplemented, false, nil, 0)
+	}
+
+	if self.Zone != nil && query.Class == ClassIN {
+		if ips, inZone := self.Zone.Lookup(query.Name); inZone {
+			if len(ips) == 0 {
+				return query.NewResponse(RcodeNameError, true, nil, 0)
+			}
+			// 名前は存在するので、A以外の問い合わせには空の応答を返す
+			if query.Type != TypeA {
+				return query.NewResponse(RcodeSuccess, true, nil, 0)
+			}
+			return query.NewResponse(RcodeSuccess, true, ips, self.Zone.Ttl)
+		}
+	}
+
+	if res = self.forward(tctx, req); res == nil {
+		res = query.NewResponse(RcodeServerFailure, false, nil, 0)
+	}
+	return
+}
+
+// forward は、問い合わせをそのまま転送し、最初に得られた応答を返す
+func (self *Server) forward(tctx *logger.TraceContext, req []byte) (res []byte) {
+	timeout := self.ForwardTimeout
+	if timeout == 0 {
+		timeout = DefaultForwardTimeout
+	}
+	for _, forwarder := range self.Forwarders {
+		addr := forwarder
+		if _, _, tmpErr := net.SplitHostPort(addr); tmpErr != nil {
+			addr = net.JoinHostPort(addr, "53")
+		}
+		tmpRes, tmpErr := exchange(addr, req, timeout)
+		if tmpErr != nil {
+			logger.Warnf(tctx, "Failed forward dns query: forwarder=%s, err=%s", addr, tmpErr.Error())
+			continue
+		}
+		return tmpRes
+	}
+	return
+}
+
+func exchange(addr string, req []byte, timeout time.Duration) (res []byte, err error) {
+	var conn net.Conn
+	if conn, err = net.DialTimeout("udp", addr, timeout); err != nil {
+		return
+	}
+	defer conn.Close()
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return
+	}
+	if _, err = conn.Write(req); err != nil {
+		return
+	}
+	buf := make([]byte, 65535)
+	// 異なるIDの応答(タイムアウトした以前の問い合わせなど)は読み捨てる
+	for {
+		var n int
+		if n, err = conn.Read(buf); err != nil {
+			return
+		}
+		if n >= 2 && buf[0] == req[0] && buf[1] == req[1] {
+			res = append([]byte{}, buf[:n]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package dns_utils
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/syunkitada/goapp2/pkg/lib/logger"
+)
+
+// newQuery は、nameのqtypeの問い合わせを作成する
+func newQuery(id uint16, name string, qtype uint16) []byte {
+	buf := make([]byte, headerLen)
+	binary.BigEndian.PutUint16(buf[0:2], id)
+	binary.BigEndian.PutUint16(buf[2:4], flagRD)
+	binary.BigEndian.PutUint16(buf[4:6], 1)
+	for _, label := range strings.Split(name, ".") {
+		buf = append(buf, byte(len(label)))
+		buf = append(buf, label...)
+	}
+	buf = append(buf, 0, byte(qtype>>8), byte(qtype), 0, ClassIN)
+	return buf
+}
+
+type response struct {
+	Id      uint16
+	Flags   uint16
+	Rcode   Rcode
+	Ips     []string
+	Ttls    []uint32
+	Ancount int
+}
+
+// parseResponse は、NewResponseで作成した応答を解析する
+func parseResponse(t *testing.T, buf []byte) (res response) {
+	a := assert.New(t)
+	a.GreaterOrEqual(len(buf), headerLen)
+	res.Id = binary.BigEndian.Uint16(buf[0:2])
+	res.Flags = binary.BigEndian.Uint16(buf[2:4])
+	res.Rcode = Rcode(res.Flags & rcodeMask)
+	res.Ancount = int(binary.BigEndian.Uint16(buf[6:8]))
+	offset := headerLen
+	if binary.BigEndian.Uint16(buf[4:6]) == 1 {
+		for buf[offset] != 0 {
+			offset += int(buf[offset]) + 1
+		}
+		offset += 5
+	}
+	for i := 0; i < res.Ancount; i++ {
+		a.Equal(uint16(questionName), binary.BigEndian.Uint16(buf[offset:]))
+		a.Equal(uint16(TypeA), binary.BigEndian.Uint16(buf[offset+2:]))
+		res.Ttls = append(res.Ttls, binary.BigEndian.Uint32(buf[offset+6:]))
+		res.Ips = append(res.Ips, net.IP(buf[offset+12:offset+16]).String())
+		offset += 16
+	}
+	return
+}
+
+// exchangeConn は、connで問い合わせを送信し、応答を解析する
+func exchangeConn(t *testing.T, conn net.Conn, req []byte) response {
+	a := assert.New(t)
+	_, err := conn.Write(req)
+	a.NoError(err)
+	a.NoError(conn.SetReadDeadline(time.Now().Add(3 * time.Second)))
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	a.NoError(err)
+	return parseResponse(t, buf[:n])
+}
+
+func TestServer(t *testing.T) {
+	a := assert.New(t)
+	logger.Init(&logger.Config{})
+	tctx := logger.NewTraceContext()
+
+	// 転送先のDNSサーバ、問い合わせのIDのまま固定のIPを返す
+	upstreamConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	a.NoError(err)
+	defer upstreamConn.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := upstreamConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			query, err := ParseQuery(buf[:n])
+			if err != nil {
+				continue
+			}
+			upstreamConn.WriteTo(query.NewResponse(RcodeSuccess, false, []net.IP{net.ParseIP("203.0.113.1")}, time.Minute), addr)
+		}
+	}()
+
+	zone := NewZone("vm.internal.", 10*time.Second)
+	a.True(zone.SetRecords(map[string][]net.IP{
+		"vm1.group1.vm.internal": {net.ParseIP("192.168.100.2"), net.ParseIP("192.168.200.2")},
+	}))
+	server := &Server{
+		Zone:       zone,
+		Forwarders: []string{"127.0.0.1:1", upstreamConn.LocalAddr().String()},
+		// 1番目の転送先は応答しないので、短くする
+		ForwardTimeout: 300 * time.Millisecond,
+	}
+	serverConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	a.NoError(err)
+	doneCh := make(chan error, 1)
+	go func() {
+		doneCh <- server.Serve(tctx, serverConn)
+	}()
+
+	clientConn, err := net.Dial("udp4", serverConn.LocalAddr().String())
+	a.NoError(err)
+	defer clientConn.Close()
+	exchange := func(req []byte) response {
+		return exchangeConn(t, clientConn, req)
+	}
+
+	// Zoneの名前は、大文字小文字を区別せずにVMの全てのIPを返す
+	res := exchange(newQuery(1, "VM1.group1.vm.internal", TypeA))
+	a.Equal(uint16(1), res.Id)
+	a.Equal(RcodeSuccess, res.Rcode)
+	a.NotZero(res.Flags & flagAA)
+	a.NotZero(res.Flags & flagRD)
+	a.Equal([]string{"192.168.100.2", "192.168.200.2"}, res.Ips)
+	a.Equal([]uint32{10, 10}, res.Ttls)
+
+	// 名前が存在する場合、A以外には空の応答を返す
+	res = exchange(newQuery(2, "vm1.group1.vm.internal", TypeAAAA))
+	a.Equal(RcodeSuccess, res.Rcode)
+	a.Equal(0, res.Ancount)
+
+	// Zone内に存在しない名前
+	res = exchange(newQuery(3, "vm2.group1.vm.internal", TypeA))
+	a.Equal(RcodeNameError, res.Rcode)
+	a.NotZero(res.Flags & flagAA)
+
+	// Zone外は転送する
+	res = exchange(newQuery(4, "example.com", TypeA))
+	a.Equal(uint16(4), res.Id)
+	a.Equal(RcodeSuccess, res.Rcode)
+	a.Zero(res.Flags & flagAA)
+	a.Equal([]string{"203.0.113.1"}, res.Ips)
+	a.Equal([]uint32{60}, res.Ttls)
+
+	// レコードを入れ替えると、すぐに反映される
+	a.False(zone.SetRecords(map[string][]net.IP{
+		"vm1.group1.vm.internal.": {net.ParseIP("192.168.100.2"), net.ParseIP("192.168.200.2")},
+	}))
+	a.True(zone.SetRecords(map[string][]net.IP{
+		"vm2.group1.vm.internal": {net.ParseIP("192.168.100.3")},
+	}))
+	res = exchange(newQuery(5, "vm2.group1.vm.internal", TypeA))
+	a.Equal([]string{"192.168.100.3"}, res.Ips)
+	res = exchange(newQuery(6, "vm1.group1.vm.internal", TypeA))
+	a.Equal(RcodeNameError, res.Rcode)
+
+	{
+		// 転送先が全て応答しない場合
+		// Serve中のServerのForwardersは変更できないので、別のServerを起動する
+		deadServer := &Server{
+			Zone:           zone,
+			Forwarders:     []string{"127.0.0.1:1"},
+			ForwardTimeout: 300 * time.Millisecond,
+		}
+		deadServerConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		a.NoError(err)
+		deadDoneCh := make(chan error, 1)
+		go func() {
+			deadDoneCh <- deadServer.Serve(tctx, deadServerConn)
+		}()
+		deadClientConn, err := net.Dial("udp4", deadServerConn.LocalAddr().String())
+		a.NoError(err)
+		defer deadClientConn.Close()
+		res := exchangeConn(t, deadClientConn, newQuery(7, "example.com", TypeA))
+		a.Equal(RcodeServerFailure, res.Rcode)
+		a.NoError(deadServerConn.Close())
+		a.NoError(<-deadDoneCh)
+	}
+
+	{
+		// 不正な問い合わせ
+		req := newQuery(8, "example.com", TypeA)
+		res := exchange(req[:headerLen+3])
+		a.Equal(uint16(8), res.Id)
+		a.Equal(RcodeFormatError, res.Rcode)
+	}
+
+	{
+		// 応答には、エラーも返さない
+		tctx := logger.NewTraceContext()
+		query, err := ParseQuery(newQuery(9, "vm2.group1.vm.internal", TypeA))
+		a.NoError(err)
+		a.Nil(server.Handle(tctx, query.NewResponse(RcodeSuccess, true, nil, 0)))
+		a.Nil(server.Handle(tctx, query.NewResponse(RcodeFormatError, false, nil, 0)[:headerLen]))
+	}
+
+	a.NoError(serverConn.Close())
+	a.NoError(<-doneCh)
+}
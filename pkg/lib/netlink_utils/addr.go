@@ -0,0 +1,92 @@
+package netlink_utils
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// EnsureAddr は、デバイスにIPv4のアドレスを追加する
+// 既に存在する場合は何もせず、created=falseを返す
+func (self *Handle) EnsureAddr(linkName string, ipNet *net.IPNet) (created bool, err error) {
+	var link *Link
+	if link, err = self.mustLinkByName(linkName); err != nil {
+		return
+	}
+	ip := ipNet.IP.To4()
+	if ip == nil {
+		err = fmt.Errorf("Invalid addr: only ipv4 is supported: addr=%s", ipNet.String())
+		return
+	}
+	prefixLen, _ := ipNet.Mask.Size()
+
+	data := make([]byte, syscall.SizeofIfAddrmsg)
+	data[0] = syscall.AF_INET
+	data[1] = byte(prefixLen)
+	data[3] = syscall.RT_SCOPE_UNIVERSE
+	nativeEndian.PutUint32(data[4:8], uint32(link.Index))
+	data = append(data, encodeAttrs(
+		newAttr(syscall.IFA_LOCAL, ip),
+		newAttr(syscall.IFA_ADDRESS, ip),
+	)...)
+	if _, err = self.execute(syscall.RTM_NEWADDR, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, data); err != nil {
+		if err == syscall.EEXIST {
+			err = nil
+			return
+		}
+		err = fmt.Errorf("Failed add addr: link=%s, addr=%s, err=%s", linkName, ipNet.String(), err.Error())
+		return
+	}
+	created = true
+	return
+}
+
+// Route は、IPv4のルート
+type Route struct {
+	Dst      *net.IPNet // nilの場合は、デフォルトルート
+	Gw       net.IP     // nilの場合は、デバイスに直接接続しているルート(scope link)
+	LinkName string
+}
+
+func (self *Route) String() string {
+	dst := "default"
+	if self.Dst != nil {
+		dst = self.Dst.String()
+	}
+	if self.Gw != nil {
+		return fmt.Sprintf("%s via %s dev %s", dst, self.Gw.String(), self.LinkName)
+	}
+	return fmt.Sprintf("%s dev %s", dst, self.LinkName)
+}
+
+// EnsureRoute は、mainテーブルにルートを追加する
+// 同じ宛先のルートが既に存在する場合は置き換える
+func (self *Handle) EnsureRoute(route *Route) (err error) {
+	var link *Link
+	if link, err = self.mustLinkByName(route.LinkName); err != nil {
+		return
+	}
+
+	data := make([]byte, syscall.SizeofRtMsg)
+	data[0] = syscall.AF_INET
+	data[4] = syscall.RT_TABLE_MAIN
+	data[5] = syscall.RTPROT_BOOT
+	data[6] = syscall.RT_SCOPE_UNIVERSE
+	data[7] = syscall.RTN_UNICAST
+	attrs := []*attr{newUint32Attr(syscall.RTA_OIF, uint32(link.Index))}
+	if route.Dst != nil {
+		dstLen, _ := route.Dst.Mask.Size()
+		data[1] = byte(dstLen)
+		attrs = append(attrs, newAttr(syscall.RTA_DST, route.Dst.IP.To4()))
+	}
+	if route.Gw != nil {
+		attrs = append(attrs, newAttr(syscall.RTA_GATEWAY, route.Gw.To4()))
+	} else {
+		data[6] = syscall.RT_SCOPE_LINK
+	}
+	data = append(data, encodeAttrs(attrs...)...)
+	if _, err = self.execute(syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE, data); err != nil {
+		err = fmt.Errorf("Failed add route: route=%s, err=%s", route.String(), err.Error())
+	}
+	return
+}
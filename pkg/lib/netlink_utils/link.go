@@ -0,0 +1,203 @@
+package netlink_utils
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+const (
+	LinkKindVeth   = "veth"
+	LinkKindBridge = "bridge"
+	LinkKindTun    = "tun"
+)
+
+// Link は、ネットワークデバイスの情報
+type Link struct {
+	Index       int
+	Name        string
+	Kind        string // veth, bridge, tun など
+	IsUp        bool
+	MasterIndex int // ブリッジに接続している場合は、ブリッジのIndex
+}
+
+func encodeIfInfomsg(index int, flags uint32, change uint32) []byte {
+	buf := make([]byte, syscall.SizeofIfInfomsg)
+	buf[0] = syscall.AF_UNSPEC
+	nativeEndian.PutUint32(buf[4:8], uint32(index))
+	nativeEndian.PutUint32(buf[8:12], flags)
+	nativeEndian.PutUint32(buf[12:16], change)
+	return buf
+}
+
+// LinkByName は、デバイスの情報を返す、存在しない場合はnilを返す
+func (self *Handle) LinkByName(name string) (link *Link, err error) {
+	data := append(encodeIfInfomsg(0, 0, 0), encodeAttrs(newStrAttr(syscall.IFLA_IFNAME, name))...)
+	var msgs []syscall.NetlinkMessage
+	if msgs, err = self.execute(syscall.RTM_GETLINK, 0, data); err != nil {
+		if err == syscall.ENODEV {
+			err = nil
+		}
+		return
+	}
+	for i := range msgs {
+		if msgs[i].Header.Type != syscall.RTM_NEWLINK {
+			continue
+		}
+		link, err = parseLink(&msgs[i])
+		return
+	}
+	return
+}
+
+func parseLink(msg *syscall.NetlinkMessage) (link *Link, err error) {
+	if len(msg.Data) < syscall.SizeofIfInfomsg {
+		err = fmt.Errorf("Invalid link message")
+		return
+	}
+	link = &Link{
+		Index: int(int32(nativeEndian.Uint32(msg.Data[4:8]))),
+		IsUp:  nativeEndian.Uint32(msg.Data[8:12])&syscall.IFF_UP != 0,
+	}
+	attrs := parseAttrs(msg.Data[syscall.SizeofIfInfomsg:])
+	if value, ok := attrs[syscall.IFLA_IFNAME]; ok {
+		link.Name = strings.TrimRight(string(value), "\x00")
+	}
+	if value, ok := attrs[syscall.IFLA_MASTER]; ok && len(value) >= 4 {
+		link.MasterIndex = int(nativeEndian.Uint32(value))
+	}
+	if value, ok := attrs[syscall.IFLA_LINKINFO]; ok {
+		if kind, ok := parseAttrs(value)[iflaInfoKind]; ok {
+			link.Kind = strings.TrimRight(string(kind), "\x00")
+		}
+	}
+	return
+}
+
+func (self *Handle) mustLinkByName(name string) (link *Link, err error) {
+	if link, err = self.LinkByName(name); err != nil {
+		return
+	}
+	if link == nil {
+		err = fmt.Errorf("Link Not Found: name=%s", name)
+	}
+	return
+}
+
+// EnsureVeth は、vethのペアを作成し、peerNameをpeerNetnsのnetnsに配置する(空の場合は同じnetns)
+// nameが既に存在する場合は何もせず、created=falseを返す
+func (self *Handle) EnsureVeth(name string, peerName string, peerNetns string) (created bool, err error) {
+	var link *Link
+	if link, err = self.LinkByName(name); err != nil {
+		return
+	}
+	if link != nil {
+		if link.Kind != LinkKindVeth {
+			err = fmt.Errorf("Invalid link kind: name=%s, kind=%s, expected=%s", name, link.Kind, LinkKindVeth)
+		}
+		return
+	}
+
+	peerAttrs := []*attr{newStrAttr(syscall.IFLA_IFNAME, peerName)}
+	if peerNetns != "" {
+		var netnsFile *os.File
+		if netnsFile, err = os.Open(NetnsPath(peerNetns)); err != nil {
+			return
+		}
+		defer netnsFile.Close()
+		peerAttrs = append(peerAttrs, newUint32Attr(iflaNetNsFd, uint32(netnsFile.Fd())))
+	}
+	peer := newAttr(vethInfoPeer, append(encodeIfInfomsg(0, 0, 0), encodeAttrs(peerAttrs...)...))
+
+	data := append(encodeIfInfomsg(0, 0, 0), encodeAttrs(
+		newStrAttr(syscall.IFLA_IFNAME, name),
+		newNestedAttr(syscall.IFLA_LINKINFO,
+			newStrAttr(iflaInfoKind, LinkKindVeth),
+			newNestedAttr(iflaInfoData, peer),
+		),
+	)...)
+	if _, err = self.execute(syscall.RTM_NEWLINK, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, data); err != nil {
+		err = fmt.Errorf("Failed create veth: name=%s, peer=%s, err=%s", name, peerName, err.Error())
+		return
+	}
+	created = true
+	return
+}
+
+// EnsureBridge は、ブリッジを作成する
+// 既に存在する場合は何もせず、created=falseを返す
+func (self *Handle) EnsureBridge(name string) (created bool, err error) {
+	var link *Link
+	if link, err = self.LinkByName(name); err != nil {
+		return
+	}
+	if link != nil {
+		if link.Kind != LinkKindBridge {
+			err = fmt.Errorf("Invalid link kind: name=%s, kind=%s, expected=%s", name, link.Kind, LinkKindBridge)
+		}
+		return
+	}
+
+	data := append(encodeIfInfomsg(0, 0, 0), encodeAttrs(
+		newStrAttr(syscall.IFLA_IFNAME, name),
+		newNestedAttr(syscall.IFLA_LINKINFO, newStrAttr(iflaInfoKind, LinkKindBridge)),
+	)...)
+	if _, err = self.execute(syscall.RTM_NEWLINK, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, data); err != nil {
+		err = fmt.Errorf("Failed create bridge: name=%s, err=%s", name, err.Error())
+		return
+	}
+	created = true
+	return
+}
+
+// SetLinkUp は、デバイスをupにする
+func (self *Handle) SetLinkUp(name string) (err error) {
+	var link *Link
+	if link, err = self.mustLinkByName(name); err != nil {
+		return
+	}
+	if link.IsUp {
+		return
+	}
+	data := encodeIfInfomsg(link.Index, syscall.IFF_UP, syscall.IFF_UP)
+	if _, err = self.execute(syscall.RTM_NEWLINK, 0, data); err != nil {
+		err = fmt.Errorf("Failed set link up: name=%s, err=%s", name, err.Error())
+	}
+	return
+}
+
+// SetLinkMaster は、デバイスをブリッジに接続する
+func (self *Handle) SetLinkMaster(name string, masterName string) (err error) {
+	var link, master *Link
+	if link, err = self.mustLinkByName(name); err != nil {
+		return
+	}
+	if master, err = self.mustLinkByName(masterName); err != nil {
+		return
+	}
+	if link.MasterIndex == master.Index {
+		return
+	}
+	data := append(encodeIfInfomsg(link.Index, 0, 0), encodeAttrs(newUint32Attr(syscall.IFLA_MASTER, uint32(master.Index)))...)
+	if _, err = self.execute(syscall.RTM_NEWLINK, 0, data); err != nil {
+		err = fmt.Errorf("Failed set link master: name=%s, master=%s, err=%s", name, masterName, err.Error())
+	}
+	return
+}
+
+// DeleteLink は、デバイスを削除する、存在しない場合は何もしない
+func (self *Handle) DeleteLink(name string) (err error) {
+	var link *Link
+	if link, err = self.LinkByName(name); err != nil || link == nil {
+		return
+	}
+	if _, err = self.execute(syscall.RTM_DELLINK, 0, encodeIfInfomsg(link.Index, 0, 0)); err != nil {
+		if err == syscall.ENODEV {
+			err = nil
+			return
+		}
+		err = fmt.Errorf("Failed delete link: name=%s, err=%s", name, err.Error())
+	}
+	return
+}
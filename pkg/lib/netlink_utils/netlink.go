@@ -0,0 +1,209 @@
+package netlink_utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// rtnetlinkでネットワークデバイス、アドレス、ルートを操作する
+// ipコマンドを実行してその出力を解析する代わりに、カーネルとメッセージを直接やり取りする
+
+// syscallパッケージに定義されていない定数
+const (
+	iflaNetNsFd   = 28
+	iflaInfoKind  = 1
+	iflaInfoData  = 2
+	vethInfoPeer  = 1
+	nlaFNested    = 1 << 15
+	nlmsgAlignTo  = 4
+	rtaAlignTo    = 4
+	recvBufferLen = 65536
+)
+
+var nativeEndian binary.ByteOrder
+
+func init() {
+	var x uint16 = 1
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		nativeEndian = binary.LittleEndian
+	} else {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+// Handle は、netnsごとのrtnetlinkのソケット
+// ソケットは作成したスレッドのnetnsに属するので、Handleの操作は全てそのnetnsに対して行われる
+type Handle struct {
+	netnsPath string // 空の場合は、現在のnetns
+	fd        int
+	seq       uint32
+}
+
+// NewHandle は、netnsNameのnetnsを操作するHandleを返す(空の場合は現在のnetns)
+func NewHandle(netnsName string) (handle *Handle, err error) {
+	handle = &Handle{fd: -1}
+	if netnsName != "" {
+		handle.netnsPath = NetnsPath(netnsName)
+	}
+	open := func() (err error) {
+		var fd int
+		if fd, err = syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE); err != nil {
+			return
+		}
+		if err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+			syscall.Close(fd)
+			return
+		}
+		handle.fd = fd
+		return
+	}
+	if err = handle.run(open); err != nil {
+		handle = nil
+		return
+	}
+	return
+}
+
+func (self *Handle) Close() {
+	if self.fd >= 0 {
+		syscall.Close(self.fd)
+		self.fd = -1
+	}
+}
+
+// run は、Handleのnetnsでfを実行する
+// /dev/net/tunやsysctlなど、netlinkのソケット以外の操作で利用する
+func (self *Handle) run(f func() error) (err error) {
+	if self.netnsPath == "" {
+		return f()
+	}
+	return RunInNetns(self.netnsPath, f)
+}
+
+// execute は、リクエストを送信してACKまでの応答を返す
+// エラーの応答の場合は、syscall.Errnoを返す
+func (self *Handle) execute(msgType uint16, flags int, data []byte) (msgs []syscall.NetlinkMessage, err error) {
+	self.seq++
+	seq := self.seq
+	msgLen := syscall.NLMSG_HDRLEN + len(data)
+	buf := make([]byte, nlmAlign(msgLen))
+	nativeEndian.PutUint32(buf[0:4], uint32(msgLen))
+	nativeEndian.PutUint16(buf[4:6], msgType)
+	nativeEndian.PutUint16(buf[6:8], uint16(flags|syscall.NLM_F_REQUEST|syscall.NLM_F_ACK))
+	nativeEndian.PutUint32(buf[8:12], seq)
+	copy(buf[syscall.NLMSG_HDRLEN:], data)
+
+	if err = syscall.Sendto(self.fd, buf, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return
+	}
+
+	for {
+		// 応答のDataはrbufを参照するので、受信ごとに確保する
+		rbuf := make([]byte, recvBufferLen)
+		var n int
+		if n, _, err = syscall.Recvfrom(self.fd, rbuf, 0); err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return
+		}
+		var rmsgs []syscall.NetlinkMessage
+		if rmsgs, err = syscall.ParseNetlinkMessage(rbuf[:n]); err != nil {
+			return
+		}
+		for _, rmsg := range rmsgs {
+			if rmsg.Header.Seq != seq {
+				continue
+			}
+			switch rmsg.Header.Type {
+			case syscall.NLMSG_DONE:
+				return
+			case syscall.NLMSG_ERROR:
+				if len(rmsg.Data) < 4 {
+					err = fmt.Errorf("Invalid netlink error message")
+					return
+				}
+				if errno := int32(nativeEndian.Uint32(rmsg.Data[0:4])); errno != 0 {
+					err = syscall.Errno(-errno)
+				}
+				return
+			default:
+				msgs = append(msgs, rmsg)
+			}
+		}
+	}
+}
+
+func nlmAlign(l int) int {
+	return (l + nlmsgAlignTo - 1) &^ (nlmsgAlignTo - 1)
+}
+
+func rtaAlign(l int) int {
+	return (l + rtaAlignTo - 1) &^ (rtaAlignTo - 1)
+}
+
+// attr は、ネストに対応したnetlinkの属性
+type attr struct {
+	typ      uint16
+	data     []byte
+	children []*attr
+}
+
+func newAttr(typ uint16, data []byte) *attr {
+	return &attr{typ: typ, data: data}
+}
+
+// newStrAttr は、NULL終端の文字列の属性
+func newStrAttr(typ uint16, str string) *attr {
+	return &attr{typ: typ, data: append([]byte(str), 0)}
+}
+
+func newUint32Attr(typ uint16, v uint32) *attr {
+	data := make([]byte, 4)
+	nativeEndian.PutUint32(data, v)
+	return &attr{typ: typ, data: data}
+}
+
+func newNestedAttr(typ uint16, children ...*attr) *attr {
+	return &attr{typ: typ | nlaFNested, children: children}
+}
+
+func (self *attr) encode() []byte {
+	payload := self.data
+	for _, child := range self.children {
+		payload = append(payload, child.encode()...)
+	}
+	l := syscall.SizeofRtAttr + len(payload)
+	buf := make([]byte, rtaAlign(l))
+	nativeEndian.PutUint16(buf[0:2], uint16(l))
+	nativeEndian.PutUint16(buf[2:4], self.typ)
+	copy(buf[syscall.SizeofRtAttr:], payload)
+	return buf
+}
+
+func encodeAttrs(attrs ...*attr) (buf []byte) {
+	for _, a := range attrs {
+		buf = append(buf, a.encode()...)
+	}
+	return
+}
+
+// parseAttrs は、ネストした属性の値を解析する
+func parseAttrs(buf []byte) (attrs map[uint16][]byte) {
+	attrs = map[uint16][]byte{}
+	for len(buf) >= syscall.SizeofRtAttr {
+		l := int(nativeEndian.Uint16(buf[0:2]))
+		typ := nativeEndian.Uint16(buf[2:4]) &^ nlaFNested
+		if l < syscall.SizeofRtAttr || l > len(buf) {
+			return
+		}
+		attrs[typ] = buf[syscall.SizeofRtAttr:l]
+		if rtaAlign(l) >= len(buf) {
+			return
+		}
+		buf = buf[rtaAlign(l):]
+	}
+	return
+}
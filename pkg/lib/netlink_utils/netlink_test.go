@@ -0,0 +1,181 @@
+package netlink_utils
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testChildEnv = "NETLINK_UTILS_TEST_CHILD"
+
+// runInUnsharedNetns は、テストを新しいnetnsとmount namespaceで実行し直す
+// ホストのネットワークやマウントには影響しない
+// root権限がない場合は、user namespaceも作成する
+func runInUnsharedNetns(t *testing.T) (isChild bool) {
+	if os.Getenv(testChildEnv) != "" {
+		// bindマウントが元のmount namespaceに伝搬しないようにする
+		if err := syscall.Mount("none", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+			t.Fatalf("Failed make-rprivate: %s", err.Error())
+		}
+		return true
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^"+t.Name()+"$", "-test.v")
+	cmd.Env = append(os.Environ(), testChildEnv+"=1")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWNET | syscall.CLONE_NEWNS,
+	}
+	if os.Getuid() != 0 {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			t.Skipf("Skip: failed unshare: %s", err.Error())
+		}
+		t.Fatalf("Failed in unshared netns: %s\n%s", err.Error(), string(out))
+	}
+	t.Log(string(out))
+	return false
+}
+
+func TestNetlink(t *testing.T) {
+	if !runInUnsharedNetns(t) {
+		return
+	}
+	a := assert.New(t)
+	NetnsDir = t.TempDir()
+
+	created, err := EnsureNetns("test-0")
+	a.NoError(err)
+	a.True(created)
+	created, err = EnsureNetns("test-0")
+	a.NoError(err)
+	a.False(created)
+	names, err := ListNetns()
+	a.NoError(err)
+	a.Equal([]string{"test-0"}, names)
+
+	host, err := NewHandle("")
+	a.NoError(err)
+	defer host.Close()
+	netns, err := NewHandle("test-0")
+	a.NoError(err)
+	defer netns.Close()
+
+	// vethの片方をnetnsに配置する
+	created, err = host.EnsureVeth("test-0-ex", "test-0-in", "test-0")
+	a.NoError(err)
+	a.True(created)
+	created, err = host.EnsureVeth("test-0-ex", "test-0-in", "test-0")
+	a.NoError(err)
+	a.False(created)
+	link, err := host.LinkByName("test-0-in")
+	a.NoError(err)
+	a.Nil(link)
+	link, err = netns.LinkByName("test-0-in")
+	a.NoError(err)
+	a.Equal("veth", link.Kind)
+
+	// netns内のブリッジにtapを接続する
+	created, err = netns.EnsureBridge("test-0-br")
+	a.NoError(err)
+	a.True(created)
+	created, err = netns.EnsureTap("test-0-tap")
+	a.NoError(err)
+	a.True(created)
+	created, err = netns.EnsureTap("test-0-tap")
+	a.NoError(err)
+	a.False(created)
+	a.NoError(netns.SetLinkMaster("test-0-tap", "test-0-br"))
+	a.NoError(netns.SetLinkMaster("test-0-tap", "test-0-br"))
+	for _, name := range []string{"lo", "test-0-in", "test-0-br", "test-0-tap"} {
+		a.NoError(netns.SetLinkUp(name))
+	}
+	tap, err := netns.LinkByName("test-0-tap")
+	a.NoError(err)
+	br, err := netns.LinkByName("test-0-br")
+	a.NoError(err)
+	a.Equal("tun", tap.Kind)
+	a.Equal(br.Index, tap.MasterIndex)
+	a.True(br.IsUp)
+
+	{
+		// 種類の異なるデバイスが既に存在する場合
+		_, err := netns.EnsureBridge("test-0-tap")
+		a.Error(err)
+	}
+
+	// qemuに渡すために、netns内のtapを開く
+	tapFile, err := netns.OpenTap("test-0-tap")
+	a.NoError(err)
+	a.NoError(tapFile.Close())
+
+	// アドレスとルート
+	_, ipNet, _ := net.ParseCIDR("169.254.32.1/32")
+	ipNet.IP = net.ParseIP("169.254.32.1")
+	created, err = netns.EnsureAddr("test-0-in", ipNet)
+	a.NoError(err)
+	a.True(created)
+	created, err = netns.EnsureAddr("test-0-in", ipNet)
+	a.NoError(err)
+	a.False(created)
+	_, gwNet, _ := net.ParseCIDR("169.254.1.1/32")
+	a.NoError(netns.EnsureRoute(&Route{Dst: gwNet, LinkName: "test-0-in"}))
+	a.NoError(netns.EnsureRoute(&Route{Gw: gwNet.IP, LinkName: "test-0-in"}))
+	a.NoError(netns.EnsureRoute(&Route{Gw: gwNet.IP, LinkName: "test-0-in"}))
+
+	// sysctlはnetnsごとの値となる
+	a.NoError(netns.SetSysctl("net/ipv4/conf/test-0-br/proxy_arp", "1"))
+	value, err := netns.GetSysctl("net/ipv4/conf/test-0-br/proxy_arp")
+	a.NoError(err)
+	a.Equal("1", value)
+	a.NoError(netns.SetSysctl("net/ipv4/ip_forward", "1"))
+	value, err = host.GetSysctl("net/ipv4/ip_forward")
+	a.NoError(err)
+	a.Equal("0", value)
+
+	// ロールバックは逆順に実行する
+	rollback := &Rollback{}
+	calls := []string{}
+	rollback.Add(func() error {
+		calls = append(calls, "netns")
+		return DeleteNetns("test-0")
+	})
+	rollback.Add(func() error {
+		calls = append(calls, "link")
+		return host.DeleteLink("test-0-ex")
+	})
+	a.Len(rollback.Run(), 0)
+	a.Equal([]string{"link", "netns"}, calls)
+	link, err = host.LinkByName("test-0-ex")
+	a.NoError(err)
+	a.Nil(link)
+	names, err = ListNetns()
+	a.NoError(err)
+	a.Len(names, 0)
+	a.NoError(DeleteNetns("test-0"))
+
+	// netnsを削除すると、vethの対向も削除される
+	_, err = EnsureNetns("test-1")
+	a.NoError(err)
+	_, err = host.EnsureVeth("test-1-ex", "test-1-in", "test-1")
+	a.NoError(err)
+	a.NoError(DeleteNetns("test-1"))
+	// netnsの破棄は非同期で行われる
+	for i := 0; i < 50; i++ {
+		if link, err = host.LinkByName("test-1-ex"); err != nil || link == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	a.NoError(err)
+	a.Nil(link)
+}
@@ -0,0 +1,169 @@
+package netlink_utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+)
+
+// NetnsDir は、名前付きのnetnsのマウント先(ip netnsと同じ)
+// テストでは、一時ディレクトリに変更する
+var NetnsDir = "/var/run/netns"
+
+func NetnsPath(name string) string {
+	return filepath.Join(NetnsDir, name)
+}
+
+// ListNetns は、NetnsDirのnetns名を返す
+// netnsを一つも作成していない場合は、ディレクトリが存在しないので空とする
+func ListNetns() (names []string, err error) {
+	fileInfos, tmpErr := ioutil.ReadDir(NetnsDir)
+	if tmpErr != nil {
+		if !os.IsNotExist(tmpErr) {
+			err = tmpErr
+		}
+		return
+	}
+	for _, fileInfo := range fileInfos {
+		names = append(names, fileInfo.Name())
+	}
+	return
+}
+
+// EnsureNetns は、ip netns add [name]と同様に名前付きのnetnsを作成する
+// 既に存在する場合は何もせず、created=falseを返す
+func EnsureNetns(name string) (created bool, err error) {
+	netnsPath := NetnsPath(name)
+	if isNetnsMounted(netnsPath) {
+		return
+	}
+
+	if err = os.MkdirAll(NetnsDir, 0755); err != nil {
+		return
+	}
+	var file *os.File
+	if file, err = os.OpenFile(netnsPath, os.O_CREATE|os.O_RDONLY, 0444); err != nil {
+		return
+	}
+	file.Close()
+
+	// unshare(2)したスレッドのnetnsを、ファイルにbindマウントして残す
+	errCh := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+
+		originFd, err := syscall.Open("/proc/thread-self/ns/net", syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			runtime.UnlockOSThread()
+			errCh <- err
+			return
+		}
+		defer syscall.Close(originFd)
+
+		if err = syscall.Unshare(syscall.CLONE_NEWNET); err != nil {
+			runtime.UnlockOSThread()
+			errCh <- fmt.Errorf("Failed unshare: err=%s", err.Error())
+			return
+		}
+
+		err = syscall.Mount("/proc/thread-self/ns/net", netnsPath, "none", syscall.MS_BIND, "")
+
+		// 元のnetnsに戻せなかった場合は、スレッドを固定したままgoroutineを終了させてスレッドごと破棄する
+		if tmpErr := setns(originFd); tmpErr != nil {
+			errCh <- fmt.Errorf("Failed setns to origin: err=%s", tmpErr.Error())
+			return
+		}
+		runtime.UnlockOSThread()
+		errCh <- err
+	}()
+	if err = <-errCh; err != nil {
+		os.Remove(netnsPath)
+		return
+	}
+	created = true
+	return
+}
+
+// DeleteNetns は、ip netns del [name]と同様にnetnsを削除する
+// netns内のデバイスも削除され、vethの場合は対向のデバイスも削除される
+func DeleteNetns(name string) (err error) {
+	netnsPath := NetnsPath(name)
+	if tmpErr := syscall.Unmount(netnsPath, syscall.MNT_DETACH); tmpErr != nil && tmpErr != syscall.EINVAL && tmpErr != syscall.ENOENT {
+		err = fmt.Errorf("Failed umount: path=%s, err=%s", netnsPath, tmpErr.Error())
+		return
+	}
+	if err = os.Remove(netnsPath); err != nil && os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
+
+// isNetnsMounted は、netnsPathにnetnsがマウントされているかを確認する
+// 作成の途中で失敗した場合などは、マウントされていない空のファイルが残っている
+func isNetnsMounted(netnsPath string) bool {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(netnsPath, &stat); err != nil {
+		return false
+	}
+	return stat.Type == nsfsMagic
+}
+
+const nsfsMagic = 0x6e736673
+
+// RunInNetns は、netnsPathのnetnsに入ったスレッドでfを実行する
+// setns(2)はスレッド単位で作用するので、専用のgoroutineをOSスレッドに固定して実行する
+func RunInNetns(netnsPath string, f func() error) (err error) {
+	errCh := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+
+		originFd, err := syscall.Open("/proc/thread-self/ns/net", syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			runtime.UnlockOSThread()
+			errCh <- err
+			return
+		}
+		defer syscall.Close(originFd)
+
+		netnsFd, err := syscall.Open(netnsPath, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			runtime.UnlockOSThread()
+			errCh <- err
+			return
+		}
+		defer syscall.Close(netnsFd)
+
+		if err = setns(netnsFd); err != nil {
+			runtime.UnlockOSThread()
+			errCh <- fmt.Errorf("Failed setns: path=%s, err=%s", netnsPath, err.Error())
+			return
+		}
+
+		err = f()
+
+		// 元のnetnsに戻せなかった場合は、スレッドを固定したままgoroutineを終了させてスレッドごと破棄する
+		if tmpErr := setns(originFd); tmpErr != nil {
+			errCh <- fmt.Errorf("Failed setns to origin: err=%s", tmpErr.Error())
+			return
+		}
+		runtime.UnlockOSThread()
+		errCh <- err
+	}()
+	err = <-errCh
+	return
+}
+
+func setns(fd int) (err error) {
+	trap := sysSetns
+	if trap < 0 {
+		err = syscall.ENOSYS
+		return
+	}
+	if _, _, errno := syscall.RawSyscall(uintptr(trap), uintptr(fd), syscall.CLONE_NEWNET, 0); errno != 0 {
+		err = errno
+	}
+	return
+}
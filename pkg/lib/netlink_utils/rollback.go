@@ -0,0 +1,35 @@
+package netlink_utils
+
+// Rollback は、Ensure系の関数で作成したものを記録し、途中で失敗した場合に逆順で削除する
+//
+//	rollback := &Rollback{}
+//	defer func() {
+//		if err != nil {
+//			rollback.Run()
+//		}
+//	}()
+//	if created, err = EnsureNetns(name); err != nil {
+//		return
+//	}
+//	if created {
+//		rollback.Add(func() error { return DeleteNetns(name) })
+//	}
+type Rollback struct {
+	funcs []func() error
+}
+
+func (self *Rollback) Add(f func() error) {
+	self.funcs = append(self.funcs, f)
+}
+
+// Run は、登録した関数を逆順に実行する
+// 途中で失敗しても残りは実行し、発生したエラーを返す
+func (self *Rollback) Run() (errs []error) {
+	for i := len(self.funcs) - 1; i >= 0; i-- {
+		if err := self.funcs[i](); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	self.funcs = nil
+	return
+}
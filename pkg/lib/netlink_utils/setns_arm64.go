@@ -0,0 +1,4 @@
+package netlink_utils
+
+// syscallパッケージにはSYS_SETNSが定義されていないので、アーキテクチャごとに定義する
+const sysSetns = 268
@@ -0,0 +1,6 @@
+//go:build !amd64 && !arm64
+
+package netlink_utils
+
+// setnsに対応していないアーキテクチャでは、RunInNetnsはENOSYSのエラーとなる
+const sysSetns = -1
@@ -0,0 +1,39 @@
+package netlink_utils
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// SetSysctl は、Handleのnetnsのsysctlを設定する
+// /proc/sys/netは開いたスレッドのnetnsの値となるので、netns内で読み書きする
+// key: net/ipv4/conf/com-0-br/proxy_arp
+func (self *Handle) SetSysctl(key string, value string) (err error) {
+	err = self.run(func() (err error) {
+		path := filepath.Join("/proc/sys", key)
+		var current []byte
+		if current, err = ioutil.ReadFile(path); err != nil {
+			return
+		}
+		if strings.TrimSpace(string(current)) == value {
+			return
+		}
+		err = ioutil.WriteFile(path, []byte(value), 0644)
+		return
+	})
+	return
+}
+
+// GetSysctl は、Handleのnetnsのsysctlの値を返す
+func (self *Handle) GetSysctl(key string) (value string, err error) {
+	err = self.run(func() (err error) {
+		var tmpBytes []byte
+		if tmpBytes, err = ioutil.ReadFile(filepath.Join("/proc/sys", key)); err != nil {
+			return
+		}
+		value = strings.TrimSpace(string(tmpBytes))
+		return
+	})
+	return
+}
@@ -0,0 +1,85 @@
+package netlink_utils
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	tunDevicePath = "/dev/net/tun"
+	tunSetPersist = 0x400454cb
+)
+
+// ifreq は、TUNSETIFFで渡すstruct ifreq(名前とフラグのみ利用する)
+type ifreq struct {
+	name  [syscall.IFNAMSIZ]byte
+	flags uint16
+	_     [22]byte
+}
+
+// EnsureTap は、永続的なtapデバイスを作成する
+// tapデバイスの作成はrtnetlinkでは行えないので、/dev/net/tunのioctlで作成する
+// 既に存在する場合は何もせず、created=falseを返す
+func (self *Handle) EnsureTap(name string) (created bool, err error) {
+	var link *Link
+	if link, err = self.LinkByName(name); err != nil {
+		return
+	}
+	if link != nil {
+		if link.Kind != LinkKindTun {
+			err = fmt.Errorf("Invalid link kind: name=%s, kind=%s, expected=%s", name, link.Kind, LinkKindTun)
+		}
+		return
+	}
+
+	if err = self.run(func() (err error) {
+		var file *os.File
+		if file, err = openTun(name, syscall.IFF_TAP|syscall.IFF_NO_PI); err != nil {
+			return
+		}
+		defer file.Close()
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), tunSetPersist, 1); errno != 0 {
+			err = errno
+		}
+		return
+	}); err != nil {
+		err = fmt.Errorf("Failed create tap: name=%s, err=%s", name, err.Error())
+		return
+	}
+	created = true
+	return
+}
+
+// OpenTap は、Handleのnetnsのtapデバイスを開いて返す
+// qemuはホストのnetnsで起動するので、netns内のtapはこのファイルをfdとして渡す
+func (self *Handle) OpenTap(name string) (file *os.File, err error) {
+	err = self.run(func() (err error) {
+		file, err = openTun(name, syscall.IFF_TAP|syscall.IFF_NO_PI|syscall.IFF_VNET_HDR)
+		return
+	})
+	if err != nil {
+		err = fmt.Errorf("Failed open tap: name=%s, err=%s", name, err.Error())
+	}
+	return
+}
+
+func openTun(name string, flags uint16) (file *os.File, err error) {
+	if len(name) >= syscall.IFNAMSIZ {
+		err = fmt.Errorf("Invalid link name: too long name=%s", name)
+		return
+	}
+	if file, err = os.OpenFile(tunDevicePath, os.O_RDWR, 0); err != nil {
+		return
+	}
+	var req ifreq
+	copy(req.name[:], name)
+	req.flags = flags
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), syscall.TUNSETIFF, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		file.Close()
+		file = nil
+		err = errno
+	}
+	return
+}
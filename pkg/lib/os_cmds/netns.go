@@ -33,6 +33,11 @@ func AddNetns(tctx *logger.TraceContext, netns string) (err error) {
 	return
 }
 
+func DelNetns(tctx *logger.TraceContext, netns string) (err error) {
+	_, err = cmd_runner.Run(&cmd_runner.Config{Cmd: fmt.Sprintf("ip netns del %s", netns)})
+	return
+}
+
 func ExecInIpNetns(tctx *logger.TraceContext, netns string, cmd string) (out string, err error) {
 	var result *cmd_runner.Result
 	if result, err = cmd_runner.Run(&cmd_runner.Config{Cmd: fmt.Sprintf("ip netns exec %s %s", netns, cmd)}); err != nil {
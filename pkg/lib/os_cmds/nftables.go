@@ -0,0 +1,22 @@
+package os_cmds
+
+import (
+	"fmt"
+
+	"github.com/syunkitada/goapp2/pkg/lib/cmd_runner"
+	"github.com/syunkitada/goapp2/pkg/lib/logger"
+)
+
+// ApplyNftables は、nft -f [path]でルールセットのファイルを適用する
+// ファイル内のコマンドは一つのトランザクションとして適用されるので、途中で失敗した場合は何も変更されない
+func ApplyNftables(tctx *logger.TraceContext, path string) (err error) {
+	var result *cmd_runner.Result
+	if result, err = cmd_runner.Run(&cmd_runner.Config{Cmd: fmt.Sprintf("nft -f %s", path)}); err != nil {
+		return
+	}
+	if result.Status != 0 {
+		err = fmt.Errorf("Failed nft -f: path=%s, output=%s, status=%d", path, result.Output, result.Status)
+		return
+	}
+	return
+}
@@ -0,0 +1,207 @@
+package os_utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AnalyzerConfig は、異常検知の設定
+type AnalyzerConfig struct {
+	// EWMAの平滑化係数(0 < alpha <= 1)、大きいほど直近の値を重視する
+	Alpha float64 `yaml:"alpha"`
+	// 平均からこの標準偏差の倍数以上離れた値を異常とする
+	Sigma float64 `yaml:"sigma"`
+	// 異常判定を始めるまでに学習するサンプル数
+	WarmUp int `yaml:"warmUp"`
+	// 標準偏差の下限、値がほぼ一定のメトリクスがわずかに揺れただけで異常とならないようにする
+	MinStddev float64 `yaml:"minStddev"`
+	// メトリクスごとの設定、キーにはfilepath.Matchのパターンを利用できる
+	Metrics map[string]AnalyzerMetricConfig `yaml:"metrics"`
+}
+
+type AnalyzerMetricConfig struct {
+	Sigma     float64 `yaml:"sigma"`
+	MinStddev float64 `yaml:"minStddev"`
+	Disable   bool    `yaml:"disable"`
+}
+
+const (
+	DefaultAnalyzerAlpha     = 0.1
+	DefaultAnalyzerSigma     = 3
+	DefaultAnalyzerWarmUp    = 30
+	DefaultAnalyzerMinStddev = 1
+)
+
+type Anomaly struct {
+	Metric    string
+	Value     float64
+	Mean      float64
+	Stddev    float64
+	Deviation float64 // 平均から標準偏差の何倍離れているか(符号は向き)
+}
+
+type ewmaState struct {
+	count     int
+	mean      float64
+	variance  float64
+	sigma     float64
+	minStddev float64
+	disable   bool
+}
+
+type StatAnalyzer struct {
+	conf   AnalyzerConfig
+	states map[string]*ewmaState
+}
+
+func LoadAnalyzerConfig(path string) (conf *AnalyzerConfig, err error) {
+	// $ cat analyzer.yaml
+	// alpha: 0.1
+	// sigma: 3
+	// warmUp: 30
+	// metrics:
+	//   cpu.ctx:
+	//     sigma: 5
+	//   disk.*.wbps:
+	//     sigma: 4
+	//     minStddev: 1048576
+	//   net.lo.*:
+	//     disable: true
+	var tmpBytes []byte
+	if tmpBytes, err = ioutil.ReadFile(path); err != nil {
+		return
+	}
+	conf = &AnalyzerConfig{}
+	if err = yaml.Unmarshal(tmpBytes, conf); err != nil {
+		err = fmt.Errorf("Failed Unmarshal: path=%s, err=%s", path, err.Error())
+		return
+	}
+	if conf.Alpha < 0 || conf.Alpha > 1 {
+		err = fmt.Errorf("Invalid alpha: path=%s, alpha=%f", path, conf.Alpha)
+		return
+	}
+	for pattern := range conf.Metrics {
+		if _, tmpErr := filepath.Match(pattern, ""); tmpErr != nil {
+			err = fmt.Errorf("Invalid metric pattern: path=%s, pattern=%s", path, pattern)
+			return
+		}
+	}
+	return
+}
+
+func NewStatAnalyzer(conf *AnalyzerConfig) (analyzer *StatAnalyzer) {
+	analyzer = &StatAnalyzer{
+		conf:   *conf,
+		states: map[string]*ewmaState{},
+	}
+	if analyzer.conf.Alpha == 0 {
+		analyzer.conf.Alpha = DefaultAnalyzerAlpha
+	}
+	if analyzer.conf.Sigma == 0 {
+		analyzer.conf.Sigma = DefaultAnalyzerSigma
+	}
+	if analyzer.conf.WarmUp == 0 {
+		analyzer.conf.WarmUp = DefaultAnalyzerWarmUp
+	}
+	if analyzer.conf.MinStddev == 0 {
+		analyzer.conf.MinStddev = DefaultAnalyzerMinStddev
+	}
+	return
+}
+
+// Analyze は、メトリクスごとにEWMAで平均と分散を更新し、閾値を超えたものを異常として返す
+// 判定には今回の値を含めない平均と分散を利用する
+func (self *StatAnalyzer) Analyze(stats *Stats) (anomalies []Anomaly) {
+	return self.analyzeMetrics(GetStatsMetrics(stats))
+}
+
+func (self *StatAnalyzer) analyzeMetrics(metrics map[string]float64) (anomalies []Anomaly) {
+	alpha := self.conf.Alpha
+	for metric, value := range metrics {
+		state, ok := self.states[metric]
+		if !ok {
+			// パターンの照合は初回のみ行う
+			sigma, minStddev, disable := self.getMetricConfig(metric)
+			self.states[metric] = &ewmaState{
+				count:     1,
+				mean:      value,
+				sigma:     sigma,
+				minStddev: minStddev,
+				disable:   disable,
+			}
+			continue
+		}
+		if state.disable {
+			continue
+		}
+
+		diff := value - state.mean
+		if state.count >= self.conf.WarmUp {
+			stddev := math.Max(math.Sqrt(state.variance), state.minStddev)
+			if deviation := diff / stddev; math.Abs(deviation) > state.sigma {
+				anomalies = append(anomalies, Anomaly{
+					Metric:    metric,
+					Value:     value,
+					Mean:      state.mean,
+					Stddev:    stddev,
+					Deviation: deviation,
+				})
+			}
+		}
+
+		state.count += 1
+		state.mean += alpha * diff
+		state.variance = (1 - alpha) * (state.variance + alpha*diff*diff)
+	}
+
+	// 今回なくなったメトリクス(削除されたnetnsやデバイスなど)の状態は削除する
+	for metric := range self.states {
+		if _, ok := metrics[metric]; !ok {
+			delete(self.states, metric)
+		}
+	}
+
+	sort.Slice(anomalies, func(i, j int) bool {
+		return math.Abs(anomalies[i].Deviation) > math.Abs(anomalies[j].Deviation)
+	})
+	return
+}
+
+func (self *StatAnalyzer) getMetricConfig(metric string) (sigma float64, minStddev float64, disable bool) {
+	sigma = self.conf.Sigma
+	minStddev = self.conf.MinStddev
+
+	// 完全一致を優先し、なければパターンに一致したものを利用する
+	metricConf, ok := self.conf.Metrics[metric]
+	if !ok {
+		patterns := make([]string, 0, len(self.conf.Metrics))
+		for pattern := range self.conf.Metrics {
+			patterns = append(patterns, pattern)
+		}
+		sort.Strings(patterns)
+		for _, pattern := range patterns {
+			if matched, _ := filepath.Match(pattern, metric); matched {
+				metricConf = self.conf.Metrics[pattern]
+				ok = true
+				break
+			}
+		}
+	}
+	if !ok {
+		return
+	}
+
+	if metricConf.Sigma > 0 {
+		sigma = metricConf.Sigma
+	}
+	if metricConf.MinStddev > 0 {
+		minStddev = metricConf.MinStddev
+	}
+	disable = metricConf.Disable
+	return
+}
@@ -0,0 +1,75 @@
+package os_utils
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatAnalyzer(t *testing.T) {
+	a := assert.New(t)
+
+	wd, err := os.Getwd()
+	a.NoError(err)
+
+	conf, err := LoadAnalyzerConfig(wd + "/testdata/analyzer.yaml")
+	a.NoError(err)
+	a.Equal(0.2, conf.Alpha)
+	a.Equal(5, conf.WarmUp)
+
+	analyzer := NewStatAnalyzer(conf)
+	a.Equal(float64(DefaultAnalyzerSigma), analyzer.conf.Sigma)
+
+	// 値が揺れながら安定している間は異常としない
+	for i := 0; i < 10; i++ {
+		anomalies := analyzer.analyzeMetrics(map[string]float64{
+			"cpu.run":       float64(10 + i%2),
+			"cpu.ctx":       float64(1000 + (i%2)*100),
+			"disk.sda.wbps": 0,
+			"net.lo.rbps":   0,
+		})
+		a.Nil(anomalies)
+	}
+
+	anomalies := analyzer.analyzeMetrics(map[string]float64{
+		"cpu.run":       50,
+		"cpu.ctx":       1200,
+		"disk.sda.wbps": 500,
+		"net.lo.rbps":   100000000,
+	})
+	a.Len(anomalies, 1)
+	a.Equal("cpu.run", anomalies[0].Metric)
+	a.Equal(float64(50), anomalies[0].Value)
+	a.True(anomalies[0].Deviation > 3)
+
+	{
+		// ウォームアップ中は異常としない
+		analyzer := NewStatAnalyzer(conf)
+		a.Nil(analyzer.analyzeMetrics(map[string]float64{"cpu.run": 1}))
+		a.Nil(analyzer.analyzeMetrics(map[string]float64{"cpu.run": 1000}))
+	}
+
+	{
+		// 今回なくなったメトリクスの状態は削除する
+		analyzer := NewStatAnalyzer(conf)
+		a.Nil(analyzer.analyzeMetrics(map[string]float64{"cpu.run": 1, "netns.com-0.sock.tcp": 1}))
+		a.Len(analyzer.states, 2)
+		a.Nil(analyzer.analyzeMetrics(map[string]float64{"cpu.run": 1}))
+		a.Len(analyzer.states, 1)
+		a.Contains(analyzer.states, "cpu.run")
+	}
+
+	{
+		// デバイス名の"/"は"_"に置き換えて、パターンの"*"に一致させる
+		a.Equal("disk.dm_0.wbps", "disk."+metricName("dm/0")+".wbps")
+		_, minStddev, _ := analyzer.getMetricConfig("disk." + metricName("dm/0") + ".wbps")
+		a.Equal(float64(1000), minStddev)
+	}
+
+	{
+		// 設定ファイルがない
+		_, err := LoadAnalyzerConfig(wd + "/testdata/none.yaml")
+		a.Error(err)
+	}
+}
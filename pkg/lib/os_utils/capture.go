@@ -0,0 +1,449 @@
+package os_utils
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/syunkitada/goapp2/pkg/lib/netlink_utils"
+	"github.com/syunkitada/goapp2/pkg/lib/str_utils"
+)
+
+// CaptureFilePatterns は、各collectorが読み込むファイルのパターン(rootDirからの相対パス)
+// collectorで読み込むファイルを追加した場合は、ここにも追加する
+var CaptureFilePatterns = []string{
+	// GetCpuStat
+	"proc/cpuinfo",
+	"proc/stat",
+	"proc/interrupts",
+	// GetMemStat
+	"sys/devices/system/node/node*/meminfo",
+	"sys/devices/system/node/node*/hugepages/hugepages-1048576kB/nr_hugepages",
+	"sys/devices/system/node/node*/hugepages/hugepages-1048576kB/free_hugepages",
+	"proc/vmstat",
+	"proc/buddyinfo",
+	// GetSlabStat
+	"proc/slabinfo",
+	// GetZoneStat
+	"proc/zoneinfo",
+	// GetDiskStat
+	"proc/diskstats",
+	"sys/block/*/queue/physical_block_size",
+	"sys/block/*/queue/rotational",
+	"sys/block/*/queue/scheduler",
+	"sys/block/*/queue/nr_requests",
+	"sys/block/*/*/partition",
+	"proc/self/mounts",
+	"proc/self/mountinfo",
+	CaptureStatfsFile,
+	// GetNetStat
+	"proc/net/netstat",
+	"proc/net/dev",
+	"proc/net/sockstat",
+	// GetConntrackStat
+	"proc/sys/net/netfilter/nf_conntrack_count",
+	"proc/sys/net/netfilter/nf_conntrack_max",
+	"proc/net/stat/nf_conntrack",
+	// GetUptimeStat
+	"proc/uptime",
+	// GetLoadavgStat
+	"proc/loadavg",
+	"proc/schedstat",
+	"sys/devices/system/cpu/online",
+	// GetFdStat
+	"proc/sys/fs/file-nr",
+	"proc/sys/fs/inode-nr",
+	"proc/sys/kernel/pid_max",
+	"proc/[0-9]*/comm",
+	"proc/[0-9]*/limits",
+	// GetProcesses
+	"proc/[0-9]*/cmdline",
+	"proc/[0-9]*/status",
+	"proc/[0-9]*/schedstat",
+	"proc/[0-9]*/stat",
+	"proc/[0-9]*/io",
+	"proc/[0-9]*/oom_score",
+	"proc/[0-9]*/oom_score_adj",
+	"proc/[0-9]*/smaps_rollup",
+	// GetSensorStat
+	"sys/class/hwmon/*/name",
+	"sys/class/hwmon/*/temp*_*",
+	"sys/class/hwmon/*/fan*_*",
+	"sys/class/thermal/thermal_zone*/type",
+	"sys/class/thermal/thermal_zone*/temp",
+	"sys/class/powercap/intel-rapl*/name",
+	"sys/class/powercap/intel-rapl*/energy_uj",
+	"sys/class/powercap/intel-rapl*/max_energy_range_uj",
+	"sys/devices/system/cpu/cpu[0-9]*/cpufreq/scaling_cur_freq",
+	"sys/devices/system/cpu/cpu[0-9]*/cpufreq/scaling_max_freq",
+	"sys/devices/system/cpu/cpu[0-9]*/thermal_throttle/core_throttle_count",
+	"sys/devices/system/cpu/cpu[0-9]*/thermal_throttle/package_throttle_count",
+}
+
+// CaptureEntryPatterns は、ファイルの中身ではなくエントリの有無や属性だけを利用するもの
+// 中身は空のファイルとして保存する
+var CaptureEntryPatterns = []string{
+	// GetFdStat
+	"proc/[0-9]*/fd/*",
+	// GetDiskStat
+	"sys/block/*/slaves/*",
+	"sys/block/*/holders/*",
+	// GetLoginUserStat
+	"dev/pts/*",
+}
+
+const (
+	// statfs(2)の結果はファイルとして読めないので、キャプチャ時にこのファイルに保存する
+	// $ cat statfs
+	// [mount path] [total size] [free size] [files]
+	CaptureStatfsFile = "statfs"
+	CaptureMetaFile   = "meta.json"
+)
+
+type CaptureMeta struct {
+	Hostname  string
+	Interval  int
+	ClkTck    int
+	Snapshots []CaptureSnapshot
+}
+
+type CaptureSnapshot struct {
+	Dir        string
+	CapturedAt time.Time
+}
+
+// CaptureStatFiles は、rootDirからcollectorが読み込むファイルをinterval秒間隔でcount回コピーし、tar.gzとして保存する
+func CaptureStatFiles(rootDir string, dstPath string, interval int, count int, clkTck int) (err error) {
+	if count < 1 {
+		err = fmt.Errorf("Invalid count: count=%d", count)
+		return
+	}
+
+	var dstFile *os.File
+	if dstFile, err = os.Create(dstPath); err != nil {
+		return
+	}
+	defer dstFile.Close()
+	gzipWriter := gzip.NewWriter(dstFile)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	meta := CaptureMeta{
+		Interval: interval,
+		ClkTck:   clkTck,
+	}
+	meta.Hostname, _ = os.Hostname()
+
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(interval) * time.Second)
+		}
+		snapshot := CaptureSnapshot{
+			Dir:        "snapshot" + strconv.Itoa(i),
+			CapturedAt: time.Now(),
+		}
+		if err = captureSnapshot(rootDir, snapshot.Dir, tarWriter); err != nil {
+			return
+		}
+		meta.Snapshots = append(meta.Snapshots, snapshot)
+	}
+
+	var metaBytes []byte
+	if metaBytes, err = json.MarshalIndent(&meta, "", "  "); err != nil {
+		return
+	}
+	if err = writeTarFile(tarWriter, CaptureMetaFile, metaBytes, time.Now()); err != nil {
+		return
+	}
+
+	if err = tarWriter.Close(); err != nil {
+		return
+	}
+	if err = gzipWriter.Close(); err != nil {
+		return
+	}
+	err = dstFile.Close()
+	return
+}
+
+func captureSnapshot(rootDir string, dir string, tarWriter *tar.Writer) (err error) {
+	now := time.Now()
+	for _, pattern := range CaptureFilePatterns {
+		if pattern == CaptureStatfsFile && rootDir == "/" {
+			// 実機の場合はここでstatfs(2)を実行して保存する
+			if err = writeTarFile(tarWriter, dir+"/"+CaptureStatfsFile, captureStatfs(rootDir), now); err != nil {
+				return
+			}
+			continue
+		}
+
+		// パターンが不正な場合のみエラーとなる
+		var paths []string
+		if paths, err = filepath.Glob(rootDir + pattern); err != nil {
+			return
+		}
+		for _, path := range paths {
+			// procfsのファイルはサイズが0となっているので、読み込んでからサイズを決める
+			// 権限がなくて読めないファイルはスキップする
+			tmpBytes, tmpErr := ioutil.ReadFile(path)
+			if tmpErr != nil {
+				continue
+			}
+			if err = writeTarFile(tarWriter, dir+"/"+strings.TrimPrefix(path, rootDir), tmpBytes, now); err != nil {
+				return
+			}
+		}
+	}
+
+	if err = captureNetns(rootDir, dir, tarWriter, now); err != nil {
+		return
+	}
+
+	for _, pattern := range CaptureEntryPatterns {
+		var paths []string
+		if paths, err = filepath.Glob(rootDir + pattern); err != nil {
+			return
+		}
+		for _, path := range paths {
+			fileInfo, tmpErr := os.Lstat(path)
+			if tmpErr != nil {
+				continue
+			}
+			header := &tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     dir + "/" + strings.TrimPrefix(path, rootDir),
+				Mode:     0644,
+				ModTime:  fileInfo.ModTime(),
+			}
+			if stat, ok := fileInfo.Sys().(*syscall.Stat_t); ok {
+				header.Uid = int(stat.Uid)
+				header.Gid = int(stat.Gid)
+			}
+			if err = tarWriter.WriteHeader(header); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// captureNetns は、GetNetnsStatが読み込むnetnsごとのファイルをCaptureNetnsDirに保存する
+func captureNetns(rootDir string, dir string, tarWriter *tar.Writer, now time.Time) (err error) {
+	if rootDir != "/" {
+		var paths []string
+		if paths, err = filepath.Glob(rootDir + CaptureNetnsDir + "*/proc/net/*"); err != nil {
+			return
+		}
+		for _, path := range paths {
+			tmpBytes, tmpErr := ioutil.ReadFile(path)
+			if tmpErr != nil {
+				continue
+			}
+			if err = writeTarFile(tarWriter, dir+"/"+strings.TrimPrefix(path, rootDir), tmpBytes, now); err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	fileInfos, tmpErr := ioutil.ReadDir(rootDir + NetnsDir)
+	if tmpErr != nil {
+		return
+	}
+	for _, fileInfo := range fileInfos {
+		name := fileInfo.Name()
+		fileMap := map[string][]byte{}
+		// setns(2)できない場合(root権限がないなど)は、スキップする
+		if tmpErr := netlink_utils.RunInNetns(rootDir+NetnsDir+name, func() (err error) {
+			for _, file := range CaptureNetnsFiles {
+				if tmpBytes, tmpErr := ioutil.ReadFile("/proc/thread-self/net/" + file); tmpErr == nil {
+					fileMap[file] = tmpBytes
+				}
+			}
+			return
+		}); tmpErr != nil {
+			continue
+		}
+		for _, file := range CaptureNetnsFiles {
+			if tmpBytes, ok := fileMap[file]; ok {
+				if err = writeTarFile(tarWriter, dir+"/"+CaptureNetnsDir+name+"/proc/net/"+file, tmpBytes, now); err != nil {
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+func captureStatfs(rootDir string) []byte {
+	var lines []string
+	mountsFile, tmpErr := os.Open(rootDir + "proc/self/mounts")
+	if tmpErr != nil {
+		return nil
+	}
+	defer mountsFile.Close()
+	tmpReader := bufio.NewReader(mountsFile)
+	for {
+		tmpBytes, _, tmpErr := tmpReader.ReadLine()
+		if tmpErr != nil {
+			break
+		}
+		splitedLine := strings.Split(string(tmpBytes), " ")
+		if len(splitedLine) < 2 {
+			continue
+		}
+		totalSize, freeSize, files, tmpErr := getStatfs(splitedLine[1])
+		if tmpErr != nil {
+			continue
+		}
+		lines = append(lines, strings.Join([]string{
+			splitedLine[1], strconv.Itoa(totalSize), strconv.Itoa(freeSize), strconv.Itoa(files),
+		}, " "))
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+func readCapturedStatfs(rootDir string) (statfsMap map[string]DiskFsStat) {
+	statfsMap = map[string]DiskFsStat{}
+	tmpBytes, tmpErr := ioutil.ReadFile(rootDir + CaptureStatfsFile)
+	if tmpErr != nil {
+		return
+	}
+	for _, line := range strings.Split(string(tmpBytes), "\n") {
+		columns := str_utils.SplitSpace(line)
+		if len(columns) != 4 {
+			continue
+		}
+		totalSize, _ := strconv.Atoi(columns[1])
+		freeSize, _ := strconv.Atoi(columns[2])
+		files, _ := strconv.Atoi(columns[3])
+		statfsMap[columns[0]] = DiskFsStat{
+			MountPath: columns[0],
+			TotalSize: totalSize,
+			FreeSize:  freeSize,
+			Files:     files,
+		}
+	}
+	return
+}
+
+func writeTarFile(tarWriter *tar.Writer, name string, data []byte, modTime time.Time) (err error) {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+	}
+	if err = tarWriter.WriteHeader(header); err != nil {
+		return
+	}
+	_, err = tarWriter.Write(data)
+	return
+}
+
+// ExtractCapture は、CaptureStatFilesで作成したtar.gzをdstDirに展開する
+func ExtractCapture(srcPath string, dstDir string) (meta *CaptureMeta, err error) {
+	var srcFile *os.File
+	if srcFile, err = os.Open(srcPath); err != nil {
+		return
+	}
+	defer srcFile.Close()
+
+	var gzipReader *gzip.Reader
+	if gzipReader, err = gzip.NewReader(srcFile); err != nil {
+		return
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		var header *tar.Header
+		header, err = tarReader.Next()
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		if err != nil {
+			return
+		}
+
+		// 他ノードから受け取ったファイルを展開するので、dstDirの外に書き込まないようにする
+		name := filepath.Clean(header.Name)
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			err = fmt.Errorf("Unexpected Path: path=%s, name=%s", srcPath, header.Name)
+			return
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		path := filepath.Join(dstDir, name)
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return
+		}
+		var dstFile *os.File
+		if dstFile, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644); err != nil {
+			return
+		}
+		_, err = io.Copy(dstFile, tarReader)
+		dstFile.Close()
+		if err != nil {
+			return
+		}
+
+		// login_userはファイルの所有者と更新日時を利用するので、できるかぎり復元する
+		_ = os.Lchown(path, header.Uid, header.Gid)
+		_ = os.Chtimes(path, header.ModTime, header.ModTime)
+	}
+
+	var metaBytes []byte
+	if metaBytes, err = ioutil.ReadFile(filepath.Join(dstDir, CaptureMetaFile)); err != nil {
+		return
+	}
+	meta = &CaptureMeta{}
+	if err = json.Unmarshal(metaBytes, meta); err != nil {
+		return
+	}
+	return
+}
+
+// AnalyzeCapture は、キャプチャしたスナップショットに対してすべてのcollectorを実行する
+// 差分Statを計算するため、handleStatsは2つ目のスナップショットから呼ばれる
+func AnalyzeCapture(srcPath string, conf *StatControllerConfig) (meta *CaptureMeta, err error) {
+	var tmpDir string
+	if tmpDir, err = ioutil.TempDir("", "os_utils_capture"); err != nil {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if meta, err = ExtractCapture(srcPath, tmpDir); err != nil {
+		return
+	}
+	if meta.Interval < 1 {
+		err = fmt.Errorf("Invalid interval: path=%s, interval=%d", srcPath, meta.Interval)
+		return
+	}
+
+	runnerConf := *conf
+	runnerConf.Config.Interval = meta.Interval
+	if runnerConf.CollectorTimeout == 0 {
+		// オフラインではtickに間に合わせる必要はないので、タイムアウトは長めにする
+		runnerConf.CollectorTimeout = time.Minute
+	}
+	statRunner := newStatRunner(&runnerConf, meta.ClkTck)
+	for _, snapshot := range meta.Snapshots {
+		statRunner.rootDir = filepath.Join(tmpDir, snapshot.Dir) + "/"
+		statRunner.Run(snapshot.CapturedAt)
+	}
+	return
+}
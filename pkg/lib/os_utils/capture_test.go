@@ -0,0 +1,100 @@
+package os_utils
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCaptureStatFiles(t *testing.T) {
+	a := assert.New(t)
+
+	wd, err := os.Getwd()
+	a.NoError(err)
+	rootDir := wd + "/testdata/root/"
+
+	tmpDir := t.TempDir()
+	capturePath := tmpDir + "/node.tar.gz"
+	a.NoError(CaptureStatFiles(rootDir, capturePath, 1, 2, 100))
+
+	meta, err := ExtractCapture(capturePath, tmpDir+"/extracted")
+	a.NoError(err)
+	a.Equal(1, meta.Interval)
+	a.Equal(100, meta.ClkTck)
+	a.Len(meta.Snapshots, 2)
+
+	// 展開したスナップショットに対してcollectorを実行すると、元のディレクトリと同じ結果になる
+	snapshotDir := tmpDir + "/extracted/" + meta.Snapshots[0].Dir + "/"
+
+	expectedProcesses, _, err := GetProcessesWithOption(rootDir, &ProcessOption{IsVerbose: true, IsDeep: true})
+	a.NoError(err)
+	processes, _, err := GetProcessesWithOption(snapshotDir, &ProcessOption{IsVerbose: true, IsDeep: true})
+	a.NoError(err)
+	a.ElementsMatch(ignoreProcessTimestamp(expectedProcesses), ignoreProcessTimestamp(processes))
+
+	expectedFdStat, err := GetFdStat(rootDir)
+	a.NoError(err)
+	fdStat, err := GetFdStat(snapshotDir)
+	a.NoError(err)
+	a.ElementsMatch(expectedFdStat.ProcessFdStats, fdStat.ProcessFdStats)
+	fdStat.ProcessFdStats, expectedFdStat.ProcessFdStats = nil, nil
+	a.Equal(expectedFdStat, fdStat)
+
+	expectedLoadavgStat, err := GetLoadavgStat(rootDir)
+	a.NoError(err)
+	loadavgStat, err := GetLoadavgStat(snapshotDir)
+	a.NoError(err)
+	a.Equal(expectedLoadavgStat, loadavgStat)
+
+	expectedNetnsStat, err := GetNetnsStat(rootDir)
+	a.NoError(err)
+	netnsStat, err := GetNetnsStat(snapshotDir)
+	a.NoError(err)
+	a.Equal(expectedNetnsStat, netnsStat)
+
+	expectedDiskStat, err := GetDiskStat(rootDir)
+	a.NoError(err)
+	diskStat, err := GetDiskStat(snapshotDir)
+	a.NoError(err)
+	a.Equal(expectedDiskStat, diskStat)
+
+	expectedConntrackStat, err := GetConntrackStat(rootDir)
+	a.NoError(err)
+	conntrackStat, err := GetConntrackStat(snapshotDir)
+	a.NoError(err)
+	a.Equal(expectedConntrackStat, conntrackStat)
+
+	expectedSensorStat, err := GetSensorStat(rootDir)
+	a.NoError(err)
+	sensorStat, err := GetSensorStat(snapshotDir)
+	a.NoError(err)
+	a.Equal(expectedSensorStat, sensorStat)
+
+	// 差分Statを計算するため、handleStatsは2つ目のスナップショットからのみ呼ばれる
+	var handledAts []time.Time
+	_, err = AnalyzeCapture(capturePath, &StatControllerConfig{
+		HandleStats: func(runAt time.Time, stats *Stats) {
+			handledAts = append(handledAts, runAt)
+			a.NotNil(stats.LoadavgStat)
+			a.NotNil(stats.FdStat)
+		},
+	})
+	a.NoError(err)
+	a.Len(handledAts, 1)
+	a.True(handledAts[0].Equal(meta.Snapshots[1].CapturedAt))
+
+	{
+		// キャプチャファイルがない
+		_, err := AnalyzeCapture(tmpDir+"/none.tar.gz", &StatControllerConfig{})
+		a.Error(err)
+	}
+}
+
+func ignoreProcessTimestamp(processes []Process) []Process {
+	for i := range processes {
+		processes[i].Stat.Timestamp = time.Time{}
+	}
+	return processes
+}
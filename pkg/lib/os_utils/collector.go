@@ -0,0 +1,252 @@
+package os_utils
+
+import (
+	"fmt"
+	"time"
+)
+
+// collectorのタイムアウトのデフォルトは、Intervalにこの割合をかけたもの
+const DefaultCollectorTimeoutRatio = 0.8
+
+const (
+	CollectorCpu       = "cpu"
+	CollectorMem       = "mem"
+	CollectorDisk      = "disk"
+	CollectorNet       = "net"
+	CollectorNetns     = "netns"
+	CollectorProcess   = "process"
+	CollectorLoginUser = "loginuser"
+	CollectorUptime    = "uptime"
+	CollectorLoadavg   = "loadavg"
+	CollectorFd        = "fd"
+	CollectorSensor    = "sensor"
+	CollectorKmsg      = "kmsg"
+	CollectorSlab      = "slab"
+	CollectorZone      = "zone"
+	CollectorConntrack = "conntrack"
+)
+
+// CollectorNames は、StatControllerConfig.Collectorsで指定できるcollectorの一覧
+var CollectorNames = []string{
+	CollectorCpu, CollectorMem, CollectorDisk, CollectorNet, CollectorNetns, CollectorProcess,
+	CollectorLoginUser, CollectorUptime, CollectorLoadavg, CollectorFd, CollectorSensor, CollectorKmsg,
+	CollectorSlab, CollectorZone, CollectorConntrack,
+}
+
+// CollectorStat は、collector自身のメトリクス
+type CollectorStat struct {
+	Name          string
+	Duration      time.Duration // 直近の収集にかかった時間
+	Errors        int           // 累計のエラー回数(タイムアウトも含む)
+	Timeouts      int           // 累計のタイムアウト回数
+	NewErrors     int           // 今回の収集で増えたエラー回数
+	LastError     string
+	IsStale       bool // 今回は収集できず、Statsには前回の値が入っている
+	LastSuccessAt time.Time
+}
+
+type statCollector struct {
+	name string
+	// collectは別goroutineで実行されるので、StatRunnerの状態を変更せずに読み込みのみを行う
+	// 返したsyncは、Runのgoroutineで順に実行される
+	collect func(rootDir string) (sync func(), err error)
+	// 読み込んだデータを再度読めないもの(kmsgなど)は、タイムアウトした結果も次回に反映する
+	stateful bool
+}
+
+type collectResult struct {
+	sync     func()
+	err      error
+	duration time.Duration
+}
+
+type collectorState struct {
+	stat CollectorStat
+	// 実行中のcollectorの結果を受け取るchannel(タイムアウトしたものは次回以降に回収する)
+	resultCh chan collectResult
+}
+
+func (self *StatRunner) initCollectors(conf *StatControllerConfig) {
+	self.collectors = []statCollector{
+		{name: CollectorCpu, collect: func(rootDir string) (func(), error) {
+			stat, err := GetCpuStat(rootDir)
+			return func() { self.syncCpuStat(stat) }, err
+		}},
+		{name: CollectorMem, collect: func(rootDir string) (func(), error) {
+			stat, err := GetMemStat(rootDir)
+			return func() { self.syncMemStat(stat) }, err
+		}},
+		{name: CollectorDisk, collect: func(rootDir string) (func(), error) {
+			stat, err := GetDiskStat(rootDir)
+			return func() { self.syncDiskStat(stat) }, err
+		}},
+		{name: CollectorProcess, collect: func(rootDir string) (func(), error) {
+			processes, pidIndexMap, err := self.processReader.GetProcesses(rootDir)
+			return func() { self.syncProcessStat(processes, pidIndexMap) }, err
+		}},
+		{name: CollectorNet, collect: func(rootDir string) (func(), error) {
+			stat, err := GetNetStat(rootDir)
+			return func() { self.syncNetStat(stat) }, err
+		}},
+		{name: CollectorNetns, collect: func(rootDir string) (func(), error) {
+			stat, err := GetNetnsStat(rootDir)
+			return func() { self.syncNetnsStat(stat) }, err
+		}},
+		{name: CollectorLoginUser, collect: func(rootDir string) (func(), error) {
+			stat, err := GetLoginUserStat(rootDir)
+			return func() { self.syncLoginUserStat(stat) }, err
+		}},
+		{name: CollectorUptime, collect: func(rootDir string) (func(), error) {
+			stat, err := GetUptimeStat(rootDir)
+			return func() { self.syncUptimeStat(stat) }, err
+		}},
+		{name: CollectorLoadavg, collect: func(rootDir string) (func(), error) {
+			stat, err := GetLoadavgStat(rootDir)
+			return func() { self.syncLoadavgStat(stat) }, err
+		}},
+		{name: CollectorFd, collect: func(rootDir string) (func(), error) {
+			stat, err := GetFdStat(rootDir)
+			return func() { self.syncFdStat(stat) }, err
+		}},
+		{name: CollectorSensor, collect: func(rootDir string) (func(), error) {
+			stat, err := GetSensorStat(rootDir)
+			return func() { self.syncSensorStat(stat) }, err
+		}},
+		{name: CollectorKmsg, collect: func(rootDir string) (func(), error) {
+			// kmsgReaderは、このcollectorのgoroutineからのみ利用する
+			if self.kmsgReader == nil || self.kmsgReader.rootDir != rootDir {
+				if self.kmsgReader != nil {
+					self.kmsgReader.Close()
+				}
+				self.kmsgReader = NewKmsgReader(rootDir)
+			}
+			stat, err := self.kmsgReader.GetKmsgStat()
+			return func() { self.syncKmsgStat(stat) }, err
+		}, stateful: true},
+		{name: CollectorSlab, collect: func(rootDir string) (func(), error) {
+			stat, err := GetSlabStat(rootDir)
+			return func() { self.syncSlabStat(stat) }, err
+		}},
+		{name: CollectorZone, collect: func(rootDir string) (func(), error) {
+			stat, err := GetZoneStat(rootDir)
+			return func() { self.syncZoneStat(stat) }, err
+		}},
+		{name: CollectorConntrack, collect: func(rootDir string) (func(), error) {
+			stat, err := GetConntrackStat(rootDir)
+			return func() { self.syncConntrackStat(stat) }, err
+		}},
+	}
+
+	if len(conf.Collectors) > 0 {
+		enabled := map[string]bool{}
+		for _, name := range conf.Collectors {
+			enabled[name] = true
+		}
+		var collectors []statCollector
+		for _, collector := range self.collectors {
+			if enabled[collector.name] {
+				collectors = append(collectors, collector)
+			}
+		}
+		self.collectors = collectors
+	}
+
+	self.collectorStates = map[string]*collectorState{}
+	for _, collector := range self.collectors {
+		self.collectorStates[collector.name] = &collectorState{
+			stat: CollectorStat{Name: collector.name},
+		}
+	}
+
+	self.collectorTimeout = conf.CollectorTimeout
+	if self.collectorTimeout == 0 {
+		self.collectorTimeout = time.Duration(float64(time.Duration(conf.Config.Interval)*time.Second) * DefaultCollectorTimeoutRatio)
+	}
+	self.collectorTimeouts = conf.CollectorTimeouts
+}
+
+// runCollectors は、collectorを並列に実行し、タイムアウトまでに終わったものの結果を反映する
+func (self *StatRunner) runCollectors(runAt time.Time) {
+	startAt := time.Now()
+	rootDir := self.rootDir
+
+	startErrors := map[string]int{}
+	defer func() {
+		for name, state := range self.collectorStates {
+			state.stat.NewErrors = state.stat.Errors - startErrors[name]
+		}
+	}()
+
+	var runningCollectors []statCollector
+	for _, collector := range self.collectors {
+		state := self.collectorStates[collector.name]
+		startErrors[collector.name] = state.stat.Errors
+		if state.resultCh != nil {
+			select {
+			case result := <-state.resultCh:
+				// 前回タイムアウトしたものの結果は古いので捨てる
+				// ただし、statefulなものは読み込んだデータが失われるので反映する
+				state.resultCh = nil
+				if collector.stateful && result.err == nil {
+					result.sync()
+				}
+			default:
+				// 前回のものがまだ終わっていないので、goroutineが溜まらないように今回は実行しない
+				state.stat.Errors += 1
+				state.stat.Timeouts += 1
+				state.stat.LastError = "previous collection is still running"
+				state.stat.IsStale = true
+				continue
+			}
+		}
+
+		resultCh := make(chan collectResult, 1)
+		state.resultCh = resultCh
+		go func(collector statCollector) {
+			collectStartAt := time.Now()
+			var result collectResult
+			defer func() {
+				if tmpErr := recover(); tmpErr != nil {
+					result.sync = nil
+					result.err = fmt.Errorf("Panic: collector=%s, err=%v", collector.name, tmpErr)
+				}
+				result.duration = time.Since(collectStartAt)
+				resultCh <- result
+			}()
+			result.sync, result.err = collector.collect(rootDir)
+		}(collector)
+		runningCollectors = append(runningCollectors, collector)
+	}
+
+	for _, collector := range runningCollectors {
+		state := self.collectorStates[collector.name]
+		timeout := self.collectorTimeout
+		if collectorTimeout, ok := self.collectorTimeouts[collector.name]; ok {
+			timeout = collectorTimeout
+		}
+
+		timer := time.NewTimer(time.Until(startAt.Add(timeout)))
+		select {
+		case result := <-state.resultCh:
+			timer.Stop()
+			state.resultCh = nil
+			state.stat.Duration = result.duration
+			if result.err != nil {
+				state.stat.Errors += 1
+				state.stat.LastError = result.err.Error()
+				state.stat.IsStale = true
+				continue
+			}
+			result.sync()
+			state.stat.LastError = ""
+			state.stat.IsStale = false
+			state.stat.LastSuccessAt = runAt
+		case <-timer.C:
+			state.stat.Duration = timeout
+			state.stat.Errors += 1
+			state.stat.Timeouts += 1
+			state.stat.LastError = fmt.Sprintf("timeout: %s", timeout)
+			state.stat.IsStale = true
+		}
+	}
+}
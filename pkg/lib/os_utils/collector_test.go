@@ -0,0 +1,122 @@
+package os_utils
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunCollectors(t *testing.T) {
+	a := assert.New(t)
+
+	statRunner := newStatRunner(&StatControllerConfig{
+		RootDir:          "/",
+		CollectorTimeout: 100 * time.Millisecond,
+	}, 100)
+
+	var synced []string
+	blockCh := make(chan bool)
+	defer close(blockCh)
+	statRunner.collectors = []statCollector{
+		{name: "ok", collect: func(rootDir string) (func(), error) {
+			return func() { synced = append(synced, "ok") }, nil
+		}},
+		{name: "error", collect: func(rootDir string) (func(), error) {
+			return nil, errors.New("read error")
+		}},
+		{name: "panic", collect: func(rootDir string) (func(), error) {
+			panic("unexpected format")
+		}},
+		{name: "hang", collect: func(rootDir string) (func(), error) {
+			<-blockCh
+			return func() { synced = append(synced, "hang") }, nil
+		}},
+	}
+	statRunner.collectorStates = map[string]*collectorState{}
+	for _, collector := range statRunner.collectors {
+		statRunner.collectorStates[collector.name] = &collectorState{stat: CollectorStat{Name: collector.name}}
+	}
+
+	runAt := time.Now()
+	startAt := time.Now()
+	statRunner.runCollectors(runAt)
+	// 1つのcollectorがブロックしても、タイムアウトで打ち切られる
+	a.True(time.Since(startAt) < time.Second)
+	a.Equal([]string{"ok"}, synced)
+
+	okStat := statRunner.collectorStates["ok"].stat
+	a.False(okStat.IsStale)
+	a.Equal(0, okStat.Errors)
+	a.Equal(runAt, okStat.LastSuccessAt)
+
+	errorStat := statRunner.collectorStates["error"].stat
+	a.True(errorStat.IsStale)
+	a.Equal(1, errorStat.Errors)
+	a.Equal(1, errorStat.NewErrors)
+	a.Equal("read error", errorStat.LastError)
+
+	panicStat := statRunner.collectorStates["panic"].stat
+	a.True(panicStat.IsStale)
+	a.Contains(panicStat.LastError, "Panic: collector=panic")
+
+	hangStat := statRunner.collectorStates["hang"].stat
+	a.True(hangStat.IsStale)
+	a.Equal(1, hangStat.Timeouts)
+
+	{
+		// 前回のものが終わっていない場合は、新たに実行しない
+		statRunner.runCollectors(time.Now())
+		hangStat := statRunner.collectorStates["hang"].stat
+		a.Equal(2, hangStat.Timeouts)
+		a.Equal("previous collection is still running", hangStat.LastError)
+		a.Equal([]string{"ok", "ok"}, synced)
+
+		// メトリクスのエラー回数は累計ではなく、今回の収集で増えた回数
+		errorStat := statRunner.collectorStates["error"].stat
+		a.Equal(2, errorStat.Errors)
+		a.Equal(1, errorStat.NewErrors)
+		a.Equal(0, statRunner.collectorStates["ok"].stat.NewErrors)
+		metrics := GetStatsMetrics(&Stats{CollectorStats: []CollectorStat{errorStat}})
+		a.Equal(float64(1), metrics["collector.error.errors"])
+	}
+}
+
+func TestRunCollectorsStateful(t *testing.T) {
+	a := assert.New(t)
+
+	rootDir := t.TempDir() + "/"
+	a.NoError(os.Mkdir(rootDir+"dev", 0755))
+	a.NoError(ioutil.WriteFile(rootDir+"dev/kmsg", []byte("3,1,100,-;INFO: task qemu:10 blocked for more than 120 seconds.\n"), 0644))
+
+	statRunner := newStatRunner(&StatControllerConfig{
+		RootDir:          rootDir,
+		Collectors:       []string{CollectorKmsg},
+		CollectorTimeout: 100 * time.Millisecond,
+	}, 100)
+	defer func() { statRunner.kmsgReader.Close() }()
+
+	// kmsgを読み込んだ後に遅延させる
+	blockCh := make(chan bool)
+	collect := statRunner.collectors[0].collect
+	statRunner.collectors[0].collect = func(rootDir string) (func(), error) {
+		sync, err := collect(rootDir)
+		<-blockCh
+		return sync, err
+	}
+
+	statRunner.runCollectors(time.Now())
+	a.Equal(1, statRunner.collectorStates[CollectorKmsg].stat.Timeouts)
+	a.Nil(statRunner.currentKmsgStat)
+
+	// タイムアウトした結果も、次回に反映される
+	close(blockCh)
+	time.Sleep(100 * time.Millisecond)
+	statRunner.runCollectors(time.Now())
+	a.Len(statRunner.currentKmsgStat.Events, 1)
+	a.Equal(KmsgEventHungTask, statRunner.currentKmsgStat.Events[0].Type)
+	a.Equal(1, statRunner.currentKmsgStat.EventCounts[KmsgEventHungTask])
+}
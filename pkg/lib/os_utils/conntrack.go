@@ -0,0 +1,122 @@
+package os_utils
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/syunkitada/goapp2/pkg/lib/str_utils"
+)
+
+// conntrackのエントリの使用率がこの割合(%)を超えた場合は、IsNearLimitとしてフラグを立てる
+// 上限に達すると、新しい接続のパケットは何も通知されずに破棄される
+const ConntrackNearLimitUtil = 80
+
+type ConntrackStat struct {
+	Count       int
+	Max         int
+	Util        int // %
+	IsNearLimit bool
+
+	// 全CPUの合計
+	InsertFailed  int
+	Drop          int
+	EarlyDrop     int
+	SearchRestart int
+
+	CpuStats []ConntrackCpuStat
+
+	// 差分Stat
+	InsertFailedPerSec  int
+	DropPerSec          int
+	EarlyDropPerSec     int
+	SearchRestartPerSec int
+}
+
+type ConntrackCpuStat struct {
+	Cpu           int
+	InsertFailed  int
+	Drop          int
+	EarlyDrop     int
+	SearchRestart int
+
+	// 差分Stat
+	InsertFailedPerSec  int
+	DropPerSec          int
+	EarlyDropPerSec     int
+	SearchRestartPerSec int
+}
+
+// GetConntrackStat は、conntrackのテーブルの使用状況を取得する
+// nf_conntrackモジュールがロードされていない場合は、conntrackStatはnilとなる
+func GetConntrackStat(rootDir string) (conntrackStat *ConntrackStat, err error) {
+	// $ cat /proc/sys/net/netfilter/nf_conntrack_count
+	// 1234
+	// $ cat /proc/sys/net/netfilter/nf_conntrack_max
+	// 262144
+	var tmpBytes []byte
+	var tmpErr error
+	if tmpBytes, tmpErr = ioutil.ReadFile(rootDir + "proc/sys/net/netfilter/nf_conntrack_count"); tmpErr != nil {
+		return
+	}
+	count, _ := strconv.Atoi(strings.TrimSpace(string(tmpBytes)))
+	if tmpBytes, err = ioutil.ReadFile(rootDir + "proc/sys/net/netfilter/nf_conntrack_max"); err != nil {
+		return
+	}
+	max, _ := strconv.Atoi(strings.TrimSpace(string(tmpBytes)))
+
+	conntrackStat = &ConntrackStat{
+		Count: count,
+		Max:   max,
+	}
+	if max > 0 {
+		conntrackStat.Util = count * 100 / max
+	}
+	conntrackStat.IsNearLimit = conntrackStat.Util >= ConntrackNearLimitUtil
+
+	// 1行目がヘッダで、以降はCPUごとの値(16進数)
+	// カーネルのバージョンによって列が異なるので、ヘッダの名前から列を決める
+	// $ cat /proc/net/stat/nf_conntrack
+	// entries  clashres found new invalid ignore delete chainlength insert insert_failed drop early_drop icmp_error  expect_new expect_create expect_delete search_restart
+	// 00000000  00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000  00000000 00000000 00000000 00000000
+	var statFile *os.File
+	if statFile, err = os.Open(rootDir + "proc/net/stat/nf_conntrack"); err != nil {
+		return
+	}
+	defer statFile.Close()
+	scanner := bufio.NewScanner(statFile)
+	columnIndexMap := map[string]int{}
+	if scanner.Scan() {
+		for i, name := range str_utils.SplitSpace(strings.TrimSpace(scanner.Text())) {
+			columnIndexMap[name] = i
+		}
+	}
+	getValue := func(columns []string, name string) (value int) {
+		index, ok := columnIndexMap[name]
+		if !ok || index >= len(columns) {
+			return
+		}
+		tmpValue, _ := strconv.ParseInt(columns[index], 16, 64)
+		value = int(tmpValue)
+		return
+	}
+	for cpu := 0; scanner.Scan(); cpu++ {
+		columns := str_utils.SplitSpace(strings.TrimSpace(scanner.Text()))
+		cpuStat := ConntrackCpuStat{
+			Cpu:           cpu,
+			InsertFailed:  getValue(columns, "insert_failed"),
+			Drop:          getValue(columns, "drop"),
+			EarlyDrop:     getValue(columns, "early_drop"),
+			SearchRestart: getValue(columns, "search_restart"),
+		}
+		conntrackStat.InsertFailed += cpuStat.InsertFailed
+		conntrackStat.Drop += cpuStat.Drop
+		conntrackStat.EarlyDrop += cpuStat.EarlyDrop
+		conntrackStat.SearchRestart += cpuStat.SearchRestart
+		conntrackStat.CpuStats = append(conntrackStat.CpuStats, cpuStat)
+	}
+	err = scanner.Err()
+	return
+}
@@ -0,0 +1,53 @@
+package os_utils
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetConntrackStat(t *testing.T) {
+	a := assert.New(t)
+
+	wd, err := os.Getwd()
+	a.NoError(err)
+	rootDir := wd + "/testdata/root/"
+
+	conntrackStat, err := GetConntrackStat(rootDir)
+	a.NoError(err)
+
+	a.Equal(209716, conntrackStat.Count)
+	a.Equal(262144, conntrackStat.Max)
+	a.Equal(80, conntrackStat.Util)
+	a.True(conntrackStat.IsNearLimit)
+	a.Equal(3, conntrackStat.InsertFailed)
+	a.Equal(7, conntrackStat.Drop)
+	a.Equal(1, conntrackStat.EarlyDrop)
+	a.Equal(48, conntrackStat.SearchRestart)
+	a.Equal([]ConntrackCpuStat{
+		{Cpu: 0, InsertFailed: 2, Drop: 3, EarlyDrop: 1, SearchRestart: 16},
+		{Cpu: 1, InsertFailed: 1, Drop: 4, SearchRestart: 32},
+	}, conntrackStat.CpuStats)
+
+	{
+		// 前回からの増加量
+		statRunner := &StatRunner{interval: 2}
+		statRunner.syncConntrackStat(conntrackStat)
+		conntrackStat2, err := GetConntrackStat(rootDir)
+		a.NoError(err)
+		conntrackStat2.Drop += 10
+		conntrackStat2.CpuStats[1].Drop += 10
+		statRunner.syncConntrackStat(conntrackStat2)
+		a.Equal(5, conntrackStat2.DropPerSec)
+		a.Equal(0, conntrackStat2.CpuStats[0].DropPerSec)
+		a.Equal(5, conntrackStat2.CpuStats[1].DropPerSec)
+	}
+
+	{
+		// nf_conntrackがロードされていない
+		conntrackStat, err := GetConntrackStat(wd + "/testdata/none/")
+		a.NoError(err)
+		a.Nil(conntrackStat)
+	}
+}
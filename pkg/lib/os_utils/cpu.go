@@ -55,7 +55,7 @@ type CpuStat struct {
 	CpuProcessorStats []CpuProcessorStat
 }
 
-func GetCpuStat() (cpuStat *CpuStat, err error) {
+func GetCpuStat(rootDir string) (cpuStat *CpuStat, err error) {
 	var tmpReader *bufio.Reader
 	timestamp := time.Now()
 
@@ -63,7 +63,7 @@ func GetCpuStat() (cpuStat *CpuStat, err error) {
 
 	// Read /proc/cpuinfo
 	var cpuinfo *os.File
-	if cpuinfo, err = os.Open("/proc/cpuinfo"); err != nil {
+	if cpuinfo, err = os.Open(rootDir + "proc/cpuinfo"); err != nil {
 		return
 	}
 	defer cpuinfo.Close()
@@ -97,6 +97,10 @@ func GetCpuStat() (cpuStat *CpuStat, err error) {
 				if len(splited) < 1 {
 					break
 				}
+				// "power management:" のように値がない行もある
+				if len(splited) < 2 {
+					continue
+				}
 				cpuinfo[splited[0]] = splited[1]
 			}
 
@@ -127,7 +131,7 @@ func GetCpuStat() (cpuStat *CpuStat, err error) {
 	// procs_blocked 0
 	// softirq 11650881 ...
 
-	f, _ := os.Open("/proc/stat")
+	f, _ := os.Open(rootDir + "proc/stat")
 	defer f.Close()
 	tmpReader = bufio.NewReader(f)
 
@@ -182,7 +186,7 @@ func GetCpuStat() (cpuStat *CpuStat, err error) {
 	//    0:         35          0          0          0          0          0          0          0          0          0          0          0  IR-IO-APIC    2-edge      timer
 	//    7:          0          0          0          0          0          0          0          0          0          0          0          0  IR-IO-APIC    7-fasteoi   pinctrl_amd
 	//    8:          0          0          0          0          0          1          0          0          0          0          0          0  IR-IO-APIC    8-edge      rtc0
-	interruptsFile, _ := os.Open("/proc/interrupts")
+	interruptsFile, _ := os.Open(rootDir + "proc/interrupts")
 	tmpReader = bufio.NewReader(interruptsFile)
 	_, _, _ = tmpReader.ReadLine() // CPUの行はスキップする
 	for {
@@ -191,8 +195,12 @@ func GetCpuStat() (cpuStat *CpuStat, err error) {
 			break
 		}
 		splitedIntr := str_utils.SplitSpace(string(tmpBytes))
+		if len(splitedIntr) < 2 {
+			continue
+		}
 		irqNumber := splitedIntr[0][0 : len(splitedIntr[0])-1]
-		if len(splitedIntr) > lenCpus {
+		// "ERR:      0" のようにCPUごとの値やデバイス名がない行もある
+		if len(splitedIntr) > lenCpus+2 {
 			for i := 0; i < lenCpus; i++ {
 				intr, _ := strconv.Atoi(splitedIntr[i+1])
 				cpuProcessorStats[i].Interrupts[irqNumber] = Interrupt{
@@ -202,7 +210,7 @@ func GetCpuStat() (cpuStat *CpuStat, err error) {
 				}
 			}
 		} else {
-			for i := 0; i < len(splitedIntr)-1; i++ {
+			for i := 0; i < len(splitedIntr)-1 && i < lenCpus; i++ {
 				intr, _ := strconv.Atoi(splitedIntr[i+1])
 				cpuProcessorStats[i].Interrupts[irqNumber] = Interrupt{
 					Interrupt: intr,
@@ -2,7 +2,9 @@ package os_utils
 
 import (
 	"bufio"
+	"io/ioutil"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -17,6 +19,12 @@ type DiskStat struct {
 
 type DiskDeviceStat struct {
 	PblockSize        int
+	Rotational        bool   // queue/rotational
+	Scheduler         string // queue/schedulerの[]で囲まれたもの
+	QueueDepth        int    // queue/nr_requests
+	Slaves            []string
+	Holders           []string
+	Partitions        []string
 	ReadsCompleted    int
 	ReadsMerges       int
 	ReadSectors       int
@@ -32,6 +40,8 @@ type DiskDeviceStat struct {
 	DiscardsMerges    int
 	DiscardSectors    int
 	DiscardMs         int
+	FlushesCompleted  int
+	FlushMs           int
 
 	ReadsPerSec         int
 	RmergesPerSec       int
@@ -45,6 +55,8 @@ type DiskDeviceStat struct {
 	DmergesPerSec       int
 	DiscardBytesPerSec  int
 	DiscardMsPerSec     int
+	FlushesPerSec       int
+	FlushMsPerSec       int
 	IosMsPerSec         int
 	WeightedIosMsPerSec int
 }
@@ -57,9 +69,14 @@ type DiskFsStat struct {
 	FreeSize  int
 	UsedSize  int
 	Files     int
+
+	// マウントしているブロックデバイス(sda1, dm-0など)から、その下のデバイスをたどったもの
+	// 例: [sda1 sda], [dm-0 sda2 sda], [md0 sdb1 sdc1 sdb sdc]
+	// ブロックデバイスでないファイルシステム(tmpfsなど)の場合は空
+	Devices []string
 }
 
-func GetDiskStat() (diskStat *DiskStat, err error) {
+func GetDiskStat(rootDir string) (diskStat *DiskStat, err error) {
 	// Read /proc/diskstats
 
 	// 259       0 nvme0n1 94360 70783 6403078 67950 136558 90723 6419592 38105 0 97140 59208 0 0 0 0
@@ -81,9 +98,16 @@ func GetDiskStat() (diskStat *DiskStat, err error) {
 	// Field 13 -- # of discards merged
 	// Field 14 -- # of sectors discarded
 	// Field 15 -- # of milliseconds spent discarding
+	// Field 16 -- # of flush requests completed successfully
+	// Field 17 -- # of milliseconds spent flushing
+
+	// カーネルのバージョンによりフィールドの数が異なる
+	// 4.18未満はField 11まで、4.18からField 15まで、5.5からField 17まで
 	diskDeviceStatMap := map[string]DiskDeviceStat{}
+	// mountinfoの[major:minor]からデバイス名を引くためのもの(パーティションも含む)
+	devNameMap := map[string]string{}
 
-	f, _ := os.Open("/proc/diskstats")
+	f, _ := os.Open(rootDir + "proc/diskstats")
 	defer f.Close()
 	tmpReader := bufio.NewReader(f)
 	for {
@@ -92,8 +116,12 @@ func GetDiskStat() (diskStat *DiskStat, err error) {
 			break
 		}
 		columns := str_utils.SplitSpace(string(tmpBytes))
+		if len(columns) < 14 {
+			continue
+		}
+		devNameMap[columns[0]+":"+columns[1]] = columns[2]
 
-		pblockSizeFile, tmpErr := os.Open("/sys/block/" + columns[2] + "/queue/physical_block_size")
+		pblockSizeFile, tmpErr := os.Open(rootDir + "sys/block/" + columns[2] + "/queue/physical_block_size")
 		if tmpErr != nil {
 			continue
 		}
@@ -118,10 +146,19 @@ func GetDiskStat() (diskStat *DiskStat, err error) {
 		iosMs, _ := strconv.Atoi(columns[12])
 		weightedIosMs, _ := strconv.Atoi(columns[13])
 
-		discardsCompleted, _ := strconv.Atoi(columns[14])
-		discardsMerges, _ := strconv.Atoi(columns[15])
-		discardSectors, _ := strconv.Atoi(columns[16])
-		discardMs, _ := strconv.Atoi(columns[17])
+		var discardsCompleted, discardsMerges, discardSectors, discardMs int
+		if len(columns) >= 18 {
+			discardsCompleted, _ = strconv.Atoi(columns[14])
+			discardsMerges, _ = strconv.Atoi(columns[15])
+			discardSectors, _ = strconv.Atoi(columns[16])
+			discardMs, _ = strconv.Atoi(columns[17])
+		}
+
+		var flushesCompleted, flushMs int
+		if len(columns) >= 20 {
+			flushesCompleted, _ = strconv.Atoi(columns[18])
+			flushMs, _ = strconv.Atoi(columns[19])
+		}
 
 		diskDeviceStatMap[columns[2]] = DiskDeviceStat{
 			PblockSize:        pblockSize,
@@ -140,39 +177,54 @@ func GetDiskStat() (diskStat *DiskStat, err error) {
 			DiscardsMerges:    discardsMerges,
 			DiscardSectors:    discardSectors,
 			DiscardMs:         discardMs,
+			FlushesCompleted:  flushesCompleted,
+			FlushMs:           flushMs,
 		}
 	}
 
-	// read /proc/self/mounts
-	// MEMO: /etc/mtab is symbolic link to /proc/self/mounts
-	diskFsStatMap := map[string]DiskFsStat{}
-	mountsFile, _ := os.Open("/proc/self/mounts")
-	defer mountsFile.Close()
-	tmpReader = bufio.NewReader(mountsFile)
-	var splitedLine []string
-	var tmpBytes []byte
-	var tmpErr error
-	for {
-		tmpBytes, _, tmpErr = tmpReader.ReadLine()
-		if tmpErr != nil {
-			break
+	// パーティションは/sys/block/[disk]/[partition]/にあるので、ディスクとの対応をここで作る
+	partitionParentMap := map[string]string{}
+	for name, stat := range diskDeviceStatMap {
+		readBlockTopology(rootDir+"sys/block/"+name+"/", &stat)
+		for _, partition := range stat.Partitions {
+			partitionParentMap[partition] = name
 		}
-		splitedLine = strings.Split(string(tmpBytes), " ")
-		var statfs syscall.Statfs_t
-		if tmpErr = syscall.Statfs(splitedLine[1], &statfs); tmpErr != nil {
+		diskDeviceStatMap[name] = stat
+	}
+
+	diskFsStatMap := map[string]DiskFsStat{}
+	var capturedStatfsMap map[string]DiskFsStat
+	if rootDir != "/" {
+		// スナップショットの場合は、キャプチャ時に保存したstatfs(2)の結果を利用する
+		capturedStatfsMap = readCapturedStatfs(rootDir)
+	}
+	for _, mount := range readMounts(rootDir) {
+		var totalSize, freeSize, files int
+		var tmpErr error
+		if capturedStatfsMap != nil {
+			captured, ok := capturedStatfsMap[mount.mountPath]
+			if !ok {
+				continue
+			}
+			totalSize, freeSize, files = captured.TotalSize, captured.FreeSize, captured.Files
+		} else if totalSize, freeSize, files, tmpErr = getStatfs(mount.mountPath); tmpErr != nil {
 			continue
 		}
-		totalSize := int(statfs.Blocks) * int(statfs.Bsize)
-		freeSize := int(statfs.Bavail) * int(statfs.Bsize)
 
-		diskFsStatMap[splitedLine[0]] = DiskFsStat{
-			Path:      splitedLine[0],
-			MountPath: splitedLine[1],
-			Type:      splitedLine[2],
+		var devices []string
+		if devName, ok := devNameMap[mount.majorMinor]; ok {
+			devices = getDeviceChain(devName, diskDeviceStatMap, partitionParentMap)
+		}
+
+		diskFsStatMap[mount.path] = DiskFsStat{
+			Path:      mount.path,
+			MountPath: mount.mountPath,
+			Type:      mount.fsType,
 			TotalSize: totalSize,
 			FreeSize:  freeSize,
 			UsedSize:  totalSize - freeSize,
-			Files:     int(statfs.Files),
+			Files:     files,
+			Devices:   devices,
 		}
 	}
 
@@ -182,3 +234,152 @@ func GetDiskStat() (diskStat *DiskStat, err error) {
 	}
 	return
 }
+
+func getStatfs(mountPath string) (totalSize int, freeSize int, files int, err error) {
+	var statfs syscall.Statfs_t
+	if err = syscall.Statfs(mountPath, &statfs); err != nil {
+		return
+	}
+	totalSize = int(statfs.Blocks) * int(statfs.Bsize)
+	freeSize = int(statfs.Bavail) * int(statfs.Bsize)
+	files = int(statfs.Files)
+	return
+}
+
+// BackingDevice は、ファイルシステムのIOの統計を表示するためのデバイスを返す
+// Devicesのうち、DiskDeviceStatMapにある最初のもの(パーティションの場合はディスク)とする
+func (self *DiskStat) BackingDevice(fsStat *DiskFsStat) (name string, deviceStat DiskDeviceStat, ok bool) {
+	for _, name = range fsStat.Devices {
+		if deviceStat, ok = self.DiskDeviceStatMap[name]; ok {
+			return
+		}
+	}
+	name = ""
+	return
+}
+
+type mountEntry struct {
+	path       string
+	mountPath  string
+	fsType     string
+	majorMinor string // mountsから読み込んだ場合は空
+}
+
+// readMounts は、/proc/self/mountinfoからマウントの一覧を読み込む
+// mountinfoがない場合(古いキャプチャなど)は、/proc/self/mountsを読み込む
+func readMounts(rootDir string) (mounts []mountEntry) {
+	// $ cat /proc/self/mountinfo
+	// 36 35 98:0 /mnt1 /mnt/parent rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+	// [mount id] [parent id] [major:minor] [root] [mount point] [options] [optional fields...] - [fs type] [source] [super options]
+	if mountinfoFile, err := os.Open(rootDir + "proc/self/mountinfo"); err == nil {
+		defer mountinfoFile.Close()
+		scanner := bufio.NewScanner(mountinfoFile)
+		for scanner.Scan() {
+			columns := strings.Split(scanner.Text(), " ")
+			separatorIndex := -1
+			for i := 6; i < len(columns); i++ {
+				if columns[i] == "-" {
+					separatorIndex = i
+					break
+				}
+			}
+			if separatorIndex < 0 || len(columns) < separatorIndex+3 {
+				continue
+			}
+			mounts = append(mounts, mountEntry{
+				path:       columns[separatorIndex+2],
+				mountPath:  columns[4],
+				fsType:     columns[separatorIndex+1],
+				majorMinor: columns[2],
+			})
+		}
+		return
+	}
+
+	// $ cat /proc/self/mounts
+	// /dev/sda1 / ext4 rw,relatime 0 0
+	// MEMO: /etc/mtab is symbolic link to /proc/self/mounts
+	mountsFile, err := os.Open(rootDir + "proc/self/mounts")
+	if err != nil {
+		return
+	}
+	defer mountsFile.Close()
+	scanner := bufio.NewScanner(mountsFile)
+	for scanner.Scan() {
+		columns := strings.Split(scanner.Text(), " ")
+		if len(columns) < 3 {
+			continue
+		}
+		mounts = append(mounts, mountEntry{
+			path:      columns[0],
+			mountPath: columns[1],
+			fsType:    columns[2],
+		})
+	}
+	return
+}
+
+// readBlockTopology は、/sys/block/[name]/からキューの設定とデバイスの親子関係を読み込む
+func readBlockTopology(blockDir string, stat *DiskDeviceStat) {
+	// $ cat /sys/block/sda/queue/scheduler
+	// none [mq-deadline] kyber bfq
+	if tmpBytes, tmpErr := ioutil.ReadFile(blockDir + "queue/rotational"); tmpErr == nil {
+		stat.Rotational = strings.TrimSpace(string(tmpBytes)) == "1"
+	}
+	if tmpBytes, tmpErr := ioutil.ReadFile(blockDir + "queue/scheduler"); tmpErr == nil {
+		scheduler := strings.TrimSpace(string(tmpBytes))
+		if startIndex := strings.Index(scheduler, "["); startIndex >= 0 {
+			if endIndex := strings.Index(scheduler, "]"); endIndex > startIndex {
+				scheduler = scheduler[startIndex+1 : endIndex]
+			}
+		}
+		stat.Scheduler = scheduler
+	}
+	if tmpBytes, tmpErr := ioutil.ReadFile(blockDir + "queue/nr_requests"); tmpErr == nil {
+		stat.QueueDepth, _ = strconv.Atoi(strings.TrimSpace(string(tmpBytes)))
+	}
+
+	// slaves, holdersには、下位、上位のデバイスへのシンボリックリンクがある
+	// $ ls /sys/block/dm-0/slaves
+	// sda2
+	stat.Slaves, _ = readDirNames(blockDir + "slaves")
+	stat.Holders, _ = readDirNames(blockDir + "holders")
+	sort.Strings(stat.Slaves)
+	sort.Strings(stat.Holders)
+
+	// パーティションは、partitionファイルを持つサブディレクトリ
+	// $ ls /sys/block/sda/sda1/partition
+	names, _ := readDirNames(blockDir)
+	for _, name := range names {
+		if _, tmpErr := os.Stat(blockDir + name + "/partition"); tmpErr == nil {
+			stat.Partitions = append(stat.Partitions, name)
+		}
+	}
+	sort.Strings(stat.Partitions)
+}
+
+// getDeviceChain は、devNameからパーティションの親やslavesを幅優先でたどったデバイスの一覧を返す
+func getDeviceChain(devName string, diskDeviceStatMap map[string]DiskDeviceStat, partitionParentMap map[string]string) (devices []string) {
+	visited := map[string]bool{devName: true}
+	queue := []string{devName}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		devices = append(devices, name)
+
+		var nexts []string
+		if parent, ok := partitionParentMap[name]; ok {
+			nexts = append(nexts, parent)
+		}
+		if stat, ok := diskDeviceStatMap[name]; ok {
+			nexts = append(nexts, stat.Slaves...)
+		}
+		for _, next := range nexts {
+			if !visited[next] {
+				visited[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,65 @@
+package os_utils
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDiskStat(t *testing.T) {
+	a := assert.New(t)
+
+	wd, err := os.Getwd()
+	a.NoError(err)
+	rootDir := wd + "/testdata/root/"
+
+	diskStat, err := GetDiskStat(rootDir)
+	a.NoError(err)
+
+	// パーティションは/sys/block/直下にないので、ディスクのみとなる
+	a.Len(diskStat.DiskDeviceStatMap, 3)
+	sda := diskStat.DiskDeviceStatMap["sda"]
+	a.Equal(94360, sda.ReadsCompleted)
+	a.Equal(120, sda.DiscardsCompleted)
+	a.Equal(40, sda.DiscardMs)
+	a.Equal(500, sda.FlushesCompleted)
+	a.Equal(70, sda.FlushMs)
+	a.True(sda.Rotational)
+	a.Equal("mq-deadline", sda.Scheduler)
+	a.Equal(64, sda.QueueDepth)
+	a.Equal([]string{"sda1", "sda2"}, sda.Partitions)
+	dm0 := diskStat.DiskDeviceStatMap["dm-0"]
+	a.False(dm0.Rotational)
+	a.Equal("none", dm0.Scheduler)
+	a.Equal([]string{"sda2"}, dm0.Slaves)
+	a.Equal(0, dm0.FlushesCompleted)
+
+	// 4.18未満のカーネルは、discardのフィールドがない
+	sdb := diskStat.DiskDeviceStatMap["sdb"]
+	a.Equal(5000, sdb.ReadsCompleted)
+	a.Equal(40000, sdb.WriteSectors)
+	a.Equal(4500, sdb.WeightedIosMs)
+	a.Equal(0, sdb.DiscardsCompleted)
+	a.Equal(128, sdb.QueueDepth)
+
+	// ファイルシステムから下位のデバイスをたどれる
+	rootFs := diskStat.DiskFsStatMap["/dev/mapper/vg-root"]
+	a.Equal("/", rootFs.MountPath)
+	a.Equal(107374182400, rootFs.TotalSize)
+	a.Equal([]string{"dm-0", "sda2", "sda"}, rootFs.Devices)
+	name, _, ok := diskStat.BackingDevice(&rootFs)
+	a.True(ok)
+	a.Equal("dm-0", name)
+
+	bootFs := diskStat.DiskFsStatMap["/dev/sda1"]
+	a.Equal([]string{"sda1", "sda"}, bootFs.Devices)
+	name, _, ok = diskStat.BackingDevice(&bootFs)
+	a.True(ok)
+	a.Equal("sda", name)
+
+	shmFs := diskStat.DiskFsStatMap["tmpfs"]
+	a.Len(shmFs.Devices, 0)
+	_, _, ok = diskStat.BackingDevice(&shmFs)
+	a.False(ok)
+}
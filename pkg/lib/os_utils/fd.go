@@ -0,0 +1,195 @@
+package os_utils
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/syunkitada/goapp2/pkg/lib/str_utils"
+)
+
+// 使用率がこの割合を超えたプロセスはIsNearLimitとしてフラグを立てる
+const ProcessFdNearLimitRatio = 0.8
+
+type FdStat struct {
+	FileAllocated int
+	FileMax       int
+	FileUtil      int // %
+
+	InodeAllocated int
+	InodeFree      int
+
+	PidMax  int
+	Tasks   int
+	PidUtil int // %
+
+	ProcessFdStats []ProcessFdStat
+}
+
+type ProcessFdStat struct {
+	Pid         int
+	Name        string
+	OpenFds     int
+	SoftLimit   int
+	HardLimit   int
+	Util        int // %
+	IsNearLimit bool
+
+	// 差分Stat
+	OpenFdsPerSec int
+}
+
+func GetFdStat(rootDir string) (fdStat *FdStat, err error) {
+	var tmpBytes []byte
+
+	// Read /proc/sys/fs/file-nr
+	// $ cat /proc/sys/fs/file-nr
+	// 12896	0	9223372036854775807
+	// [allocated file handles] [unused file handles(always 0)] [max file handles]
+	if tmpBytes, err = ioutil.ReadFile(rootDir + "proc/sys/fs/file-nr"); err != nil {
+		return
+	}
+	fileNr := str_utils.SplitSpace(strings.TrimSpace(string(tmpBytes)))
+	var fileAllocated, fileMax int
+	if len(fileNr) == 3 {
+		fileAllocated, _ = strconv.Atoi(fileNr[0])
+		fileMax, _ = strconv.Atoi(fileNr[2])
+	}
+
+	// Read /proc/sys/fs/inode-nr
+	// $ cat /proc/sys/fs/inode-nr
+	// 396843	61226
+	// [allocated inodes] [free inodes]
+	var inodeAllocated, inodeFree int
+	if tmpBytes, err = ioutil.ReadFile(rootDir + "proc/sys/fs/inode-nr"); err != nil {
+		return
+	}
+	inodeNr := str_utils.SplitSpace(strings.TrimSpace(string(tmpBytes)))
+	if len(inodeNr) == 2 {
+		inodeAllocated, _ = strconv.Atoi(inodeNr[0])
+		inodeFree, _ = strconv.Atoi(inodeNr[1])
+	}
+
+	// Read /proc/sys/kernel/pid_max
+	// pid_maxはスレッドIDも含めた上限なので、/proc/loadavgのタスク数(スレッド数)と比較する
+	if tmpBytes, err = ioutil.ReadFile(rootDir + "proc/sys/kernel/pid_max"); err != nil {
+		return
+	}
+	pidMax, _ := strconv.Atoi(strings.TrimSpace(string(tmpBytes)))
+	var tasks int
+	if loadavgStat, tmpErr := GetLoadavgStat(rootDir); tmpErr == nil {
+		tasks = loadavgStat.Tasks
+	}
+
+	var processFdStats []ProcessFdStat
+	if processFdStats, err = getProcessFdStats(rootDir); err != nil {
+		return
+	}
+
+	fdStat = &FdStat{
+		FileAllocated:  fileAllocated,
+		FileMax:        fileMax,
+		InodeAllocated: inodeAllocated,
+		InodeFree:      inodeFree,
+		PidMax:         pidMax,
+		Tasks:          tasks,
+		ProcessFdStats: processFdStats,
+	}
+	if fileMax > 0 {
+		fdStat.FileUtil = fileAllocated * 100 / fileMax
+	}
+	if pidMax > 0 {
+		fdStat.PidUtil = tasks * 100 / pidMax
+	}
+	return
+}
+
+func getProcessFdStats(rootDir string) (processFdStats []ProcessFdStat, err error) {
+	procDir := rootDir + ProcDir
+	var pidStrs []string
+	if pidStrs, err = readDirNames(procDir); err != nil {
+		return
+	}
+
+	for _, pidStr := range pidStrs {
+		pid, tmpErr := strconv.Atoi(pidStr)
+		if tmpErr != nil {
+			continue
+		}
+		pidDir := procDir + pidStr + "/"
+
+		// /proc/[pid]/fd は、プロセスと同一ユーザかroot権限がないと見れないので、見れないものはスキップする
+		fds, tmpErr := readDirNames(pidDir + "fd")
+		if tmpErr != nil {
+			continue
+		}
+
+		softLimit, hardLimit, tmpErr := getMaxOpenFiles(pidDir + "limits")
+		if tmpErr != nil {
+			continue
+		}
+
+		var name string
+		if tmpBytes, tmpErr := ioutil.ReadFile(pidDir + "comm"); tmpErr == nil {
+			name = strings.TrimSpace(string(tmpBytes))
+		}
+
+		processFdStat := ProcessFdStat{
+			Pid:       pid,
+			Name:      name,
+			OpenFds:   len(fds),
+			SoftLimit: softLimit,
+			HardLimit: hardLimit,
+		}
+		if softLimit > 0 {
+			processFdStat.Util = len(fds) * 100 / softLimit
+			processFdStat.IsNearLimit = float64(len(fds)) >= float64(softLimit)*ProcessFdNearLimitRatio
+		}
+		processFdStats = append(processFdStats, processFdStat)
+	}
+	return
+}
+
+func getMaxOpenFiles(limitsPath string) (softLimit int, hardLimit int, err error) {
+	// $ cat /proc/[pid]/limits
+	// Limit                     Soft Limit           Hard Limit           Units
+	// Max cpu time              unlimited            unlimited            seconds
+	// Max open files            1024                 1048576              files
+	var limitsFile *os.File
+	if limitsFile, err = os.Open(limitsPath); err != nil {
+		return
+	}
+	defer limitsFile.Close()
+	tmpReader := bufio.NewReader(limitsFile)
+	for {
+		tmpBytes, _, tmpErr := tmpReader.ReadLine()
+		if tmpErr != nil {
+			break
+		}
+		line := string(tmpBytes)
+		if strings.Index(line, "Max open files") != 0 {
+			continue
+		}
+		columns := str_utils.SplitSpace(line[len("Max open files"):])
+		if len(columns) < 2 {
+			break
+		}
+		// unlimitedの場合は0とする
+		softLimit, _ = strconv.Atoi(columns[0])
+		hardLimit, _ = strconv.Atoi(columns[1])
+		break
+	}
+	return
+}
+
+func readDirNames(dir string) (names []string, err error) {
+	var dirFile *os.File
+	if dirFile, err = os.Open(dir); err != nil {
+		return
+	}
+	names, err = dirFile.Readdirnames(-1)
+	dirFile.Close()
+	return
+}
@@ -0,0 +1,56 @@
+package os_utils
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetFdStat(t *testing.T) {
+	a := assert.New(t)
+
+	wd, err := os.Getwd()
+	a.NoError(err)
+	rootDir := wd + "/testdata/root/"
+
+	fdStat, err := GetFdStat(rootDir)
+	a.NoError(err)
+
+	a.Equal(12896, fdStat.FileAllocated)
+	a.Equal(9223372036854775807, fdStat.FileMax)
+	a.Equal(0, fdStat.FileUtil)
+	a.Equal(396843, fdStat.InodeAllocated)
+	a.Equal(61226, fdStat.InodeFree)
+	a.Equal(4194304, fdStat.PidMax)
+	a.Equal(1181, fdStat.Tasks)
+	a.Equal(0, fdStat.PidUtil)
+
+	expectedProcessFdStats := []ProcessFdStat{
+		ProcessFdStat{
+			Pid:       1,
+			Name:      "systemd",
+			OpenFds:   3,
+			SoftLimit: 1048576,
+			HardLimit: 1048576,
+			Util:      0,
+		},
+		ProcessFdStat{
+			// fdが上限の80%を超えている
+			Pid:         21607,
+			Name:        "os_utils.test",
+			OpenFds:     8,
+			SoftLimit:   10,
+			HardLimit:   4096,
+			Util:        80,
+			IsNearLimit: true,
+		},
+	}
+	a.ElementsMatch(expectedProcessFdStats, fdStat.ProcessFdStats)
+
+	{
+		// rootがない
+		_, err := GetFdStat(wd + "/testdata/none/")
+		a.Error(err)
+	}
+}
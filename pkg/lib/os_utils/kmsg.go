@@ -0,0 +1,293 @@
+package os_utils
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	KmsgEventOom        = "oom"
+	KmsgEventHungTask   = "hungtask"
+	KmsgEventSoftLockup = "softlockup"
+	KmsgEventHardLockup = "hardlockup"
+	KmsgEventIoError    = "ioerror"
+	KmsgEventMce        = "mce"
+	KmsgEventEdac       = "edac"
+)
+
+// KmsgEventTypes は、KmsgStat.EventCountsのキーとなるイベントの種類
+var KmsgEventTypes = []string{
+	KmsgEventOom, KmsgEventHungTask, KmsgEventSoftLockup, KmsgEventHardLockup,
+	KmsgEventIoError, KmsgEventMce, KmsgEventEdac,
+}
+
+// /dev/kmsgの1レコードの最大サイズ(これより小さいバッファでreadするとEINVALとなる)
+const kmsgRecordSize = 8192
+
+type KmsgStat struct {
+	Events      []KmsgEvent    // 前回の収集以降に発生したイベント
+	EventCounts map[string]int // 収集開始からのイベントの累計
+}
+
+// KmsgEvent は、カーネルログから分類したイベント
+type KmsgEvent struct {
+	Type      string
+	Seq       int
+	Priority  int           // 0(emerg)〜7(debug)
+	SinceBoot time.Duration // 起動してからの時間
+	Message   string
+	Pid       int    // oom, hungtask, softlockup
+	Comm      string // oom, hungtask, softlockup
+	Cgroup    string // oom (task_memcg)
+	Cpu       int    // softlockup, hardlockup, mce
+	Device    string // ioerror(sdaなど), edac(MC0など)
+}
+
+var (
+	// oom-kill:constraint=CONSTRAINT_NONE,nodemask=(null),cpuset=/,mems_allowed=0,global_oom,task_memcg=/machine.slice/vm1.scope,task=qemu-system-x86,pid=1234,uid=0
+	kmsgOomKillRegexp = regexp.MustCompile(`^oom-kill:.*task_memcg=([^,]*),task=(.*),pid=(\d+)`)
+	// Out of memory: Killed process 1234 (qemu-system-x86) total-vm:...
+	// Memory cgroup out of memory: Killed process 1234 (qemu-system-x86) total-vm:...
+	// Out of memory: Kill process 1234 (qemu-system-x86) score 900 or sacrifice child (古いカーネル)
+	kmsgOomRegexp = regexp.MustCompile(`[Oo]ut of memory: Kill(?:ed)? process (\d+) \((.*?)\)`)
+	// INFO: task kworker/1:2:123 blocked for more than 120 seconds.
+	kmsgHungTaskRegexp = regexp.MustCompile(`task (.+):(\d+) blocked for more than \d+ seconds`)
+	// watchdog: BUG: soft lockup - CPU#3 stuck for 22s! [qemu-system-x86:1234]
+	kmsgSoftLockupRegexp = regexp.MustCompile(`soft lockup - CPU#(\d+) stuck for \d+s! \[(.+):(\d+)\]`)
+	// Watchdog detected hard LOCKUP on cpu 3
+	kmsgHardLockupRegexp = regexp.MustCompile(`hard LOCKUP on cpu (\d+)`)
+	// blk_update_request: I/O error, dev sda, sector 12345 op 0x0:(READ) flags 0x0 phys_seg 1 prio class 0
+	// Buffer I/O error on dev sda1, logical block 0, async page read
+	kmsgIoErrorRegexp = regexp.MustCompile(`I/O error,? (?:on )?dev ([^, ]+)`)
+	// EDAC MC0: 1 CE memory read error on CPU_SrcID#0_Ha#0_Chan#0_DIMM#0 (channel:0 slot:0 page:0x0 offset:0x0 grain:32 syndrome:0x0)
+	kmsgEdacRegexp = regexp.MustCompile(`^EDAC (\w+):`)
+	// mce: [Hardware Error]: CPU 0: Machine Check: 0 Bank 5: be00000000800400
+	// mce: [Hardware Error]: TSC 0 ADDR fef1c140 MISC 38a0000086
+	// 1つのMCEは複数行の[Hardware Error]として出力されるので、CPUの行を先頭とする
+	kmsgMceRegexp = regexp.MustCompile(`\[Hardware Error\]: CPU (\d+): Machine Check`)
+)
+
+// oom-killのレコードは、直後のKilled processのレコードと対応づける
+// この数以上のレコードを読んでも対応づけられなかったものは捨てる
+const kmsgOomKillMaxDistance = 100
+
+type kmsgOomKill struct {
+	seq    int
+	cgroup string
+}
+
+// KmsgReader は、/dev/kmsgを読み込んでKmsgEventに分類する
+// 実機の場合は、/dev/kmsgをO_NONBLOCKで開いて末尾から読み込み、新しいレコードのみを扱う
+// テストなどでrootDirを指定した場合は、rootDir/dev/kmsgを通常のファイルとして先頭から読み込む
+type KmsgReader struct {
+	rootDir string
+	fd      int
+	buf     []byte
+	rest    []byte              // 通常のファイルの場合に、改行で終わっていない読みかけの行
+	oomKill map[int]kmsgOomKill // pidごとのoom-killのtask_memcg
+	lastSeq int
+	inMce   bool // 直前のレコードが[Hardware Error]のレコード
+	counts  map[string]int
+}
+
+func NewKmsgReader(rootDir string) *KmsgReader {
+	counts := map[string]int{}
+	for _, eventType := range KmsgEventTypes {
+		counts[eventType] = 0
+	}
+	return &KmsgReader{
+		rootDir: rootDir,
+		fd:      -1,
+		buf:     make([]byte, kmsgRecordSize),
+		oomKill: map[int]kmsgOomKill{},
+		counts:  counts,
+	}
+}
+
+// GetKmsgStat は、前回の呼び出し以降に追記されたレコードを読み込んでKmsgStatを返す
+// kmsgが存在しない場合(コンテナ内など)は、イベントなしとする
+func (self *KmsgReader) GetKmsgStat() (kmsgStat *KmsgStat, err error) {
+	if self.fd < 0 {
+		if self.fd, err = syscall.Open(self.rootDir+"dev/kmsg", syscall.O_RDONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0); err != nil {
+			self.fd = -1
+			if err == syscall.ENOENT {
+				err = nil
+				kmsgStat = self.newKmsgStat(nil)
+			}
+			return
+		}
+		if self.rootDir == "/" {
+			if _, err = syscall.Seek(self.fd, 0, 2); err != nil {
+				return
+			}
+		}
+	}
+
+	var events []KmsgEvent
+	for {
+		n, tmpErr := syscall.Read(self.fd, self.buf)
+		if tmpErr == syscall.EINTR {
+			continue
+		}
+		if tmpErr == syscall.EPIPE {
+			// 読み込む前にリングバッファが上書きされたレコードがあったので、次のレコードから読み込む
+			continue
+		}
+		if tmpErr == syscall.EAGAIN {
+			break
+		}
+		if tmpErr != nil {
+			err = tmpErr
+			return
+		}
+		if n <= 0 {
+			break
+		}
+
+		data := self.buf[:n]
+		if len(self.rest) > 0 {
+			data = append(self.rest, data...)
+			self.rest = nil
+		}
+		lastIndex := bytes.LastIndexByte(data, '\n')
+		if lastIndex < len(data)-1 {
+			self.rest = append([]byte{}, data[lastIndex+1:]...)
+		}
+		if lastIndex < 0 {
+			continue
+		}
+		for _, line := range strings.Split(string(data[:lastIndex]), "\n") {
+			if event, ok := self.parseRecord(line); ok {
+				events = append(events, event)
+			}
+		}
+	}
+
+	for pid, oomKill := range self.oomKill {
+		if self.lastSeq-oomKill.seq >= kmsgOomKillMaxDistance {
+			delete(self.oomKill, pid)
+		}
+	}
+
+	kmsgStat = self.newKmsgStat(events)
+	return
+}
+
+func (self *KmsgReader) Close() {
+	if self.fd >= 0 {
+		syscall.Close(self.fd)
+		self.fd = -1
+	}
+}
+
+func (self *KmsgReader) newKmsgStat(events []KmsgEvent) *KmsgStat {
+	for _, event := range events {
+		self.counts[event.Type] += 1
+	}
+	eventCounts := make(map[string]int, len(self.counts))
+	for eventType, count := range self.counts {
+		eventCounts[eventType] = count
+	}
+	return &KmsgStat{Events: events, EventCounts: eventCounts}
+}
+
+// parseRecord は、kmsgの1レコードを解析し、分類できた場合はKmsgEventを返す
+func (self *KmsgReader) parseRecord(line string) (event KmsgEvent, ok bool) {
+	// $ cat /dev/kmsg
+	// 6,339,5140900,-;NET: Registered protocol family 10
+	// 3,1370,7224712421,-;Out of memory: Killed process 1234 (qemu-system-x86) total-vm:8388608kB, anon-rss:4194304kB
+	//  SUBSYSTEM=...
+	// 先頭が空白の行は、直前のレコードの付加情報なので無視する
+	if len(line) == 0 || line[0] == ' ' {
+		return
+	}
+	splitedLine := strings.SplitN(line, ";", 2)
+	if len(splitedLine) != 2 {
+		return
+	}
+	header := strings.Split(splitedLine[0], ",")
+	if len(header) < 3 {
+		return
+	}
+	prefix, _ := strconv.Atoi(header[0])
+	seq, _ := strconv.Atoi(header[1])
+	usec, _ := strconv.ParseInt(header[2], 10, 64)
+	message := splitedLine[1]
+	self.lastSeq = seq
+	inMce := self.inMce
+	self.inMce = strings.Contains(message, "[Hardware Error]")
+
+	event = KmsgEvent{
+		Seq:       seq,
+		Priority:  prefix & 7,
+		SinceBoot: time.Duration(usec) * time.Microsecond,
+		Message:   message,
+	}
+
+	if matched := kmsgOomKillRegexp.FindStringSubmatch(message); matched != nil {
+		// 直後のKilled processの行でイベントとする
+		pid, _ := strconv.Atoi(matched[3])
+		self.oomKill[pid] = kmsgOomKill{seq: seq, cgroup: matched[1]}
+		return
+	}
+	if matched := kmsgOomRegexp.FindStringSubmatch(message); matched != nil {
+		event.Type = KmsgEventOom
+		event.Pid, _ = strconv.Atoi(matched[1])
+		event.Comm = matched[2]
+		event.Cgroup = self.oomKill[event.Pid].cgroup
+		delete(self.oomKill, event.Pid)
+		ok = true
+		return
+	}
+	if matched := kmsgHungTaskRegexp.FindStringSubmatch(message); matched != nil {
+		event.Type = KmsgEventHungTask
+		event.Comm = matched[1]
+		event.Pid, _ = strconv.Atoi(matched[2])
+		ok = true
+		return
+	}
+	if matched := kmsgSoftLockupRegexp.FindStringSubmatch(message); matched != nil {
+		event.Type = KmsgEventSoftLockup
+		event.Cpu, _ = strconv.Atoi(matched[1])
+		event.Comm = matched[2]
+		event.Pid, _ = strconv.Atoi(matched[3])
+		ok = true
+		return
+	}
+	if matched := kmsgHardLockupRegexp.FindStringSubmatch(message); matched != nil {
+		event.Type = KmsgEventHardLockup
+		event.Cpu, _ = strconv.Atoi(matched[1])
+		ok = true
+		return
+	}
+	if matched := kmsgIoErrorRegexp.FindStringSubmatch(message); matched != nil {
+		event.Type = KmsgEventIoError
+		event.Device = matched[1]
+		ok = true
+		return
+	}
+	if self.inMce {
+		// 同じMCEの続きの行は、イベントとしない
+		matched := kmsgMceRegexp.FindStringSubmatch(message)
+		if matched == nil && inMce {
+			return
+		}
+		event.Type = KmsgEventMce
+		if matched != nil {
+			event.Cpu, _ = strconv.Atoi(matched[1])
+		}
+		ok = true
+		return
+	}
+	if matched := kmsgEdacRegexp.FindStringSubmatch(message); matched != nil {
+		event.Type = KmsgEventEdac
+		event.Device = matched[1]
+		ok = true
+		return
+	}
+	return
+}
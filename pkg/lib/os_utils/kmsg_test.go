@@ -0,0 +1,104 @@
+package os_utils
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetKmsgStat(t *testing.T) {
+	a := assert.New(t)
+
+	wd, err := os.Getwd()
+	a.NoError(err)
+	rootDir := wd + "/testdata/root/"
+
+	reader := NewKmsgReader(rootDir)
+	defer reader.Close()
+	kmsgStat, err := reader.GetKmsgStat()
+	a.NoError(err)
+	a.Len(kmsgStat.Events, 9)
+
+	event := kmsgStat.Events[0]
+	event.Message = ""
+	a.Equal(KmsgEvent{
+		Type: KmsgEventOom, Seq: 1370, Priority: 3, SinceBoot: 7224712421 * time.Microsecond,
+		Pid: 1234, Comm: "qemu-system-x86", Cgroup: "/machine.slice/vm1.scope",
+	}, event)
+
+	a.Equal(KmsgEventHungTask, kmsgStat.Events[1].Type)
+	a.Equal("kworker/1:2", kmsgStat.Events[1].Comm)
+	a.Equal(123, kmsgStat.Events[1].Pid)
+
+	a.Equal(KmsgEventSoftLockup, kmsgStat.Events[2].Type)
+	a.Equal(3, kmsgStat.Events[2].Cpu)
+	a.Equal(2345, kmsgStat.Events[2].Pid)
+
+	a.Equal(KmsgEventHardLockup, kmsgStat.Events[3].Type)
+	a.Equal(5, kmsgStat.Events[3].Cpu)
+
+	a.Equal(KmsgEventIoError, kmsgStat.Events[4].Type)
+	a.Equal("sda", kmsgStat.Events[4].Device)
+	a.Equal("sdb1", kmsgStat.Events[5].Device)
+
+	// MCEは、複数行で1つのイベント
+	a.Equal(KmsgEventMce, kmsgStat.Events[6].Type)
+	a.Equal(0, kmsgStat.Events[6].Cpu)
+	a.Equal(KmsgEventMce, kmsgStat.Events[7].Type)
+	a.Equal(2, kmsgStat.Events[7].Cpu)
+	a.Equal(KmsgEventEdac, kmsgStat.Events[8].Type)
+	a.Equal("MC0", kmsgStat.Events[8].Device)
+
+	a.Equal(1, kmsgStat.EventCounts[KmsgEventOom])
+	a.Equal(2, kmsgStat.EventCounts[KmsgEventIoError])
+	a.Equal(2, kmsgStat.EventCounts[KmsgEventMce])
+	a.Empty(reader.oomKill)
+
+	{
+		// 追記されたレコードのみを読み込む(改行で終わっていない行は次回に読み込む)
+		tmpRootDir := t.TempDir() + "/"
+		a.NoError(os.Mkdir(tmpRootDir+"dev", 0755))
+		kmsgFile, err := os.Create(tmpRootDir + "dev/kmsg")
+		a.NoError(err)
+		defer kmsgFile.Close()
+
+		reader := NewKmsgReader(tmpRootDir)
+		defer reader.Close()
+		_, err = kmsgFile.WriteString("3,1,100,-;INFO: task qemu:10 blocked for more than 120 seconds.\n3,2,200,-;INFO: task qe")
+		a.NoError(err)
+		kmsgStat, err := reader.GetKmsgStat()
+		a.NoError(err)
+		a.Len(kmsgStat.Events, 1)
+
+		_, err = kmsgFile.WriteString("mu:11 blocked for more than 120 seconds.\n")
+		a.NoError(err)
+		kmsgStat, err = reader.GetKmsgStat()
+		a.NoError(err)
+		a.Len(kmsgStat.Events, 1)
+		a.Equal("qemu", kmsgStat.Events[0].Comm)
+		a.Equal(11, kmsgStat.Events[0].Pid)
+		a.Equal(2, kmsgStat.EventCounts[KmsgEventHungTask])
+
+		// 対応するKilled processのレコードがないoom-killは、一定数のレコードの後に捨てる
+		_, err = kmsgFile.WriteString("6,3,300,-;oom-kill:constraint=CONSTRAINT_NONE,task_memcg=/,task=qemu,pid=12,uid=0\n")
+		a.NoError(err)
+		_, err = reader.GetKmsgStat()
+		a.NoError(err)
+		a.Len(reader.oomKill, 1)
+		_, err = kmsgFile.WriteString("6,103,400,-;NET: Registered protocol family 10\n")
+		a.NoError(err)
+		_, err = reader.GetKmsgStat()
+		a.NoError(err)
+		a.Empty(reader.oomKill)
+	}
+
+	{
+		// kmsgがない
+		reader := NewKmsgReader(wd + "/testdata/none/")
+		kmsgStat, err := reader.GetKmsgStat()
+		a.NoError(err)
+		a.Len(kmsgStat.Events, 0)
+	}
+}
@@ -0,0 +1,155 @@
+package os_utils
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/syunkitada/goapp2/pkg/lib/str_utils"
+)
+
+type LoadavgStat struct {
+	Load1    float64
+	Load5    float64
+	Load15   float64
+	Runnable int
+	Tasks    int
+	LastPid  int
+
+	// CPU数で正規化したload average
+	NumCpus      int
+	Load1PerCpu  float64
+	Load5PerCpu  float64
+	Load15PerCpu float64
+
+	SchedCpuStats []SchedCpuStat
+
+	// 差分Stat
+	// 全CPUのrunqueueの待ち時間の合計(ms/sec)、およびCPUあたりの平均
+	SchedWaitMsPerSec       int
+	SchedWaitMsPerSecPerCpu int
+}
+
+type SchedCpuStat struct {
+	Cpu             int
+	SchedCpuTime    int // time spent running by tasks on this processor (ns)
+	SchedWaitTime   int // time spent waiting to run by tasks on this processor (ns)
+	SchedTimeSlices int // # of timeslices run on this cpu
+
+	// 差分Stat
+	SchedCpuMsPerSec      int
+	SchedWaitMsPerSec     int
+	SchedTimeSlicesPerSec int
+}
+
+func GetLoadavgStat(rootDir string) (loadavgStat *LoadavgStat, err error) {
+	// Read /proc/loadavg
+	// $ cat /proc/loadavg
+	// 0.52 0.58 0.59 2/1181 123456
+	// [load1] [load5] [load15] [runnable]/[tasks] [last pid]
+	var tmpBytes []byte
+	if tmpBytes, err = ioutil.ReadFile(rootDir + "proc/loadavg"); err != nil {
+		return
+	}
+	text := strings.TrimSpace(string(tmpBytes))
+	columns := str_utils.SplitSpace(text)
+	if len(columns) != 5 {
+		err = fmt.Errorf("Unexpected Format: path=/proc/loadavg, text=%s", text)
+		return
+	}
+	tasks := strings.Split(columns[3], "/")
+	if len(tasks) != 2 {
+		err = fmt.Errorf("Unexpected Format: path=/proc/loadavg, text=%s", text)
+		return
+	}
+
+	load1, _ := strconv.ParseFloat(columns[0], 64)
+	load5, _ := strconv.ParseFloat(columns[1], 64)
+	load15, _ := strconv.ParseFloat(columns[2], 64)
+	runnable, _ := strconv.Atoi(tasks[0])
+	totalTasks, _ := strconv.Atoi(tasks[1])
+	lastPid, _ := strconv.Atoi(columns[4])
+
+	// /proc/schedstatはカーネルのビルドオプション(CONFIG_SCHEDSTATS)によっては存在しないので、なくてもエラーにはしない
+	schedCpuStats, _ := getSchedCpuStats(rootDir)
+
+	numCpus := len(schedCpuStats)
+	if numCpus == 0 {
+		numCpus = getNumCpus(rootDir)
+	}
+
+	loadavgStat = &LoadavgStat{
+		Load1:         load1,
+		Load5:         load5,
+		Load15:        load15,
+		Runnable:      runnable,
+		Tasks:         totalTasks,
+		LastPid:       lastPid,
+		NumCpus:       numCpus,
+		SchedCpuStats: schedCpuStats,
+	}
+	if numCpus > 0 {
+		loadavgStat.Load1PerCpu = load1 / float64(numCpus)
+		loadavgStat.Load5PerCpu = load5 / float64(numCpus)
+		loadavgStat.Load15PerCpu = load15 / float64(numCpus)
+	}
+	return
+}
+
+func getSchedCpuStats(rootDir string) (schedCpuStats []SchedCpuStat, err error) {
+	// Read /proc/schedstat
+	// $ cat /proc/schedstat
+	// version 15
+	// timestamp 4295160479
+	// cpu0 0 0 6843 2187 3870 2092 1390390316 174349390 4656
+	// domain0 00000000,00000003 ...
+	// cpu1 0 0 5324 1870 3108 1570 1196405553 143950424 3454
+	// domain0 00000000,00000003 ...
+	//
+	// cpu<N> 1 2 3 4 5 6 [time spent running (ns)] [time spent waiting on a runqueue (ns)] [# of timeslices]
+	var schedstatFile *os.File
+	if schedstatFile, err = os.Open(rootDir + "proc/schedstat"); err != nil {
+		return
+	}
+	defer schedstatFile.Close()
+	tmpReader := bufio.NewReader(schedstatFile)
+	for {
+		tmpBytes, _, tmpErr := tmpReader.ReadLine()
+		if tmpErr != nil {
+			break
+		}
+		columns := str_utils.SplitSpace(string(tmpBytes))
+		if len(columns) < 10 || strings.Index(columns[0], "cpu") != 0 {
+			continue
+		}
+		cpu, tmpErr := strconv.Atoi(columns[0][3:])
+		if tmpErr != nil {
+			continue
+		}
+		schedCpuTime, _ := strconv.Atoi(columns[7])
+		schedWaitTime, _ := strconv.Atoi(columns[8])
+		schedTimeSlices, _ := strconv.Atoi(columns[9])
+		schedCpuStats = append(schedCpuStats, SchedCpuStat{
+			Cpu:             cpu,
+			SchedCpuTime:    schedCpuTime,
+			SchedWaitTime:   schedWaitTime,
+			SchedTimeSlices: schedTimeSlices,
+		})
+	}
+	return
+}
+
+func getNumCpus(rootDir string) (numCpus int) {
+	// Read /sys/devices/system/cpu/online
+	// $ cat /sys/devices/system/cpu/online
+	// 0-15
+	tmpBytes, tmpErr := ioutil.ReadFile(rootDir + "sys/devices/system/cpu/online")
+	if tmpErr != nil {
+		return
+	}
+	numCpus = len(str_utils.ParseRangeFormatStr(strings.TrimSpace(string(tmpBytes))))
+	return
+}
@@ -0,0 +1,43 @@
+package os_utils
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetLoadavgStat(t *testing.T) {
+	a := assert.New(t)
+
+	wd, err := os.Getwd()
+	a.NoError(err)
+	rootDir := wd + "/testdata/root/"
+
+	loadavgStat, err := GetLoadavgStat(rootDir)
+	a.NoError(err)
+
+	expected := LoadavgStat{
+		Load1:        0.52,
+		Load5:        0.58,
+		Load15:       0.59,
+		Runnable:     2,
+		Tasks:        1181,
+		LastPid:      123456,
+		NumCpus:      2,
+		Load1PerCpu:  0.26,
+		Load5PerCpu:  0.29,
+		Load15PerCpu: 0.295,
+		SchedCpuStats: []SchedCpuStat{
+			SchedCpuStat{Cpu: 0, SchedCpuTime: 1390390316, SchedWaitTime: 174349390, SchedTimeSlices: 4656},
+			SchedCpuStat{Cpu: 1, SchedCpuTime: 1196405553, SchedWaitTime: 143950424, SchedTimeSlices: 3454},
+		},
+	}
+	a.Equal(expected, *loadavgStat)
+
+	{
+		// rootがない
+		_, err := GetLoadavgStat(wd + "/testdata/none/")
+		a.Error(err)
+	}
+}
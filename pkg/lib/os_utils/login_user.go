@@ -24,9 +24,9 @@ type UserStat struct {
 	What          string
 }
 
-func GetLoginUserStat() (loginUserStat *LoginUserStat, err error) {
+func GetLoginUserStat(rootDir string) (loginUserStat *LoginUserStat, err error) {
 	var files []os.FileInfo
-	if files, err = ioutil.ReadDir("/dev/pts"); err != nil {
+	if files, err = ioutil.ReadDir(rootDir + "dev/pts"); err != nil {
 		return
 	}
 	now := time.Now()
@@ -66,6 +66,44 @@ type BuddyinfoStat struct {
 	M1M   int
 	M2M   int
 	M4M   int
+
+	// 2M(order 9)の割り当てに対する断片化指数
+	FragIndex2M float64
+}
+
+// hugepage(2M)のorder
+const HugepageOrder = 9
+
+// FreeBlocks は、orderごとの空きブロック数を返す
+func (self *BuddyinfoStat) FreeBlocks() []int {
+	return []int{
+		self.M4K, self.M8K, self.M16K, self.M32K, self.M64K, self.M128K,
+		self.M256K, self.M512K, self.M1M, self.M2M, self.M4M,
+	}
+}
+
+// FragmentationIndex は、/sys/kernel/debug/extfrag/extfrag_indexと同じ方法で断片化指数を計算する
+// 0に近い場合は空きメモリ自体が不足しており、1に近い場合は断片化のために割り当てに失敗する
+// orderのブロックをそのまま割り当てられる場合は-1となる
+func (self *BuddyinfoStat) FragmentationIndex(order int) float64 {
+	requested := 1 << order
+	freeBlocksTotal := 0
+	freeBlocksSuitable := 0
+	freePages := 0
+	for o, blocks := range self.FreeBlocks() {
+		freeBlocksTotal += blocks
+		freePages += blocks << o
+		if o >= order {
+			freeBlocksSuitable += blocks << (o - order)
+		}
+	}
+	if freeBlocksTotal == 0 {
+		return 0
+	}
+	if freeBlocksSuitable > 0 {
+		return -1
+	}
+	return 1 - (1+float64(freePages)/float64(requested))/float64(freeBlocksTotal)
 }
 
 type Vmstat struct {
@@ -114,13 +152,13 @@ func GetMemStat(rootDir string) (stat *MemStat, err error) {
 			nodeName := nodeFileInfo.Name()
 			id := len(nodes)
 
-			tmpBytes, _ = ioutil.ReadFile("/sys/devices/system/node/" + nodeName + "/hugepages/hugepages-1048576kB/nr_hugepages")
+			tmpBytes, _ = ioutil.ReadFile(nodeDir + "/" + nodeName + "/hugepages/hugepages-1048576kB/nr_hugepages")
 			nr1GHugepages, _ := strconv.Atoi(string(tmpBytes))
 
-			tmpBytes, _ = ioutil.ReadFile("/sys/devices/system/node/" + nodeName + "/hugepages/hugepages-1048576kB/free_hugepages")
+			tmpBytes, _ = ioutil.ReadFile(nodeDir + "/" + nodeName + "/hugepages/hugepages-1048576kB/free_hugepages")
 			free1GHugepages, _ := strconv.Atoi(string(tmpBytes))
 
-			if tmpFile, err = os.Open("/sys/devices/system/node/" + nodeName + "/meminfo"); err != nil {
+			if tmpFile, err = os.Open(nodeDir + "/" + nodeName + "/meminfo"); err != nil {
 				return
 			}
 			tmpReader = bufio.NewReader(tmpFile)
@@ -222,7 +260,7 @@ func GetMemStat(rootDir string) (stat *MemStat, err error) {
 
 	// Read /proc/vmstat
 	var vmstatFile *os.File
-	if vmstatFile, err = os.Open("/proc/vmstat"); err != nil {
+	if vmstatFile, err = os.Open(rootDir + "proc/vmstat"); err != nil {
 		return
 	}
 	defer vmstatFile.Close()
@@ -259,7 +297,7 @@ func GetMemStat(rootDir string) (stat *MemStat, err error) {
 	// Node 0, zone      DMA      0      0      0      1      2      1      1      0      1      1      3
 	// Node 0, zone    DMA32      3      3      3      3      3      2      5      6      5      2    874
 	// Node 0, zone   Normal  24727  53842  18419  15120  10448   4451   1761    804    382    105    229
-	buddyinfoFile, _ := os.Open("/proc/buddyinfo")
+	buddyinfoFile, _ := os.Open(rootDir + "proc/buddyinfo")
 	defer buddyinfoFile.Close()
 	tmpReader = bufio.NewReader(buddyinfoFile)
 	for {
@@ -298,6 +336,7 @@ func GetMemStat(rootDir string) (stat *MemStat, err error) {
 				M2M:   m2M,
 				M4M:   m4M,
 			}
+			nodes[nodeId].Buddyinfo.FragIndex2M = nodes[nodeId].Buddyinfo.FragmentationIndex(HugepageOrder)
 		}
 	}
 
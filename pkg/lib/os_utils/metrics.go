@@ -0,0 +1,189 @@
+package os_utils
+
+import (
+	"strconv"
+	"strings"
+)
+
+// GetStatsMetrics は、Statsを"cpu.ctx"や"disk.sda.rbps"のようなメトリクス名と値のマップに変換する
+// 異常検知や履歴の保存など、Statsを汎用的に扱う処理で利用する
+// プロセスごとの値は数が多いので含めない
+func GetStatsMetrics(stats *Stats) (metrics map[string]float64) {
+	metrics = map[string]float64{}
+
+	if stat := stats.CpuStat; stat != nil {
+		metrics["cpu.run"] = float64(stat.ProcsRunning)
+		metrics["cpu.blocked"] = float64(stat.ProcsBlocked)
+		metrics["cpu.intr"] = float64(stat.IntrPerSec)
+		metrics["cpu.ctx"] = float64(stat.CtxPerSec)
+		metrics["cpu.process"] = float64(stat.ProcessesPerSec)
+		metrics["cpu.sirq"] = float64(stat.SoftirqPerSec)
+	}
+
+	if stat := stats.LoadavgStat; stat != nil {
+		metrics["load.1m"] = stat.Load1
+		metrics["load.5m"] = stat.Load5
+		metrics["load.15m"] = stat.Load15
+		metrics["load.1mpc"] = stat.Load1PerCpu
+		metrics["load.run"] = float64(stat.Runnable)
+		metrics["load.tasks"] = float64(stat.Tasks)
+		metrics["load.rqwait"] = float64(stat.SchedWaitMsPerSec)
+		metrics["load.rqwaitpc"] = float64(stat.SchedWaitMsPerSecPerCpu)
+	}
+
+	if stat := stats.MemStat; stat != nil {
+		for _, node := range stat.Nodes {
+			prefix := "mem." + node.NodeName + "."
+			metrics[prefix+"free"] = float64(node.MemFree)
+			metrics[prefix+"used"] = float64(node.MemUsed)
+			metrics[prefix+"avai"] = float64(node.MemAvailable)
+		}
+		for _, node := range stat.Nodes {
+			metrics["mem."+node.NodeName+".fragidx2m"] = node.Buddyinfo.FragIndex2M
+		}
+		metrics["vmstat.pgscan_kswapd"] = float64(stat.Vmstat.PgscanKswapdPerSec)
+		metrics["vmstat.pgscan_direct"] = float64(stat.Vmstat.PgscanDirectPerSec)
+		metrics["vmstat.pgfault"] = float64(stat.Vmstat.PgfaultPerSec)
+		metrics["vmstat.pswapin"] = float64(stat.Vmstat.PswapinPerSec)
+		metrics["vmstat.pswapout"] = float64(stat.Vmstat.PswapoutPerSec)
+	}
+
+	if stat := stats.DiskStat; stat != nil {
+		for name, dstat := range stat.DiskDeviceStatMap {
+			prefix := "disk." + metricName(name) + "."
+			metrics[prefix+"rps"] = float64(dstat.ReadsPerSec)
+			metrics[prefix+"rbps"] = float64(dstat.ReadBytesPerSec)
+			metrics[prefix+"rmsps"] = float64(dstat.ReadMsPerSec)
+			metrics[prefix+"wps"] = float64(dstat.WritesPerSec)
+			metrics[prefix+"wbps"] = float64(dstat.WriteBytesPerSec)
+			metrics[prefix+"wmsps"] = float64(dstat.WriteMsPerSec)
+			metrics[prefix+"pios"] = float64(dstat.ProgressIos)
+		}
+		for _, fstat := range stat.DiskFsStatMap {
+			prefix := "fs." + metricName(fstat.MountPath) + "."
+			metrics[prefix+"used"] = float64(fstat.UsedSize)
+			metrics[prefix+"free"] = float64(fstat.FreeSize)
+		}
+	}
+
+	if stat := stats.NetStat; stat != nil {
+		addNetStatMetrics(metrics, "", stat)
+	}
+
+	if stat := stats.NetnsStat; stat != nil {
+		for name, netStat := range stat.NetStatMap {
+			addNetStatMetrics(metrics, "netns."+metricName(name)+".", netStat)
+		}
+	}
+
+	if stat := stats.FdStat; stat != nil {
+		metrics["fd.file"] = float64(stat.FileAllocated)
+		metrics["fd.fileutil"] = float64(stat.FileUtil)
+		metrics["fd.inode"] = float64(stat.InodeAllocated)
+		metrics["fd.tasks"] = float64(stat.Tasks)
+		metrics["fd.pidutil"] = float64(stat.PidUtil)
+	}
+
+	if stat := stats.SensorStat; stat != nil {
+		for _, tstat := range stat.TempStats {
+			metrics["temp."+metricName(tstat.Chip)+"."+metricName(tstat.Label)] = tstat.Temp
+		}
+		for _, fstat := range stat.FanStats {
+			metrics["fan."+metricName(fstat.Chip)+"."+metricName(fstat.Label)] = float64(fstat.Rpm)
+		}
+		for _, zstat := range stat.ThermalZoneStats {
+			metrics["thermal."+metricName(zstat.Zone)] = zstat.Temp
+		}
+		for _, rstat := range stat.RaplStats {
+			metrics["rapl."+metricName(rstat.Zone)+".watts"] = rstat.Watts
+		}
+		for _, cstat := range stat.CpuFreqStats {
+			prefix := "freq.cpu" + strconv.Itoa(cstat.Cpu) + "."
+			metrics[prefix+"cur"] = float64(cstat.CurKhz)
+			metrics[prefix+"throttle"] = float64(cstat.CoreThrottlePerSec + cstat.PackageThrottlePerSec)
+		}
+	}
+
+	if stat := stats.KmsgStat; stat != nil {
+		// 累計ではなく、前回からのイベント数
+		for _, eventType := range KmsgEventTypes {
+			metrics["kmsg."+eventType] = 0
+		}
+		for _, event := range stat.Events {
+			metrics["kmsg."+event.Type] += 1
+		}
+	}
+
+	if stat := stats.SlabStat; stat != nil {
+		metrics["slab.total.bytes"] = float64(stat.TotalBytes)
+		metrics["slab.total.bps"] = float64(stat.TotalBytesPerSec)
+		for _, cstat := range stat.TopCaches(SlabTopCaches) {
+			prefix := "slab." + metricName(cstat.Name) + "."
+			metrics[prefix+"bytes"] = float64(cstat.Bytes)
+			metrics[prefix+"bps"] = float64(cstat.BytesPerSec)
+		}
+	}
+
+	if stat := stats.ZoneStat; stat != nil {
+		for _, zone := range stat.Zones {
+			prefix := "zone.node" + strconv.Itoa(zone.NodeId) + "." + metricName(zone.Zone) + "."
+			metrics[prefix+"free"] = float64(zone.Free)
+			metrics[prefix+"low"] = float64(zone.Low)
+		}
+	}
+
+	if stat := stats.ConntrackStat; stat != nil {
+		metrics["conntrack.count"] = float64(stat.Count)
+		metrics["conntrack.util"] = float64(stat.Util)
+		metrics["conntrack.insert_failed"] = float64(stat.InsertFailedPerSec)
+		metrics["conntrack.drop"] = float64(stat.DropPerSec)
+		metrics["conntrack.early_drop"] = float64(stat.EarlyDropPerSec)
+		metrics["conntrack.search_restart"] = float64(stat.SearchRestartPerSec)
+	}
+
+	for _, cstat := range stats.CollectorStats {
+		prefix := "collector." + cstat.Name + "."
+		metrics[prefix+"ms"] = float64(cstat.Duration.Milliseconds())
+		// 累計ではなく、今回の収集で増えたエラー回数
+		metrics[prefix+"errors"] = float64(cstat.NewErrors)
+	}
+
+	return
+}
+
+// addNetStatMetrics は、NetStatのメトリクスを追加する
+// netnsごとのNetStatは、"netns.com-0."のようなprefixをつける
+func addNetStatMetrics(metrics map[string]float64, prefix string, stat *NetStat) {
+	for name, nstat := range stat.NetDevStatMap {
+		devPrefix := prefix + "net." + metricName(name) + "."
+		metrics[devPrefix+"rbps"] = float64(nstat.ReceiveBytesPerSec)
+		metrics[devPrefix+"rpps"] = float64(nstat.ReceivePacketsPerSec)
+		metrics[devPrefix+"reps"] = float64(nstat.ReceiveErrorsPerSec)
+		metrics[devPrefix+"rdps"] = float64(nstat.ReceiveDropsPerSec)
+		metrics[devPrefix+"tbps"] = float64(nstat.TransmitBytesPerSec)
+		metrics[devPrefix+"tpps"] = float64(nstat.TransmitPacketsPerSec)
+		metrics[devPrefix+"teps"] = float64(nstat.TransmitErrorsPerSec)
+		metrics[devPrefix+"tdps"] = float64(nstat.TransmitDropsPerSec)
+	}
+	metrics[prefix+"tcpext.ListenOverflows"] = float64(stat.TcpExtStat.ListenOverflowsPerSec)
+	metrics[prefix+"tcpext.ListenDrops"] = float64(stat.TcpExtStat.ListenDropsPerSec)
+	metrics[prefix+"tcpext.TcpTimeouts"] = float64(stat.TcpExtStat.TcpTimeoutsPerSec)
+	metrics[prefix+"tcpext.TcpSynRetrans"] = float64(stat.TcpExtStat.TcpSynRetransPerSec)
+	metrics[prefix+"tcpext.TcpBacklogDrop"] = float64(stat.TcpExtStat.TcpBacklogDropPerSec)
+	metrics[prefix+"tcpext.PruneCalled"] = float64(stat.TcpExtStat.PruneCalledPerSec)
+	metrics[prefix+"tcpext.TcpAbortOnMemory"] = float64(stat.TcpExtStat.TcpAbortOnMemoryPerSec)
+	metrics[prefix+"ipext.InOctets"] = float64(stat.IpExtStat.InOctetsPerSec)
+	metrics[prefix+"ipext.OutOctets"] = float64(stat.IpExtStat.OutOctetsPerSec)
+	metrics[prefix+"sock.tcp"] = float64(stat.SockStat.TcpInuse)
+	metrics[prefix+"sock.orphan"] = float64(stat.SockStat.TcpOrphan)
+	metrics[prefix+"sock.tw"] = float64(stat.SockStat.TcpTw)
+	metrics[prefix+"sock.udp"] = float64(stat.SockStat.UdpInuse)
+}
+
+var metricNameReplacer = strings.NewReplacer(".", "_", "/", "_", " ", "_", "\t", "_")
+
+// metricName は、メトリクス名の区切りに使う"."や"/"、空白をデバイス名(dm/0など)から取り除く
+// "/"が含まれると、パターンの照合(filepath.Match)で"*"に一致しなくなる
+func metricName(name string) string {
+	return metricNameReplacer.Replace(name)
+}
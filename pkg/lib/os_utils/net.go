@@ -13,6 +13,7 @@ import (
 type NetStat struct {
 	TcpExtStat    TcpExtStat
 	IpExtStat     IpExtStat
+	SockStat      SockStat
 	NetDevStatMap map[string]NetDevStat
 }
 
@@ -300,6 +301,21 @@ type IpExtStat struct {
 	ReasmOverlapsPerSec   int
 }
 
+// SockStat は、ソケットの使用状況(/proc/net/sockstat)
+// 値はカウンタではなく現在の値なので、差分Statはない
+type SockStat struct {
+	SocketsUsed int
+	TcpInuse    int
+	TcpOrphan   int
+	TcpTw       int
+	TcpAlloc    int // TcpAllocとTcpMemPagesは、netnsによらずホスト全体の値
+	TcpMemPages int
+	UdpInuse    int
+	UdpMemPages int
+	RawInuse    int
+	FragInuse   int
+}
+
 type NetDevStat struct {
 	ReceiveBytes    int
 	ReceivePackets  int
@@ -320,9 +336,15 @@ type NetDevStat struct {
 	TransmitDropsPerSec   int
 }
 
-func GetNetStat() (netStat *NetStat, err error) {
+func GetNetStat(rootDir string) (netStat *NetStat, err error) {
+	netStat, err = readNetStat(rootDir + "proc/net/")
+	return
+}
+
+// readNetStat は、procNetDir(/proc/net/や/proc/thread-self/net/)のファイルからNetStatを読み込む
+func readNetStat(procNetDir string) (netStat *NetStat, err error) {
 	// $ cat /proc/net/snmp
-	netstatFile, _ := os.Open("/proc/net/netstat")
+	netstatFile, _ := os.Open(procNetDir + "netstat")
 	defer netstatFile.Close()
 	tmpReader := bufio.NewReader(netstatFile)
 
@@ -504,20 +526,64 @@ func GetNetStat() (netStat *NetStat, err error) {
 	//   com-2-ex:   26578     383    0    0    0     0          0         0    32083     406    0    0    0     0       0          0
 	//   com-4-ex:   28084     420    0    0    0     0          0         0    33499     442    0    0    0     0       0          0
 	//   docker0:       0       0    0    0    0     0          0         0        0       0    0    0    0     0       0          0
-	bytes, tmpErr := ioutil.ReadFile("/proc/net/dev")
+	bytes, tmpErr := ioutil.ReadFile(procNetDir + "dev")
 	if tmpErr != nil {
 		return
 	}
 	netDevStatMap := parseNetDev(string(bytes))
 
+	var sockStat SockStat
+	if bytes, tmpErr = ioutil.ReadFile(procNetDir + "sockstat"); tmpErr == nil {
+		sockStat = parseSockstat(string(bytes))
+	}
+
 	netStat = &NetStat{
 		TcpExtStat:    tcpExtStat,
 		IpExtStat:     ipExtStat,
+		SockStat:      sockStat,
 		NetDevStatMap: netDevStatMap,
 	}
 	return
 }
 
+func parseSockstat(out string) (sockStat SockStat) {
+	// $ cat /proc/net/sockstat
+	// sockets: used 18
+	// TCP: inuse 4 orphan 0 tw 0 alloc 4 mem 0
+	// UDP: inuse 0 mem 0
+	// UDPLITE: inuse 0
+	// RAW: inuse 0
+	// FRAG: inuse 0 memory 0
+	for _, line := range strings.Split(out, "\n") {
+		columns := str_utils.SplitSpace(line)
+		if len(columns) < 1 {
+			continue
+		}
+		valueMap := map[string]int{}
+		for i := 2; i < len(columns); i += 2 {
+			valueMap[columns[i-1]], _ = strconv.Atoi(columns[i])
+		}
+		switch columns[0] {
+		case "sockets:":
+			sockStat.SocketsUsed = valueMap["used"]
+		case "TCP:":
+			sockStat.TcpInuse = valueMap["inuse"]
+			sockStat.TcpOrphan = valueMap["orphan"]
+			sockStat.TcpTw = valueMap["tw"]
+			sockStat.TcpAlloc = valueMap["alloc"]
+			sockStat.TcpMemPages = valueMap["mem"]
+		case "UDP:":
+			sockStat.UdpInuse = valueMap["inuse"]
+			sockStat.UdpMemPages = valueMap["mem"]
+		case "RAW:":
+			sockStat.RawInuse = valueMap["inuse"]
+		case "FRAG:":
+			sockStat.FragInuse = valueMap["inuse"]
+		}
+	}
+	return
+}
+
 func parseNetDev(out string) (netDevStatMap map[string]NetDevStat) {
 	// $ cat /proc/net/dev
 	// Inter-|   Receive                                                |  Transmit
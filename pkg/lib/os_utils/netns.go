@@ -0,0 +1,76 @@
+package os_utils
+
+import (
+	"io/ioutil"
+
+	"github.com/syunkitada/goapp2/pkg/lib/netlink_utils"
+)
+
+const (
+	// ip netnsで作成したnetnsのマウント先(rootDirからの相対パス)
+	NetnsDir = "var/run/netns/"
+	// キャプチャしたnetnsごとのファイルの保存先(rootDirからの相対パス)
+	// netns/[name]/proc/net/dev のように保存する
+	CaptureNetnsDir = "netns/"
+)
+
+// CaptureNetnsFiles は、netnsごとに読み込む/proc/net/以下のファイル
+var CaptureNetnsFiles = []string{"netstat", "dev", "sockstat"}
+
+// NetnsStat は、netnsごとのNetStat
+// virtのcom-Nなど、ホストのnetnsからは見えない統計を取得する
+type NetnsStat struct {
+	NetStatMap map[string]*NetStat // key: netns名
+}
+
+// GetNetnsStat は、ip netnsで作成したnetnsごとにNetStatを取得する
+// 実機の場合は、setns(2)で各netnsに入って/proc/thread-self/net/を読み込むのでroot権限が必要
+// キャプチャしたディレクトリの場合は、CaptureNetnsDirに保存したファイルを読み込む
+func GetNetnsStat(rootDir string) (netnsStat *NetnsStat, err error) {
+	netnsStat = &NetnsStat{NetStatMap: map[string]*NetStat{}}
+
+	if rootDir != "/" {
+		fileInfos, tmpErr := ioutil.ReadDir(rootDir + CaptureNetnsDir)
+		if tmpErr != nil {
+			return
+		}
+		for _, fileInfo := range fileInfos {
+			var netStat *NetStat
+			if netStat, err = readNetStat(rootDir + CaptureNetnsDir + fileInfo.Name() + "/proc/net/"); err != nil {
+				return
+			}
+			if netStat != nil {
+				netnsStat.NetStatMap[fileInfo.Name()] = netStat
+			}
+		}
+		return
+	}
+
+	netnsStat.NetStatMap = readNetnsNetStatMap(rootDir + NetnsDir)
+	return
+}
+
+// readNetnsNetStatMap は、netnsDirのnetnsごとにsetns(2)で入ってNetStatを読み込む
+// 読み込み中に削除されたnetnsや、setns(2)できないnetnsは、他のnetnsの統計を失わないようにスキップする
+func readNetnsNetStatMap(netnsDir string) (netStatMap map[string]*NetStat) {
+	netStatMap = map[string]*NetStat{}
+	// netnsを一つも作成していない場合は、ディレクトリが存在しない
+	fileInfos, tmpErr := ioutil.ReadDir(netnsDir)
+	if tmpErr != nil {
+		return
+	}
+	for _, fileInfo := range fileInfos {
+		name := fileInfo.Name()
+		var netStat *NetStat
+		if tmpErr := netlink_utils.RunInNetns(netnsDir+name, func() (err error) {
+			netStat, err = readNetStat("/proc/thread-self/net/")
+			return
+		}); tmpErr != nil {
+			continue
+		}
+		if netStat != nil {
+			netStatMap[name] = netStat
+		}
+	}
+	return
+}
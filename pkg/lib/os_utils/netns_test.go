@@ -0,0 +1,64 @@
+package os_utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetNetnsStat(t *testing.T) {
+	a := assert.New(t)
+
+	wd, err := os.Getwd()
+	a.NoError(err)
+	rootDir := wd + "/testdata/root/"
+
+	netnsStat, err := GetNetnsStat(rootDir)
+	a.NoError(err)
+	a.Len(netnsStat.NetStatMap, 1)
+
+	netStat := netnsStat.NetStatMap["com-0"]
+	a.Equal(3, netStat.TcpExtStat.ListenOverflows)
+	a.Equal(12, netStat.TcpExtStat.TcpTimeouts)
+	a.Equal(123456, netStat.IpExtStat.InOctets)
+	a.Equal(SockStat{
+		SocketsUsed: 5, TcpInuse: 2, TcpTw: 1, TcpAlloc: 40, TcpMemPages: 3, UdpInuse: 1, UdpMemPages: 2,
+	}, netStat.SockStat)
+	a.Equal(NetDevStat{ReceiveBytes: 29026, ReceivePackets: 447, ReceiveDrops: 2, TransmitBytes: 34621, TransmitPackets: 471},
+		netStat.NetDevStatMap["com-0-in"])
+
+	// netnsごとにメトリクス名が分かれる
+	metrics := GetStatsMetrics(&Stats{NetnsStat: netnsStat})
+	a.Equal(float64(2), metrics["netns.com-0.sock.tcp"])
+	_, ok := metrics["netns.com-0.net.com-0-in.rbps"]
+	a.True(ok)
+
+	{
+		// netnsがない
+		netnsStat, err := GetNetnsStat(wd + "/testdata/none/")
+		a.NoError(err)
+		a.Len(netnsStat.NetStatMap, 0)
+	}
+}
+
+func TestReadNetnsNetStatMap(t *testing.T) {
+	a := assert.New(t)
+
+	// netnsでないファイルや削除されたnetnsは、スキップして他のnetnsを読み込む
+	netnsDir := t.TempDir() + "/"
+	a.NoError(ioutil.WriteFile(filepath.Join(netnsDir, "bogus"), []byte{}, 0644))
+	a.NoError(os.Symlink("/proc/self/ns/net", filepath.Join(netnsDir, "self")))
+	a.NoError(os.Symlink(filepath.Join(netnsDir, "deleted-target"), filepath.Join(netnsDir, "deleted")))
+
+	netStatMap := readNetnsNetStatMap(netnsDir)
+	a.NotContains(netStatMap, "bogus")
+	a.NotContains(netStatMap, "deleted")
+	if os.Geteuid() != 0 {
+		t.Skip("setns(2) requires root")
+	}
+	a.Len(netStatMap, 1)
+	a.NotNil(netStatMap["self"])
+}
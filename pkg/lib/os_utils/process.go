@@ -1,15 +1,13 @@
 package os_utils
 
 import (
-	"bufio"
+	"bytes"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"strconv"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
-
-	"github.com/syunkitada/goapp2/pkg/lib/str_utils"
 )
 
 type Thread struct {
@@ -70,47 +68,129 @@ type ProcessStat struct {
 	SyscwPerSec      int
 	ReadBytesPerSec  int
 	WriteBytesPerSec int
+
+	OomScore    int
+	OomScoreAdj int
+
+	// /proc/[pid]/smaps_rollup から取得する(ProcessOption.IsDeepの場合のみ)
+	PssKb           int
+	UssKb           int
+	SwapKb          int
+	SwapPssKb       int
+	SharedCleanKb   int
+	SharedDirtyKb   int
+	PrivateCleanKb  int
+	PrivateDirtyKb  int
+	AnonHugePagesKb int
 }
 
 const ProcDir = "proc/"
 
+// ProcessField は、GetProcessesで読み込む/proc/[pid]/以下のファイルを選択する
+type ProcessField int
+
+const (
+	ProcessFieldCmdline   ProcessField = 1 << iota // Cmds
+	ProcessFieldStatus                             // Name, Tgid, Ppid, State, VmRssKb など
+	ProcessFieldSchedstat                          // SchedCpuTime, SchedWaitTime, SchedTimeSlices
+	ProcessFieldStat                               // Utime, Stime, Gtime, Cgtime, StartTime
+	ProcessFieldIo                                 // Syscr, Syscw, ReadBytes, WriteBytes
+	ProcessFieldOom                                // OomScore, OomScoreAdj
+	ProcessFieldSmaps                              // PssKb, UssKb, SwapKb など
+
+	ProcessFieldsBasic   = ProcessFieldCmdline | ProcessFieldStatus
+	ProcessFieldsVerbose = ProcessFieldsBasic | ProcessFieldSchedstat | ProcessFieldStat | ProcessFieldIo | ProcessFieldOom
+	ProcessFieldsDeep    = ProcessFieldsVerbose | ProcessFieldSmaps
+)
+
+type ProcessOption struct {
+	// schedstat, stat, io なども読み込む
+	IsVerbose bool
+	// smaps_rollup も読み込む(IsVerboseの場合のみ有効)
+	// smaps_rollupはページテーブルを走査するため重いので、必要な場合のみ有効にする
+	IsDeep bool
+	// 指定した場合は、IsVerbose, IsDeepの代わりにこれで読み込むファイルを選択する
+	Fields ProcessField
+}
+
+func (self *ProcessOption) fields() ProcessField {
+	if self.Fields != 0 {
+		return self.Fields
+	}
+	if !self.IsVerbose {
+		return ProcessFieldsBasic
+	}
+	if self.IsDeep {
+		return ProcessFieldsDeep
+	}
+	return ProcessFieldsVerbose
+}
+
+// ProcessReader は、GetProcessesで利用するバッファを使いまわすためのもの
+// タスクが数万あるホストで毎秒実行しても、ファイルごとのアロケーションが発生しないようにする
+// 同時に複数のgoroutineから利用してはならない
+type ProcessReader struct {
+	fields    ProcessField
+	buf       []byte
+	pidStrs   []string
+	lastCount int
+}
+
+func NewProcessReader(option *ProcessOption) (reader *ProcessReader) {
+	reader = &ProcessReader{
+		fields: option.fields(),
+		buf:    make([]byte, 4096),
+	}
+	return
+}
+
 func GetProcesses(rootDir string, isVerbose bool) (processes []Process, pidIndexMap map[int]int, err error) {
+	return GetProcessesWithOption(rootDir, &ProcessOption{IsVerbose: isVerbose})
+}
+
+func GetProcessesWithOption(rootDir string, option *ProcessOption) (processes []Process, pidIndexMap map[int]int, err error) {
+	return NewProcessReader(option).GetProcesses(rootDir)
+}
+
+func (self *ProcessReader) GetProcesses(rootDir string) (processes []Process, pidIndexMap map[int]int, err error) {
+	// Readdirは全エントリをlstatするので、名前だけを取得する
 	var procDirFile *os.File
 	procDir := rootDir + ProcDir
 	if procDirFile, err = os.Open(procDir); err != nil {
 		return
 	}
-
-	var procFileInfos []os.FileInfo
-	procFileInfos, err = procDirFile.Readdir(-1)
+	self.pidStrs, err = procDirFile.Readdirnames(-1)
 	procDirFile.Close()
 	if err != nil {
 		return
 	}
 
 	timestamp := time.Now()
-	index := 0
-	pidIndexMap = map[int]int{}
-	for _, procFileInfo := range procFileInfos {
-		if !procFileInfo.IsDir() {
+	processes = make([]Process, 0, self.lastCount)
+	pidIndexMap = make(map[int]int, self.lastCount)
+	for _, pidStr := range self.pidStrs {
+		// /proc/self, /proc/fs などのPID(int)でないものは除外する
+		pid, ok := parseIntBytes([]byte(pidStr))
+		if !ok || pid <= 0 {
 			continue
 		}
 
-		var process *Process
-		if process, err = getProcess(procDir, procFileInfo.Name(), isVerbose); err != nil {
+		processes = append(processes, Process{Pid: pid})
+		process := &processes[len(processes)-1]
+		var isFound bool
+		if isFound, err = self.readProcess(procDir+pidStr+"/", process); err != nil {
 			return
 		}
-
-		if process == nil {
-			// fs, bus, irq, sys, tty, acpi, scsi, asound, driver, sysvipc, pressure はスキップされる
+		if !isFound {
+			// 読み込み中にプロセスが終了した
+			processes = processes[:len(processes)-1]
 			continue
 		}
 
 		process.Stat.Timestamp = timestamp
-		pidIndexMap[process.Pid] = index
-		index += 1
-		processes = append(processes, *process)
+		pidIndexMap[pid] = len(processes) - 1
 	}
+	self.lastCount = len(processes)
 
 	for _, process := range processes {
 		if index, ok := pidIndexMap[process.Ppid]; ok {
@@ -121,194 +201,158 @@ func GetProcesses(rootDir string, isVerbose bool) (processes []Process, pidIndex
 	return
 }
 
-func getProcess(rootProcDir string, pidStr string, isVerbose bool) (process *Process, err error) {
-	var tmpFile *os.File
-	var tmpBytes []byte
-	var tmpTexts []string
-	var tmpReader *bufio.Reader
-
-	// /proc/self, /proc/fs などのPID(int)でないものは除外する
-	var pid int
-	var tmpErr error
-	if pid, tmpErr = strconv.Atoi(pidStr); tmpErr != nil {
-		return
+// readFile は、self.bufにファイルを読み込む(返したスライスは次のreadFileまで有効)
+func (self *ProcessReader) readFile(path string) (data []byte, err error) {
+	var fd int
+	for {
+		if fd, err = syscall.Open(path, syscall.O_RDONLY|syscall.O_CLOEXEC, 0); err != syscall.EINTR {
+			break
+		}
 	}
-
-	procDir := rootProcDir + pidStr + "/"
-
-	// ----------------------------------------------------------------------------------------------------
-	// Parse cmdline
-	cmdlinePath := procDir + "cmdline"
-	if tmpBytes, tmpErr = ioutil.ReadFile(cmdlinePath); tmpErr != nil {
+	if err != nil {
 		return
 	}
-	cmds := strings.Split(string(tmpBytes), string(byte(0)))
-	if cmds[len(cmds)-1] == "" {
-		cmds = cmds[0 : len(cmds)-1]
-	}
+	defer syscall.Close(fd)
 
-	// ----------------------------------------------------------------------------------------------------
-	// Parse status
-	if tmpFile, tmpErr = os.Open(procDir + "status"); tmpErr != nil {
-		return
-	}
-	defer tmpFile.Close()
-	tmpReader = bufio.NewReader(tmpFile)
-	statusMap := map[string][]string{}
+	size := 0
 	for {
-		tmpBytes, _, tmpErr := tmpReader.ReadLine()
+		if size == len(self.buf) {
+			self.buf = append(self.buf, make([]byte, len(self.buf))...)
+		}
+		n, tmpErr := syscall.Read(fd, self.buf[size:])
+		if tmpErr == syscall.EINTR {
+			continue
+		}
 		if tmpErr != nil {
+			err = tmpErr
+			return
+		}
+		if n == 0 {
 			break
 		}
+		size += n
+	}
+	data = self.buf[:size]
+	return
+}
+
+func (self *ProcessReader) readProcess(procDir string, process *Process) (isFound bool, err error) {
+	var data []byte
+	var tmpErr error
 
-		fields := str_utils.SplitSpace(string(tmpBytes))
-		statusMap[fields[0]] = fields[1:]
+	// ----------------------------------------------------------------------------------------------------
+	// Parse cmdline
+	// 引数はNUL区切りになっている
+	if self.fields&ProcessFieldCmdline != 0 {
+		if data, tmpErr = self.readFile(procDir + "cmdline"); tmpErr != nil {
+			return
+		}
+		data = bytes.TrimSuffix(data, []byte{0})
+		process.Cmds = []string{}
+		if len(data) > 0 {
+			process.Cmds = strings.Split(string(data), "\x00")
+		}
 	}
 
+	// ----------------------------------------------------------------------------------------------------
+	// Parse status
 	// Name:   kworker/6:2-events
-	name := statusMap["Name:"][0]
-
 	// Umask:  0000
 	// State:  I (idle)
-	var stateInt int
-	switch statusMap["State:"][0] {
-	case "R":
-		stateInt = 3
-	case "D":
-		stateInt = 2
-	case "S":
-		stateInt = 1
-	case "I":
-		stateInt = 0
-	case "Z":
-		stateInt = -1
-	default:
-		stateInt = 0
-	}
-
 	// Tgid:   23550
-	tgid, _ := strconv.Atoi(statusMap["Tgid:"][0])
 	// Ngid:   0
 	// Pid:    23550
 	// PPid:   23547
-	ppid, _ := strconv.Atoi(statusMap["PPid:"][0])
-	// TracerPid:      0
-	// Uid:    0       0       0       0
-	// Gid:    0       0       0       0
-	// FDSize: 256
-	// Groups:
-	// NStgid: 23550
-	// NSpid:  23550
-	// NSpgid: 23550
-	// NSsid:  23547
-	// VmPeak:  3235840 kB
+	// ...
 	// VmSize:  2461756 kB
-
-	// VmSize
-	var vmSizeKb int
-	if value, ok := statusMap["VmSize:"]; ok {
-		vmSizeKb, _ = strconv.Atoi(value[0])
-	}
 	// VmLck:         0 kB
 	// VmPin:         0 kB
 	// VmHWM:     31584 kB
 	// VmRSS:     28784 kB
-	var vmRssKb int
-	if value, ok := statusMap["VmRSS:"]; ok {
-		vmRssKb, _ = strconv.Atoi(value[0])
-	}
-	// RssAnon:           16256 kB
-	// RssFile:           12528 kB
-	// RssShmem:              0 kB
-	// VmData:  2399228 kB
-	// VmStk:       132 kB
-	// VmExe:     11452 kB
-	// VmLib:      7424 kB
-	// VmPTE:       572 kB
-	// VmSwap:        0 kB
+	// ...
 	// HugetlbPages:    2097152 kB
-	var hugetlbPages int
-	if value, ok := statusMap["HugetlbPages:"]; ok {
-		hugetlbPages, _ = strconv.Atoi(value[0])
-	}
-	// CoreDumping:    0
-	// THP_enabled:    1
+	// ...
 	// Threads:        4
-	threads, _ := strconv.Atoi(statusMap["Threads:"][0])
-	// SigQ:   0/62468
-	// SigPnd: 0000000000000000
-	// ShdPnd: 0000000000000000
-	// SigBlk: 0000000010002240
-	// SigIgn: 0000000000001000
-	// SigCgt: 0000000180004243
-	// CapInh: 0000000000000000
-	// CapPrm: 0000003fffffffff
-	// CapEff: 0000003fffffffff
-	// CapBnd: 0000003fffffffff
-	// CapAmb: 0000000000000000
-	// NoNewPrivs:     0
-	// Seccomp:        0
-	// Speculation_Store_Bypass:       thread vulnerable
-	// Cpus_allowed:   ffff
-	// Cpus_allowed_list:      0-15
-	// Mems_allowed:   00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000000,00000001
-	// Mems_allowed_list:      0
+	// ...
 	// voluntary_ctxt_switches:        14415
-	voluntaryCtxtSwitches, _ := strconv.Atoi(statusMap["voluntary_ctxt_switches:"][0])
 	// nonvoluntary_ctxt_switches:     219
-	nonvoluntaryCtxtSwitches, _ := strconv.Atoi(statusMap["nonvoluntary_ctxt_switches:"][0])
-
-	process = &Process{
-		Name:  name,
-		Cmds:  cmds,
-		Pid:   pid,
-		Tgid:  tgid,
-		Ppid:  ppid,
-		State: stateInt,
-	}
-	if !isVerbose {
-		return
+	if self.fields&ProcessFieldStatus != 0 {
+		if data, tmpErr = self.readFile(procDir + "status"); tmpErr != nil {
+			return
+		}
+		stat := &process.Stat
+		forEachKeyValue(data, func(key []byte, value []byte) {
+			switch string(key) {
+			case "Name":
+				process.Name = string(value)
+			case "State":
+				switch value[0] {
+				case 'R':
+					process.State = 3
+				case 'D':
+					process.State = 2
+				case 'S':
+					process.State = 1
+				case 'Z':
+					process.State = -1
+				default:
+					process.State = 0
+				}
+			case "Tgid":
+				process.Tgid, _ = parseIntBytes(value)
+			case "PPid":
+				process.Ppid, _ = parseIntBytes(value)
+			case "VmSize":
+				stat.VmSizeKb, _ = parseIntBytes(firstField(value))
+			case "VmRSS":
+				stat.VmRssKb, _ = parseIntBytes(firstField(value))
+			case "HugetlbPages":
+				stat.HugetlbPages, _ = parseIntBytes(firstField(value))
+			case "Threads":
+				stat.Threads, _ = parseIntBytes(value)
+			case "voluntary_ctxt_switches":
+				stat.VoluntaryCtxtSwitches, _ = parseIntBytes(value)
+			case "nonvoluntary_ctxt_switches":
+				stat.NonvoluntaryCtxtSwitches, _ = parseIntBytes(value)
+			}
+		})
 	}
+	isFound = true
 
 	// ----------------------------------------------------------------------------------------------------
 	// Parse /proc/[pid]/schedstat
 	// 2554841551 177487694 35200
 	// [time spent on the cpu] [time spent waiting on a runqueue] [timeslices run on this cpu]
-	if tmpFile, tmpErr = os.Open(procDir + "schedstat"); tmpErr != nil {
-		return
-	}
-	tmpReader = bufio.NewReader(tmpFile)
-	if tmpBytes, _, tmpErr = tmpReader.ReadLine(); tmpErr != nil {
-		return
-	}
-	tmpTexts = strings.Split(string(tmpBytes), " ")
-	if len(tmpTexts) != 3 {
-		err = fmt.Errorf("Unexpected Format: path=/proc/[pid]/schedstat, text=%s", string(tmpBytes))
-		return
+	if self.fields&ProcessFieldSchedstat != 0 {
+		if data, tmpErr = self.readFile(procDir + "schedstat"); tmpErr == nil {
+			var values [3]int
+			if n := parseIntFields(data, values[:]); n != 3 {
+				err = fmt.Errorf("Unexpected Format: path=/proc/[pid]/schedstat, text=%s", string(data))
+				return
+			}
+			process.Stat.SchedCpuTime = values[0]
+			process.Stat.SchedWaitTime = values[1]
+			process.Stat.SchedTimeSlices = values[2]
+		}
 	}
-	// time spent on the cpu
-	schedCpuTime, _ := strconv.Atoi(tmpTexts[0])
-	// time spent waiting on a runqueue
-	schedWaitTime, _ := strconv.Atoi(tmpTexts[1])
-	// # of timeslices run on this cpu
-	schedTimeSlices, _ := strconv.Atoi(tmpTexts[2])
 
 	// ----------------------------------------------------------------------------------------------------
 	// $ cat /proc/24120/stat
 	// 24120 (qemu-system-x86) S 24119 24120 24119 0 -1 138412416 23189 0 0 0 2227 753 0 0 20 0 6 0 251962 4969209856 7743 18446744073709551615 1 1 0 0 0 0 268444224 4096 16963 0 0 0 17 9 0 0 0 2041 0 0 0 0 0 0 0 0 0
-	if tmpFile, tmpErr = os.Open(procDir + "stat"); tmpErr != nil {
-		return
-	}
-	tmpReader = bufio.NewReader(tmpFile)
-	if tmpBytes, _, tmpErr = tmpReader.ReadLine(); tmpErr != nil {
-		return
+	// commには空白や括弧が含まれることがあるので、最後の")"より後ろをパースする(stateが先頭となる)
+	if self.fields&ProcessFieldStat != 0 {
+		if data, tmpErr = self.readFile(procDir + "stat"); tmpErr == nil {
+			if index := bytes.LastIndexByte(data, ')'); index >= 0 && index+2 < len(data) {
+				var values [42]int
+				parseIntFields(data[index+2:], values[:])
+				process.Stat.Utime = values[11]
+				process.Stat.Stime = values[12]
+				process.Stat.StartTime = values[19]
+				process.Stat.Gtime = values[40]
+				process.Stat.Cgtime = values[41]
+			}
+		}
 	}
-	tmpTexts = strings.Split(string(tmpBytes), " ")
-	utime, _ := strconv.Atoi(tmpTexts[13])
-	stime, _ := strconv.Atoi(tmpTexts[14])
-	gtime, _ := strconv.Atoi(tmpTexts[42])
-	cgtime, _ := strconv.Atoi(tmpTexts[43])
-	startTime, _ := strconv.Atoi(tmpTexts[21])
 
 	// ----------------------------------------------------------------------------------------------------
 	// $ cat /proc/24120/io
@@ -320,43 +364,214 @@ func getProcess(rootProcDir string, pidStr string, isVerbose bool) (process *Pro
 	// write_bytes: 15466496
 	// cancelled_write_bytes: 0
 	// root権限がないと見れない
-	if tmpFile, tmpErr = os.Open(procDir + "io"); tmpErr != nil {
+	if self.fields&ProcessFieldIo != 0 {
+		if data, tmpErr = self.readFile(procDir + "io"); tmpErr == nil {
+			stat := &process.Stat
+			forEachKeyValue(data, func(key []byte, value []byte) {
+				switch string(key) {
+				case "syscr":
+					stat.Syscr, _ = parseIntBytes(value)
+				case "syscw":
+					stat.Syscw, _ = parseIntBytes(value)
+				case "read_bytes":
+					stat.ReadBytes, _ = parseIntBytes(value)
+				case "write_bytes":
+					stat.WriteBytes, _ = parseIntBytes(value)
+				}
+			})
+		}
+	}
+
+	// ----------------------------------------------------------------------------------------------------
+	// $ cat /proc/24120/oom_score
+	// 666
+	// $ cat /proc/24120/oom_score_adj
+	// 0
+	// カーネルスレッドなどでは読めないこともあるので、読めなくてもエラーにはしない
+	if self.fields&ProcessFieldOom != 0 {
+		if data, tmpErr = self.readFile(procDir + "oom_score"); tmpErr == nil {
+			process.Stat.OomScore, _ = parseIntBytes(bytes.TrimSpace(data))
+		}
+		if data, tmpErr = self.readFile(procDir + "oom_score_adj"); tmpErr == nil {
+			process.Stat.OomScoreAdj, _ = parseIntBytes(bytes.TrimSpace(data))
+		}
+	}
+
+	// ----------------------------------------------------------------------------------------------------
+	// $ cat /proc/24120/smaps_rollup
+	// 55faf8464000-7fff0bd88000 ---p 00000000 00:00 0                          [rollup]
+	// Rss:                1296 kB
+	// Pss:                 485 kB
+	// Pss_Anon:            100 kB
+	// Pss_File:            385 kB
+	// Pss_Shmem:             0 kB
+	// Shared_Clean:       1112 kB
+	// Shared_Dirty:          0 kB
+	// Private_Clean:        84 kB
+	// Private_Dirty:       100 kB
+	// Referenced:         1296 kB
+	// Anonymous:           100 kB
+	// LazyFree:              0 kB
+	// AnonHugePages:         0 kB
+	// ShmemPmdMapped:        0 kB
+	// FilePmdMapped:         0 kB
+	// Shared_Hugetlb:        0 kB
+	// Private_Hugetlb:       0 kB
+	// Swap:                  0 kB
+	// SwapPss:               0 kB
+	// Locked:                0 kB
+	// ptraceの権限がないと見れない
+	if self.fields&ProcessFieldSmaps != 0 {
+		if data, tmpErr = self.readFile(procDir + "smaps_rollup"); tmpErr == nil {
+			stat := &process.Stat
+			forEachKeyValue(data, func(key []byte, value []byte) {
+				switch string(key) {
+				case "Pss":
+					stat.PssKb, _ = parseIntBytes(firstField(value))
+				case "Swap":
+					stat.SwapKb, _ = parseIntBytes(firstField(value))
+				case "SwapPss":
+					stat.SwapPssKb, _ = parseIntBytes(firstField(value))
+				case "Shared_Clean":
+					stat.SharedCleanKb, _ = parseIntBytes(firstField(value))
+				case "Shared_Dirty":
+					stat.SharedDirtyKb, _ = parseIntBytes(firstField(value))
+				case "Private_Clean":
+					stat.PrivateCleanKb, _ = parseIntBytes(firstField(value))
+				case "Private_Dirty":
+					stat.PrivateDirtyKb, _ = parseIntBytes(firstField(value))
+				case "AnonHugePages":
+					stat.AnonHugePagesKb, _ = parseIntBytes(firstField(value))
+				}
+			})
+			stat.UssKb = stat.PrivateCleanKb + stat.PrivateDirtyKb
+		}
+	}
+
+	return
+}
+
+// forEachKeyValue は、"Key:   value" 形式の行ごとに、前後の空白を除いたkeyとvalueでfを呼ぶ
+// 行ごとにstringを作らないように、dataのスライスをそのまま渡す
+func forEachKeyValue(data []byte, f func(key []byte, value []byte)) {
+	for len(data) > 0 {
+		line := data
+		if index := bytes.IndexByte(data, '\n'); index >= 0 {
+			line = data[:index]
+			data = data[index+1:]
+		} else {
+			data = nil
+		}
+		index := bytes.IndexByte(line, ':')
+		if index < 0 {
+			continue
+		}
+		value := bytes.TrimSpace(line[index+1:])
+		if len(value) == 0 {
+			continue
+		}
+		f(line[:index], value)
+	}
+}
+
+// firstField は、"28784 kB" のような値から最初のフィールドを返す
+func firstField(value []byte) []byte {
+	if index := bytes.IndexAny(value, " \t"); index >= 0 {
+		return value[:index]
+	}
+	return value
+}
+
+// parseIntBytes は、strconv.Atoiと異なりstringへの変換を行わずに整数をパースする
+func parseIntBytes(data []byte) (value int, ok bool) {
+	if len(data) == 0 {
 		return
 	}
-	tmpReader = bufio.NewReader(tmpFile)
-	_, _, _ = tmpReader.ReadLine()
-	_, _, _ = tmpReader.ReadLine()
-	tmpBytes, _, _ = tmpReader.ReadLine()
-	syscr, _ := strconv.Atoi(str_utils.ParseLastValue(string(tmpBytes)))
-	tmpBytes, _, _ = tmpReader.ReadLine()
-	syscw, _ := strconv.Atoi(str_utils.ParseLastValue(string(tmpBytes)))
-	tmpBytes, _, _ = tmpReader.ReadLine()
-	readBytes, _ := strconv.Atoi(str_utils.ParseLastValue(string(tmpBytes)))
-	tmpBytes, _, _ = tmpReader.ReadLine()
-	writeBytes, _ := strconv.Atoi(str_utils.ParseLastValue(string(tmpBytes)))
-
-	process.Stat = ProcessStat{
-		SchedCpuTime:             schedCpuTime,
-		SchedWaitTime:            schedWaitTime,
-		SchedTimeSlices:          schedTimeSlices,
-		VmSizeKb:                 vmSizeKb,
-		VmRssKb:                  vmRssKb,
-		HugetlbPages:             hugetlbPages,
-		Threads:                  threads,
-		VoluntaryCtxtSwitches:    voluntaryCtxtSwitches,
-		NonvoluntaryCtxtSwitches: nonvoluntaryCtxtSwitches,
-
-		Utime:     utime,
-		Stime:     stime,
-		Gtime:     gtime,
-		Cgtime:    cgtime,
-		StartTime: startTime,
-
-		Syscr:      syscr,
-		Syscw:      syscw,
-		ReadBytes:  readBytes,
-		WriteBytes: writeBytes,
+	isNegative := false
+	if data[0] == '-' {
+		isNegative = true
+		data = data[1:]
+		if len(data) == 0 {
+			return
+		}
+	}
+	for _, c := range data {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		value = value*10 + int(c-'0')
+	}
+	if isNegative {
+		value = -value
+	}
+	ok = true
+	return
+}
+
+// parseIntFields は、空白区切りの整数をvaluesに順に格納し、フィールド数を返す
+// values に入りきらないフィールドは数えるだけで格納しない
+func parseIntFields(data []byte, values []int) (n int) {
+	for len(data) > 0 {
+		for len(data) > 0 && (data[0] == ' ' || data[0] == '\t' || data[0] == '\n') {
+			data = data[1:]
+		}
+		if len(data) == 0 {
+			break
+		}
+		end := 0
+		for end < len(data) && data[end] != ' ' && data[end] != '\t' && data[end] != '\n' {
+			end += 1
+		}
+		if n < len(values) {
+			values[n], _ = parseIntBytes(data[:end])
+		}
+		n += 1
+		data = data[end:]
+	}
+	return
+}
+
+const (
+	ProcessSortKeyRss      = "rss"
+	ProcessSortKeyPss      = "pss"
+	ProcessSortKeyUss      = "uss"
+	ProcessSortKeySwap     = "swap"
+	ProcessSortKeyAnonHuge = "anonhuge"
+	ProcessSortKeyOomScore = "oom"
+	ProcessSortKeyCpu      = "cpu"
+)
+
+var processSortKeyFuncMap = map[string]func(process *Process) int{
+	ProcessSortKeyRss:      func(process *Process) int { return process.Stat.VmRssKb },
+	ProcessSortKeyPss:      func(process *Process) int { return process.Stat.PssKb },
+	ProcessSortKeyUss:      func(process *Process) int { return process.Stat.UssKb },
+	ProcessSortKeySwap:     func(process *Process) int { return process.Stat.SwapKb },
+	ProcessSortKeyAnonHuge: func(process *Process) int { return process.Stat.AnonHugePagesKb },
+	ProcessSortKeyOomScore: func(process *Process) int { return process.Stat.OomScore },
+	ProcessSortKeyCpu:      func(process *Process) int { return process.Stat.UserUtil + process.Stat.SystemUtil },
+}
+
+// IsDeepProcessSortKey は、keyの値がsmaps_rollupから読み込むもの(IsProcessDeepが必要)かを返す
+func IsDeepProcessSortKey(key string) bool {
+	switch key {
+	case ProcessSortKeyPss, ProcessSortKeyUss, ProcessSortKeySwap, ProcessSortKeyAnonHuge:
+		return true
 	}
+	return false
+}
 
+// SortProcesses は、keyの値の降順に並べ替えたprocessesのコピーを返す
+// GetProcessesの結果はpidIndexMapとインデックスが対応しているので、元のスライスは並べ替えない
+func SortProcesses(processes []Process, key string) (sortedProcesses []Process, err error) {
+	keyFunc, ok := processSortKeyFuncMap[key]
+	if !ok {
+		err = fmt.Errorf("Unexpected SortKey: key=%s", key)
+		return
+	}
+	sortedProcesses = make([]Process, len(processes))
+	copy(sortedProcesses, processes)
+	sort.SliceStable(sortedProcesses, func(i, j int) bool {
+		return keyFunc(&sortedProcesses[i]) > keyFunc(&sortedProcesses[j])
+	})
 	return
 }
@@ -120,6 +120,8 @@ func TestGetProcesses(t *testing.T) {
 				Syscw:                    1,
 				ReadBytes:                0,
 				WriteBytes:               0,
+				OomScore:                 668,
+				OomScoreAdj:              200,
 			},
 		},
 		Process{
@@ -207,5 +209,134 @@ func TestGetProcesses(t *testing.T) {
 		_, _, err := GetProcesses(wd+"/testdata/exception_no_io/", true)
 		a.NoError(err)
 	}
+}
+
+func TestGetProcessesWithOption(t *testing.T) {
+	a := assert.New(t)
+
+	wd, err := os.Getwd()
+	a.NoError(err)
+	rootDir := wd + "/testdata/root/"
+
+	processes, pidIndexMap, err := GetProcessesWithOption(rootDir, &ProcessOption{IsVerbose: true, IsDeep: true})
+	a.NoError(err)
+
+	{
+		stat := processes[pidIndexMap[1]].Stat
+		a.Equal(3339, stat.PssKb)
+		a.Equal(3124, stat.UssKb)
+		a.Equal(128, stat.SwapKb)
+		a.Equal(128, stat.SwapPssKb)
+		a.Equal(6288, stat.SharedCleanKb)
+		a.Equal(0, stat.SharedDirtyKb)
+		a.Equal(152, stat.PrivateCleanKb)
+		a.Equal(2972, stat.PrivateDirtyKb)
+		a.Equal(0, stat.AnonHugePagesKb)
+	}
+
+	{
+		stat := processes[pidIndexMap[21607]].Stat
+		a.Equal(12904, stat.PssKb)
+		a.Equal(12904, stat.UssKb)
+		a.Equal(8192, stat.AnonHugePagesKb)
+		a.Equal(668, stat.OomScore)
+		a.Equal(200, stat.OomScoreAdj)
+	}
+
+	{
+		// smaps_rollupがない
+		stat := processes[pidIndexMap[21613]].Stat
+		a.Equal(0, stat.PssKb)
+		a.Equal(856, stat.VmRssKb)
+	}
+
+	{
+		// IsDeepでなければsmaps_rollupは読まない
+		processes, pidIndexMap, err := GetProcessesWithOption(rootDir, &ProcessOption{IsVerbose: true})
+		a.NoError(err)
+		a.Equal(0, processes[pidIndexMap[1]].Stat.PssKb)
+	}
+
+	{
+		// Fieldsで選択したファイルのみ読む
+		processes, pidIndexMap, err := GetProcessesWithOption(rootDir, &ProcessOption{Fields: ProcessFieldCmdline | ProcessFieldStatus})
+		a.NoError(err)
+		process := processes[pidIndexMap[21613]]
+		a.Equal([]string{"sleep", "1000"}, process.Cmds)
+		a.Equal(21607, process.Ppid)
+		a.Equal(856, process.Stat.VmRssKb)
+		a.Equal(0, process.Stat.SchedCpuTime)
+		a.Equal(0, process.Stat.Syscr)
+		a.Equal([]int{21613}, processes[pidIndexMap[21607]].Children)
+	}
+}
 
+func TestSortProcesses(t *testing.T) {
+	a := assert.New(t)
+
+	wd, err := os.Getwd()
+	a.NoError(err)
+	rootDir := wd + "/testdata/root/"
+
+	processes, pidIndexMap, err := GetProcessesWithOption(rootDir, &ProcessOption{IsVerbose: true, IsDeep: true})
+	a.NoError(err)
+
+	sortedProcesses, err := SortProcesses(processes, ProcessSortKeyPss)
+	a.NoError(err)
+	pids := []int{}
+	for _, process := range sortedProcesses {
+		pids = append(pids, process.Pid)
+	}
+	a.Equal([]int{21607, 1}, pids[0:2])
+
+	sortedProcesses, err = SortProcesses(processes, ProcessSortKeyOomScore)
+	a.NoError(err)
+	a.Equal(21607, sortedProcesses[0].Pid)
+
+	// 元のスライスは並べ替えない
+	for pid, index := range pidIndexMap {
+		a.Equal(pid, processes[index].Pid)
+	}
+
+	_, err = SortProcesses(processes, "unknown")
+	a.Error(err)
+}
+
+func BenchmarkGetProcesses(b *testing.B) {
+	wd, _ := os.Getwd()
+	rootDir := wd + "/testdata/root/"
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := GetProcesses(rootDir, true); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProcessReader(b *testing.B) {
+	wd, _ := os.Getwd()
+	rootDir := wd + "/testdata/root/"
+
+	benchmarks := []struct {
+		name   string
+		option ProcessOption
+	}{
+		{name: "Basic", option: ProcessOption{}},
+		{name: "Verbose", option: ProcessOption{IsVerbose: true}},
+		{name: "Deep", option: ProcessOption{IsVerbose: true, IsDeep: true}},
+		{name: "CmdlineOnly", option: ProcessOption{Fields: ProcessFieldCmdline}},
+	}
+	for _, benchmark := range benchmarks {
+		b.Run(benchmark.name, func(b *testing.B) {
+			// StatRunnerと同様に、Readerを使いまわしてバッファを再利用する
+			reader := NewProcessReader(&benchmark.option)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := reader.GetProcesses(rootDir); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
 }
@@ -0,0 +1,231 @@
+package os_utils
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type SensorStat struct {
+	TempStats        []TempStat
+	FanStats         []FanStat
+	ThermalZoneStats []ThermalZoneStat
+	RaplStats        []RaplStat
+	CpuFreqStats     []CpuFreqStat
+}
+
+type TempStat struct {
+	Chip  string
+	Label string
+	Temp  float64 // ℃
+	Max   float64 // ℃
+	Crit  float64 // ℃
+}
+
+type FanStat struct {
+	Chip  string
+	Label string
+	Rpm   int
+}
+
+type ThermalZoneStat struct {
+	Zone string
+	Type string
+	Temp float64 // ℃
+}
+
+type RaplStat struct {
+	Zone             string
+	Name             string
+	EnergyUj         int
+	MaxEnergyRangeUj int
+
+	// 差分Stat
+	Watts float64
+}
+
+type CpuFreqStat struct {
+	Cpu                  int
+	CurKhz               int
+	MaxKhz               int
+	CoreThrottleCount    int
+	PackageThrottleCount int
+
+	// 差分Stat
+	CoreThrottlePerSec    int
+	PackageThrottlePerSec int
+}
+
+const (
+	HwmonDir     = "sys/class/hwmon/"
+	ThermalDir   = "sys/class/thermal/"
+	PowercapDir  = "sys/class/powercap/"
+	CpuDeviceDir = "sys/devices/system/cpu/"
+)
+
+func GetSensorStat(rootDir string) (sensorStat *SensorStat, err error) {
+	// センサーはハードウェアによって存在しないことが多いので、どれも読めなくてもエラーにはしない
+	tempStats, fanStats := getHwmonStats(rootDir)
+	sensorStat = &SensorStat{
+		TempStats:        tempStats,
+		FanStats:         fanStats,
+		ThermalZoneStats: getThermalZoneStats(rootDir),
+		RaplStats:        getRaplStats(rootDir),
+		CpuFreqStats:     getCpuFreqStats(rootDir),
+	}
+	return
+}
+
+func getHwmonStats(rootDir string) (tempStats []TempStat, fanStats []FanStat) {
+	// $ ls /sys/class/hwmon/hwmon2
+	// device  name  power  subsystem  temp1_crit  temp1_input  temp1_label  temp1_max  uevent
+	// $ cat /sys/class/hwmon/hwmon2/name
+	// coretemp
+	// $ cat /sys/class/hwmon/hwmon2/temp1_input
+	// 45000
+	// temp*は1/1000℃単位、fan*はRPM
+	hwmonDir := rootDir + HwmonDir
+	hwmons, _ := readDirNames(hwmonDir)
+	sort.Strings(hwmons)
+	for _, hwmon := range hwmons {
+		chipDir := filepath.Join(hwmonDir, hwmon)
+		chip := readSysfsStr(filepath.Join(chipDir, "name"))
+		if chip == "" {
+			chip = hwmon
+		}
+
+		files, _ := readDirNames(chipDir)
+		sort.Strings(files)
+		for _, file := range files {
+			if !strings.HasSuffix(file, "_input") {
+				continue
+			}
+			prefix := strings.TrimSuffix(file, "_input")
+			label := readSysfsStr(filepath.Join(chipDir, prefix+"_label"))
+			if label == "" {
+				label = prefix
+			}
+			switch {
+			case strings.HasPrefix(prefix, "temp"):
+				tempStats = append(tempStats, TempStat{
+					Chip:  chip,
+					Label: label,
+					Temp:  float64(readSysfsInt(filepath.Join(chipDir, file))) / 1000,
+					Max:   float64(readSysfsInt(filepath.Join(chipDir, prefix+"_max"))) / 1000,
+					Crit:  float64(readSysfsInt(filepath.Join(chipDir, prefix+"_crit"))) / 1000,
+				})
+			case strings.HasPrefix(prefix, "fan"):
+				fanStats = append(fanStats, FanStat{
+					Chip:  chip,
+					Label: label,
+					Rpm:   readSysfsInt(filepath.Join(chipDir, file)),
+				})
+			}
+		}
+	}
+	return
+}
+
+func getThermalZoneStats(rootDir string) (thermalZoneStats []ThermalZoneStat) {
+	// $ cat /sys/class/thermal/thermal_zone0/type
+	// x86_pkg_temp
+	// $ cat /sys/class/thermal/thermal_zone0/temp
+	// 46000
+	thermalDir := rootDir + ThermalDir
+	zones, _ := readDirNames(thermalDir)
+	sort.Strings(zones)
+	for _, zone := range zones {
+		if !strings.HasPrefix(zone, "thermal_zone") {
+			continue
+		}
+		zoneDir := filepath.Join(thermalDir, zone)
+		thermalZoneStats = append(thermalZoneStats, ThermalZoneStat{
+			Zone: zone,
+			Type: readSysfsStr(filepath.Join(zoneDir, "type")),
+			Temp: float64(readSysfsInt(filepath.Join(zoneDir, "temp"))) / 1000,
+		})
+	}
+	return
+}
+
+func getRaplStats(rootDir string) (raplStats []RaplStat) {
+	// $ ls /sys/class/powercap
+	// intel-rapl  intel-rapl:0  intel-rapl:0:0  intel-rapl:0:1
+	// $ cat /sys/class/powercap/intel-rapl:0/name
+	// package-0
+	// $ cat /sys/class/powercap/intel-rapl:0/energy_uj
+	// 53287146215
+	// energy_ujは積算値であり、max_energy_range_ujを超えると0に戻る
+	powercapDir := rootDir + PowercapDir
+	zones, _ := readDirNames(powercapDir)
+	sort.Strings(zones)
+	for _, zone := range zones {
+		if !strings.HasPrefix(zone, "intel-rapl:") {
+			continue
+		}
+		zoneDir := filepath.Join(powercapDir, zone)
+		energyUjStr := readSysfsStr(filepath.Join(zoneDir, "energy_uj"))
+		if energyUjStr == "" {
+			// energy_ujはroot権限がないと読めない
+			continue
+		}
+		energyUj, _ := strconv.Atoi(energyUjStr)
+		raplStats = append(raplStats, RaplStat{
+			Zone:             zone,
+			Name:             readSysfsStr(filepath.Join(zoneDir, "name")),
+			EnergyUj:         energyUj,
+			MaxEnergyRangeUj: readSysfsInt(filepath.Join(zoneDir, "max_energy_range_uj")),
+		})
+	}
+	return
+}
+
+func getCpuFreqStats(rootDir string) (cpuFreqStats []CpuFreqStat) {
+	// $ cat /sys/devices/system/cpu/cpu0/cpufreq/scaling_cur_freq
+	// 2194843
+	// $ cat /sys/devices/system/cpu/cpu0/thermal_throttle/core_throttle_count
+	// 0
+	cpuDeviceDir := rootDir + CpuDeviceDir
+	cpus, _ := readDirNames(cpuDeviceDir)
+	for _, cpuName := range cpus {
+		if !strings.HasPrefix(cpuName, "cpu") {
+			continue
+		}
+		cpu, tmpErr := strconv.Atoi(cpuName[3:])
+		if tmpErr != nil {
+			continue
+		}
+		cpuDir := filepath.Join(cpuDeviceDir, cpuName)
+		curKhzStr := readSysfsStr(filepath.Join(cpuDir, "cpufreq/scaling_cur_freq"))
+		if curKhzStr == "" {
+			continue
+		}
+		curKhz, _ := strconv.Atoi(curKhzStr)
+		cpuFreqStats = append(cpuFreqStats, CpuFreqStat{
+			Cpu:                  cpu,
+			CurKhz:               curKhz,
+			MaxKhz:               readSysfsInt(filepath.Join(cpuDir, "cpufreq/scaling_max_freq")),
+			CoreThrottleCount:    readSysfsInt(filepath.Join(cpuDir, "thermal_throttle/core_throttle_count")),
+			PackageThrottleCount: readSysfsInt(filepath.Join(cpuDir, "thermal_throttle/package_throttle_count")),
+		})
+	}
+	sort.Slice(cpuFreqStats, func(i, j int) bool {
+		return cpuFreqStats[i].Cpu < cpuFreqStats[j].Cpu
+	})
+	return
+}
+
+func readSysfsStr(path string) string {
+	tmpBytes, tmpErr := ioutil.ReadFile(path)
+	if tmpErr != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(tmpBytes))
+}
+
+func readSysfsInt(path string) int {
+	value, _ := strconv.Atoi(readSysfsStr(path))
+	return value
+}
@@ -0,0 +1,84 @@
+package os_utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSensorStat(t *testing.T) {
+	a := assert.New(t)
+
+	wd, err := os.Getwd()
+	a.NoError(err)
+	rootDir := wd + "/testdata/root/"
+
+	sensorStat, err := GetSensorStat(rootDir)
+	a.NoError(err)
+
+	expectedTempStats := []TempStat{
+		TempStat{Chip: "coretemp", Label: "Package id 0", Temp: 45, Max: 80, Crit: 100},
+		TempStat{Chip: "coretemp", Label: "Core 0", Temp: 43, Max: 80, Crit: 100},
+		TempStat{Chip: "nct6775", Label: "temp1", Temp: 36.5},
+	}
+	a.Equal(expectedTempStats, sensorStat.TempStats)
+
+	expectedFanStats := []FanStat{
+		FanStat{Chip: "nct6775", Label: "fan1", Rpm: 1200},
+	}
+	a.Equal(expectedFanStats, sensorStat.FanStats)
+
+	expectedThermalZoneStats := []ThermalZoneStat{
+		ThermalZoneStat{Zone: "thermal_zone0", Type: "x86_pkg_temp", Temp: 46},
+	}
+	a.Equal(expectedThermalZoneStats, sensorStat.ThermalZoneStats)
+
+	expectedCpuFreqStats := []CpuFreqStat{
+		CpuFreqStat{Cpu: 0, CurKhz: 2194843, MaxKhz: 3600000, CoreThrottleCount: 3, PackageThrottleCount: 5},
+		CpuFreqStat{Cpu: 1, CurKhz: 1800000, MaxKhz: 3600000, CoreThrottleCount: 0, PackageThrottleCount: 5},
+	}
+	a.Equal(expectedCpuFreqStats, sensorStat.CpuFreqStats)
+
+	// powercapがない
+	a.Nil(sensorStat.RaplStats)
+
+	{
+		// rootがなくてもエラーにはしない
+		sensorStat, err := GetSensorStat(wd + "/testdata/none/")
+		a.NoError(err)
+		a.Equal(SensorStat{}, *sensorStat)
+	}
+}
+
+func TestGetSensorStatRapl(t *testing.T) {
+	a := assert.New(t)
+
+	// intel-rapl:0 のようにコロンを含むパスはモジュールに含められないので、テスト時に作成する
+	rootDir := t.TempDir() + "/"
+	files := map[string]string{
+		"sys/class/powercap/intel-rapl/enabled":                    "1",
+		"sys/class/powercap/intel-rapl:0/name":                     "package-0",
+		"sys/class/powercap/intel-rapl:0/energy_uj":                "53287146215",
+		"sys/class/powercap/intel-rapl:0/max_energy_range_uj":      "262143328850",
+		"sys/class/powercap/intel-rapl:0:0/name":                   "core",
+		"sys/class/powercap/intel-rapl:0:0/energy_uj":              "21287146215",
+		"sys/class/powercap/intel-rapl:0:0/max_energy_range_uj":    "262143328850",
+		"sys/class/powercap/intel-rapl-mmio:0/max_energy_range_uj": "262143328850",
+	}
+	for path, content := range files {
+		a.NoError(os.MkdirAll(filepath.Dir(rootDir+path), 0755))
+		a.NoError(ioutil.WriteFile(rootDir+path, []byte(content+"\n"), 0644))
+	}
+
+	sensorStat, err := GetSensorStat(rootDir)
+	a.NoError(err)
+
+	expectedRaplStats := []RaplStat{
+		RaplStat{Zone: "intel-rapl:0", Name: "package-0", EnergyUj: 53287146215, MaxEnergyRangeUj: 262143328850},
+		RaplStat{Zone: "intel-rapl:0:0", Name: "core", EnergyUj: 21287146215, MaxEnergyRangeUj: 262143328850},
+	}
+	a.Equal(expectedRaplStats, sensorStat.RaplStats)
+}
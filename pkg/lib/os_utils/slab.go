@@ -0,0 +1,94 @@
+package os_utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/syunkitada/goapp2/pkg/lib/str_utils"
+)
+
+// 表示やメトリクスに利用する、メモリ使用量の多いキャッシュの数
+const SlabTopCaches = 10
+
+type SlabStat struct {
+	TotalBytes int
+	Caches     []SlabCacheStat // Bytesの降順
+
+	// 差分Stat
+	TotalBytesPerSec int
+}
+
+type SlabCacheStat struct {
+	Name         string
+	ActiveObjs   int
+	NumObjs      int
+	ObjSize      int
+	ObjPerSlab   int
+	PagesPerSlab int
+	ActiveSlabs  int
+	NumSlabs     int
+	Bytes        int // NumSlabs * PagesPerSlab * ページサイズ
+
+	// 差分Stat
+	BytesPerSec int
+}
+
+// GetSlabStat は、/proc/slabinfoからキャッシュごとのメモリ使用量を取得する
+// /proc/slabinfoはrootのみ読み込めるので、root権限が必要
+func GetSlabStat(rootDir string) (slabStat *SlabStat, err error) {
+	// $ cat /proc/slabinfo
+	// slabinfo - version: 2.1
+	// # name            <active_objs> <num_objs> <objsize> <objperslab> <pagesperslab> : tunables <limit> <batchcount> <sharedfactor> : slabdata <active_slabs> <num_slabs> <sharedavail>
+	// ext4_groupinfo_4k   2054   2054    152   26    1 : tunables    0    0    0 : slabdata     79     79      0
+	var slabinfoFile *os.File
+	if slabinfoFile, err = os.Open(rootDir + "proc/slabinfo"); err != nil {
+		return
+	}
+	defer slabinfoFile.Close()
+
+	pageSize := os.Getpagesize()
+	slabStat = &SlabStat{}
+	scanner := bufio.NewScanner(slabinfoFile)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "slabinfo") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		columns := str_utils.SplitSpace(line)
+		if len(columns) < 16 {
+			err = fmt.Errorf("Unexpected Format: path=/proc/slabinfo, line=%s", line)
+			return
+		}
+		cacheStat := SlabCacheStat{Name: columns[0]}
+		cacheStat.ActiveObjs, _ = strconv.Atoi(columns[1])
+		cacheStat.NumObjs, _ = strconv.Atoi(columns[2])
+		cacheStat.ObjSize, _ = strconv.Atoi(columns[3])
+		cacheStat.ObjPerSlab, _ = strconv.Atoi(columns[4])
+		cacheStat.PagesPerSlab, _ = strconv.Atoi(columns[5])
+		cacheStat.ActiveSlabs, _ = strconv.Atoi(columns[13])
+		cacheStat.NumSlabs, _ = strconv.Atoi(columns[14])
+		cacheStat.Bytes = cacheStat.NumSlabs * cacheStat.PagesPerSlab * pageSize
+		slabStat.TotalBytes += cacheStat.Bytes
+		slabStat.Caches = append(slabStat.Caches, cacheStat)
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+
+	sort.SliceStable(slabStat.Caches, func(i, j int) bool {
+		return slabStat.Caches[i].Bytes > slabStat.Caches[j].Bytes
+	})
+	return
+}
+
+// TopCaches は、メモリ使用量の多い順にn個のキャッシュを返す
+func (self *SlabStat) TopCaches(n int) []SlabCacheStat {
+	if len(self.Caches) < n {
+		return self.Caches
+	}
+	return self.Caches[:n]
+}
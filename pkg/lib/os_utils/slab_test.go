@@ -0,0 +1,46 @@
+package os_utils
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSlabStat(t *testing.T) {
+	a := assert.New(t)
+
+	wd, err := os.Getwd()
+	a.NoError(err)
+	rootDir := wd + "/testdata/root/"
+
+	slabStat, err := GetSlabStat(rootDir)
+	a.NoError(err)
+
+	pageSize := os.Getpagesize()
+	a.Len(slabStat.Caches, 4)
+	a.Equal((79+4+4715+200)*pageSize, slabStat.TotalBytes)
+	// メモリ使用量の降順
+	a.Equal(SlabCacheStat{
+		Name: "dentry", ActiveObjs: 98910, NumObjs: 99015, ObjSize: 192, ObjPerSlab: 21, PagesPerSlab: 1,
+		ActiveSlabs: 4715, NumSlabs: 4715, Bytes: 4715 * pageSize,
+	}, slabStat.Caches[0])
+	a.Equal("kmalloc-64", slabStat.Caches[1].Name)
+	a.Equal("AF_VSOCK", slabStat.Caches[3].Name)
+	a.Len(slabStat.TopCaches(2), 2)
+	a.Len(slabStat.TopCaches(SlabTopCaches), 4)
+
+	{
+		// 前回からの増加量
+		statRunner := &StatRunner{interval: 2}
+		statRunner.syncSlabStat(slabStat)
+		slabStat2, err := GetSlabStat(rootDir)
+		a.NoError(err)
+		slabStat2.Caches[0].Bytes += 4 * pageSize
+		slabStat2.TotalBytes += 4 * pageSize
+		statRunner.syncSlabStat(slabStat2)
+		a.Equal(2*pageSize, slabStat2.TotalBytesPerSec)
+		a.Equal(2*pageSize, slabStat2.Caches[0].BytesPerSec)
+		a.Equal(0, slabStat2.Caches[1].BytesPerSec)
+	}
+}
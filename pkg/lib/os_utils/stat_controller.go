@@ -14,6 +14,18 @@ import (
 type StatControllerConfig struct {
 	runner.Config
 	HandleStats func(runAt time.Time, stats *Stats)
+	// プロセスのsmaps_rollupも収集する
+	IsProcessDeep bool
+	// /proc, /sys を読み込むルートディレクトリ(デフォルトは"/")
+	RootDir string
+	// 異常検知の設定(nilの場合は異常検知を行わない)
+	AnalyzerConfig *AnalyzerConfig
+	// collectorごとのタイムアウト(デフォルトはIntervalのDefaultCollectorTimeoutRatio倍)
+	CollectorTimeout time.Duration
+	// 特定のcollectorのみタイムアウトを変更する(キーはcpu, disk などのcollector名)
+	CollectorTimeouts map[string]time.Duration
+	// 実行するcollector(CollectorNamesの値)、空の場合は全てのcollectorを実行する
+	Collectors []string
 }
 
 type StatController struct {
@@ -22,60 +34,103 @@ type StatController struct {
 }
 
 func NewStatController(conf *StatControllerConfig) (statController *StatController) {
+	clkTck, tmpErr := GetClkTck()
+	if tmpErr != nil {
+		os.Exit(1)
+	}
+	statRunner := newStatRunner(conf, clkTck)
+	statController = &StatController{
+		Runner:     *runner.New(&conf.Config, statRunner),
+		statRunner: statRunner,
+	}
+	return
+}
+
+func GetClkTck() (clkTck int, err error) {
 	ecmd := exec.Command("getconf", "CLK_TCK")
 	out := new(bytes.Buffer)
 	ecmd.Stdout = out
-	tmpErr := ecmd.Run()
-	if tmpErr != nil {
-		os.Exit(1)
+	if err = ecmd.Run(); err != nil {
+		return
 	}
-	clkTck, tmpErr := strconv.Atoi(strings.TrimSpace(out.String()))
-	if tmpErr != nil {
-		os.Exit(1)
+	clkTck, err = strconv.Atoi(strings.TrimSpace(out.String()))
+	return
+}
+
+func newStatRunner(conf *StatControllerConfig, clkTck int) (statRunner *StatRunner) {
+	rootDir := conf.RootDir
+	if !strings.HasSuffix(rootDir, "/") {
+		rootDir += "/"
 	}
-	statRunner := StatRunner{
+	statRunner = &StatRunner{
+		rootDir:     rootDir,
 		clkTck:      clkTck,
 		handleStats: conf.HandleStats,
 		interval:    conf.Config.Interval,
+		processReader: NewProcessReader(&ProcessOption{
+			IsVerbose: true,
+			IsDeep:    conf.IsProcessDeep,
+		}),
 	}
-	statController = &StatController{
-		Runner:     *runner.New(&conf.Config, &statRunner),
-		statRunner: &statRunner,
+	if conf.AnalyzerConfig != nil {
+		statRunner.analyzer = NewStatAnalyzer(conf.AnalyzerConfig)
 	}
+	statRunner.initCollectors(conf)
 	return
 }
 
 type StatRunner struct {
+	rootDir              string
 	clkTck               int
 	interval             int
+	processReader        *ProcessReader
+	analyzer             *StatAnalyzer
+	collectors           []statCollector
+	collectorStates      map[string]*collectorState
+	collectorTimeout     time.Duration
+	collectorTimeouts    map[string]time.Duration
 	handleStats          func(runAt time.Time, stats *Stats)
 	currentCpuStat       *CpuStat
 	currentMemStat       *MemStat
 	currentDiskStat      *DiskStat
 	currentNetStat       *NetStat
+	currentNetnsStat     *NetnsStat
 	currentLoginUserStat *LoginUserStat
 	currentUptimeStat    *UptimeStat
+	currentLoadavgStat   *LoadavgStat
+	currentFdStat        *FdStat
+	currentSensorStat    *SensorStat
+	currentKmsgStat      *KmsgStat
+	kmsgReader           *KmsgReader
+	currentSlabStat      *SlabStat
+	currentZoneStat      *ZoneStat
+	currentConntrackStat *ConntrackStat
 	currentProcesses     []Process
 	currentPidIndexMap   map[int]int
 	currentStats         *Stats
 }
 
 type Stats struct {
-	CpuStat       *CpuStat
-	MemStat       *MemStat
-	DiskStat      *DiskStat
-	NetStat       *NetStat
-	Processes     []Process
-	LoginUserStat *LoginUserStat
-	UptimeStat    *UptimeStat
+	CpuStat        *CpuStat
+	MemStat        *MemStat
+	DiskStat       *DiskStat
+	NetStat        *NetStat
+	NetnsStat      *NetnsStat
+	Processes      []Process
+	LoginUserStat  *LoginUserStat
+	UptimeStat     *UptimeStat
+	LoadavgStat    *LoadavgStat
+	FdStat         *FdStat
+	SensorStat     *SensorStat
+	KmsgStat       *KmsgStat
+	SlabStat       *SlabStat
+	ZoneStat       *ZoneStat
+	ConntrackStat  *ConntrackStat
+	Anomalies      []Anomaly
+	CollectorStats []CollectorStat
 }
 
-func (self *StatRunner) syncCpuStat() {
-	cpuStat, err := GetCpuStat()
-	if err != nil {
-		return
-	}
-
+func (self *StatRunner) syncCpuStat(cpuStat *CpuStat) {
 	if self.currentCpuStat == nil {
 		self.currentCpuStat = cpuStat
 		return
@@ -92,13 +147,7 @@ func (self *StatRunner) syncCpuStat() {
 	self.currentCpuStat = cpuStat
 }
 
-func (self *StatRunner) syncMemStat() {
-	var memStat *MemStat
-	var err error
-	if memStat, err = GetMemStat("/"); err != nil {
-		return
-	}
-
+func (self *StatRunner) syncMemStat(memStat *MemStat) {
 	if self.currentMemStat == nil {
 		self.currentMemStat = memStat
 		return
@@ -116,13 +165,7 @@ func (self *StatRunner) syncMemStat() {
 	return
 }
 
-func (self *StatRunner) syncDiskStat() {
-	var diskStat *DiskStat
-	var err error
-	if diskStat, err = GetDiskStat(); err != nil {
-		return
-	}
-
+func (self *StatRunner) syncDiskStat(diskStat *DiskStat) {
 	if self.currentDiskStat == nil {
 		self.currentDiskStat = diskStat
 		return
@@ -131,7 +174,7 @@ func (self *StatRunner) syncDiskStat() {
 	interval := self.interval
 
 	for deviceName, cstat := range diskStat.DiskDeviceStatMap {
-		bstat, ok := diskStat.DiskDeviceStatMap[deviceName]
+		bstat, ok := self.currentDiskStat.DiskDeviceStatMap[deviceName]
 		if !ok {
 			continue
 		}
@@ -150,6 +193,9 @@ func (self *StatRunner) syncDiskStat() {
 		cstat.DiscardBytesPerSec = ((cstat.DiscardSectors - bstat.DiscardSectors) * cstat.PblockSize) / interval
 		cstat.DiscardMsPerSec = (cstat.DiscardMs - bstat.DiscardMs) / interval
 
+		cstat.FlushesPerSec = (cstat.FlushesCompleted - bstat.FlushesCompleted) / interval
+		cstat.FlushMsPerSec = (cstat.FlushMs - bstat.FlushMs) / interval
+
 		cstat.IosMsPerSec = (cstat.IosMs - bstat.IosMs) / interval
 		cstat.WeightedIosMsPerSec = (cstat.WeightedIosMs - bstat.WeightedIosMs) / interval
 
@@ -160,185 +206,192 @@ func (self *StatRunner) syncDiskStat() {
 	return
 }
 
-func (self *StatRunner) syncNetStat() {
-	netStat, err := GetNetStat()
-	if err != nil {
-		return
-	}
+func (self *StatRunner) syncNetStat(netStat *NetStat) {
 	if self.currentNetStat == nil {
 		self.currentNetStat = netStat
 		return
 	}
 
-	interval := self.interval
+	calcNetStatPerSec(self.currentNetStat, netStat, self.interval)
+	self.currentNetStat = netStat
+}
+
+// syncNetnsStat は、netnsごとに差分Statを計算する(新しく作られたnetnsは次回から計算される)
+func (self *StatRunner) syncNetnsStat(netnsStat *NetnsStat) {
+	if self.currentNetnsStat != nil {
+		for name, netStat := range netnsStat.NetStatMap {
+			if bstat, ok := self.currentNetnsStat.NetStatMap[name]; ok {
+				calcNetStatPerSec(bstat, netStat, self.interval)
+			}
+		}
+	}
+	self.currentNetnsStat = netnsStat
+}
 
+// calcNetStatPerSec は、前回のbstatとの差分からnetStatの差分Statを計算する
+func calcNetStatPerSec(bstat *NetStat, netStat *NetStat, interval int) {
 	for dev, cstat := range netStat.NetDevStatMap {
-		bstat, ok := self.currentNetStat.NetDevStatMap[dev]
+		bdevStat, ok := bstat.NetDevStatMap[dev]
 		if !ok {
 			continue
 		}
-		cstat.ReceiveBytesPerSec = (cstat.ReceiveBytes - bstat.ReceiveBytes) / interval
-		cstat.ReceivePacketsPerSec = (cstat.ReceivePackets - bstat.ReceivePackets) / interval
-		cstat.ReceiveErrorsPerSec = (cstat.ReceiveErrors - bstat.ReceiveErrors) / interval
-		cstat.ReceiveDropsPerSec = (cstat.ReceiveDrops - bstat.ReceiveDrops) / interval
-		cstat.TransmitBytesPerSec = (cstat.TransmitBytes - bstat.TransmitBytes) / interval
-		cstat.TransmitPacketsPerSec = (cstat.TransmitPackets - bstat.TransmitPackets) / interval
-		cstat.TransmitErrorsPerSec = (cstat.TransmitErrors - bstat.TransmitErrors) / interval
-		cstat.TransmitDropsPerSec = (cstat.TransmitDrops - bstat.TransmitDrops) / interval
+		cstat.ReceiveBytesPerSec = (cstat.ReceiveBytes - bdevStat.ReceiveBytes) / interval
+		cstat.ReceivePacketsPerSec = (cstat.ReceivePackets - bdevStat.ReceivePackets) / interval
+		cstat.ReceiveErrorsPerSec = (cstat.ReceiveErrors - bdevStat.ReceiveErrors) / interval
+		cstat.ReceiveDropsPerSec = (cstat.ReceiveDrops - bdevStat.ReceiveDrops) / interval
+		cstat.TransmitBytesPerSec = (cstat.TransmitBytes - bdevStat.TransmitBytes) / interval
+		cstat.TransmitPacketsPerSec = (cstat.TransmitPackets - bdevStat.TransmitPackets) / interval
+		cstat.TransmitErrorsPerSec = (cstat.TransmitErrors - bdevStat.TransmitErrors) / interval
+		cstat.TransmitDropsPerSec = (cstat.TransmitDrops - bdevStat.TransmitDrops) / interval
 
 		netStat.NetDevStatMap[dev] = cstat
 	}
 
-	netStat.TcpExtStat.SyncookiesSentPerSec = (netStat.TcpExtStat.SyncookiesSent - self.currentNetStat.TcpExtStat.SyncookiesSent) / interval
-	netStat.TcpExtStat.SyncookiesRecvPerSec = (netStat.TcpExtStat.SyncookiesRecv - self.currentNetStat.TcpExtStat.SyncookiesRecv) / interval
-	netStat.TcpExtStat.SyncookiesFailedPerSec = (netStat.TcpExtStat.SyncookiesFailed - self.currentNetStat.TcpExtStat.SyncookiesFailed) / interval
-	netStat.TcpExtStat.EmbryonicRstsPerSec = (netStat.TcpExtStat.EmbryonicRsts - self.currentNetStat.TcpExtStat.EmbryonicRsts) / interval
-	netStat.TcpExtStat.PruneCalledPerSec = (netStat.TcpExtStat.PruneCalled - self.currentNetStat.TcpExtStat.PruneCalled) / interval
-	netStat.TcpExtStat.RcvPrunedPerSec = (netStat.TcpExtStat.RcvPruned - self.currentNetStat.TcpExtStat.RcvPruned) / interval
-	netStat.TcpExtStat.OfoPrunedPerSec = (netStat.TcpExtStat.OfoPruned - self.currentNetStat.TcpExtStat.OfoPruned) / interval
-	netStat.TcpExtStat.OutOfWindowIcmpsPerSec = (netStat.TcpExtStat.OutOfWindowIcmps - self.currentNetStat.TcpExtStat.OutOfWindowIcmps) / interval
-	netStat.TcpExtStat.LockDroppedIcmpsPerSec = (netStat.TcpExtStat.LockDroppedIcmps - self.currentNetStat.TcpExtStat.LockDroppedIcmps) / interval
-	netStat.TcpExtStat.ArpFilterPerSec = (netStat.TcpExtStat.ArpFilter - self.currentNetStat.TcpExtStat.ArpFilter) / interval
-	netStat.TcpExtStat.TwPerSec = (netStat.TcpExtStat.Tw - self.currentNetStat.TcpExtStat.Tw) / interval
-	netStat.TcpExtStat.TwRecycledPerSec = (netStat.TcpExtStat.TwRecycled - self.currentNetStat.TcpExtStat.TwRecycled) / interval
-	netStat.TcpExtStat.TwKilledPerSec = (netStat.TcpExtStat.TwKilled - self.currentNetStat.TcpExtStat.TwKilled) / interval
-	netStat.TcpExtStat.PawsActivePerSec = (netStat.TcpExtStat.PawsActive - self.currentNetStat.TcpExtStat.PawsActive) / interval
-	netStat.TcpExtStat.PawsEstabPerSec = (netStat.TcpExtStat.PawsEstab - self.currentNetStat.TcpExtStat.PawsEstab) / interval
-	netStat.TcpExtStat.DelayedAcksPerSec = (netStat.TcpExtStat.DelayedAcks - self.currentNetStat.TcpExtStat.DelayedAcks) / interval
-	netStat.TcpExtStat.DelayedAckLockedPerSec = (netStat.TcpExtStat.DelayedAckLocked - self.currentNetStat.TcpExtStat.DelayedAckLocked) / interval
-	netStat.TcpExtStat.DelayedAckLostPerSec = (netStat.TcpExtStat.DelayedAckLost - self.currentNetStat.TcpExtStat.DelayedAckLost) / interval
-	netStat.TcpExtStat.ListenOverflowsPerSec = (netStat.TcpExtStat.ListenOverflows - self.currentNetStat.TcpExtStat.ListenOverflows) / interval
-	netStat.TcpExtStat.ListenDropsPerSec = (netStat.TcpExtStat.ListenDrops - self.currentNetStat.TcpExtStat.ListenDrops) / interval
-	netStat.TcpExtStat.TcpHpHitsPerSec = (netStat.TcpExtStat.TcpHpHits - self.currentNetStat.TcpExtStat.TcpHpHits) / interval
-	netStat.TcpExtStat.TcpPureAcksPerSec = (netStat.TcpExtStat.TcpPureAcks - self.currentNetStat.TcpExtStat.TcpPureAcks) / interval
-	netStat.TcpExtStat.TcpHpAcksPerSec = (netStat.TcpExtStat.TcpHpAcks - self.currentNetStat.TcpExtStat.TcpHpAcks) / interval
-	netStat.TcpExtStat.TcpRenoRecoveryPerSec = (netStat.TcpExtStat.TcpRenoRecovery - self.currentNetStat.TcpExtStat.TcpRenoRecovery) / interval
-	netStat.TcpExtStat.TcpSackRecoveryPerSec = (netStat.TcpExtStat.TcpSackRecovery - self.currentNetStat.TcpExtStat.TcpSackRecovery) / interval
-	netStat.TcpExtStat.TcpSackRenegingPerSec = (netStat.TcpExtStat.TcpSackReneging - self.currentNetStat.TcpExtStat.TcpSackReneging) / interval
-	netStat.TcpExtStat.TcpSackReorderPerSec = (netStat.TcpExtStat.TcpSackReorder - self.currentNetStat.TcpExtStat.TcpSackReorder) / interval
-	netStat.TcpExtStat.TcpRenoReorderPerSec = (netStat.TcpExtStat.TcpRenoReorder - self.currentNetStat.TcpExtStat.TcpRenoReorder) / interval
-	netStat.TcpExtStat.TcpTsReorderPerSec = (netStat.TcpExtStat.TcpTsReorder - self.currentNetStat.TcpExtStat.TcpTsReorder) / interval
-	netStat.TcpExtStat.TcpFullUndoPerSec = (netStat.TcpExtStat.TcpFullUndo - self.currentNetStat.TcpExtStat.TcpFullUndo) / interval
-	netStat.TcpExtStat.TcpPartialUndoPerSec = (netStat.TcpExtStat.TcpPartialUndo - self.currentNetStat.TcpExtStat.TcpPartialUndo) / interval
-	netStat.TcpExtStat.TcpDsackUndoPerSec = (netStat.TcpExtStat.TcpDsackUndo - self.currentNetStat.TcpExtStat.TcpDsackUndo) / interval
-	netStat.TcpExtStat.TcpLossUndoPerSec = (netStat.TcpExtStat.TcpLossUndo - self.currentNetStat.TcpExtStat.TcpLossUndo) / interval
-	netStat.TcpExtStat.TcpLostRetransmitPerSec = (netStat.TcpExtStat.TcpLostRetransmit - self.currentNetStat.TcpExtStat.TcpLostRetransmit) / interval
-	netStat.TcpExtStat.TcpRenoFailuresPerSec = (netStat.TcpExtStat.TcpRenoFailures - self.currentNetStat.TcpExtStat.TcpRenoFailures) / interval
-	netStat.TcpExtStat.TcpSackFailuresPerSec = (netStat.TcpExtStat.TcpSackFailures - self.currentNetStat.TcpExtStat.TcpSackFailures) / interval
-	netStat.TcpExtStat.TcpLossFailuresPerSec = (netStat.TcpExtStat.TcpLossFailures - self.currentNetStat.TcpExtStat.TcpLossFailures) / interval
-	netStat.TcpExtStat.TcpFastRetransPerSec = (netStat.TcpExtStat.TcpFastRetrans - self.currentNetStat.TcpExtStat.TcpFastRetrans) / interval
-	netStat.TcpExtStat.TcpSlowStartRetransPerSec = (netStat.TcpExtStat.TcpSlowStartRetrans - self.currentNetStat.TcpExtStat.TcpSlowStartRetrans) / interval
-	netStat.TcpExtStat.TcpTimeoutsPerSec = (netStat.TcpExtStat.TcpTimeouts - self.currentNetStat.TcpExtStat.TcpTimeouts) / interval
-	netStat.TcpExtStat.TcpLossProbesPerSec = (netStat.TcpExtStat.TcpLossProbes - self.currentNetStat.TcpExtStat.TcpLossProbes) / interval
-	netStat.TcpExtStat.TcpLossProbeRecoveryPerSec = (netStat.TcpExtStat.TcpLossProbeRecovery - self.currentNetStat.TcpExtStat.TcpLossProbeRecovery) / interval
-	netStat.TcpExtStat.TcpRenoRecoveryFailPerSec = (netStat.TcpExtStat.TcpRenoRecoveryFail - self.currentNetStat.TcpExtStat.TcpRenoRecoveryFail) / interval
-	netStat.TcpExtStat.TcpSackRecoveryFailPerSec = (netStat.TcpExtStat.TcpSackRecoveryFail - self.currentNetStat.TcpExtStat.TcpSackRecoveryFail) / interval
-	netStat.TcpExtStat.TcpRcvCollapsedPerSec = (netStat.TcpExtStat.TcpRcvCollapsed - self.currentNetStat.TcpExtStat.TcpRcvCollapsed) / interval
-	netStat.TcpExtStat.TcpBacklogCoalescePerSec = (netStat.TcpExtStat.TcpBacklogCoalesce - self.currentNetStat.TcpExtStat.TcpBacklogCoalesce) / interval
-	netStat.TcpExtStat.TcpDsackOldSentPerSec = (netStat.TcpExtStat.TcpDsackOldSent - self.currentNetStat.TcpExtStat.TcpDsackOldSent) / interval
-	netStat.TcpExtStat.TcpDsackOfoSentPerSec = (netStat.TcpExtStat.TcpDsackOfoSent - self.currentNetStat.TcpExtStat.TcpDsackOfoSent) / interval
-	netStat.TcpExtStat.TcpDsackRecvPerSec = (netStat.TcpExtStat.TcpDsackRecv - self.currentNetStat.TcpExtStat.TcpDsackRecv) / interval
-	netStat.TcpExtStat.TcpDsackOfoRecvPerSec = (netStat.TcpExtStat.TcpDsackOfoRecv - self.currentNetStat.TcpExtStat.TcpDsackOfoRecv) / interval
-	netStat.TcpExtStat.TcpAbortOnDataPerSec = (netStat.TcpExtStat.TcpAbortOnData - self.currentNetStat.TcpExtStat.TcpAbortOnData) / interval
-	netStat.TcpExtStat.TcpAbortOnClosePerSec = (netStat.TcpExtStat.TcpAbortOnClose - self.currentNetStat.TcpExtStat.TcpAbortOnClose) / interval
-	netStat.TcpExtStat.TcpAbortOnMemoryPerSec = (netStat.TcpExtStat.TcpAbortOnMemory - self.currentNetStat.TcpExtStat.TcpAbortOnMemory) / interval
-	netStat.TcpExtStat.TcpAbortOnTimeoutPerSec = (netStat.TcpExtStat.TcpAbortOnTimeout - self.currentNetStat.TcpExtStat.TcpAbortOnTimeout) / interval
-	netStat.TcpExtStat.TcpAbortOnLingerPerSec = (netStat.TcpExtStat.TcpAbortOnLinger - self.currentNetStat.TcpExtStat.TcpAbortOnLinger) / interval
-	netStat.TcpExtStat.TcpAbortFailedPerSec = (netStat.TcpExtStat.TcpAbortFailed - self.currentNetStat.TcpExtStat.TcpAbortFailed) / interval
-	netStat.TcpExtStat.TcpMemoryPressuresPerSec = (netStat.TcpExtStat.TcpMemoryPressures - self.currentNetStat.TcpExtStat.TcpMemoryPressures) / interval
-	netStat.TcpExtStat.TcpMemoryPressuresChronoPerSec = (netStat.TcpExtStat.TcpMemoryPressuresChrono - self.currentNetStat.TcpExtStat.TcpMemoryPressuresChrono) / interval
-	netStat.TcpExtStat.TcpSackDiscardPerSec = (netStat.TcpExtStat.TcpSackDiscard - self.currentNetStat.TcpExtStat.TcpSackDiscard) / interval
-	netStat.TcpExtStat.TcpDsackIgnoredOldPerSec = (netStat.TcpExtStat.TcpDsackIgnoredOld - self.currentNetStat.TcpExtStat.TcpDsackIgnoredOld) / interval
-	netStat.TcpExtStat.TcpDsackIgnoredNoUndoPerSec = (netStat.TcpExtStat.TcpDsackIgnoredNoUndo - self.currentNetStat.TcpExtStat.TcpDsackIgnoredNoUndo) / interval
-	netStat.TcpExtStat.TcpSpuriousRTOsPerSec = (netStat.TcpExtStat.TcpSpuriousRTOs - self.currentNetStat.TcpExtStat.TcpSpuriousRTOs) / interval
-	netStat.TcpExtStat.TcpMd5NotFoundPerSec = (netStat.TcpExtStat.TcpMd5NotFound - self.currentNetStat.TcpExtStat.TcpMd5NotFound) / interval
-	netStat.TcpExtStat.TcpMd5UnexpectedPerSec = (netStat.TcpExtStat.TcpMd5Unexpected - self.currentNetStat.TcpExtStat.TcpMd5Unexpected) / interval
-	netStat.TcpExtStat.TcpMd5FailurePerSec = (netStat.TcpExtStat.TcpMd5Failure - self.currentNetStat.TcpExtStat.TcpMd5Failure) / interval
-	netStat.TcpExtStat.TcpSackShiftedPerSec = (netStat.TcpExtStat.TcpSackShifted - self.currentNetStat.TcpExtStat.TcpSackShifted) / interval
-	netStat.TcpExtStat.TcpSackMergedPerSec = (netStat.TcpExtStat.TcpSackMerged - self.currentNetStat.TcpExtStat.TcpSackMerged) / interval
-	netStat.TcpExtStat.TcpSackShiftFallbackPerSec = (netStat.TcpExtStat.TcpSackShiftFallback - self.currentNetStat.TcpExtStat.TcpSackShiftFallback) / interval
-	netStat.TcpExtStat.TcpBacklogDropPerSec = (netStat.TcpExtStat.TcpBacklogDrop - self.currentNetStat.TcpExtStat.TcpBacklogDrop) / interval
-	netStat.TcpExtStat.PfMemallocDropPerSec = (netStat.TcpExtStat.PfMemallocDrop - self.currentNetStat.TcpExtStat.PfMemallocDrop) / interval
-	netStat.TcpExtStat.TcpMinTtlDropPerSec = (netStat.TcpExtStat.TcpMinTtlDrop - self.currentNetStat.TcpExtStat.TcpMinTtlDrop) / interval
-	netStat.TcpExtStat.TcpDeferAcceptDropPerSec = (netStat.TcpExtStat.TcpDeferAcceptDrop - self.currentNetStat.TcpExtStat.TcpDeferAcceptDrop) / interval
-	netStat.TcpExtStat.IpReversePathFilterPerSec = (netStat.TcpExtStat.IpReversePathFilter - self.currentNetStat.TcpExtStat.IpReversePathFilter) / interval
-	netStat.TcpExtStat.TcpTimeWaitOverflowPerSec = (netStat.TcpExtStat.TcpTimeWaitOverflow - self.currentNetStat.TcpExtStat.TcpTimeWaitOverflow) / interval
-	netStat.TcpExtStat.TcpReqQFullDoCookiesPerSec = (netStat.TcpExtStat.TcpReqQFullDoCookies - self.currentNetStat.TcpExtStat.TcpReqQFullDoCookies) / interval
-	netStat.TcpExtStat.TcpReqQFullDropPerSec = (netStat.TcpExtStat.TcpReqQFullDrop - self.currentNetStat.TcpExtStat.TcpReqQFullDrop) / interval
-	netStat.TcpExtStat.TcpRetransFailPerSec = (netStat.TcpExtStat.TcpRetransFail - self.currentNetStat.TcpExtStat.TcpRetransFail) / interval
-	netStat.TcpExtStat.TcpRcvCoalescePerSec = (netStat.TcpExtStat.TcpRcvCoalesce - self.currentNetStat.TcpExtStat.TcpRcvCoalesce) / interval
-	netStat.TcpExtStat.TcpOfoQueuePerSec = (netStat.TcpExtStat.TcpOfoQueue - self.currentNetStat.TcpExtStat.TcpOfoQueue) / interval
-	netStat.TcpExtStat.TcpOfoDropPerSec = (netStat.TcpExtStat.TcpOfoDrop - self.currentNetStat.TcpExtStat.TcpOfoDrop) / interval
-	netStat.TcpExtStat.TcpOfoMergePerSec = (netStat.TcpExtStat.TcpOfoMerge - self.currentNetStat.TcpExtStat.TcpOfoMerge) / interval
-	netStat.TcpExtStat.TcpChallengeACKPerSec = (netStat.TcpExtStat.TcpChallengeACK - self.currentNetStat.TcpExtStat.TcpChallengeACK) / interval
-	netStat.TcpExtStat.TcpSynChallengePerSec = (netStat.TcpExtStat.TcpSynChallenge - self.currentNetStat.TcpExtStat.TcpSynChallenge) / interval
-	netStat.TcpExtStat.TcpFastOpenActivePerSec = (netStat.TcpExtStat.TcpFastOpenActive - self.currentNetStat.TcpExtStat.TcpFastOpenActive) / interval
-	netStat.TcpExtStat.TcpFastOpenActiveFailPerSec = (netStat.TcpExtStat.TcpFastOpenActiveFail - self.currentNetStat.TcpExtStat.TcpFastOpenActiveFail) / interval
-	netStat.TcpExtStat.TcpFastOpenPassivePerSec = (netStat.TcpExtStat.TcpFastOpenPassive - self.currentNetStat.TcpExtStat.TcpFastOpenPassive) / interval
-	netStat.TcpExtStat.TcpFastOpenPassiveFailPerSec = (netStat.TcpExtStat.TcpFastOpenPassiveFail - self.currentNetStat.TcpExtStat.TcpFastOpenPassiveFail) / interval
-	netStat.TcpExtStat.TcpFastOpenListenOverflowPerSec = (netStat.TcpExtStat.TcpFastOpenListenOverflow - self.currentNetStat.TcpExtStat.TcpFastOpenListenOverflow) / interval
-	netStat.TcpExtStat.TcpFastOpenCookieReqdPerSec = (netStat.TcpExtStat.TcpFastOpenCookieReqd - self.currentNetStat.TcpExtStat.TcpFastOpenCookieReqd) / interval
-	netStat.TcpExtStat.TcpFastOpenBlackholePerSec = (netStat.TcpExtStat.TcpFastOpenBlackhole - self.currentNetStat.TcpExtStat.TcpFastOpenBlackhole) / interval
-	netStat.TcpExtStat.TcpSpuriousRtxHostQueuesPerSec = (netStat.TcpExtStat.TcpSpuriousRtxHostQueues - self.currentNetStat.TcpExtStat.TcpSpuriousRtxHostQueues) / interval
-	netStat.TcpExtStat.BusyPollRxPacketsPerSec = (netStat.TcpExtStat.BusyPollRxPackets - self.currentNetStat.TcpExtStat.BusyPollRxPackets) / interval
-	netStat.TcpExtStat.TcpAutoCorkingPerSec = (netStat.TcpExtStat.TcpAutoCorking - self.currentNetStat.TcpExtStat.TcpAutoCorking) / interval
-	netStat.TcpExtStat.TcpFromZeroWindowAdvPerSec = (netStat.TcpExtStat.TcpFromZeroWindowAdv - self.currentNetStat.TcpExtStat.TcpFromZeroWindowAdv) / interval
-	netStat.TcpExtStat.TcpToZeroWindowAdvPerSec = (netStat.TcpExtStat.TcpToZeroWindowAdv - self.currentNetStat.TcpExtStat.TcpToZeroWindowAdv) / interval
-	netStat.TcpExtStat.TcpWantZeroWindowAdvPerSec = (netStat.TcpExtStat.TcpWantZeroWindowAdv - self.currentNetStat.TcpExtStat.TcpWantZeroWindowAdv) / interval
-	netStat.TcpExtStat.TcpSynRetransPerSec = (netStat.TcpExtStat.TcpSynRetrans - self.currentNetStat.TcpExtStat.TcpSynRetrans) / interval
-	netStat.TcpExtStat.TcpOrigDataSentPerSec = (netStat.TcpExtStat.TcpOrigDataSent - self.currentNetStat.TcpExtStat.TcpOrigDataSent) / interval
-	netStat.TcpExtStat.TcpHystartTrainDetectPerSec = (netStat.TcpExtStat.TcpHystartTrainDetect - self.currentNetStat.TcpExtStat.TcpHystartTrainDetect) / interval
-	netStat.TcpExtStat.TcpHystartTrainCwndPerSec = (netStat.TcpExtStat.TcpHystartTrainCwnd - self.currentNetStat.TcpExtStat.TcpHystartTrainCwnd) / interval
-	netStat.TcpExtStat.TcpHystartDelayDetectPerSec = (netStat.TcpExtStat.TcpHystartDelayDetect - self.currentNetStat.TcpExtStat.TcpHystartDelayDetect) / interval
-	netStat.TcpExtStat.TcpHystartDelayCwndPerSec = (netStat.TcpExtStat.TcpHystartDelayCwnd - self.currentNetStat.TcpExtStat.TcpHystartDelayCwnd) / interval
-	netStat.TcpExtStat.TcpAckSkippedSynRecvPerSec = (netStat.TcpExtStat.TcpAckSkippedSynRecv - self.currentNetStat.TcpExtStat.TcpAckSkippedSynRecv) / interval
-	netStat.TcpExtStat.TcpAckSkippedPAWSPerSec = (netStat.TcpExtStat.TcpAckSkippedPAWS - self.currentNetStat.TcpExtStat.TcpAckSkippedPAWS) / interval
-	netStat.TcpExtStat.TcpAckSkippedSeqPerSec = (netStat.TcpExtStat.TcpAckSkippedSeq - self.currentNetStat.TcpExtStat.TcpAckSkippedSeq) / interval
-	netStat.TcpExtStat.TcpAckSkippedFinWait2PerSec = (netStat.TcpExtStat.TcpAckSkippedFinWait2 - self.currentNetStat.TcpExtStat.TcpAckSkippedFinWait2) / interval
-	netStat.TcpExtStat.TcpAckSkippedTimeWaitPerSec = (netStat.TcpExtStat.TcpAckSkippedTimeWait - self.currentNetStat.TcpExtStat.TcpAckSkippedTimeWait) / interval
-	netStat.TcpExtStat.TcpAckSkippedChallengePerSec = (netStat.TcpExtStat.TcpAckSkippedChallenge - self.currentNetStat.TcpExtStat.TcpAckSkippedChallenge) / interval
-	netStat.TcpExtStat.TcpWinProbePerSec = (netStat.TcpExtStat.TcpWinProbe - self.currentNetStat.TcpExtStat.TcpWinProbe) / interval
-	netStat.TcpExtStat.TcpKeepAlivePerSec = (netStat.TcpExtStat.TcpKeepAlive - self.currentNetStat.TcpExtStat.TcpKeepAlive) / interval
-	netStat.TcpExtStat.TcpMtupFailPerSec = (netStat.TcpExtStat.TcpMtupFail - self.currentNetStat.TcpExtStat.TcpMtupFail) / interval
-	netStat.TcpExtStat.TcpMtupSuccessPerSec = (netStat.TcpExtStat.TcpMtupSuccess - self.currentNetStat.TcpExtStat.TcpMtupSuccess) / interval
-	netStat.TcpExtStat.TcpDeliveredPerSec = (netStat.TcpExtStat.TcpDelivered - self.currentNetStat.TcpExtStat.TcpDelivered) / interval
-	netStat.TcpExtStat.TcpDeliveredCEPerSec = (netStat.TcpExtStat.TcpDeliveredCE - self.currentNetStat.TcpExtStat.TcpDeliveredCE) / interval
-	netStat.TcpExtStat.TcpAckCompressedPerSec = (netStat.TcpExtStat.TcpAckCompressed - self.currentNetStat.TcpExtStat.TcpAckCompressed) / interval
-	netStat.TcpExtStat.TcpZeroWindowDropPerSec = (netStat.TcpExtStat.TcpZeroWindowDrop - self.currentNetStat.TcpExtStat.TcpZeroWindowDrop) / interval
-	netStat.TcpExtStat.TcpRcvQDropPerSec = (netStat.TcpExtStat.TcpRcvQDrop - self.currentNetStat.TcpExtStat.TcpRcvQDrop) / interval
-	netStat.TcpExtStat.TcpWqueueTooBigPerSec = (netStat.TcpExtStat.TcpWqueueTooBig - self.currentNetStat.TcpExtStat.TcpWqueueTooBig) / interval
-	netStat.TcpExtStat.TcpFastOpenPassiveAltKeyPerSec = (netStat.TcpExtStat.TcpFastOpenPassiveAltKey - self.currentNetStat.TcpExtStat.TcpFastOpenPassiveAltKey) / interval
-
-	netStat.IpExtStat.InNoRoutesPerSec = (netStat.IpExtStat.InNoRoutes - self.currentNetStat.IpExtStat.InNoRoutes) / interval
-	netStat.IpExtStat.InTruncatedPktsPerSec = (netStat.IpExtStat.InTruncatedPkts - self.currentNetStat.IpExtStat.InTruncatedPkts) / interval
-	netStat.IpExtStat.InCsumErrorsPerSec = (netStat.IpExtStat.InCsumErrors - self.currentNetStat.IpExtStat.InCsumErrors) / interval
-	netStat.IpExtStat.InNoRoutesPerSec = (netStat.IpExtStat.InNoRoutes - self.currentNetStat.IpExtStat.InNoRoutes) / interval
-	netStat.IpExtStat.InTruncatedPktsPerSec = (netStat.IpExtStat.InTruncatedPkts - self.currentNetStat.IpExtStat.InTruncatedPkts) / interval
-	netStat.IpExtStat.InMcastPktsPerSec = (netStat.IpExtStat.InMcastPkts - self.currentNetStat.IpExtStat.InMcastPkts) / interval
-	netStat.IpExtStat.OutMcastPktsPerSec = (netStat.IpExtStat.OutMcastPkts - self.currentNetStat.IpExtStat.OutMcastPkts) / interval
-	netStat.IpExtStat.InBcastPktsPerSec = (netStat.IpExtStat.InBcastPkts - self.currentNetStat.IpExtStat.InBcastPkts) / interval
-	netStat.IpExtStat.OutBcastPktsPerSec = (netStat.IpExtStat.OutBcastPkts - self.currentNetStat.IpExtStat.OutBcastPkts) / interval
-	netStat.IpExtStat.InOctetsPerSec = (netStat.IpExtStat.InOctets - self.currentNetStat.IpExtStat.InOctets) / interval
-	netStat.IpExtStat.OutOctetsPerSec = (netStat.IpExtStat.OutOctets - self.currentNetStat.IpExtStat.OutOctets) / interval
-	netStat.IpExtStat.InMcastOctetsPerSec = (netStat.IpExtStat.InMcastOctets - self.currentNetStat.IpExtStat.InMcastOctets) / interval
-	netStat.IpExtStat.OutMcastOctetsPerSec = (netStat.IpExtStat.OutMcastOctets - self.currentNetStat.IpExtStat.OutMcastOctets) / interval
-	netStat.IpExtStat.InBcastOctetsPerSec = (netStat.IpExtStat.InBcastOctets - self.currentNetStat.IpExtStat.InBcastOctets) / interval
-	netStat.IpExtStat.OutBcastOctetsPerSec = (netStat.IpExtStat.OutBcastOctets - self.currentNetStat.IpExtStat.OutBcastOctets) / interval
-	netStat.IpExtStat.InCsumErrorsPerSec = (netStat.IpExtStat.InCsumErrors - self.currentNetStat.IpExtStat.InCsumErrors) / interval
-	netStat.IpExtStat.InNoECTPktsPerSec = (netStat.IpExtStat.InNoECTPkts - self.currentNetStat.IpExtStat.InNoECTPkts) / interval
-	netStat.IpExtStat.InECT1PktsPerSec = (netStat.IpExtStat.InECT1Pkts - self.currentNetStat.IpExtStat.InECT1Pkts) / interval
-	netStat.IpExtStat.InECT0PktsPerSec = (netStat.IpExtStat.InECT0Pkts - self.currentNetStat.IpExtStat.InECT0Pkts) / interval
-	netStat.IpExtStat.InCEPktsPerSec = (netStat.IpExtStat.InCEPkts - self.currentNetStat.IpExtStat.InCEPkts) / interval
-	netStat.IpExtStat.ReasmOverlapsPerSec = (netStat.IpExtStat.ReasmOverlaps - self.currentNetStat.IpExtStat.ReasmOverlaps) / interval
+	netStat.TcpExtStat.SyncookiesSentPerSec = (netStat.TcpExtStat.SyncookiesSent - bstat.TcpExtStat.SyncookiesSent) / interval
+	netStat.TcpExtStat.SyncookiesRecvPerSec = (netStat.TcpExtStat.SyncookiesRecv - bstat.TcpExtStat.SyncookiesRecv) / interval
+	netStat.TcpExtStat.SyncookiesFailedPerSec = (netStat.TcpExtStat.SyncookiesFailed - bstat.TcpExtStat.SyncookiesFailed) / interval
+	netStat.TcpExtStat.EmbryonicRstsPerSec = (netStat.TcpExtStat.EmbryonicRsts - bstat.TcpExtStat.EmbryonicRsts) / interval
+	netStat.TcpExtStat.PruneCalledPerSec = (netStat.TcpExtStat.PruneCalled - bstat.TcpExtStat.PruneCalled) / interval
+	netStat.TcpExtStat.RcvPrunedPerSec = (netStat.TcpExtStat.RcvPruned - bstat.TcpExtStat.RcvPruned) / interval
+	netStat.TcpExtStat.OfoPrunedPerSec = (netStat.TcpExtStat.OfoPruned - bstat.TcpExtStat.OfoPruned) / interval
+	netStat.TcpExtStat.OutOfWindowIcmpsPerSec = (netStat.TcpExtStat.OutOfWindowIcmps - bstat.TcpExtStat.OutOfWindowIcmps) / interval
+	netStat.TcpExtStat.LockDroppedIcmpsPerSec = (netStat.TcpExtStat.LockDroppedIcmps - bstat.TcpExtStat.LockDroppedIcmps) / interval
+	netStat.TcpExtStat.ArpFilterPerSec = (netStat.TcpExtStat.ArpFilter - bstat.TcpExtStat.ArpFilter) / interval
+	netStat.TcpExtStat.TwPerSec = (netStat.TcpExtStat.Tw - bstat.TcpExtStat.Tw) / interval
+	netStat.TcpExtStat.TwRecycledPerSec = (netStat.TcpExtStat.TwRecycled - bstat.TcpExtStat.TwRecycled) / interval
+	netStat.TcpExtStat.TwKilledPerSec = (netStat.TcpExtStat.TwKilled - bstat.TcpExtStat.TwKilled) / interval
+	netStat.TcpExtStat.PawsActivePerSec = (netStat.TcpExtStat.PawsActive - bstat.TcpExtStat.PawsActive) / interval
+	netStat.TcpExtStat.PawsEstabPerSec = (netStat.TcpExtStat.PawsEstab - bstat.TcpExtStat.PawsEstab) / interval
+	netStat.TcpExtStat.DelayedAcksPerSec = (netStat.TcpExtStat.DelayedAcks - bstat.TcpExtStat.DelayedAcks) / interval
+	netStat.TcpExtStat.DelayedAckLockedPerSec = (netStat.TcpExtStat.DelayedAckLocked - bstat.TcpExtStat.DelayedAckLocked) / interval
+	netStat.TcpExtStat.DelayedAckLostPerSec = (netStat.TcpExtStat.DelayedAckLost - bstat.TcpExtStat.DelayedAckLost) / interval
+	netStat.TcpExtStat.ListenOverflowsPerSec = (netStat.TcpExtStat.ListenOverflows - bstat.TcpExtStat.ListenOverflows) / interval
+	netStat.TcpExtStat.ListenDropsPerSec = (netStat.TcpExtStat.ListenDrops - bstat.TcpExtStat.ListenDrops) / interval
+	netStat.TcpExtStat.TcpHpHitsPerSec = (netStat.TcpExtStat.TcpHpHits - bstat.TcpExtStat.TcpHpHits) / interval
+	netStat.TcpExtStat.TcpPureAcksPerSec = (netStat.TcpExtStat.TcpPureAcks - bstat.TcpExtStat.TcpPureAcks) / interval
+	netStat.TcpExtStat.TcpHpAcksPerSec = (netStat.TcpExtStat.TcpHpAcks - bstat.TcpExtStat.TcpHpAcks) / interval
+	netStat.TcpExtStat.TcpRenoRecoveryPerSec = (netStat.TcpExtStat.TcpRenoRecovery - bstat.TcpExtStat.TcpRenoRecovery) / interval
+	netStat.TcpExtStat.TcpSackRecoveryPerSec = (netStat.TcpExtStat.TcpSackRecovery - bstat.TcpExtStat.TcpSackRecovery) / interval
+	netStat.TcpExtStat.TcpSackRenegingPerSec = (netStat.TcpExtStat.TcpSackReneging - bstat.TcpExtStat.TcpSackReneging) / interval
+	netStat.TcpExtStat.TcpSackReorderPerSec = (netStat.TcpExtStat.TcpSackReorder - bstat.TcpExtStat.TcpSackReorder) / interval
+	netStat.TcpExtStat.TcpRenoReorderPerSec = (netStat.TcpExtStat.TcpRenoReorder - bstat.TcpExtStat.TcpRenoReorder) / interval
+	netStat.TcpExtStat.TcpTsReorderPerSec = (netStat.TcpExtStat.TcpTsReorder - bstat.TcpExtStat.TcpTsReorder) / interval
+	netStat.TcpExtStat.TcpFullUndoPerSec = (netStat.TcpExtStat.TcpFullUndo - bstat.TcpExtStat.TcpFullUndo) / interval
+	netStat.TcpExtStat.TcpPartialUndoPerSec = (netStat.TcpExtStat.TcpPartialUndo - bstat.TcpExtStat.TcpPartialUndo) / interval
+	netStat.TcpExtStat.TcpDsackUndoPerSec = (netStat.TcpExtStat.TcpDsackUndo - bstat.TcpExtStat.TcpDsackUndo) / interval
+	netStat.TcpExtStat.TcpLossUndoPerSec = (netStat.TcpExtStat.TcpLossUndo - bstat.TcpExtStat.TcpLossUndo) / interval
+	netStat.TcpExtStat.TcpLostRetransmitPerSec = (netStat.TcpExtStat.TcpLostRetransmit - bstat.TcpExtStat.TcpLostRetransmit) / interval
+	netStat.TcpExtStat.TcpRenoFailuresPerSec = (netStat.TcpExtStat.TcpRenoFailures - bstat.TcpExtStat.TcpRenoFailures) / interval
+	netStat.TcpExtStat.TcpSackFailuresPerSec = (netStat.TcpExtStat.TcpSackFailures - bstat.TcpExtStat.TcpSackFailures) / interval
+	netStat.TcpExtStat.TcpLossFailuresPerSec = (netStat.TcpExtStat.TcpLossFailures - bstat.TcpExtStat.TcpLossFailures) / interval
+	netStat.TcpExtStat.TcpFastRetransPerSec = (netStat.TcpExtStat.TcpFastRetrans - bstat.TcpExtStat.TcpFastRetrans) / interval
+	netStat.TcpExtStat.TcpSlowStartRetransPerSec = (netStat.TcpExtStat.TcpSlowStartRetrans - bstat.TcpExtStat.TcpSlowStartRetrans) / interval
+	netStat.TcpExtStat.TcpTimeoutsPerSec = (netStat.TcpExtStat.TcpTimeouts - bstat.TcpExtStat.TcpTimeouts) / interval
+	netStat.TcpExtStat.TcpLossProbesPerSec = (netStat.TcpExtStat.TcpLossProbes - bstat.TcpExtStat.TcpLossProbes) / interval
+	netStat.TcpExtStat.TcpLossProbeRecoveryPerSec = (netStat.TcpExtStat.TcpLossProbeRecovery - bstat.TcpExtStat.TcpLossProbeRecovery) / interval
+	netStat.TcpExtStat.TcpRenoRecoveryFailPerSec = (netStat.TcpExtStat.TcpRenoRecoveryFail - bstat.TcpExtStat.TcpRenoRecoveryFail) / interval
+	netStat.TcpExtStat.TcpSackRecoveryFailPerSec = (netStat.TcpExtStat.TcpSackRecoveryFail - bstat.TcpExtStat.TcpSackRecoveryFail) / interval
+	netStat.TcpExtStat.TcpRcvCollapsedPerSec = (netStat.TcpExtStat.TcpRcvCollapsed - bstat.TcpExtStat.TcpRcvCollapsed) / interval
+	netStat.TcpExtStat.TcpBacklogCoalescePerSec = (netStat.TcpExtStat.TcpBacklogCoalesce - bstat.TcpExtStat.TcpBacklogCoalesce) / interval
+	netStat.TcpExtStat.TcpDsackOldSentPerSec = (netStat.TcpExtStat.TcpDsackOldSent - bstat.TcpExtStat.TcpDsackOldSent) / interval
+	netStat.TcpExtStat.TcpDsackOfoSentPerSec = (netStat.TcpExtStat.TcpDsackOfoSent - bstat.TcpExtStat.TcpDsackOfoSent) / interval
+	netStat.TcpExtStat.TcpDsackRecvPerSec = (netStat.TcpExtStat.TcpDsackRecv - bstat.TcpExtStat.TcpDsackRecv) / interval
+	netStat.TcpExtStat.TcpDsackOfoRecvPerSec = (netStat.TcpExtStat.TcpDsackOfoRecv - bstat.TcpExtStat.TcpDsackOfoRecv) / interval
+	netStat.TcpExtStat.TcpAbortOnDataPerSec = (netStat.TcpExtStat.TcpAbortOnData - bstat.TcpExtStat.TcpAbortOnData) / interval
+	netStat.TcpExtStat.TcpAbortOnClosePerSec = (netStat.TcpExtStat.TcpAbortOnClose - bstat.TcpExtStat.TcpAbortOnClose) / interval
+	netStat.TcpExtStat.TcpAbortOnMemoryPerSec = (netStat.TcpExtStat.TcpAbortOnMemory - bstat.TcpExtStat.TcpAbortOnMemory) / interval
+	netStat.TcpExtStat.TcpAbortOnTimeoutPerSec = (netStat.TcpExtStat.TcpAbortOnTimeout - bstat.TcpExtStat.TcpAbortOnTimeout) / interval
+	netStat.TcpExtStat.TcpAbortOnLingerPerSec = (netStat.TcpExtStat.TcpAbortOnLinger - bstat.TcpExtStat.TcpAbortOnLinger) / interval
+	netStat.TcpExtStat.TcpAbortFailedPerSec = (netStat.TcpExtStat.TcpAbortFailed - bstat.TcpExtStat.TcpAbortFailed) / interval
+	netStat.TcpExtStat.TcpMemoryPressuresPerSec = (netStat.TcpExtStat.TcpMemoryPressures - bstat.TcpExtStat.TcpMemoryPressures) / interval
+	netStat.TcpExtStat.TcpMemoryPressuresChronoPerSec = (netStat.TcpExtStat.TcpMemoryPressuresChrono - bstat.TcpExtStat.TcpMemoryPressuresChrono) / interval
+	netStat.TcpExtStat.TcpSackDiscardPerSec = (netStat.TcpExtStat.TcpSackDiscard - bstat.TcpExtStat.TcpSackDiscard) / interval
+	netStat.TcpExtStat.TcpDsackIgnoredOldPerSec = (netStat.TcpExtStat.TcpDsackIgnoredOld - bstat.TcpExtStat.TcpDsackIgnoredOld) / interval
+	netStat.TcpExtStat.TcpDsackIgnoredNoUndoPerSec = (netStat.TcpExtStat.TcpDsackIgnoredNoUndo - bstat.TcpExtStat.TcpDsackIgnoredNoUndo) / interval
+	netStat.TcpExtStat.TcpSpuriousRTOsPerSec = (netStat.TcpExtStat.TcpSpuriousRTOs - bstat.TcpExtStat.TcpSpuriousRTOs) / interval
+	netStat.TcpExtStat.TcpMd5NotFoundPerSec = (netStat.TcpExtStat.TcpMd5NotFound - bstat.TcpExtStat.TcpMd5NotFound) / interval
+	netStat.TcpExtStat.TcpMd5UnexpectedPerSec = (netStat.TcpExtStat.TcpMd5Unexpected - bstat.TcpExtStat.TcpMd5Unexpected) / interval
+	netStat.TcpExtStat.TcpMd5FailurePerSec = (netStat.TcpExtStat.TcpMd5Failure - bstat.TcpExtStat.TcpMd5Failure) / interval
+	netStat.TcpExtStat.TcpSackShiftedPerSec = (netStat.TcpExtStat.TcpSackShifted - bstat.TcpExtStat.TcpSackShifted) / interval
+	netStat.TcpExtStat.TcpSackMergedPerSec = (netStat.TcpExtStat.TcpSackMerged - bstat.TcpExtStat.TcpSackMerged) / interval
+	netStat.TcpExtStat.TcpSackShiftFallbackPerSec = (netStat.TcpExtStat.TcpSackShiftFallback - bstat.TcpExtStat.TcpSackShiftFallback) / interval
+	netStat.TcpExtStat.TcpBacklogDropPerSec = (netStat.TcpExtStat.TcpBacklogDrop - bstat.TcpExtStat.TcpBacklogDrop) / interval
+	netStat.TcpExtStat.PfMemallocDropPerSec = (netStat.TcpExtStat.PfMemallocDrop - bstat.TcpExtStat.PfMemallocDrop) / interval
+	netStat.TcpExtStat.TcpMinTtlDropPerSec = (netStat.TcpExtStat.TcpMinTtlDrop - bstat.TcpExtStat.TcpMinTtlDrop) / interval
+	netStat.TcpExtStat.TcpDeferAcceptDropPerSec = (netStat.TcpExtStat.TcpDeferAcceptDrop - bstat.TcpExtStat.TcpDeferAcceptDrop) / interval
+	netStat.TcpExtStat.IpReversePathFilterPerSec = (netStat.TcpExtStat.IpReversePathFilter - bstat.TcpExtStat.IpReversePathFilter) / interval
+	netStat.TcpExtStat.TcpTimeWaitOverflowPerSec = (netStat.TcpExtStat.TcpTimeWaitOverflow - bstat.TcpExtStat.TcpTimeWaitOverflow) / interval
+	netStat.TcpExtStat.TcpReqQFullDoCookiesPerSec = (netStat.TcpExtStat.TcpReqQFullDoCookies - bstat.TcpExtStat.TcpReqQFullDoCookies) / interval
+	netStat.TcpExtStat.TcpReqQFullDropPerSec = (netStat.TcpExtStat.TcpReqQFullDrop - bstat.TcpExtStat.TcpReqQFullDrop) / interval
+	netStat.TcpExtStat.TcpRetransFailPerSec = (netStat.TcpExtStat.TcpRetransFail - bstat.TcpExtStat.TcpRetransFail) / interval
+	netStat.TcpExtStat.TcpRcvCoalescePerSec = (netStat.TcpExtStat.TcpRcvCoalesce - bstat.TcpExtStat.TcpRcvCoalesce) / interval
+	netStat.TcpExtStat.TcpOfoQueuePerSec = (netStat.TcpExtStat.TcpOfoQueue - bstat.TcpExtStat.TcpOfoQueue) / interval
+	netStat.TcpExtStat.TcpOfoDropPerSec = (netStat.TcpExtStat.TcpOfoDrop - bstat.TcpExtStat.TcpOfoDrop) / interval
+	netStat.TcpExtStat.TcpOfoMergePerSec = (netStat.TcpExtStat.TcpOfoMerge - bstat.TcpExtStat.TcpOfoMerge) / interval
+	netStat.TcpExtStat.TcpChallengeACKPerSec = (netStat.TcpExtStat.TcpChallengeACK - bstat.TcpExtStat.TcpChallengeACK) / interval
+	netStat.TcpExtStat.TcpSynChallengePerSec = (netStat.TcpExtStat.TcpSynChallenge - bstat.TcpExtStat.TcpSynChallenge) / interval
+	netStat.TcpExtStat.TcpFastOpenActivePerSec = (netStat.TcpExtStat.TcpFastOpenActive - bstat.TcpExtStat.TcpFastOpenActive) / interval
+	netStat.TcpExtStat.TcpFastOpenActiveFailPerSec = (netStat.TcpExtStat.TcpFastOpenActiveFail - bstat.TcpExtStat.TcpFastOpenActiveFail) / interval
+	netStat.TcpExtStat.TcpFastOpenPassivePerSec = (netStat.TcpExtStat.TcpFastOpenPassive - bstat.TcpExtStat.TcpFastOpenPassive) / interval
+	netStat.TcpExtStat.TcpFastOpenPassiveFailPerSec = (netStat.TcpExtStat.TcpFastOpenPassiveFail - bstat.TcpExtStat.TcpFastOpenPassiveFail) / interval
+	netStat.TcpExtStat.TcpFastOpenListenOverflowPerSec = (netStat.TcpExtStat.TcpFastOpenListenOverflow - bstat.TcpExtStat.TcpFastOpenListenOverflow) / interval
+	netStat.TcpExtStat.TcpFastOpenCookieReqdPerSec = (netStat.TcpExtStat.TcpFastOpenCookieReqd - bstat.TcpExtStat.TcpFastOpenCookieReqd) / interval
+	netStat.TcpExtStat.TcpFastOpenBlackholePerSec = (netStat.TcpExtStat.TcpFastOpenBlackhole - bstat.TcpExtStat.TcpFastOpenBlackhole) / interval
+	netStat.TcpExtStat.TcpSpuriousRtxHostQueuesPerSec = (netStat.TcpExtStat.TcpSpuriousRtxHostQueues - bstat.TcpExtStat.TcpSpuriousRtxHostQueues) / interval
+	netStat.TcpExtStat.BusyPollRxPacketsPerSec = (netStat.TcpExtStat.BusyPollRxPackets - bstat.TcpExtStat.BusyPollRxPackets) / interval
+	netStat.TcpExtStat.TcpAutoCorkingPerSec = (netStat.TcpExtStat.TcpAutoCorking - bstat.TcpExtStat.TcpAutoCorking) / interval
+	netStat.TcpExtStat.TcpFromZeroWindowAdvPerSec = (netStat.TcpExtStat.TcpFromZeroWindowAdv - bstat.TcpExtStat.TcpFromZeroWindowAdv) / interval
+	netStat.TcpExtStat.TcpToZeroWindowAdvPerSec = (netStat.TcpExtStat.TcpToZeroWindowAdv - bstat.TcpExtStat.TcpToZeroWindowAdv) / interval
+	netStat.TcpExtStat.TcpWantZeroWindowAdvPerSec = (netStat.TcpExtStat.TcpWantZeroWindowAdv - bstat.TcpExtStat.TcpWantZeroWindowAdv) / interval
+	netStat.TcpExtStat.TcpSynRetransPerSec = (netStat.TcpExtStat.TcpSynRetrans - bstat.TcpExtStat.TcpSynRetrans) / interval
+	netStat.TcpExtStat.TcpOrigDataSentPerSec = (netStat.TcpExtStat.TcpOrigDataSent - bstat.TcpExtStat.TcpOrigDataSent) / interval
+	netStat.TcpExtStat.TcpHystartTrainDetectPerSec = (netStat.TcpExtStat.TcpHystartTrainDetect - bstat.TcpExtStat.TcpHystartTrainDetect) / interval
+	netStat.TcpExtStat.TcpHystartTrainCwndPerSec = (netStat.TcpExtStat.TcpHystartTrainCwnd - bstat.TcpExtStat.TcpHystartTrainCwnd) / interval
+	netStat.TcpExtStat.TcpHystartDelayDetectPerSec = (netStat.TcpExtStat.TcpHystartDelayDetect - bstat.TcpExtStat.TcpHystartDelayDetect) / interval
+	netStat.TcpExtStat.TcpHystartDelayCwndPerSec = (netStat.TcpExtStat.TcpHystartDelayCwnd - bstat.TcpExtStat.TcpHystartDelayCwnd) / interval
+	netStat.TcpExtStat.TcpAckSkippedSynRecvPerSec = (netStat.TcpExtStat.TcpAckSkippedSynRecv - bstat.TcpExtStat.TcpAckSkippedSynRecv) / interval
+	netStat.TcpExtStat.TcpAckSkippedPAWSPerSec = (netStat.TcpExtStat.TcpAckSkippedPAWS - bstat.TcpExtStat.TcpAckSkippedPAWS) / interval
+	netStat.TcpExtStat.TcpAckSkippedSeqPerSec = (netStat.TcpExtStat.TcpAckSkippedSeq - bstat.TcpExtStat.TcpAckSkippedSeq) / interval
+	netStat.TcpExtStat.TcpAckSkippedFinWait2PerSec = (netStat.TcpExtStat.TcpAckSkippedFinWait2 - bstat.TcpExtStat.TcpAckSkippedFinWait2) / interval
+	netStat.TcpExtStat.TcpAckSkippedTimeWaitPerSec = (netStat.TcpExtStat.TcpAckSkippedTimeWait - bstat.TcpExtStat.TcpAckSkippedTimeWait) / interval
+	netStat.TcpExtStat.TcpAckSkippedChallengePerSec = (netStat.TcpExtStat.TcpAckSkippedChallenge - bstat.TcpExtStat.TcpAckSkippedChallenge) / interval
+	netStat.TcpExtStat.TcpWinProbePerSec = (netStat.TcpExtStat.TcpWinProbe - bstat.TcpExtStat.TcpWinProbe) / interval
+	netStat.TcpExtStat.TcpKeepAlivePerSec = (netStat.TcpExtStat.TcpKeepAlive - bstat.TcpExtStat.TcpKeepAlive) / interval
+	netStat.TcpExtStat.TcpMtupFailPerSec = (netStat.TcpExtStat.TcpMtupFail - bstat.TcpExtStat.TcpMtupFail) / interval
+	netStat.TcpExtStat.TcpMtupSuccessPerSec = (netStat.TcpExtStat.TcpMtupSuccess - bstat.TcpExtStat.TcpMtupSuccess) / interval
+	netStat.TcpExtStat.TcpDeliveredPerSec = (netStat.TcpExtStat.TcpDelivered - bstat.TcpExtStat.TcpDelivered) / interval
+	netStat.TcpExtStat.TcpDeliveredCEPerSec = (netStat.TcpExtStat.TcpDeliveredCE - bstat.TcpExtStat.TcpDeliveredCE) / interval
+	netStat.TcpExtStat.TcpAckCompressedPerSec = (netStat.TcpExtStat.TcpAckCompressed - bstat.TcpExtStat.TcpAckCompressed) / interval
+	netStat.TcpExtStat.TcpZeroWindowDropPerSec = (netStat.TcpExtStat.TcpZeroWindowDrop - bstat.TcpExtStat.TcpZeroWindowDrop) / interval
+	netStat.TcpExtStat.TcpRcvQDropPerSec = (netStat.TcpExtStat.TcpRcvQDrop - bstat.TcpExtStat.TcpRcvQDrop) / interval
+	netStat.TcpExtStat.TcpWqueueTooBigPerSec = (netStat.TcpExtStat.TcpWqueueTooBig - bstat.TcpExtStat.TcpWqueueTooBig) / interval
+	netStat.TcpExtStat.TcpFastOpenPassiveAltKeyPerSec = (netStat.TcpExtStat.TcpFastOpenPassiveAltKey - bstat.TcpExtStat.TcpFastOpenPassiveAltKey) / interval
+
+	netStat.IpExtStat.InNoRoutesPerSec = (netStat.IpExtStat.InNoRoutes - bstat.IpExtStat.InNoRoutes) / interval
+	netStat.IpExtStat.InTruncatedPktsPerSec = (netStat.IpExtStat.InTruncatedPkts - bstat.IpExtStat.InTruncatedPkts) / interval
+	netStat.IpExtStat.InCsumErrorsPerSec = (netStat.IpExtStat.InCsumErrors - bstat.IpExtStat.InCsumErrors) / interval
+	netStat.IpExtStat.InNoRoutesPerSec = (netStat.IpExtStat.InNoRoutes - bstat.IpExtStat.InNoRoutes) / interval
+	netStat.IpExtStat.InTruncatedPktsPerSec = (netStat.IpExtStat.InTruncatedPkts - bstat.IpExtStat.InTruncatedPkts) / interval
+	netStat.IpExtStat.InMcastPktsPerSec = (netStat.IpExtStat.InMcastPkts - bstat.IpExtStat.InMcastPkts) / interval
+	netStat.IpExtStat.OutMcastPktsPerSec = (netStat.IpExtStat.OutMcastPkts - bstat.IpExtStat.OutMcastPkts) / interval
+	netStat.IpExtStat.InBcastPktsPerSec = (netStat.IpExtStat.InBcastPkts - bstat.IpExtStat.InBcastPkts) / interval
+	netStat.IpExtStat.OutBcastPktsPerSec = (netStat.IpExtStat.OutBcastPkts - bstat.IpExtStat.OutBcastPkts) / interval
+	netStat.IpExtStat.InOctetsPerSec = (netStat.IpExtStat.InOctets - bstat.IpExtStat.InOctets) / interval
+	netStat.IpExtStat.OutOctetsPerSec = (netStat.IpExtStat.OutOctets - bstat.IpExtStat.OutOctets) / interval
+	netStat.IpExtStat.InMcastOctetsPerSec = (netStat.IpExtStat.InMcastOctets - bstat.IpExtStat.InMcastOctets) / interval
+	netStat.IpExtStat.OutMcastOctetsPerSec = (netStat.IpExtStat.OutMcastOctets - bstat.IpExtStat.OutMcastOctets) / interval
+	netStat.IpExtStat.InBcastOctetsPerSec = (netStat.IpExtStat.InBcastOctets - bstat.IpExtStat.InBcastOctets) / interval
+	netStat.IpExtStat.OutBcastOctetsPerSec = (netStat.IpExtStat.OutBcastOctets - bstat.IpExtStat.OutBcastOctets) / interval
+	netStat.IpExtStat.InCsumErrorsPerSec = (netStat.IpExtStat.InCsumErrors - bstat.IpExtStat.InCsumErrors) / interval
+	netStat.IpExtStat.InNoECTPktsPerSec = (netStat.IpExtStat.InNoECTPkts - bstat.IpExtStat.InNoECTPkts) / interval
+	netStat.IpExtStat.InECT1PktsPerSec = (netStat.IpExtStat.InECT1Pkts - bstat.IpExtStat.InECT1Pkts) / interval
+	netStat.IpExtStat.InECT0PktsPerSec = (netStat.IpExtStat.InECT0Pkts - bstat.IpExtStat.InECT0Pkts) / interval
+	netStat.IpExtStat.InCEPktsPerSec = (netStat.IpExtStat.InCEPkts - bstat.IpExtStat.InCEPkts) / interval
+	netStat.IpExtStat.ReasmOverlapsPerSec = (netStat.IpExtStat.ReasmOverlaps - bstat.IpExtStat.ReasmOverlaps) / interval
 
-	self.currentNetStat = netStat
 }
 
-func (self *StatRunner) syncProcessStat() {
-	processes, pidIndexMap, err := GetProcesses("/", true)
-	if err != nil {
-		return
-	}
+func (self *StatRunner) syncProcessStat(processes []Process, pidIndexMap map[int]int) {
 	if self.currentProcesses == nil {
 		self.currentProcesses = processes
 		self.currentPidIndexMap = pidIndexMap
@@ -379,36 +432,185 @@ func (self *StatRunner) syncProcessStat() {
 	self.currentPidIndexMap = pidIndexMap
 }
 
-func (self *StatRunner) syncLoginUserStat() {
-	var loginUserStat *LoginUserStat
-	var err error
-	if loginUserStat, err = GetLoginUserStat(); err != nil {
+func (self *StatRunner) syncLoginUserStat(loginUserStat *LoginUserStat) {
+	self.currentLoginUserStat = loginUserStat
+}
+
+func (self *StatRunner) syncUptimeStat(uptimeStat *UptimeStat) {
+	self.currentUptimeStat = uptimeStat
+}
+
+func (self *StatRunner) syncLoadavgStat(loadavgStat *LoadavgStat) {
+	if self.currentLoadavgStat == nil {
+		self.currentLoadavgStat = loadavgStat
 		return
 	}
 
-	self.currentLoginUserStat = loginUserStat
+	interval := self.interval
+
+	bstatMap := map[int]SchedCpuStat{}
+	for _, bstat := range self.currentLoadavgStat.SchedCpuStats {
+		bstatMap[bstat.Cpu] = bstat
+	}
+	for i, cstat := range loadavgStat.SchedCpuStats {
+		bstat, ok := bstatMap[cstat.Cpu]
+		if !ok {
+			continue
+		}
+		cstat.SchedCpuMsPerSec = (cstat.SchedCpuTime - bstat.SchedCpuTime) / 1000000 / interval
+		cstat.SchedWaitMsPerSec = (cstat.SchedWaitTime - bstat.SchedWaitTime) / 1000000 / interval
+		cstat.SchedTimeSlicesPerSec = (cstat.SchedTimeSlices - bstat.SchedTimeSlices) / interval
+		loadavgStat.SchedWaitMsPerSec += cstat.SchedWaitMsPerSec
+		loadavgStat.SchedCpuStats[i] = cstat
+	}
+	if loadavgStat.NumCpus > 0 {
+		loadavgStat.SchedWaitMsPerSecPerCpu = loadavgStat.SchedWaitMsPerSec / loadavgStat.NumCpus
+	}
+
+	self.currentLoadavgStat = loadavgStat
 	return
 }
 
-func (self *StatRunner) syncUptimeStat() {
-	var uptimeStat *UptimeStat
-	var err error
-	if uptimeStat, err = GetUptimeStat("/"); err != nil {
+func (self *StatRunner) syncFdStat(fdStat *FdStat) {
+	if self.currentFdStat == nil {
+		self.currentFdStat = fdStat
 		return
 	}
 
-	self.currentUptimeStat = uptimeStat
+	interval := self.interval
+
+	bstatMap := map[int]ProcessFdStat{}
+	for _, bstat := range self.currentFdStat.ProcessFdStats {
+		bstatMap[bstat.Pid] = bstat
+	}
+	for i, cstat := range fdStat.ProcessFdStats {
+		bstat, ok := bstatMap[cstat.Pid]
+		if !ok {
+			continue
+		}
+		cstat.OpenFdsPerSec = (cstat.OpenFds - bstat.OpenFds) / interval
+		fdStat.ProcessFdStats[i] = cstat
+	}
+
+	self.currentFdStat = fdStat
+	return
+}
+
+func (self *StatRunner) syncSensorStat(sensorStat *SensorStat) {
+	if self.currentSensorStat == nil {
+		self.currentSensorStat = sensorStat
+		return
+	}
+
+	interval := self.interval
+
+	braplStatMap := map[string]RaplStat{}
+	for _, bstat := range self.currentSensorStat.RaplStats {
+		braplStatMap[bstat.Zone] = bstat
+	}
+	for i, cstat := range sensorStat.RaplStats {
+		bstat, ok := braplStatMap[cstat.Zone]
+		if !ok {
+			continue
+		}
+		energyUj := cstat.EnergyUj - bstat.EnergyUj
+		if energyUj < 0 {
+			// カウンタが一周した
+			energyUj += cstat.MaxEnergyRangeUj
+		}
+		cstat.Watts = float64(energyUj) / 1000000 / float64(interval)
+		sensorStat.RaplStats[i] = cstat
+	}
+
+	bcpuFreqStatMap := map[int]CpuFreqStat{}
+	for _, bstat := range self.currentSensorStat.CpuFreqStats {
+		bcpuFreqStatMap[bstat.Cpu] = bstat
+	}
+	for i, cstat := range sensorStat.CpuFreqStats {
+		bstat, ok := bcpuFreqStatMap[cstat.Cpu]
+		if !ok {
+			continue
+		}
+		cstat.CoreThrottlePerSec = (cstat.CoreThrottleCount - bstat.CoreThrottleCount) / interval
+		cstat.PackageThrottlePerSec = (cstat.PackageThrottleCount - bstat.PackageThrottleCount) / interval
+		sensorStat.CpuFreqStats[i] = cstat
+	}
+
+	self.currentSensorStat = sensorStat
+	return
+}
+
+// syncKmsgStat は、handleStatsで通知するまでイベントを溜めておく
+func (self *StatRunner) syncKmsgStat(kmsgStat *KmsgStat) {
+	if self.currentKmsgStat != nil {
+		kmsgStat.Events = append(self.currentKmsgStat.Events, kmsgStat.Events...)
+	}
+	self.currentKmsgStat = kmsgStat
+}
+
+func (self *StatRunner) syncSlabStat(slabStat *SlabStat) {
+	if self.currentSlabStat == nil {
+		self.currentSlabStat = slabStat
+		return
+	}
+
+	interval := self.interval
+
+	slabStat.TotalBytesPerSec = (slabStat.TotalBytes - self.currentSlabStat.TotalBytes) / interval
+
+	bstatMap := map[string]SlabCacheStat{}
+	for _, bstat := range self.currentSlabStat.Caches {
+		bstatMap[bstat.Name] = bstat
+	}
+	for i, cstat := range slabStat.Caches {
+		bstat, ok := bstatMap[cstat.Name]
+		if !ok {
+			continue
+		}
+		cstat.BytesPerSec = (cstat.Bytes - bstat.Bytes) / interval
+		slabStat.Caches[i] = cstat
+	}
+
+	self.currentSlabStat = slabStat
+	return
+}
+
+func (self *StatRunner) syncZoneStat(zoneStat *ZoneStat) {
+	self.currentZoneStat = zoneStat
+}
+
+func (self *StatRunner) syncConntrackStat(conntrackStat *ConntrackStat) {
+	if self.currentConntrackStat == nil || conntrackStat == nil {
+		self.currentConntrackStat = conntrackStat
+		return
+	}
+
+	interval := self.interval
+	bstat := self.currentConntrackStat
+
+	conntrackStat.InsertFailedPerSec = (conntrackStat.InsertFailed - bstat.InsertFailed) / interval
+	conntrackStat.DropPerSec = (conntrackStat.Drop - bstat.Drop) / interval
+	conntrackStat.EarlyDropPerSec = (conntrackStat.EarlyDrop - bstat.EarlyDrop) / interval
+	conntrackStat.SearchRestartPerSec = (conntrackStat.SearchRestart - bstat.SearchRestart) / interval
+
+	for i, cstat := range conntrackStat.CpuStats {
+		if i >= len(bstat.CpuStats) {
+			break
+		}
+		bcpuStat := bstat.CpuStats[i]
+		cstat.InsertFailedPerSec = (cstat.InsertFailed - bcpuStat.InsertFailed) / interval
+		cstat.DropPerSec = (cstat.Drop - bcpuStat.Drop) / interval
+		cstat.EarlyDropPerSec = (cstat.EarlyDrop - bcpuStat.EarlyDrop) / interval
+		cstat.SearchRestartPerSec = (cstat.SearchRestart - bcpuStat.SearchRestart) / interval
+		conntrackStat.CpuStats[i] = cstat
+	}
+
+	self.currentConntrackStat = conntrackStat
 	return
 }
 
 func (self *StatRunner) Run(runAt time.Time) {
-	self.syncCpuStat()
-	self.syncMemStat()
-	self.syncDiskStat()
-	self.syncProcessStat()
-	self.syncNetStat()
-	self.syncLoginUserStat()
-	self.syncUptimeStat()
+	self.runCollectors(runAt)
 
 	stats := &Stats{
 		CpuStat:       self.currentCpuStat,
@@ -416,15 +618,34 @@ func (self *StatRunner) Run(runAt time.Time) {
 		DiskStat:      self.currentDiskStat,
 		Processes:     self.currentProcesses,
 		NetStat:       self.currentNetStat,
+		NetnsStat:     self.currentNetnsStat,
 		LoginUserStat: self.currentLoginUserStat,
 		UptimeStat:    self.currentUptimeStat,
+		LoadavgStat:   self.currentLoadavgStat,
+		FdStat:        self.currentFdStat,
+		SensorStat:    self.currentSensorStat,
+		KmsgStat:      self.currentKmsgStat,
+		SlabStat:      self.currentSlabStat,
+		ZoneStat:      self.currentZoneStat,
+		ConntrackStat: self.currentConntrackStat,
+	}
+	for _, collector := range self.collectors {
+		stats.CollectorStats = append(stats.CollectorStats, self.collectorStates[collector.name].stat)
 	}
 
 	if self.currentStats != nil {
+		// 初回は差分Statがないので、2回目以降から異常検知を行う
+		if self.analyzer != nil {
+			stats.Anomalies = self.analyzer.Analyze(stats)
+		}
 		self.currentStats = stats
 		if self.handleStats != nil {
 			self.handleStats(runAt, stats)
 		}
+		// イベントは一度だけ通知する
+		if self.currentKmsgStat != nil {
+			self.currentKmsgStat = &KmsgStat{EventCounts: self.currentKmsgStat.EventCounts}
+		}
 	} else {
 		self.currentStats = stats
 	}
@@ -0,0 +1,101 @@
+package os_utils
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/syunkitada/goapp2/pkg/lib/str_utils"
+)
+
+type ZoneStat struct {
+	Zones []ZoneInfo
+}
+
+// ZoneInfo は、ノードのゾーンごとの空きページとwatermark(単位はページ)
+// 空きページがlowを下回るとkswapdが起動し、minを下回ると直接回収(direct reclaim)となる
+type ZoneInfo struct {
+	NodeId  int
+	Zone    string
+	Free    int
+	Min     int
+	Low     int
+	High    int
+	Managed int
+
+	IsBelowLow bool
+	IsBelowMin bool
+}
+
+func GetZoneStat(rootDir string) (zoneStat *ZoneStat, err error) {
+	// $ cat /proc/zoneinfo
+	// Node 0, zone      DMA
+	//   per-node stats
+	//       nr_inactive_anon 49804
+	//       ...
+	//   pages free     3840
+	//         boost    0
+	//         min      48
+	//         low      60
+	//         high     72
+	//         promo    84
+	//         spanned  4095
+	//         present  3998
+	//         managed  3840
+	//         ...
+	// Node 0, zone    DMA32
+	// メモリのないゾーン(managedが0)は除く
+	var zoneinfoFile *os.File
+	if zoneinfoFile, err = os.Open(rootDir + "proc/zoneinfo"); err != nil {
+		return
+	}
+	defer zoneinfoFile.Close()
+
+	zoneStat = &ZoneStat{}
+	var zoneInfo *ZoneInfo
+	appendZoneInfo := func() {
+		if zoneInfo != nil && zoneInfo.Managed > 0 {
+			zoneInfo.IsBelowLow = zoneInfo.Free < zoneInfo.Low
+			zoneInfo.IsBelowMin = zoneInfo.Free < zoneInfo.Min
+			zoneStat.Zones = append(zoneStat.Zones, *zoneInfo)
+		}
+	}
+
+	scanner := bufio.NewScanner(zoneinfoFile)
+	for scanner.Scan() {
+		columns := str_utils.SplitSpace(strings.TrimSpace(scanner.Text()))
+		if len(columns) == 0 {
+			continue
+		}
+		if columns[0] == "Node" {
+			if len(columns) != 4 {
+				continue
+			}
+			appendZoneInfo()
+			nodeId, _ := strconv.Atoi(strings.TrimSuffix(columns[1], ","))
+			zoneInfo = &ZoneInfo{NodeId: nodeId, Zone: columns[3]}
+			continue
+		}
+		if zoneInfo == nil {
+			continue
+		}
+		switch {
+		case len(columns) == 3 && columns[0] == "pages" && columns[1] == "free":
+			zoneInfo.Free, _ = strconv.Atoi(columns[2])
+		case len(columns) == 2 && columns[0] == "min":
+			zoneInfo.Min, _ = strconv.Atoi(columns[1])
+		case len(columns) == 2 && columns[0] == "low":
+			zoneInfo.Low, _ = strconv.Atoi(columns[1])
+		case len(columns) == 2 && columns[0] == "high":
+			zoneInfo.High, _ = strconv.Atoi(columns[1])
+		case len(columns) == 2 && columns[0] == "managed":
+			zoneInfo.Managed, _ = strconv.Atoi(columns[1])
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	appendZoneInfo()
+	return
+}
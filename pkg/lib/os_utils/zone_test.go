@@ -0,0 +1,48 @@
+package os_utils
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetZoneStat(t *testing.T) {
+	a := assert.New(t)
+
+	wd, err := os.Getwd()
+	a.NoError(err)
+	rootDir := wd + "/testdata/root/"
+
+	zoneStat, err := GetZoneStat(rootDir)
+	a.NoError(err)
+
+	// managedが0のMovableは除かれる
+	a.Equal([]ZoneInfo{
+		{NodeId: 0, Zone: "DMA", Free: 3840, Min: 48, Low: 60, High: 72, Managed: 3840},
+		{NodeId: 0, Zone: "Normal", Free: 900, Min: 1000, Low: 1250, High: 1500, Managed: 541341, IsBelowLow: true, IsBelowMin: true},
+	}, zoneStat.Zones)
+
+	metrics := GetStatsMetrics(&Stats{ZoneStat: zoneStat})
+	a.Equal(float64(900), metrics["zone.node0.Normal.free"])
+}
+
+func TestFragmentationIndex(t *testing.T) {
+	a := assert.New(t)
+
+	// 2M以上のブロックがあれば、そのまま割り当てられる
+	buddyinfo := BuddyinfoStat{M4K: 100, M2M: 1}
+	a.Equal(float64(-1), buddyinfo.FragmentationIndex(HugepageOrder))
+
+	// 空きページは十分にあるが、細かいブロックに断片化している
+	buddyinfo = BuddyinfoStat{M4K: 10000, M8K: 5000}
+	a.InDelta(0.997, buddyinfo.FragmentationIndex(HugepageOrder), 0.001)
+
+	// 空きページ自体が少ない
+	buddyinfo = BuddyinfoStat{M4K: 1}
+	a.InDelta(0.0, buddyinfo.FragmentationIndex(HugepageOrder), 0.01)
+
+	// 空きページがない
+	buddyinfo = BuddyinfoStat{}
+	a.Equal(float64(0), buddyinfo.FragmentationIndex(HugepageOrder))
+}
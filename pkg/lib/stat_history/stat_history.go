@@ -0,0 +1,312 @@
+package stat_history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/syunkitada/goapp2/pkg/lib/db_utils"
+	"github.com/syunkitada/goapp2/pkg/lib/logger"
+	"github.com/syunkitada/goapp2/pkg/lib/os_utils"
+)
+
+const (
+	DefaultRawRetention    = time.Hour
+	DefaultRollupRetention = 7 * 24 * time.Hour
+	DefaultCompactInterval = time.Hour
+
+	// ロールアップの単位(秒)
+	rollupStep = 60
+
+	// sqliteの変数の上限(999)を超えないように、まとめてINSERTする行数を制限する
+	insertChunkSize = 300
+)
+
+type Config struct {
+	Database        db_utils.Config
+	RawRetention    time.Duration // 1秒ごとの生データを保存する期間
+	RollupRetention time.Duration // 1分ごとのロールアップを保存する期間
+	CompactInterval time.Duration // VACUUMでDBファイルを縮小する間隔
+}
+
+// StatRawSample は、収集したメトリクスの生データ
+type StatRawSample struct {
+	Timestamp int64   `gorm:"not null;unique_index:udx_stat_raw_sample;index:idx_stat_raw_sample_timestamp"`
+	Metric    string  `gorm:"not null;unique_index:udx_stat_raw_sample"`
+	Value     float64 `gorm:"not null"`
+}
+
+// StatRollupSample は、生データを1分ごとに集約したもの
+type StatRollupSample struct {
+	Timestamp int64   `gorm:"not null;unique_index:udx_stat_rollup_sample;index:idx_stat_rollup_sample_timestamp"`
+	Metric    string  `gorm:"not null;unique_index:udx_stat_rollup_sample"`
+	Avg       float64 `gorm:"not null"`
+	Min       float64 `gorm:"not null"`
+	Max       float64 `gorm:"not null"`
+	Count     int     `gorm:"not null"`
+}
+
+// Sample は、Queryの結果
+// 生データの場合は、Avg, Min, Maxは同じ値でCountは1となる
+type Sample struct {
+	Time     time.Time
+	Metric   string
+	Avg      float64
+	Min      float64
+	Max      float64
+	Count    int
+	IsRollup bool
+}
+
+type StatHistory struct {
+	conf      Config
+	sqlClient *db_utils.SqlClient
+
+	// この時刻(分の先頭)以降の生データは、まだロールアップしていない
+	rolledUpAt  int64
+	compactedAt time.Time
+}
+
+func NewStatHistory(conf *Config) (history *StatHistory) {
+	history = &StatHistory{
+		conf:      *conf,
+		sqlClient: db_utils.NewSqlClient(&conf.Database),
+	}
+	if history.conf.RawRetention == 0 {
+		history.conf.RawRetention = DefaultRawRetention
+	}
+	if history.conf.RollupRetention == 0 {
+		history.conf.RollupRetention = DefaultRollupRetention
+	}
+	if history.conf.CompactInterval == 0 {
+		history.conf.CompactInterval = DefaultCompactInterval
+	}
+	return
+}
+
+// MustOpen は、DBを開いてテーブルを作成する
+func (self *StatHistory) MustOpen(tctx *logger.TraceContext) {
+	if self.conf.Database.Connection != "" {
+		if tmpErr := os.MkdirAll(filepath.Dir(self.conf.Database.Connection), 0755); tmpErr != nil {
+			logger.Fatalf(tctx, "Failed MkdirAll: err=%s", tmpErr.Error())
+		}
+	}
+	self.sqlClient.MustOpen(tctx)
+	if tmpErr := self.sqlClient.DB.AutoMigrate(&StatRawSample{}, &StatRollupSample{}).Error; tmpErr != nil {
+		logger.Fatalf(tctx, "Failed AutoMigrate: err=%s", tmpErr.Error())
+	}
+}
+
+func (self *StatHistory) MustClose(tctx *logger.TraceContext) {
+	self.sqlClient.MustClose(tctx)
+}
+
+// Write は、StatsをGetStatsMetricsで変換して生データとして保存する
+// 分が変わったタイミングで、前の分までのロールアップ、保存期間を過ぎたデータの削除、定期的なVACUUMを行う
+func (self *StatHistory) Write(tctx *logger.TraceContext, runAt time.Time, stats *os_utils.Stats) (err error) {
+	metrics := os_utils.GetStatsMetrics(stats)
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	timestamp := runAt.Unix()
+	err = self.sqlClient.Transact(tctx, func(tx *gorm.DB) (err error) {
+		for i := 0; i < len(names); i += insertChunkSize {
+			end := i + insertChunkSize
+			if end > len(names) {
+				end = len(names)
+			}
+			placeholders := make([]string, 0, end-i)
+			values := make([]interface{}, 0, (end-i)*3)
+			for _, name := range names[i:end] {
+				placeholders = append(placeholders, "(?, ?, ?)")
+				values = append(values, timestamp, name, metrics[name])
+			}
+			if err = tx.Exec("INSERT OR REPLACE INTO stat_raw_samples (timestamp, metric, value) VALUES "+
+				strings.Join(placeholders, ", "), values...).Error; err != nil {
+				return
+			}
+		}
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	if self.rolledUpAt == 0 {
+		if self.rolledUpAt, err = self.getRolledUpAt(timestamp); err != nil {
+			return
+		}
+	}
+
+	minuteAt := timestamp - timestamp%rollupStep
+	if minuteAt > self.rolledUpAt {
+		if err = self.rollup(tctx, minuteAt, runAt); err != nil {
+			return
+		}
+	}
+
+	if runAt.Sub(self.compactedAt) > self.conf.CompactInterval {
+		// 起動直後は、前回のVACUUMからの経過時間がわからないので、CompactInterval後に行う
+		if !self.compactedAt.IsZero() {
+			if err = self.sqlClient.DB.Exec("VACUUM").Error; err != nil {
+				return
+			}
+		}
+		self.compactedAt = runAt
+	}
+	return
+}
+
+// getRolledUpAt は、ロールアップ済みの最後の分の次の分を返す
+// ロールアップがまだない場合は、生データの最初の分(生データもない場合はtimestampの分)の先頭を返す
+func (self *StatHistory) getRolledUpAt(timestamp int64) (rolledUpAt int64, err error) {
+	var lastRollupAt *int64
+	if lastRollupAt, err = self.selectTimestamp("SELECT MAX(timestamp) AS timestamp FROM stat_rollup_samples"); err != nil {
+		return
+	}
+	if lastRollupAt != nil {
+		rolledUpAt = *lastRollupAt + rollupStep
+		return
+	}
+	rolledUpAt = timestamp - timestamp%rollupStep
+	var firstRawAt *int64
+	if firstRawAt, err = self.selectTimestamp("SELECT MIN(timestamp) AS timestamp FROM stat_raw_samples"); err != nil {
+		return
+	}
+	if firstRawAt != nil && *firstRawAt < rolledUpAt {
+		rolledUpAt = *firstRawAt - *firstRawAt%rollupStep
+	}
+	return
+}
+
+// selectTimestamp は、MAXやMINで集約したtimestampを返す(行がない場合はnil)
+func (self *StatHistory) selectTimestamp(query string) (timestamp *int64, err error) {
+	var result struct {
+		Timestamp *int64
+	}
+	if err = self.sqlClient.DB.Raw(query).Scan(&result).Error; err != nil {
+		return
+	}
+	timestamp = result.Timestamp
+	return
+}
+
+// rollup は、[rolledUpAt, minuteAt)の生データを1分ごとに集約し、保存期間を過ぎたデータを削除する
+func (self *StatHistory) rollup(tctx *logger.TraceContext, minuteAt int64, runAt time.Time) (err error) {
+	err = self.sqlClient.Transact(tctx, func(tx *gorm.DB) (err error) {
+		if err = tx.Exec("INSERT OR REPLACE INTO stat_rollup_samples (timestamp, metric, avg, min, max, count) "+
+			"SELECT timestamp - timestamp % ?, metric, AVG(value), MIN(value), MAX(value), COUNT(*) "+
+			"FROM stat_raw_samples WHERE timestamp >= ? AND timestamp < ? "+
+			"GROUP BY timestamp - timestamp % ?, metric",
+			rollupStep, self.rolledUpAt, minuteAt, rollupStep).Error; err != nil {
+			return
+		}
+		if err = tx.Exec("DELETE FROM stat_raw_samples WHERE timestamp < ?",
+			runAt.Add(-self.conf.RawRetention).Unix()).Error; err != nil {
+			return
+		}
+		if err = tx.Exec("DELETE FROM stat_rollup_samples WHERE timestamp < ?",
+			runAt.Add(-self.conf.RollupRetention).Unix()).Error; err != nil {
+			return
+		}
+		return
+	})
+	if err != nil {
+		return
+	}
+	self.rolledUpAt = minuteAt
+	return
+}
+
+// Query は、since以降のmetricの値を時刻順に返す
+// metricには"disk.*.rbps"のようなglobパターンを指定できる
+// 生データが残っている期間は生データを、それより古い期間はロールアップを返す
+func (self *StatHistory) Query(metric string, since time.Time) (samples []Sample, err error) {
+	var firstRawAt, lastRollupAt *int64
+	if firstRawAt, err = self.selectTimestamp("SELECT MIN(timestamp) AS timestamp FROM stat_raw_samples"); err != nil {
+		return
+	}
+	if lastRollupAt, err = self.selectTimestamp("SELECT MAX(timestamp) AS timestamp FROM stat_rollup_samples"); err != nil {
+		return
+	}
+
+	sinceTimestamp := since.Unix()
+	var rollups []StatRollupSample
+	rollupQuery := self.sqlClient.DB.Table("stat_rollup_samples").Select("*").
+		Where("metric GLOB ? AND timestamp >= ?", metric, sinceTimestamp-sinceTimestamp%rollupStep)
+	if firstRawAt != nil {
+		// 生データの最初の分は生データが欠けている可能性があるので、ロールアップ済みであればロールアップを使う
+		rawFrom := (*firstRawAt + rollupStep - 1) / rollupStep * rollupStep
+		if lastRollupAt != nil && rawFrom > *lastRollupAt+rollupStep {
+			rawFrom = *lastRollupAt + rollupStep
+		}
+		if lastRollupAt == nil {
+			rawFrom = *firstRawAt
+		}
+		rollupQuery = rollupQuery.Where("timestamp < ?", rawFrom)
+		if sinceTimestamp < rawFrom {
+			sinceTimestamp = rawFrom
+		}
+	}
+	if err = rollupQuery.Order("timestamp, metric").Find(&rollups).Error; err != nil {
+		return
+	}
+	for _, rollup := range rollups {
+		samples = append(samples, Sample{
+			Time:     time.Unix(rollup.Timestamp, 0),
+			Metric:   rollup.Metric,
+			Avg:      rollup.Avg,
+			Min:      rollup.Min,
+			Max:      rollup.Max,
+			Count:    rollup.Count,
+			IsRollup: true,
+		})
+	}
+
+	if firstRawAt == nil {
+		return
+	}
+	var raws []StatRawSample
+	if err = self.sqlClient.DB.Table("stat_raw_samples").Select("*").
+		Where("metric GLOB ? AND timestamp >= ?", metric, sinceTimestamp).
+		Order("timestamp, metric").Find(&raws).Error; err != nil {
+		return
+	}
+	for _, raw := range raws {
+		samples = append(samples, Sample{
+			Time:   time.Unix(raw.Timestamp, 0),
+			Metric: raw.Metric,
+			Avg:    raw.Value,
+			Min:    raw.Value,
+			Max:    raw.Value,
+			Count:  1,
+		})
+	}
+	return
+}
+
+// ParseSince は、"2h"や"7d"のような期間を、現在時刻からさかのぼった時刻に変換する
+func ParseSince(now time.Time, since string) (sinceAt time.Time, err error) {
+	if strings.HasSuffix(since, "d") {
+		var days int
+		if _, err = fmt.Sscanf(since, "%dd", &days); err != nil {
+			err = fmt.Errorf("Invalid since: since=%s, err=%s", since, err.Error())
+			return
+		}
+		sinceAt = now.Add(-time.Duration(days) * 24 * time.Hour)
+		return
+	}
+	var duration time.Duration
+	if duration, err = time.ParseDuration(since); err != nil {
+		return
+	}
+	sinceAt = now.Add(-duration)
+	return
+}
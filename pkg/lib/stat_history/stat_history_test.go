@@ -0,0 +1,91 @@
+package stat_history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/syunkitada/goapp2/pkg/lib/db_utils"
+	"github.com/syunkitada/goapp2/pkg/lib/logger"
+	"github.com/syunkitada/goapp2/pkg/lib/os_utils"
+)
+
+func TestStatHistory(t *testing.T) {
+	a := assert.New(t)
+	tctx := logger.NewTraceContext()
+
+	history := NewStatHistory(&Config{
+		Database: db_utils.Config{
+			Connection: t.TempDir() + "/history/stat.db",
+		},
+		RawRetention:    90 * time.Second,
+		RollupRetention: 10 * time.Minute,
+	})
+	history.MustOpen(tctx)
+	defer history.MustClose(tctx)
+
+	// 3分間、1秒ごとにcpu.ctxとして秒数を書き込む
+	baseAt := time.Unix(1700000000-1700000000%60, 0)
+	for i := 0; i < 180; i++ {
+		stats := &os_utils.Stats{
+			CpuStat: &os_utils.CpuStat{CtxPerSec: i},
+		}
+		a.NoError(history.Write(tctx, baseAt.Add(time.Duration(i)*time.Second), stats))
+	}
+
+	// 最初の1分は生データが削除されていて、ロールアップのみ残っている
+	samples, err := history.Query("cpu.ctx", baseAt)
+	a.NoError(err)
+	a.Len(samples, 1+120)
+	a.Equal(Sample{
+		Time: baseAt, Metric: "cpu.ctx", Avg: 29.5, Min: 0, Max: 59, Count: 60, IsRollup: true,
+	}, samples[0])
+	a.Equal(Sample{
+		Time: baseAt.Add(60 * time.Second), Metric: "cpu.ctx", Avg: 60, Min: 60, Max: 60, Count: 1,
+	}, samples[1])
+	a.Equal(float64(179), samples[len(samples)-1].Avg)
+
+	// globでメトリクスを指定できる
+	samples, err = history.Query("cpu.c*", baseAt.Add(170*time.Second))
+	a.NoError(err)
+	a.Len(samples, 10)
+
+	{
+		// 再度開いた場合は、ロールアップ済みの続きからロールアップする
+		history2 := NewStatHistory(&history.conf)
+		history2.MustOpen(tctx)
+		defer history2.MustClose(tctx)
+		rolledUpAt, err := history2.getRolledUpAt(baseAt.Add(3 * time.Minute).Unix())
+		a.NoError(err)
+		a.Equal(baseAt.Add(2*time.Minute).Unix(), rolledUpAt)
+	}
+
+	{
+		// 保存期間を過ぎたロールアップは削除される
+		stats := &os_utils.Stats{CpuStat: &os_utils.CpuStat{CtxPerSec: 1}}
+		a.NoError(history.Write(tctx, baseAt.Add(20*time.Minute), stats))
+		samples, err := history.Query("cpu.ctx", baseAt)
+		a.NoError(err)
+		a.Len(samples, 1)
+		a.False(samples[0].IsRollup)
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	a := assert.New(t)
+	now := time.Unix(1700000000, 0)
+
+	sinceAt, err := ParseSince(now, "2h")
+	a.NoError(err)
+	a.Equal(now.Add(-2*time.Hour), sinceAt)
+
+	sinceAt, err = ParseSince(now, "7d")
+	a.NoError(err)
+	a.Equal(now.Add(-7*24*time.Hour), sinceAt)
+
+	{
+		// 不正な期間
+		_, err := ParseSince(now, "xd")
+		a.Error(err)
+	}
+}
@@ -0,0 +1,44 @@
+package str_utils
+
+// DiffLines は、oldLinesからnewLinesへの行単位の差分を返す
+// 各行の先頭には、共通の行は"  "、oldLinesのみの行は"- "、newLinesのみの行は"+ "を付ける
+func DiffLines(oldLines []string, newLines []string) (lines []string) {
+	// lcs[i][j]は、oldLines[i:]とnewLines[j:]の最長共通部分列の長さ
+	lcs := make([][]int, len(oldLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(newLines)+1)
+	}
+	for i := len(oldLines) - 1; i >= 0; i-- {
+		for j := len(newLines) - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(oldLines) && j < len(newLines) {
+		if oldLines[i] == newLines[j] {
+			lines = append(lines, "  "+oldLines[i])
+			i++
+			j++
+		} else if lcs[i+1][j] >= lcs[i][j+1] {
+			lines = append(lines, "- "+oldLines[i])
+			i++
+		} else {
+			lines = append(lines, "+ "+newLines[j])
+			j++
+		}
+	}
+	for ; i < len(oldLines); i++ {
+		lines = append(lines, "- "+oldLines[i])
+	}
+	for ; j < len(newLines); j++ {
+		lines = append(lines, "+ "+newLines[j])
+	}
+	return
+}
@@ -0,0 +1,30 @@
+package str_utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffLines(t *testing.T) {
+	a := assert.New(t)
+	{
+		result := DiffLines([]string{"a", "b", "c"}, []string{"a", "b", "c"})
+		a.Equal([]string{"  a", "  b", "  c"}, result)
+	}
+
+	{
+		result := DiffLines([]string{"a", "b", "c"}, []string{"a", "x", "c", "d"})
+		a.Equal([]string{"  a", "- b", "+ x", "  c", "+ d"}, result)
+	}
+
+	{
+		result := DiffLines(nil, []string{"a"})
+		a.Equal([]string{"+ a"}, result)
+	}
+
+	{
+		result := DiffLines([]string{"a"}, nil)
+		a.Equal([]string{"- a"}, result)
+	}
+}
@@ -13,14 +13,14 @@ func OutputByFormat(data interface{}, format string) {
 	case "yaml":
 		bytes, err := yaml.Marshal(&data)
 		if err != nil {
-			fmt.Println("Failed yaml.Marshal: err=%s", err.Error())
+			fmt.Printf("Failed yaml.Marshal: err=%s\n", err.Error())
 			os.Exit(1)
 		}
 		fmt.Println(string(bytes))
 	case "json":
 		bytes, err := json.Marshal(&data)
 		if err != nil {
-			fmt.Println("Failed yaml.Marshal: err=%s", err.Error())
+			fmt.Printf("Failed json.Marshal: err=%s\n", err.Error())
 			os.Exit(1)
 		}
 		fmt.Println(string(bytes))
@@ -39,7 +39,7 @@ func AppendOutputByFormat(outputs []string, data interface{}, format string) []s
 		bytes, err = json.Marshal(&data)
 	}
 	if err != nil {
-		fmt.Println("Failed yaml.Marshal: err=%s", err.Error())
+		fmt.Printf("Failed marshal: format=%s, err=%s\n", format, err.Error())
 		os.Exit(1)
 	}
 	return append(outputs, string(bytes))
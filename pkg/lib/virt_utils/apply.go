@@ -0,0 +1,555 @@
+package virt_utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/syunkitada/goapp2/pkg/lib/errors"
+	"github.com/syunkitada/goapp2/pkg/lib/logger"
+	"github.com/syunkitada/goapp2/pkg/lib/str_utils"
+)
+
+const (
+	ApplyActionCreate    = "create"
+	ApplyActionUpdate    = "update"
+	ApplyActionUnchanged = "unchanged"
+	ApplyActionDelete    = "delete"
+
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorReset  = "\x1b[0m"
+)
+
+var applyActionColors = map[string]string{
+	ApplyActionCreate: colorGreen,
+	ApplyActionUpdate: colorYellow,
+	ApplyActionDelete: colorRed,
+}
+
+// immutableFields は、作成後に変更できないフィールド(差分のキー)
+// 変更する場合は、削除してから作成しなおす
+var immutableFields = map[string][]string{
+	KindImage:   {"namespace", "kind"},
+	KindNetwork: {"namespace", "kind", "subnet", "gateway"},
+	KindVm:      {"namespace", "kind", "diskGb", "image", "networks"},
+}
+
+// ApplyPlan は、ファイルのリソースとDBのリソースを比較した、適用する操作の一覧
+// Itemsは適用する順で、ネットワーク、イメージ、VMの作成と更新の後に、VM、イメージ、ネットワークの削除を並べる
+type ApplyPlan struct {
+	Items []ApplyPlanItem
+}
+
+type ApplyPlanItem struct {
+	Action  string
+	Kind    string
+	Name    string
+	Diff    []string // 先頭が"  "、"- "、"+ "の差分の行
+	image   *ImageSpec
+	network *NetworkSpec
+	vm      *VmSpec
+}
+
+// Format は、planを差分とともに文字列にする、colorの場合は操作ごとに色を付ける
+func (self *ApplyPlan) Format(color bool) string {
+	var b strings.Builder
+	counts := map[string]int{}
+	for _, item := range self.Items {
+		counts[item.Action]++
+		b.WriteString(colorize(color, applyActionColors[item.Action],
+			fmt.Sprintf("%s %s/%s", item.Action, item.Kind, item.Name)) + "\n")
+		for _, line := range item.Diff {
+			lineColor := ""
+			switch line[0] {
+			case '-':
+				lineColor = colorRed
+			case '+':
+				lineColor = colorGreen
+			}
+			b.WriteString(colorize(color, lineColor, "  "+line) + "\n")
+		}
+	}
+	fmt.Fprintf(&b, "Plan: %d to create, %d to update, %d to delete, %d unchanged",
+		counts[ApplyActionCreate], counts[ApplyActionUpdate], counts[ApplyActionDelete], counts[ApplyActionUnchanged])
+	return b.String()
+}
+
+func (self *ApplyPlan) Output() {
+	fmt.Println(self.Format(true))
+}
+
+func colorize(color bool, colorStr string, str string) string {
+	if !color || colorStr == "" {
+		return str
+	}
+	return colorStr + str + colorReset
+}
+
+// PlanApply は、ファイルのリソースとDBのリソースを比較し、適用する操作を返す
+// 変更はSpecStrと各カラムを比較して検出し、pruneの場合はファイルにないリソースを削除する
+func (self *VirtController) PlanApply(tctx *logger.TraceContext, resourcesBytes [][]byte, prune bool) (plan *ApplyPlan, err error) {
+	var images []ImageSpec
+	var networks []NetworkSpec
+	var vms []VmSpec
+	if images, networks, vms, err = parseResources(resourcesBytes); err != nil {
+		return
+	}
+
+	var currentNetworks map[string]NetworkSpec
+	if currentNetworks, err = self.getCurrentNetworkSpecs(); err != nil {
+		return
+	}
+	var currentImages map[string]ImageSpec
+	if currentImages, err = self.getCurrentImageSpecs(); err != nil {
+		return
+	}
+	var currentVms map[string]VmSpec
+	if currentVms, err = self.getCurrentVmSpecs(tctx); err != nil {
+		return
+	}
+
+	plan = &ApplyPlan{}
+	var item ApplyPlanItem
+	for i := range networks {
+		spec := &networks[i]
+		if _, err = self.validateNetworkSpec(spec); err != nil {
+			return
+		}
+		var current interface{}
+		if currentSpec, ok := currentNetworks[spec.Name]; ok {
+			current = currentSpec
+		}
+		if item, err = newApplyPlanItem(KindNetwork, spec.Name, current, *spec); err != nil {
+			return
+		}
+		item.network = spec
+		plan.Items = append(plan.Items, item)
+	}
+
+	for i := range images {
+		spec := &images[i]
+		if _, err = self.validateImageSpec(spec); err != nil {
+			return
+		}
+		var current interface{}
+		if currentSpec, ok := currentImages[spec.Name]; ok {
+			current = currentSpec
+		}
+		if item, err = newApplyPlanItem(KindImage, spec.Name, current, *spec); err != nil {
+			return
+		}
+		item.image = spec
+		plan.Items = append(plan.Items, item)
+	}
+
+	// VMが利用するイメージとネットワーク、キーは名前
+	usedImages := map[string][]string{}
+	usedNetworks := map[string][]string{}
+	for i := range vms {
+		spec := &vms[i]
+		if _, err = self.validateVmSpec(spec); err != nil {
+			return
+		}
+		var current interface{}
+		var desired VmSpec
+		if currentSpec, ok := currentVms[spec.Name]; ok {
+			current = currentSpec
+			desired = normalizeVmSpec(*spec, &currentSpec)
+		} else {
+			desired = normalizeVmSpec(*spec, nil)
+		}
+		if item, err = newApplyPlanItem(KindVm, spec.Name, current, desired); err != nil {
+			return
+		}
+		item.vm = spec
+		plan.Items = append(plan.Items, item)
+
+		if desired.Image.Name != "" {
+			usedImages[desired.Image.Name] = append(usedImages[desired.Image.Name], spec.Name)
+		}
+		for _, network := range desired.Networks {
+			usedNetworks[network.Name] = append(usedNetworks[network.Name], spec.Name)
+		}
+	}
+
+	if !prune {
+		return
+	}
+
+	desiredNames := map[string]bool{}
+	for _, item := range plan.Items {
+		desiredNames[item.Kind+"/"+item.Name] = true
+	}
+	vmNames := []string{}
+	for name := range currentVms {
+		vmNames = append(vmNames, name)
+	}
+	sort.Strings(vmNames)
+	for _, name := range vmNames {
+		if desiredNames[KindVm+"/"+name] {
+			continue
+		}
+		if item, err = newApplyPlanItem(KindVm, name, currentVms[name], nil); err != nil {
+			return
+		}
+		plan.Items = append(plan.Items, item)
+	}
+	imageNames := []string{}
+	for name := range currentImages {
+		imageNames = append(imageNames, name)
+	}
+	sort.Strings(imageNames)
+	for _, name := range imageNames {
+		if desiredNames[KindImage+"/"+name] {
+			continue
+		}
+		if usedVmNames, ok := usedImages[name]; ok {
+			err = errors.NewConflictErrorf("image is used by vms: name=%s, vms=%s", name, strings.Join(usedVmNames, ","))
+			return
+		}
+		if item, err = newApplyPlanItem(KindImage, name, currentImages[name], nil); err != nil {
+			return
+		}
+		plan.Items = append(plan.Items, item)
+	}
+	networkNames := []string{}
+	for name := range currentNetworks {
+		networkNames = append(networkNames, name)
+	}
+	sort.Strings(networkNames)
+	for _, name := range networkNames {
+		if desiredNames[KindNetwork+"/"+name] {
+			continue
+		}
+		if usedVmNames, ok := usedNetworks[name]; ok {
+			err = errors.NewConflictErrorf("network is used by vms: name=%s, vms=%s", name, strings.Join(usedVmNames, ","))
+			return
+		}
+		if item, err = newApplyPlanItem(KindNetwork, name, currentNetworks[name], nil); err != nil {
+			return
+		}
+		plan.Items = append(plan.Items, item)
+	}
+	return
+}
+
+// Apply は、planの操作を順に適用する
+// 途中で失敗した場合は、それまでに適用した操作はそのままとなる
+func (self *VirtController) Apply(tctx *logger.TraceContext, plan *ApplyPlan) (err error) {
+	for _, item := range plan.Items {
+		switch item.Action {
+		case ApplyActionCreate, ApplyActionUpdate:
+			switch item.Kind {
+			case KindNetwork:
+				err = self.CreateOrUpdateNetwork(tctx, item.network)
+			case KindImage:
+				err = self.CreateOrUpdateImage(tctx, item.image)
+			case KindVm:
+				err = self.CreateOrUpdateVm(tctx, item.vm)
+			}
+		case ApplyActionDelete:
+			switch item.Kind {
+			case KindNetwork:
+				_, err = self.DeleteNetworkResources(tctx, []string{item.Name})
+			case KindImage:
+				_, err = self.DeleteImageResources(tctx, []string{item.Name})
+			case KindVm:
+				_, err = self.DeleteVmResources(tctx, []string{item.Name})
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return
+		}
+		logger.Infof(tctx, "applied: action=%s, kind=%s, name=%s", item.Action, item.Kind, item.Name)
+	}
+	return
+}
+
+// parseResources は、ファイルのリソースをkindごとのspecに変換する
+// 不明なkindや、同じkindで名前が重複している場合はエラーとする
+func parseResources(resourcesBytes [][]byte) (images []ImageSpec, networks []NetworkSpec, vms []VmSpec, err error) {
+	names := map[string]bool{}
+	for _, resourceBytes := range resourcesBytes {
+		// ファイルの先頭や末尾の---により、空のドキュメントとなる場合がある
+		if len(bytes.TrimSpace(resourceBytes)) == 0 {
+			continue
+		}
+		var resource Resource
+		if err = yaml.Unmarshal(resourceBytes, &resource); err != nil {
+			return
+		}
+		if resource.Kind == "" && resource.Spec == nil {
+			continue
+		}
+		var specBytes []byte
+		if specBytes, err = json.Marshal(resource.Spec); err != nil {
+			return
+		}
+
+		var name string
+		switch resource.Kind {
+		case KindImage:
+			var spec ImageSpec
+			if err = json.Unmarshal(specBytes, &spec); err != nil {
+				return
+			}
+			images = append(images, spec)
+			name = spec.Name
+		case KindNetwork:
+			var spec NetworkSpec
+			if err = json.Unmarshal(specBytes, &spec); err != nil {
+				return
+			}
+			networks = append(networks, spec)
+			name = spec.Name
+		case KindVm:
+			var spec VmSpec
+			if err = json.Unmarshal(specBytes, &spec); err != nil {
+				return
+			}
+			vms = append(vms, spec)
+			name = spec.Name
+		default:
+			err = errors.NewBadInputErrorf("invalid resource kind: kind=%s", resource.Kind)
+			return
+		}
+
+		key := resource.Kind + "/" + name
+		if names[key] {
+			err = errors.NewBadInputErrorf("duplicated resources are found: kind=%s, name=%s", resource.Kind, name)
+			return
+		}
+		names[key] = true
+	}
+	return
+}
+
+func (self *VirtController) getCurrentNetworkSpecs() (specs map[string]NetworkSpec, err error) {
+	var networks []Network
+	if err = self.sqlClient.DB.Table("networks").Select("*").Where("deleted_at IS NULL").Scan(&networks).Error; err != nil {
+		return
+	}
+	specs = map[string]NetworkSpec{}
+	for _, network := range networks {
+		spec := network.NetworkSpec
+		if spec.Spec, err = unmarshalSpecStr(network.SpecStr); err != nil {
+			return
+		}
+		specs[spec.Name] = spec
+	}
+	return
+}
+
+func (self *VirtController) getCurrentImageSpecs() (specs map[string]ImageSpec, err error) {
+	var images []Image
+	if err = self.sqlClient.DB.Table("images").Select("*").Where("deleted_at IS NULL").Scan(&images).Error; err != nil {
+		return
+	}
+	specs = map[string]ImageSpec{}
+	for _, image := range images {
+		spec := image.ImageSpec
+		if spec.Spec, err = unmarshalSpecStr(image.SpecStr); err != nil {
+			return
+		}
+		specs[spec.Name] = spec
+	}
+	return
+}
+
+// getCurrentVmSpecs は、DBからVmSpecを復元する
+// Image、Networksは、割り当てたイメージとポート、転送ポートから復元する
+func (self *VirtController) getCurrentVmSpecs(tctx *logger.TraceContext) (specs map[string]VmSpec, err error) {
+	var vmResources VmResources
+	if vmResources, err = self.GetVmResources(tctx, nil); err != nil {
+		return
+	}
+	var portForwards []PortForward
+	if err = self.sqlClient.DB.Table("port_forwards").Select("*").Order("id").Scan(&portForwards).Error; err != nil {
+		return
+	}
+
+	specs = map[string]VmSpec{}
+	for _, r := range vmResources {
+		vm := r.Spec
+		spec := vm.VmSpec
+		if spec.Spec, err = unmarshalSpecStr(vm.SpecStr); err != nil {
+			return
+		}
+		spec.Image = ImageDetectSpec{Name: vm.ImageName}
+		spec.Networks = nil
+		for _, port := range vm.NetworkPorts {
+			network := NetworkDetectSpec{Name: port.VmNetwork.Name}
+			for _, portForward := range portForwards {
+				if portForward.VmId == vm.Id && portForward.NetworkId == port.NetworkId {
+					network.PortForwards = append(network.PortForwards, PortForwardSpec{
+						Protocol: portForward.Protocol,
+						Port:     portForward.VmPort,
+					})
+				}
+			}
+			spec.Networks = append(spec.Networks, network)
+		}
+		specs[spec.Name] = spec
+	}
+	return
+}
+
+// normalizeVmSpec は、作成時に補われる値をspecに補い、DBから復元したspecと比較できるようにする
+func normalizeVmSpec(spec VmSpec, current *VmSpec) VmSpec {
+	// イメージの名前を省略した場合は、作成時に選ばれたイメージのままとする
+	if spec.Image.Name == "" && current != nil {
+		spec.Image.Name = current.Image.Name
+	}
+	networks := make([]NetworkDetectSpec, len(spec.Networks))
+	for i, network := range spec.Networks {
+		portForwards := make([]PortForwardSpec, len(network.PortForwards))
+		for j, portForward := range network.PortForwards {
+			if portForward.Protocol == "" {
+				portForward.Protocol = PortForwardProtocolTcp
+			}
+			portForwards[j] = portForward
+		}
+		network.PortForwards = portForwards
+		networks[i] = network
+	}
+	spec.Networks = networks
+	return spec
+}
+
+// newApplyPlanItem は、currentとdesiredを比較して操作と差分を決める
+// currentがnilの場合は作成、desiredがnilの場合は削除とする
+func newApplyPlanItem(kind string, name string, current interface{}, desired interface{}) (item ApplyPlanItem, err error) {
+	item = ApplyPlanItem{
+		Kind: kind,
+		Name: name,
+	}
+	var currentValue map[string]interface{}
+	var desiredValue map[string]interface{}
+	if current != nil {
+		if currentValue, err = toDiffValue(current); err != nil {
+			return
+		}
+	}
+	if desired != nil {
+		if desiredValue, err = toDiffValue(desired); err != nil {
+			return
+		}
+	}
+
+	switch {
+	case current == nil:
+		item.Action = ApplyActionCreate
+	case desired == nil:
+		item.Action = ApplyActionDelete
+	case reflect.DeepEqual(currentValue, desiredValue):
+		item.Action = ApplyActionUnchanged
+		return
+	default:
+		item.Action = ApplyActionUpdate
+		changedFields := []string{}
+		for _, field := range immutableFields[kind] {
+			if !reflect.DeepEqual(currentValue[field], desiredValue[field]) {
+				changedFields = append(changedFields, field)
+			}
+		}
+		if len(changedFields) > 0 {
+			err = errors.NewBadInputErrorf("immutable fields are changed: kind=%s, name=%s, fields=%s",
+				kind, name, strings.Join(changedFields, ","))
+			return
+		}
+	}
+
+	var currentLines []string
+	var desiredLines []string
+	if currentLines, err = toDiffLines(kind, currentValue); err != nil {
+		return
+	}
+	if desiredLines, err = toDiffLines(kind, desiredValue); err != nil {
+		return
+	}
+	item.Diff = str_utils.DiffLines(currentLines, desiredLines)
+	return
+}
+
+// toDiffValue は、比較と差分の表示のために、specをjsonを経由してmapに変換する
+// キーはファイルと同じく先頭を小文字とし、省略した場合と区別しないように空の値は除く
+func toDiffValue(spec interface{}) (value map[string]interface{}, err error) {
+	var specBytes []byte
+	if specBytes, err = json.Marshal(spec); err != nil {
+		return
+	}
+	var tmpValue interface{}
+	if err = json.Unmarshal(specBytes, &tmpValue); err != nil {
+		return
+	}
+	value, _ = compactDiffValue(tmpValue).(map[string]interface{})
+	return
+}
+
+func compactDiffValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		compacted := map[string]interface{}{}
+		for key, elem := range v {
+			elem = compactDiffValue(elem)
+			if isEmptyDiffValue(elem) || key == "" {
+				continue
+			}
+			compacted[strings.ToLower(key[:1])+key[1:]] = elem
+		}
+		return compacted
+	case []interface{}:
+		compacted := make([]interface{}, len(v))
+		for i, elem := range v {
+			compacted[i] = compactDiffValue(elem)
+		}
+		return compacted
+	}
+	return value
+}
+
+func isEmptyDiffValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// toDiffLines は、ファイルと同じ形式のyamlの行にする
+func toDiffLines(kind string, value map[string]interface{}) (lines []string, err error) {
+	if value == nil {
+		return
+	}
+	var yamlBytes []byte
+	if yamlBytes, err = yaml.Marshal(map[string]interface{}{
+		"kind": kind,
+		"spec": value,
+	}); err != nil {
+		return
+	}
+	lines = strings.Split(strings.TrimRight(string(yamlBytes), "\n"), "\n")
+	return
+}
+
+// unmarshalSpecStr は、保存したSpecStrをspecに戻す
+func unmarshalSpecStr(specStr string) (spec interface{}, err error) {
+	if specStr == "" {
+		return
+	}
+	err = json.Unmarshal([]byte(specStr), &spec)
+	return
+}
@@ -0,0 +1,113 @@
+package virt_utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/syunkitada/goapp2/pkg/lib/errors"
+	"github.com/syunkitada/goapp2/pkg/lib/logger"
+)
+
+func planActions(plan *ApplyPlan) (actions []string) {
+	for _, item := range plan.Items {
+		actions = append(actions, item.Action+" "+item.Kind+"/"+item.Name)
+	}
+	return
+}
+
+func TestPlanApply(t *testing.T) {
+	a := assert.New(t)
+	virtController := newTestVirtController(t)
+	tctx := logger.NewTraceContext()
+
+	// 適用済みのファイルは、変更なし
+	resources := readTestResources(t, "image_centos8.yaml", "network_local1.yaml", "vm_vm1.yaml")
+	plan, err := virtController.PlanApply(tctx, resources, true)
+	a.NoError(err)
+	a.Equal([]string{"unchanged network/local1", "unchanged image/centos8", "unchanged vm/vm1"}, planActions(plan))
+
+	// 変更したリソースは更新、追加したリソースは作成する
+	image2 := []byte(`kind: image
+spec:
+  name: centos9
+  namespace: default
+  kind: url
+  spec:
+    url: "https://example.com/centos9.qcow2"
+    pullPolicy: "IfNotPresent"
+`)
+	network := strings.Replace(string(resources[1]), "endIp: 192.168.100.254", "endIp: 192.168.100.200", 1)
+	vm := strings.Replace(string(resources[2]), "vcpus: 4", "vcpus: 2", 1)
+	resources = [][]byte{resources[0], image2, []byte(network), []byte(vm)}
+	plan, err = virtController.PlanApply(tctx, resources, false)
+	a.NoError(err)
+	a.Equal([]string{"update network/local1", "unchanged image/centos8", "create image/centos9", "update vm/vm1"}, planActions(plan))
+	a.Contains(plan.Items[0].Diff, "-     endIp: 192.168.100.254")
+	a.Contains(plan.Items[0].Diff, "+     endIp: 192.168.100.200")
+	a.Contains(plan.Items[2].Diff, "+     name: centos9")
+	formatted := plan.Format(false)
+	a.Contains(formatted, "update vm/vm1\n")
+	a.Contains(formatted, "  -     vcpus: 4\n")
+	a.Contains(formatted, "  +     vcpus: 2\n")
+	a.Contains(formatted, "Plan: 1 to create, 2 to update, 0 to delete, 1 unchanged")
+
+	a.NoError(virtController.Apply(tctx, plan))
+	savedVm, err := virtController.GetVm("vm1")
+	a.NoError(err)
+	a.Equal(uint(2), savedVm.Vcpus)
+	savedNetwork, err := virtController.GetNetwork("local1")
+	a.NoError(err)
+	a.Equal("192.168.100.200", savedNetwork.EndIp)
+	_, err = virtController.GetImage("centos9")
+	a.NoError(err)
+
+	plan, err = virtController.PlanApply(tctx, resources, false)
+	a.NoError(err)
+	for _, item := range plan.Items {
+		a.Equal(ApplyActionUnchanged, item.Action)
+	}
+
+	{
+		// 作成後に変更できないフィールド
+		vm := strings.Replace(string(resources[3]), "diskGb: 10", "diskGb: 20", 1)
+		_, err := virtController.PlanApply(tctx, [][]byte{[]byte(vm)}, false)
+		a.True(errors.IsBadInputError(err))
+		a.Contains(err.Error(), "fields=diskGb")
+	}
+
+	{
+		// 不明なkind
+		_, err := virtController.PlanApply(tctx, [][]byte{[]byte("kind: unknown\nspec:\n  name: foo\n")}, false)
+		a.True(errors.IsBadInputError(err))
+	}
+
+	{
+		// 同じリソースの重複
+		_, err := virtController.PlanApply(tctx, [][]byte{image2, image2}, false)
+		a.True(errors.IsBadInputError(err))
+	}
+
+	{
+		// VMが利用しているネットワークは、pruneで削除できない
+		_, err := virtController.PlanApply(tctx, [][]byte{resources[0], []byte(vm)}, true)
+		a.True(errors.IsConflictError(err))
+	}
+
+	// pruneしない場合は、ファイルにないリソースは削除しない
+	resources = [][]byte{resources[0], []byte(network)}
+	plan, err = virtController.PlanApply(tctx, resources, false)
+	a.NoError(err)
+	a.Equal([]string{"unchanged network/local1", "unchanged image/centos8"}, planActions(plan))
+
+	// pruneする場合は、VM、イメージ、ネットワークの順に削除する
+	plan, err = virtController.PlanApply(tctx, resources, true)
+	a.NoError(err)
+	a.Equal([]string{"unchanged network/local1", "unchanged image/centos8", "delete vm/vm1", "delete image/centos9"}, planActions(plan))
+	a.Contains(plan.Items[2].Diff, "-     name: vm1")
+	a.NoError(virtController.Apply(tctx, plan))
+	_, err = virtController.GetVm("vm1")
+	a.True(errors.IsNotFoundError(err))
+	_, err = virtController.GetImage("centos9")
+	a.True(errors.IsNotFoundError(err))
+}
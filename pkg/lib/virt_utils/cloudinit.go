@@ -0,0 +1,127 @@
+package virt_utils
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/syunkitada/goapp2/pkg/lib/logger"
+)
+
+const (
+	vmSeedFile = "seed.iso"
+	// NoCloudのシードは、このラベルのボリュームとして認識される
+	cloudInitVolumeId        = "cidata"
+	cloudInitDefaultUserData = "#cloud-config\n"
+)
+
+type cloudInitMetaData struct {
+	InstanceId    string `yaml:"instance-id"`
+	LocalHostname string `yaml:"local-hostname"`
+}
+
+// cloudInitNetworkConfig は、network-configのversion 2
+type cloudInitNetworkConfig struct {
+	Version   int                                 `yaml:"version"`
+	Ethernets map[string]cloudInitNetworkEthernet `yaml:"ethernets"`
+}
+
+type cloudInitNetworkEthernet struct {
+	Match       cloudInitNetworkMatch        `yaml:"match"`
+	SetName     string                       `yaml:"set-name"`
+	Addresses   []string                     `yaml:"addresses"`
+	Gateway4    string                       `yaml:"gateway4,omitempty"`
+	Nameservers *cloudInitNetworkNameservers `yaml:"nameservers,omitempty"`
+}
+
+type cloudInitNetworkMatch struct {
+	MacAddress string `yaml:"macaddress"`
+}
+
+type cloudInitNetworkNameservers struct {
+	Addresses []string `yaml:"addresses"`
+}
+
+// BuildCloudInitFiles は、VmからNoCloudのmeta-data, user-data, network-configを作成する
+func BuildCloudInitFiles(vm *Vm) (files []IsoFile, err error) {
+	metaData := cloudInitMetaData{
+		InstanceId:    vm.Namespace + "-" + vm.Name,
+		LocalHostname: vm.Name,
+	}
+	var metaDataBytes []byte
+	if metaDataBytes, err = yaml.Marshal(&metaData); err != nil {
+		return
+	}
+
+	userData := vm.UserData
+	if userData == "" {
+		userData = cloudInitDefaultUserData
+	}
+
+	// ポートの順にeth0, eth1とし、デフォルトゲートウェイは最初のポートのみに設定する
+	networkConfig := cloudInitNetworkConfig{
+		Version:   2,
+		Ethernets: map[string]cloudInitNetworkEthernet{},
+	}
+	for i, port := range vm.NetworkPorts {
+		if port.VmNetwork == nil {
+			err = fmt.Errorf("port's network is not found: vm=%s, ip=%s", vm.Name, port.Ip)
+			return
+		}
+		var ipNet *net.IPNet
+		if _, ipNet, err = net.ParseCIDR(port.Subnet); err != nil {
+			return
+		}
+		prefixLen, _ := ipNet.Mask.Size()
+
+		name := fmt.Sprintf("eth%d", i)
+		ethernet := cloudInitNetworkEthernet{
+			Match:     cloudInitNetworkMatch{MacAddress: port.Mac},
+			SetName:   name,
+			Addresses: []string{fmt.Sprintf("%s/%d", port.Ip, prefixLen)},
+		}
+		if i == 0 {
+			ethernet.Gateway4 = port.Gateway
+		}
+		// netns内のDNSサーバを利用し、ネットワークのResolversにはDNSサーバから転送する
+		ethernet.Nameservers = &cloudInitNetworkNameservers{
+			Addresses: []string{port.networkLocalSpec.ServiceIp()},
+		}
+		networkConfig.Ethernets[name] = ethernet
+	}
+	var networkConfigBytes []byte
+	if networkConfigBytes, err = yaml.Marshal(&networkConfig); err != nil {
+		return
+	}
+
+	files = []IsoFile{
+		{Name: "meta-data", Data: metaDataBytes},
+		{Name: "user-data", Data: []byte(userData)},
+		{Name: "network-config", Data: networkConfigBytes},
+	}
+	return
+}
+
+// PrepareSeeds は、VMごとにcloud-initのNoCloudのシードをvmsDir/[namespace]/[name]/seed.isoに作成する
+// specやポートの変更を反映するため、起動するたびに作り直す
+func (self *VirtController) PrepareSeeds(tctx *logger.TraceContext, vmResources VmResources) (err error) {
+	for i := range vmResources {
+		vm := &vmResources[i].Spec
+		hypervisorVm := self.newHypervisorVm(vm)
+
+		var files []IsoFile
+		if files, err = BuildCloudInitFiles(vm); err != nil {
+			return
+		}
+		if err = os.MkdirAll(hypervisorVm.Dir, 0755); err != nil {
+			return
+		}
+		if err = WriteIso(hypervisorVm.SeedPath, cloudInitVolumeId, files); err != nil {
+			return
+		}
+		logger.Infof(tctx, "created vm seed: vm=%s, path=%s", vm.Name, hypervisorVm.SeedPath)
+	}
+	return
+}
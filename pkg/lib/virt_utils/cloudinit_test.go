@@ -0,0 +1,115 @@
+package virt_utils
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/syunkitada/goapp2/pkg/lib/logger"
+)
+
+// readIsoFiles は、WriteIsoで作成したイメージのルートディレクトリのファイルを読み込む
+func readIsoFiles(t *testing.T, image []byte, joliet bool) (volumeId string, files map[string]string) {
+	a := assert.New(t)
+	sector := isoPrimaryVdSector
+	if joliet {
+		sector = isoJolietVdSector
+	}
+	vd := image[sector*isoSectorSize:]
+	a.Equal("CD001", string(vd[1:6]))
+	volumeId = decodeIsoString(vd[40:72], joliet)
+
+	rootSector := binary.LittleEndian.Uint32(vd[156+2:])
+	rootSize := binary.LittleEndian.Uint32(vd[156+10:])
+	dir := image[rootSector*isoSectorSize : rootSector*isoSectorSize+rootSize]
+	files = map[string]string{}
+	for offset := 0; offset < len(dir) && dir[offset] > 0; offset += int(dir[offset]) {
+		record := dir[offset:]
+		nameLen := int(record[32])
+		if record[25]&2 != 0 {
+			continue
+		}
+		name := decodeIsoString(record[33:33+nameLen], joliet)
+		fileSector := binary.LittleEndian.Uint32(record[2:])
+		fileSize := binary.LittleEndian.Uint32(record[10:])
+		a.Equal(fileSector, binary.BigEndian.Uint32(record[6:]))
+		files[name] = string(image[fileSector*isoSectorSize : fileSector*isoSectorSize+fileSize])
+	}
+	return
+}
+
+func decodeIsoString(buf []byte, joliet bool) string {
+	if !joliet {
+		return strings.TrimRight(string(buf), " ")
+	}
+	u := make([]uint16, len(buf)/2)
+	for i := range u {
+		u[i] = binary.BigEndian.Uint16(buf[i*2:])
+	}
+	return strings.TrimRight(string(utf16.Decode(u)), " ")
+}
+
+func TestPrepareSeeds(t *testing.T) {
+	a := assert.New(t)
+	virtController := newTestVirtController(t)
+	tctx := logger.NewTraceContext()
+
+	vmResources, err := virtController.GetVmResources(tctx, []string{"vm1"})
+	a.NoError(err)
+	a.NoError(virtController.PrepareSeeds(tctx, vmResources))
+
+	vm := &vmResources[0].Spec
+	hypervisorVm := virtController.newHypervisorVm(vm)
+	a.Equal(filepath.Join(hypervisorVm.Dir, "seed.iso"), hypervisorVm.SeedPath)
+	a.Contains(BuildQemuArgs(hypervisorVm), "file="+hypervisorVm.SeedPath+",format=raw,if=virtio,readonly=on")
+
+	image, err := ioutil.ReadFile(hypervisorVm.SeedPath)
+	a.NoError(err)
+	a.Equal(0, len(image)%isoSectorSize)
+
+	volumeId, files := readIsoFiles(t, image, true)
+	a.Equal("cidata", volumeId)
+	a.Equal("instance-id: group1-vm1\nlocal-hostname: vm1\n", files["meta-data"])
+	a.True(strings.HasPrefix(files["user-data"], "#cloud-config\nssh_pwauth: true\n"))
+	a.Equal(`version: 2
+ethernets:
+    eth0:
+        match:
+            macaddress: `+vm.NetworkPorts[0].Mac+`
+        set-name: eth0
+        addresses:
+            - 192.168.100.2/24
+        gateway4: 192.168.100.1
+        nameservers:
+            addresses:
+                - 169.254.1.200
+`, files["network-config"])
+
+	// プライマリボリューム記述子では、8.3形式のファイル名となる
+	volumeId, primaryFiles := readIsoFiles(t, image, false)
+	a.Equal("cidata", volumeId)
+	a.Equal(files["meta-data"], primaryFiles["META_DAT.;1"])
+	a.Equal(files["user-data"], primaryFiles["USER_DAT.;1"])
+	a.Equal(files["network-config"], primaryFiles["NETWORK_.;1"])
+
+	{
+		// user-dataが指定されていない場合
+		vm := *vm
+		vm.UserData = ""
+		files, err := BuildCloudInitFiles(&vm)
+		a.NoError(err)
+		a.Equal("user-data", files[1].Name)
+		a.Equal("#cloud-config\n", string(files[1].Data))
+	}
+
+	{
+		// 変換後のファイル名が重複する場合
+		a.Equal("META_DAT.;1", isoPrimaryName("meta-data", 0))
+		a.Equal("META_D_1.;1", isoPrimaryName("meta-data", 1))
+		a.Equal("README.TXT;1", isoPrimaryName("readme.txt", 0))
+	}
+}
@@ -3,4 +3,6 @@ package virt_utils
 const (
 	StatusRunning = "Running"
 	StatusCreated = "Created"
+	StatusStopped = "Stopped"
+	StatusDeleted = "Deleted"
 )
@@ -0,0 +1,68 @@
+package virt_utils
+
+import (
+	"encoding/json"
+	"net"
+
+	"github.com/syunkitada/goapp2/pkg/lib/dhcp_utils"
+	"github.com/syunkitada/goapp2/pkg/lib/errors"
+)
+
+const defaultMtu = 1500
+
+// dhcpPort は、DHCPで割り当てるポートとそのVM、ネットワークの情報
+type dhcpPort struct {
+	NetworkPort
+	VmName         string
+	Subnet         string
+	Gateway        string
+	NetworkSpecStr string
+}
+
+// GetDhcpLease は、netnsNameのnetnsに接続しているmacのポートのLeaseを返す
+// IPの割り当てはDBが正なので、問い合わせのたびにnetwork_portsを参照する
+// 割り当てがない場合は、nilを返す
+func (self *VirtController) GetDhcpLease(netnsName string, mac net.HardwareAddr) (lease *dhcp_utils.Lease, err error) {
+	var ports []dhcpPort
+	if err = self.sqlClient.DB.Table("network_ports AS p").
+		Select("p.*, v.name as vm_name, n.subnet as subnet, n.gateway as gateway, n.spec as network_spec_str").
+		Joins("INNER JOIN vms AS v ON p.vm_id = v.id").
+		Joins("INNER JOIN networks AS n ON p.network_id = n.id").
+		Where("v.deleted_at IS NULL").
+		Where("p.netns_name = ? AND p.mac = ?", netnsName, mac.String()).Scan(&ports).Error; err != nil {
+		return
+	}
+	if len(ports) == 0 {
+		return
+	}
+	if len(ports) > 1 {
+		err = errors.NewConflictErrorf("duplicated ports are found: netns=%s, mac=%s", netnsName, mac.String())
+		return
+	}
+	port := ports[0]
+
+	var ipNet *net.IPNet
+	if _, ipNet, err = net.ParseCIDR(port.Subnet); err != nil {
+		return
+	}
+	var networkLocalSpec NetworkLocalSpec
+	if err = json.Unmarshal([]byte(port.NetworkSpecStr), &networkLocalSpec); err != nil {
+		return
+	}
+
+	lease = &dhcp_utils.Lease{
+		Ip:       net.ParseIP(port.Ip).To4(),
+		Mask:     ipNet.Mask,
+		Router:   net.ParseIP(port.Gateway).To4(),
+		Mtu:      networkLocalSpec.Mtu,
+		Hostname: port.VmName,
+	}
+	if lease.Mtu == 0 {
+		lease.Mtu = defaultMtu
+	}
+	// ネットワークのResolversには、netns内のDNSサーバから転送する
+	if ip := net.ParseIP(networkLocalSpec.ServiceIp()).To4(); ip != nil {
+		lease.Dns = []net.IP{ip}
+	}
+	return
+}
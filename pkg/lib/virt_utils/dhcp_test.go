@@ -0,0 +1,55 @@
+package virt_utils
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/syunkitada/goapp2/pkg/lib/logger"
+)
+
+func TestGetDhcpLease(t *testing.T) {
+	a := assert.New(t)
+	virtController := newTestVirtController(t)
+	tctx := logger.NewTraceContext()
+
+	vmResources, err := virtController.GetVmResources(tctx, []string{"vm1"})
+	a.NoError(err)
+	port := vmResources[0].Spec.NetworkPorts[0]
+	a.NoError(virtController.sqlClient.DB.Table("network_ports").Where("vm_id = ?", port.VmId).
+		Updates(map[string]interface{}{"netns_name": "com-0"}).Error)
+	mac, err := net.ParseMAC(port.Mac)
+	a.NoError(err)
+
+	lease, err := virtController.GetDhcpLease("com-0", mac)
+	a.NoError(err)
+	a.Equal(port.Ip, lease.Ip.String())
+	a.Equal("ffffff00", lease.Mask.String())
+	a.Equal("192.168.100.1", lease.Router.String())
+	a.Equal([]net.IP{net.ParseIP("169.254.1.200").To4()}, lease.Dns)
+	a.Equal(1500, lease.Mtu)
+	a.Equal("vm1", lease.Hostname)
+
+	{
+		// 他のnetnsのポートには応答しない
+		lease, err := virtController.GetDhcpLease("com-1", mac)
+		a.NoError(err)
+		a.Nil(lease)
+	}
+
+	{
+		// 割り当てのないMACアドレス
+		unknownMac, _ := net.ParseMAC("02:00:00:00:00:00")
+		lease, err := virtController.GetDhcpLease("com-0", unknownMac)
+		a.NoError(err)
+		a.Nil(lease)
+	}
+
+	// 削除したVMのポートには応答しない
+	a.NoError(virtController.sqlClient.DB.Table("vms").Where("id = ?", port.VmId).
+		Updates(map[string]interface{}{"deleted_at": time.Now()}).Error)
+	lease, err = virtController.GetDhcpLease("com-0", mac)
+	a.NoError(err)
+	a.Nil(lease)
+}
@@ -0,0 +1,166 @@
+package virt_utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/syunkitada/goapp2/pkg/lib/errors"
+	"github.com/syunkitada/goapp2/pkg/lib/logger"
+)
+
+const gib = 1024 * 1024 * 1024
+
+// PrepareDisks は、VMごとにイメージをバッキングファイルとするqcow2のオーバーレイディスクを作成する
+// バッキングファイルは、PullPolicy: Alwaysでイメージが置き換えられても変わらないように、
+// イメージのハードリンク(imagePath-[sha256]のスナップショット)とする
+// ディスクはvmsDir/[namespace]/[name]/disk.qcow2に作成し、パスとサイズをvmsテーブルに保存する
+// 作成済みの場合は、既存のディスクをそのまま利用する
+func (self *VirtController) PrepareDisks(tctx *logger.TraceContext, vmResources VmResources) (err error) {
+	for i := range vmResources {
+		vm := &vmResources[i].Spec
+		hypervisorVm := self.newHypervisorVm(vm)
+		var diskBytes uint64
+		if _, tmpErr := os.Stat(hypervisorVm.DiskPath); tmpErr == nil {
+			if vm.DiskPath == hypervisorVm.DiskPath {
+				continue
+			}
+			// ディスクの作成後にvmsテーブルを更新できなかった場合は、作成済みのディスクを登録する
+			var image *Qcow2Image
+			if image, err = ReadQcow2Image(hypervisorVm.DiskPath); err != nil {
+				return
+			}
+			diskBytes = image.Header.Size
+		} else {
+			imagePath := filepath.Join(self.imagesDir, vm.ImageName)
+			var imageFormat string
+			var imageBytes uint64
+			if imageFormat, imageBytes, err = GetImageFormat(imagePath); err != nil {
+				return
+			}
+
+			// DiskGbが指定されていない場合は、イメージと同じサイズとする
+			diskBytes = uint64(vm.DiskGb) * gib
+			if diskBytes == 0 {
+				diskBytes = imageBytes
+			} else if diskBytes < imageBytes {
+				err = errors.NewBadInputErrorf("diskGb is smaller than image: vm=%s, diskGb=%d, imageBytes=%d",
+					vm.Name, vm.DiskGb, imageBytes)
+				return
+			}
+
+			// sha256を保存する前にダウンロードしたイメージは、ここで計算して保存する
+			if vm.ImageDigest == "" {
+				if vm.ImageDigest, err = sha256File(imagePath); err != nil {
+					return
+				}
+				if err = self.updateImageDigest(vm.ImageId, vm.ImageDigest); err != nil {
+					return
+				}
+				for j := range vmResources {
+					if vmResources[j].Spec.ImageId == vm.ImageId {
+						vmResources[j].Spec.ImageDigest = vm.ImageDigest
+					}
+				}
+			}
+			backingPath := imagePath + "-" + vm.ImageDigest
+			if err = linkImageSnapshot(imagePath, backingPath); err != nil {
+				return
+			}
+			if err = os.MkdirAll(hypervisorVm.Dir, 0755); err != nil {
+				return
+			}
+			if err = CreateQcow2Overlay(hypervisorVm.DiskPath, backingPath, imageFormat, diskBytes); err != nil {
+				return
+			}
+			logger.Infof(tctx, "created vm disk: vm=%s, path=%s, backing=%s, bytes=%d",
+				vm.Name, hypervisorVm.DiskPath, backingPath, diskBytes)
+		}
+
+		if err = self.sqlClient.DB.Table("vms").Where("id = ?", vm.Id).Updates(map[string]interface{}{
+			"disk_path":  hypervisorVm.DiskPath,
+			"disk_bytes": diskBytes,
+		}).Error; err != nil {
+			return
+		}
+		vm.DiskPath = hypervisorVm.DiskPath
+		vm.DiskBytes = diskBytes
+	}
+	return
+}
+
+// linkImageSnapshot は、imagePathのハードリンクをsnapshotPathに作成する
+// ダウンロードしたイメージはリネームで置き換えるので、リンク先の内容は変わらない
+func linkImageSnapshot(imagePath string, snapshotPath string) (err error) {
+	if _, tmpErr := os.Stat(snapshotPath); tmpErr == nil {
+		return
+	}
+	if err = os.Link(imagePath, snapshotPath); err != nil && os.IsExist(err) {
+		err = nil
+	}
+	return
+}
+
+func sha256File(path string) (digest string, err error) {
+	var file *os.File
+	if file, err = os.Open(path); err != nil {
+		return
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return
+	}
+	digest = hex.EncodeToString(hash.Sum(nil))
+	return
+}
+
+// imagePath-[sha256]
+var imageSnapshotRegexp = regexp.MustCompile(`-[0-9a-f]{64}$`)
+
+// cleanupImageSnapshots は、削除されていないVMのディスクから参照されていないスナップショットを削除する
+func (self *VirtController) cleanupImageSnapshots(tctx *logger.TraceContext) (err error) {
+	var fileInfos []os.FileInfo
+	if fileInfos, err = ioutil.ReadDir(self.imagesDir); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	var vms []Vm
+	if err = self.sqlClient.DB.Table("vms").Select("*").Where("deleted_at IS NULL").Where("disk_path != ''").Scan(&vms).Error; err != nil {
+		return
+	}
+	referencedSet := map[string]bool{}
+	for _, vm := range vms {
+		image, tmpErr := ReadQcow2Image(vm.DiskPath)
+		if tmpErr != nil {
+			if os.IsNotExist(tmpErr) {
+				continue
+			}
+			// 参照しているスナップショットが分からないので、削除しない
+			logger.Warnf(tctx, "Failed read vm disk, skip cleanup image snapshots: vm=%s, path=%s, err=%s",
+				vm.Name, vm.DiskPath, tmpErr.Error())
+			return
+		}
+		referencedSet[image.BackingFile] = true
+	}
+
+	for _, fileInfo := range fileInfos {
+		snapshotPath := filepath.Join(self.imagesDir, fileInfo.Name())
+		if !imageSnapshotRegexp.MatchString(fileInfo.Name()) || referencedSet[snapshotPath] {
+			continue
+		}
+		if err = os.Remove(snapshotPath); err != nil && !os.IsNotExist(err) {
+			return
+		}
+		err = nil
+		logger.Infof(tctx, "deleted image snapshot: path=%s", snapshotPath)
+	}
+	return
+}
@@ -0,0 +1,43 @@
+package virt_utils
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+type DnsConfig struct {
+	// VMの名前は、[vm].[namespace].[Suffix]で解決する
+	Suffix string        `json:",omitempty"`
+	Ttl    time.Duration `json:",omitempty"`
+}
+
+type dnsRecordPort struct {
+	Ip        string
+	VmName    string
+	Namespace string
+}
+
+// GetDnsRecords は、削除されていないVMの名前とポートのIPのレコードを返す
+// キーは[vm].[namespace].[suffix]とし、ポートが複数ある場合は全てのIPを返す
+func (self *VirtController) GetDnsRecords() (records map[string][]net.IP, err error) {
+	var ports []dnsRecordPort
+	if err = self.sqlClient.DB.Table("network_ports AS p").
+		Select("p.ip, v.name as vm_name, v.namespace as namespace").
+		Joins("INNER JOIN vms AS v ON p.vm_id = v.id").
+		Where("v.deleted_at IS NULL").Order("p.network_id, p.ip").Scan(&ports).Error; err != nil {
+		return
+	}
+
+	records = map[string][]net.IP{}
+	suffix := strings.Trim(self.conf.Dns.Suffix, ".")
+	for _, port := range ports {
+		ip := net.ParseIP(port.Ip)
+		if ip == nil {
+			continue
+		}
+		name := strings.ToLower(port.VmName + "." + port.Namespace + "." + suffix)
+		records[name] = append(records[name], ip)
+	}
+	return
+}
@@ -0,0 +1,33 @@
+package virt_utils
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/syunkitada/goapp2/pkg/lib/logger"
+)
+
+func TestGetDnsRecords(t *testing.T) {
+	a := assert.New(t)
+	virtController := newTestVirtController(t)
+	tctx := logger.NewTraceContext()
+
+	vmResources, err := virtController.GetVmResources(tctx, []string{"vm1"})
+	a.NoError(err)
+	port := vmResources[0].Spec.NetworkPorts[0]
+
+	records, err := virtController.GetDnsRecords()
+	a.NoError(err)
+	a.Equal(map[string][]net.IP{
+		"vm1.group1.vm.internal": {net.ParseIP(port.Ip)},
+	}, records)
+
+	// 削除したVMのレコードは返さない
+	a.NoError(virtController.sqlClient.DB.Table("vms").Where("id = ?", port.VmId).
+		Updates(map[string]interface{}{"deleted_at": time.Now()}).Error)
+	records, err = virtController.GetDnsRecords()
+	a.NoError(err)
+	a.Empty(records)
+}
@@ -0,0 +1,302 @@
+package virt_utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/syunkitada/goapp2/pkg/lib/errors"
+	"github.com/syunkitada/goapp2/pkg/lib/logger"
+	"github.com/syunkitada/goapp2/pkg/lib/netlink_utils"
+)
+
+const (
+	HypervisorDriverQemu = "qemu"
+	// KVMのない環境でライフサイクルを確認するためのドライバ
+	// qemuの代わりにfakeQemuScriptを起動する
+	HypervisorDriverFake = "fake"
+)
+
+const (
+	vmDiskFile     = "disk.qcow2"
+	vmPidFile      = "qemu.pid"
+	vmLogFile      = "qemu.log"
+	vmQmpSocket    = "qmp.sock"
+	vmSerialSocket = "serial.sock"
+)
+
+type HypervisorConfig struct {
+	Driver   string `json:",omitempty"` // qemu(デフォルト), fake
+	QemuPath string `json:",omitempty"`
+	// 起動直後にプロセスが終了していないかを確認する時間
+	StartCheckDuration time.Duration `json:",omitempty"`
+	// Stopで、SIGTERMを送ってからSIGKILLを送るまでの時間
+	StopTimeout time.Duration `json:",omitempty"`
+}
+
+// HypervisorDriver は、VMのプロセスを起動、停止するドライバ
+type HypervisorDriver interface {
+	Start(tctx *logger.TraceContext, vm *HypervisorVm) (err error)
+	Stop(tctx *logger.TraceContext, vm *HypervisorVm) (err error)
+	// Status は、StatusRunningかStatusStoppedを返す
+	Status(tctx *logger.TraceContext, vm *HypervisorVm) (status string, err error)
+}
+
+// HypervisorVm は、ドライバに渡すVMの情報
+type HypervisorVm struct {
+	*Vm
+	Dir      string // pidファイルやログなどを置くVMごとのディレクトリ
+	DiskPath string
+	SeedPath string // cloud-initのシード、空の場合は接続しない
+}
+
+// fakeQemuScript は、qemuと同じ引数を受け取ってSIGTERMまで待つだけのスクリプト
+const fakeQemuScript = `#!/bin/sh
+echo "fake-qemu $@"
+trap 'echo "fake-qemu stopped"; exit 0' TERM INT
+while :; do
+  sleep 1
+done
+`
+
+func NewHypervisorDriver(conf *HypervisorConfig, varDir string) (driver HypervisorDriver, err error) {
+	processDriver := &qemuProcessDriver{
+		qemuPath:           conf.QemuPath,
+		startCheckDuration: conf.StartCheckDuration,
+		stopTimeout:        conf.StopTimeout,
+	}
+	if processDriver.startCheckDuration == 0 {
+		processDriver.startCheckDuration = 500 * time.Millisecond
+	}
+	if processDriver.stopTimeout == 0 {
+		processDriver.stopTimeout = 30 * time.Second
+	}
+
+	switch conf.Driver {
+	case "", HypervisorDriverQemu:
+		if processDriver.qemuPath == "" {
+			processDriver.qemuPath = "qemu-system-x86_64"
+		}
+	case HypervisorDriverFake:
+		// スクリプトは、VarDirに書き出してから利用する
+		processDriver.qemuPath = filepath.Join(varDir, "bin", "fake-qemu")
+		if err = os.MkdirAll(filepath.Dir(processDriver.qemuPath), 0755); err != nil {
+			return
+		}
+		if err = ioutil.WriteFile(processDriver.qemuPath, []byte(fakeQemuScript), 0755); err != nil {
+			return
+		}
+	default:
+		err = errors.NewBadInputErrorf("invalid hypervisor driver: driver=%s", conf.Driver)
+		return
+	}
+	driver = processDriver
+	return
+}
+
+// qemuProcessDriver は、qemuをセッションリーダーとして起動し、pidファイルで管理する
+// node-ctlが終了してもVMは動き続け、Statusではpidファイルと/proc/[pid]/cmdlineで生存を確認する
+type qemuProcessDriver struct {
+	qemuPath           string
+	startCheckDuration time.Duration
+	stopTimeout        time.Duration
+}
+
+// BuildQemuArgs は、Vmからqemuの引数を組み立てる
+func BuildQemuArgs(vm *HypervisorVm) (args []string) {
+	args = []string{
+		"-name", vm.Name,
+		"-machine", "q35,accel=kvm",
+		"-cpu", "host",
+		"-smp", strconv.Itoa(int(vm.Vcpus)),
+		"-m", strconv.Itoa(int(vm.MemoryMb)),
+		"-display", "none",
+		"-serial", "unix:" + filepath.Join(vm.Dir, vmSerialSocket) + ",server,nowait",
+		"-qmp", "unix:" + filepath.Join(vm.Dir, vmQmpSocket) + ",server,nowait",
+		"-drive", "file=" + vm.DiskPath + ",format=qcow2,if=virtio,cache=none",
+	}
+	if vm.SeedPath != "" {
+		args = append(args, "-drive", "file="+vm.SeedPath+",format=raw,if=virtio,readonly=on")
+	}
+	// netns内のtapは、qemuのnetnsからはifnameで参照できないので、Startで開いたfdを渡す
+	// fdは、ExtraFilesの順に3から割り当てられる
+	tapFd := 3
+	for i, port := range vm.NetworkPorts {
+		netdevId := "net" + strconv.Itoa(i)
+		netdev := "tap,id=" + netdevId + ",ifname=" + port.TapName + ",script=no,downscript=no"
+		if port.NetnsName != "" {
+			netdev = "tap,id=" + netdevId + ",fd=" + strconv.Itoa(tapFd)
+			tapFd++
+		}
+		args = append(args,
+			"-netdev", netdev,
+			"-device", "virtio-net-pci,netdev="+netdevId+",mac="+port.Mac,
+		)
+	}
+	return
+}
+
+// openTaps は、netns内のtapを開く、BuildQemuArgsのfdと同じ順に返す
+func openTaps(vm *HypervisorVm) (files []*os.File, err error) {
+	defer func() {
+		if err != nil {
+			for _, file := range files {
+				file.Close()
+			}
+			files = nil
+		}
+	}()
+	for _, port := range vm.NetworkPorts {
+		if port.NetnsName == "" {
+			continue
+		}
+		var handle *netlink_utils.Handle
+		if handle, err = netlink_utils.NewHandle(port.NetnsName); err != nil {
+			return
+		}
+		var file *os.File
+		file, err = handle.OpenTap(port.TapName)
+		handle.Close()
+		if err != nil {
+			return
+		}
+		files = append(files, file)
+	}
+	return
+}
+
+func (self *qemuProcessDriver) Start(tctx *logger.TraceContext, vm *HypervisorVm) (err error) {
+	if err = os.MkdirAll(vm.Dir, 0755); err != nil {
+		return
+	}
+
+	var logFile *os.File
+	if logFile, err = os.OpenFile(filepath.Join(vm.Dir, vmLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return
+	}
+	defer logFile.Close()
+
+	var tapFiles []*os.File
+	if tapFiles, err = openTaps(vm); err != nil {
+		return
+	}
+	// 子プロセスに複製されるので、起動後は閉じてよい
+	defer func() {
+		for _, file := range tapFiles {
+			file.Close()
+		}
+	}()
+
+	cmd := exec.Command(self.qemuPath, BuildQemuArgs(vm)...)
+	cmd.ExtraFiles = tapFiles
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	// node-ctlのシグナルを受けないように、別のセッションで起動する
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err = cmd.Start(); err != nil {
+		return
+	}
+	logger.Infof(tctx, "started vm process: name=%s, pid=%d", vm.Name, cmd.Process.Pid)
+
+	if err = ioutil.WriteFile(filepath.Join(vm.Dir, vmPidFile), []byte(strconv.Itoa(cmd.Process.Pid)), 0644); err != nil {
+		return
+	}
+
+	// 引数の誤りやKVMがないなどで、すぐに終了していないかを確認する
+	// 終了したプロセスがゾンビとして残らないように、Waitは常に行う
+	doneCh := make(chan error, 1)
+	go func() {
+		doneCh <- cmd.Wait()
+	}()
+	select {
+	case waitErr := <-doneCh:
+		err = fmt.Errorf("vm process exited: name=%s, err=%v, log=%s", vm.Name, waitErr, filepath.Join(vm.Dir, vmLogFile))
+	case <-time.After(self.startCheckDuration):
+	}
+	return
+}
+
+func (self *qemuProcessDriver) Stop(tctx *logger.TraceContext, vm *HypervisorVm) (err error) {
+	var pid int
+	if pid, err = self.getRunningPid(vm); err != nil {
+		return
+	}
+	if pid == 0 {
+		os.Remove(filepath.Join(vm.Dir, vmPidFile))
+		return
+	}
+
+	// qemuはSIGTERMで終了する、セッションごとシグナルを送る
+	if err = syscall.Kill(-pid, syscall.SIGTERM); err != nil {
+		return
+	}
+	stopAt := time.Now().Add(self.stopTimeout)
+	for time.Now().Before(stopAt) {
+		time.Sleep(100 * time.Millisecond)
+		if pid, err = self.getRunningPid(vm); err != nil || pid == 0 {
+			break
+		}
+	}
+	if err != nil {
+		return
+	}
+	if pid != 0 {
+		logger.Warnf(tctx, "vm process is not stopped by SIGTERM, send SIGKILL: name=%s, pid=%d", vm.Name, pid)
+		if err = syscall.Kill(-pid, syscall.SIGKILL); err != nil {
+			return
+		}
+	}
+	logger.Infof(tctx, "stopped vm process: name=%s", vm.Name)
+	os.Remove(filepath.Join(vm.Dir, vmPidFile))
+	return
+}
+
+func (self *qemuProcessDriver) Status(tctx *logger.TraceContext, vm *HypervisorVm) (status string, err error) {
+	var pid int
+	if pid, err = self.getRunningPid(vm); err != nil {
+		return
+	}
+	if pid == 0 {
+		status = StatusStopped
+	} else {
+		status = StatusRunning
+	}
+	return
+}
+
+// getRunningPid は、pidファイルのプロセスがこのVMのものとして動いていればpidを返し、そうでなければ0を返す
+// pidが再利用されている場合もあるので、cmdlineの-nameで確認する
+func (self *qemuProcessDriver) getRunningPid(vm *HypervisorVm) (pid int, err error) {
+	tmpBytes, tmpErr := ioutil.ReadFile(filepath.Join(vm.Dir, vmPidFile))
+	if tmpErr != nil {
+		if !os.IsNotExist(tmpErr) {
+			err = tmpErr
+		}
+		return
+	}
+	tmpPid, tmpErr := strconv.Atoi(strings.TrimSpace(string(tmpBytes)))
+	if tmpErr != nil {
+		err = fmt.Errorf("Invalid pidfile: path=%s", filepath.Join(vm.Dir, vmPidFile))
+		return
+	}
+
+	// 終了してゾンビになったプロセスのcmdlineは空となる
+	cmdlineBytes, tmpErr := ioutil.ReadFile("/proc/" + strconv.Itoa(tmpPid) + "/cmdline")
+	if tmpErr != nil {
+		return
+	}
+	cmds := strings.Split(string(cmdlineBytes), "\x00")
+	for i := 0; i < len(cmds)-1; i++ {
+		if cmds[i] == "-name" && cmds[i+1] == vm.Name {
+			pid = tmpPid
+			return
+		}
+	}
+	return
+}
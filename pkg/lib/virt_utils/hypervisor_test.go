@@ -0,0 +1,132 @@
+package virt_utils
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/syunkitada/goapp2/pkg/lib/db_utils"
+	"github.com/syunkitada/goapp2/pkg/lib/logger"
+)
+
+func newTestVirtController(t *testing.T) *VirtController {
+	a := assert.New(t)
+	logger.Init(&logger.Config{})
+	tctx := logger.NewTraceContext()
+	tmpDir := t.TempDir()
+
+	virtController := NewVirtContoller(&VirtControllerConfig{
+		VarDir: tmpDir,
+		Database: db_utils.Config{
+			Connection: filepath.Join(tmpDir, "virt.db"),
+		},
+		Hypervisor: HypervisorConfig{
+			Driver:      HypervisorDriverFake,
+			StopTimeout: 3 * time.Second,
+		},
+	})
+	virtController.MustInit()
+	// nftは実行せず、ルールのファイルを書き出すのみとする
+	virtController.applyNftables = func(tctx *logger.TraceContext, path string) error { return nil }
+	a.NoError(virtController.BootstrapImage(tctx))
+	a.NoError(virtController.BootstrapNetwork(tctx))
+	a.NoError(virtController.BootstrapVm(tctx))
+
+	resources := readTestResources(t, "image_centos8.yaml", "network_local1.yaml", "vm_vm1.yaml")
+	a.NoError(applyTestResources(tctx, virtController, resources))
+	return virtController
+}
+
+func readTestResources(t *testing.T, files ...string) (resources [][]byte) {
+	a := assert.New(t)
+	for _, file := range files {
+		tmpBytes, err := ioutil.ReadFile(filepath.Join("testdata", file))
+		a.NoError(err)
+		resources = append(resources, tmpBytes)
+	}
+	return
+}
+
+// applyTestResources は、pruneせずにresourcesを適用する
+func applyTestResources(tctx *logger.TraceContext, virtController *VirtController, resources [][]byte) (err error) {
+	var plan *ApplyPlan
+	if plan, err = virtController.PlanApply(tctx, resources, false); err != nil {
+		return
+	}
+	err = virtController.Apply(tctx, plan)
+	return
+}
+
+func TestHypervisorFakeDriver(t *testing.T) {
+	a := assert.New(t)
+	tctx := logger.NewTraceContext()
+	virtController := newTestVirtController(t)
+
+	vmResources, err := virtController.GetVmResources(tctx, []string{"vm1"})
+	a.NoError(err)
+	a.Len(vmResources, 1)
+	vm := &vmResources[0].Spec
+	a.Equal(StatusCreated, vm.Status)
+	vm.NetworkPorts[0].TapName = "com-0-tap"
+
+	// Vmからqemuの引数を組み立てる
+	hypervisorVm := virtController.newHypervisorVm(vm)
+	args := BuildQemuArgs(hypervisorVm)
+	a.Equal([]string{"-name", "vm1"}, args[0:2])
+	a.Contains(args, "4")
+	a.Contains(args, "4096")
+	a.Contains(args, "tap,id=net0,ifname=com-0-tap,script=no,downscript=no")
+	a.Contains(args, "virtio-net-pci,netdev=net0,mac="+vm.NetworkPorts[0].Mac)
+
+	{
+		// netns内のtapは、fdで渡す
+		netnsVm := *hypervisorVm
+		netnsVm.Vm = &Vm{VmSpec: vm.VmSpec, NetworkPorts: []VmNetworkPort{vm.NetworkPorts[0]}}
+		netnsVm.NetworkPorts[0].NetnsName = "com-0"
+		a.Contains(BuildQemuArgs(&netnsVm), "tap,id=net0,fd=3")
+	}
+
+	// 起動するとRunningとなり、vmsテーブルにも保存される
+	a.NoError(virtController.startVm(tctx, vm))
+	a.Equal(StatusRunning, vm.Status)
+	savedVm, err := virtController.GetVm("vm1")
+	a.NoError(err)
+	a.Equal(StatusRunning, savedVm.Status)
+
+	hypervisor, err := virtController.getHypervisor()
+	a.NoError(err)
+	status, err := hypervisor.Status(tctx, hypervisorVm)
+	a.NoError(err)
+	a.Equal(StatusRunning, status)
+	pidBytes, err := ioutil.ReadFile(filepath.Join(hypervisorVm.Dir, vmPidFile))
+	a.NoError(err)
+
+	// 起動済みの場合は、新たにプロセスを起動しない
+	a.NoError(virtController.startVm(tctx, vm))
+	pidBytes2, err := ioutil.ReadFile(filepath.Join(hypervisorVm.Dir, vmPidFile))
+	a.NoError(err)
+	a.Equal(pidBytes, pidBytes2)
+
+	a.NoError(hypervisor.Stop(tctx, hypervisorVm))
+	status, err = hypervisor.Status(tctx, hypervisorVm)
+	a.NoError(err)
+	a.Equal(StatusStopped, status)
+
+	{
+		// 起動直後に終了した場合はエラーとなる
+		hypervisor, err := NewHypervisorDriver(&HypervisorConfig{QemuPath: "/bin/false"}, t.TempDir())
+		a.NoError(err)
+		a.Error(hypervisor.Start(tctx, hypervisorVm))
+		status, err := hypervisor.Status(tctx, hypervisorVm)
+		a.NoError(err)
+		a.Equal(StatusStopped, status)
+	}
+
+	{
+		// 不正なドライバ
+		_, err := NewHypervisorDriver(&HypervisorConfig{Driver: "xen"}, t.TempDir())
+		a.Error(err)
+	}
+}
@@ -2,9 +2,9 @@ package virt_utils
 
 import (
 	"encoding/json"
-	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/jinzhu/gorm"
@@ -47,6 +47,7 @@ type Image struct {
 	Id        uint       `gorm:"not null;primaryKey;autoIncrement;"`
 	DeletedAt *time.Time `gorm:"uniqueIndex:udx_name;"`
 	SpecStr   string     `gorm:"not null;column:spec" json:"-"`
+	Digest    string     `gorm:"not null;default:''"` // ダウンロードしたイメージのsha256、スナップショット(imagePath-[sha256])の名前に利用する
 }
 
 type VmImage struct {
@@ -55,12 +56,15 @@ type VmImage struct {
 	ImageNamespace string `gorm:"-" json:"-"`
 	ImageKind      string `gorm:"-" json:"-"`
 	ImageSpecStr   string `gorm:"-" json:"-"`
+	ImageDigest    string `gorm:"-" json:"-"`
 	imageUrlSpec   ImageUrlSpec
 }
 
 type ImageUrlSpec struct {
 	Url        string `gorm:"not null;" validate:"required"`
-	PullPolicy string `gorm:"not null;" validate:"required,oneof=IfNotPresent"`
+	PullPolicy string `gorm:"not null;" validate:"required,oneof=IfNotPresent Always Never"`
+	Sha256     string `gorm:"-" validate:"omitempty,hexadecimal,len=64"`
+	Sha512     string `gorm:"-" validate:"omitempty,hexadecimal,len=128"`
 }
 
 const (
@@ -75,26 +79,8 @@ func (self *VirtController) BootstrapImage(tctx *logger.TraceContext) (err error
 }
 
 func (self *VirtController) CreateOrUpdateImage(tctx *logger.TraceContext, spec *ImageSpec) (err error) {
-	if err = self.validate.Struct(spec); err != nil {
-		return
-	}
-
 	var specBytes []byte
-	if specBytes, err = json.Marshal(spec.Spec); err != nil {
-		return
-	}
-
-	var imageUrlSpec ImageUrlSpec
-	switch spec.Kind {
-	case KindImageUrl:
-		if err = json.Unmarshal(specBytes, &imageUrlSpec); err != nil {
-			return
-		}
-		if err = self.validate.Struct(imageUrlSpec); err != nil {
-			return
-		}
-	default:
-		err = errors.NewBadInputErrorf("invalid image kind: kind=%s", spec.Kind)
+	if specBytes, err = self.validateImageSpec(spec); err != nil {
 		return
 	}
 
@@ -114,7 +100,7 @@ func (self *VirtController) CreateOrUpdateImage(tctx *logger.TraceContext, spec
 		}
 		return
 	} else {
-		if string(specBytes) != image.Spec {
+		if string(specBytes) != image.SpecStr {
 			if err = self.sqlClient.DB.Table("images").Where("id = ?", image.Id).Updates(map[string]interface{}{
 				"spec": string(specBytes),
 			}).Error; err != nil {
@@ -125,9 +111,35 @@ func (self *VirtController) CreateOrUpdateImage(tctx *logger.TraceContext, spec
 	return
 }
 
+// validateImageSpec は、specを検証し、保存するSpecStrを返す
+func (self *VirtController) validateImageSpec(spec *ImageSpec) (specBytes []byte, err error) {
+	if err = self.validate.Struct(spec); err != nil {
+		return
+	}
+
+	if specBytes, err = json.Marshal(spec.Spec); err != nil {
+		return
+	}
+
+	var imageUrlSpec ImageUrlSpec
+	switch spec.Kind {
+	case KindImageUrl:
+		if err = json.Unmarshal(specBytes, &imageUrlSpec); err != nil {
+			return
+		}
+		if err = self.validate.Struct(imageUrlSpec); err != nil {
+			return
+		}
+	default:
+		err = errors.NewBadInputErrorf("invalid image kind: kind=%s", spec.Kind)
+		return
+	}
+	return
+}
+
 func (self *VirtController) GetImage(name string) (image *Image, err error) {
 	var images []Image
-	sql := self.sqlClient.DB.Table("images").Select("*").Where("deleted_at IS NULL")
+	sql := self.sqlClient.DB.Table("images").Select("*").Where("deleted_at IS NULL").Where("name = ?", name)
 	if err = sql.Scan(&images).Error; err != nil {
 		return
 	}
@@ -182,15 +194,94 @@ func (self *VirtController) DetectImage(tctx *logger.TraceContext, tx *gorm.DB,
 	return
 }
 
+// PrepareImages は、VMが利用するイメージをimagesDirにダウンロードする
+// ダウンロードした場合は、イメージのsha256をimagesテーブルに保存する
 func (self *VirtController) PrepareImages(tctx *logger.TraceContext, vmResources VmResources) (err error) {
-	// namespaceImageMap := map[string]map[string]VmImage{}
-	fmt.Println("prepre images")
-	for _, vm := range vmResources {
-		imagePath := filepath.Join(self.imagesDir, vm.Spec.ImageName)
-		if _, err := os.Stat(imagePath); os.IsNotExist(err) {
-			fmt.Println("download", imagePath)
+	downloader := NewImageDownloader(os.Stdout)
+	preparedDigests := map[string]string{}
+	for i := range vmResources {
+		vm := &vmResources[i].Spec
+		if digest, ok := preparedDigests[vm.ImageName]; ok {
+			if digest != "" {
+				vm.ImageDigest = digest
+			}
+			continue
+		}
+		imagePath := filepath.Join(self.imagesDir, vm.ImageName)
+		var digest string
+		switch vm.ImageKind {
+		case KindImageUrl:
+			if digest, err = downloader.Pull(tctx, &vm.imageUrlSpec, imagePath); err != nil {
+				return
+			}
+		default:
+			err = errors.NewBadInputErrorf("invalid image kind: kind=%s", vm.ImageKind)
+			return
+		}
+		if digest != "" {
+			if err = self.updateImageDigest(vm.ImageId, digest); err != nil {
+				return
+			}
+			vm.ImageDigest = digest
+		}
+		preparedDigests[vm.ImageName] = digest
+	}
+	return
+}
+
+func (self *VirtController) updateImageDigest(imageId uint, digest string) (err error) {
+	err = self.sqlClient.DB.Table("images").Where("id = ?", imageId).Updates(map[string]interface{}{
+		"digest": digest,
+	}).Error
+	return
+}
+
+// DeleteImageResources は、イメージを論理削除する
+// 削除されていないVMが利用しているイメージは削除できない
+func (self *VirtController) DeleteImageResources(tctx *logger.TraceContext, names []string) (imageResources ImageResources, err error) {
+	if len(names) == 0 {
+		err = errors.NewBadInputErrorf("image names are required")
+		return
+	}
+	if imageResources, err = self.GetImageResources(tctx, names); err != nil {
+		return
+	}
+	foundNames := []string{}
+	for _, r := range imageResources {
+		foundNames = append(foundNames, r.Spec.Name)
+	}
+	if err = checkNotFoundNames(KindImage, names, foundNames); err != nil {
+		return
+	}
+
+	for _, r := range imageResources {
+		image := r.Spec
+		err = self.sqlClient.Transact(tctx, func(tx *gorm.DB) (err error) {
+			var vms []Vm
+			if err = tx.Table("vms").Select("name").Where("deleted_at IS NULL").Where("image_id = ?", image.Id).Scan(&vms).Error; err != nil {
+				return
+			}
+			if len(vms) > 0 {
+				vmNames := []string{}
+				for _, vm := range vms {
+					vmNames = append(vmNames, vm.Name)
+				}
+				err = errors.NewConflictErrorf("image is used by vms: name=%s, vms=%s", image.Name, strings.Join(vmNames, ","))
+				return
+			}
+			if err = tx.Table("images").Where("id = ?", image.Id).Updates(map[string]interface{}{
+				"deleted_at": time.Now(),
+			}).Error; err != nil {
+				return
+			}
+			return
+		})
+		if err != nil {
+			return
 		}
+		logger.Infof(tctx, "deleted image: name=%s", image.Name)
 	}
-	// TODO prepare image and set vmResources
+
+	err = self.cleanupImageSnapshots(tctx)
 	return
 }
@@ -0,0 +1,327 @@
+package virt_utils
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/syunkitada/goapp2/pkg/lib/errors"
+	"github.com/syunkitada/goapp2/pkg/lib/logger"
+)
+
+const (
+	PullPolicyIfNotPresent = "IfNotPresent"
+	PullPolicyAlways       = "Always"
+	PullPolicyNever        = "Never"
+)
+
+// ダウンロード中のファイルの拡張子、完了してチェックサムを確認してからリネームする
+const imageDownloadingSuffix = ".part"
+
+// .partをダウンロードしたときのETag、またはLast-Modifiedを保存するファイルの拡張子
+// 再開時にIf-Rangeで送り、サーバ上のイメージが変わっていれば最初からダウンロードし直す
+const imageValidatorSuffix = ".part.validator"
+
+const (
+	// 接続とレスポンスヘッダのタイムアウト、応答しないミラーで止まり続けないようにする
+	// (ボディの転送は、イメージのサイズによるのでタイムアウトしない)
+	ImageDownloadConnectTimeout        = 30 * time.Second
+	ImageDownloadResponseHeaderTimeout = 60 * time.Second
+)
+
+// ImageDownloader は、ImageUrlSpecのイメージをimagesDirにダウンロードする
+type ImageDownloader struct {
+	client           *http.Client
+	progress         io.Writer // nilの場合は進捗を表示しない
+	progressInterval time.Duration
+}
+
+func NewImageDownloader(progress io.Writer) *ImageDownloader {
+	return &ImageDownloader{
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				DialContext: (&net.Dialer{
+					Timeout:   ImageDownloadConnectTimeout,
+					KeepAlive: 30 * time.Second,
+				}).DialContext,
+				TLSHandshakeTimeout:   ImageDownloadConnectTimeout,
+				ResponseHeaderTimeout: ImageDownloadResponseHeaderTimeout,
+			},
+		},
+		progress:         progress,
+		progressInterval: time.Second,
+	}
+}
+
+// Pull は、pullPolicyに従ってイメージをimagePathにダウンロードする
+//
+// IfNotPresent: imagePathが存在しない場合のみダウンロードする
+// Always: 毎回サーバに問い合わせ、imagePathより新しい場合のみダウンロードする(If-Modified-Since)
+// Never: ダウンロードせず、imagePathが存在しない場合はエラーとする
+//
+// 中断された場合は、次回にimagePath.partからRangeリクエストで再開する
+// (サーバ上のイメージが変わっていた場合は、If-Rangeにより最初からダウンロードし直す)
+// ダウンロードした場合は、イメージのsha256をdigestとして返す
+func (self *ImageDownloader) Pull(tctx *logger.TraceContext, spec *ImageUrlSpec, imagePath string) (digest string, err error) {
+	imageStat, tmpErr := os.Stat(imagePath)
+	isExist := tmpErr == nil
+
+	switch spec.PullPolicy {
+	case PullPolicyIfNotPresent, "":
+		if isExist {
+			return
+		}
+	case PullPolicyNever:
+		if !isExist {
+			err = errors.NewNotFoundErrorf("image is not found, and pullPolicy is Never: path=%s", imagePath)
+		}
+		return
+	case PullPolicyAlways:
+	default:
+		err = errors.NewBadInputErrorf("invalid pullPolicy: pullPolicy=%s", spec.PullPolicy)
+		return
+	}
+
+	if err = os.MkdirAll(filepath.Dir(imagePath), 0755); err != nil {
+		return
+	}
+
+	partPath := imagePath + imageDownloadingSuffix
+	validatorPath := imagePath + imageValidatorSuffix
+	var offset int64
+	var validator string
+	if partStat, tmpErr := os.Stat(partPath); tmpErr == nil {
+		// validatorがない場合は、.partが今のイメージの一部か分からないので最初からダウンロードする
+		if tmpBytes, tmpErr := ioutil.ReadFile(validatorPath); tmpErr == nil && len(tmpBytes) > 0 {
+			offset = partStat.Size()
+			validator = string(tmpBytes)
+		}
+	}
+
+	var req *http.Request
+	if req, err = http.NewRequest(http.MethodGet, spec.Url, nil); err != nil {
+		return
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", validator)
+	} else if isExist {
+		req.Header.Set("If-Modified-Since", imageStat.ModTime().UTC().Format(http.TimeFormat))
+	}
+
+	var resp *http.Response
+	if resp, err = self.client.Do(req); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	flag := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		logger.Infof(tctx, "image is not modified: url=%s", spec.Url)
+		return
+	case http.StatusPartialContent:
+		if start, _, ok := parseContentRange(resp.Header.Get("Content-Range")); !ok || start != offset {
+			err = fmt.Errorf("Failed download: url=%s, invalid Content-Range: %s", spec.Url, resp.Header.Get("Content-Range"))
+			return
+		}
+		logger.Infof(tctx, "resume download: url=%s, offset=%d", spec.Url, offset)
+		flag |= os.O_APPEND
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		// .partが既に全てダウンロード済みの場合
+		if _, total, ok := parseContentRange(resp.Header.Get("Content-Range")); ok && total == offset {
+			digest, err = self.complete(tctx, spec, partPath, imagePath)
+			return
+		}
+		// .partがサーバ上のイメージより大きい場合は、最初からダウンロードし直す
+		logger.Warnf(tctx, "discard downloading image: url=%s, offset=%d, Content-Range=%s",
+			spec.Url, offset, resp.Header.Get("Content-Range"))
+		if err = os.Remove(validatorPath); err != nil {
+			return
+		}
+		digest, err = self.Pull(tctx, spec, imagePath)
+		return
+	case http.StatusOK:
+		// Rangeに対応していないサーバや、If-Rangeが一致しない(イメージが変わった)場合は、最初からダウンロードし直す
+		if offset > 0 {
+			logger.Infof(tctx, "restart download: url=%s", spec.Url)
+		}
+		offset = 0
+		flag |= os.O_TRUNC
+		// 再開時に利用できるのは、強いETag、またはLast-Modified
+		validator = resp.Header.Get("ETag")
+		if validator == "" || strings.HasPrefix(validator, "W/") {
+			validator = resp.Header.Get("Last-Modified")
+		}
+		if err = ioutil.WriteFile(validatorPath, []byte(validator), 0644); err != nil {
+			return
+		}
+	default:
+		err = fmt.Errorf("Failed download: url=%s, status=%s", spec.Url, resp.Status)
+		return
+	}
+
+	var partFile *os.File
+	if partFile, err = os.OpenFile(partPath, flag, 0644); err != nil {
+		return
+	}
+	defer partFile.Close()
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	progress := &downloadProgress{
+		w:        self.progress,
+		name:     filepath.Base(imagePath),
+		current:  offset,
+		total:    total,
+		interval: self.progressInterval,
+	}
+	if _, err = io.Copy(partFile, io.TeeReader(resp.Body, progress)); err != nil {
+		return
+	}
+	progress.done()
+	if err = partFile.Close(); err != nil {
+		return
+	}
+
+	digest, err = self.complete(tctx, spec, partPath, imagePath)
+	return
+}
+
+// complete は、ダウンロードしたファイルのチェックサムを確認してからimagePathにリネームし、sha256を返す
+// チェックサムが一致しない場合は、壊れたファイルから再開しないように削除する
+func (self *ImageDownloader) complete(tctx *logger.TraceContext, spec *ImageUrlSpec, partPath string, imagePath string) (digest string, err error) {
+	if digest, err = verifyImageChecksum(spec, partPath); err != nil {
+		os.Remove(partPath)
+		os.Remove(imagePath + imageValidatorSuffix)
+		return
+	}
+	if err = os.Rename(partPath, imagePath); err != nil {
+		return
+	}
+	if err = os.Remove(imagePath + imageValidatorSuffix); err != nil && os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		return
+	}
+	logger.Infof(tctx, "downloaded image: url=%s, path=%s", spec.Url, imagePath)
+	return
+}
+
+// parseContentRange は、Content-Range(bytes 100-199/1000、bytes */1000)の開始位置と全体のサイズを返す
+// 全体のサイズが不明(*)の場合は、total=-1とする
+func parseContentRange(value string) (start int64, total int64, ok bool) {
+	if !strings.HasPrefix(value, "bytes ") {
+		return
+	}
+	splitedValue := strings.SplitN(strings.TrimPrefix(value, "bytes "), "/", 2)
+	if len(splitedValue) != 2 {
+		return
+	}
+	var err error
+	if splitedValue[1] == "*" {
+		total = -1
+	} else if total, err = strconv.ParseInt(splitedValue[1], 10, 64); err != nil {
+		return
+	}
+	if splitedValue[0] != "*" {
+		if start, err = strconv.ParseInt(strings.SplitN(splitedValue[0], "-", 2)[0], 10, 64); err != nil {
+			return
+		}
+	}
+	ok = true
+	return
+}
+
+// verifyImageChecksum は、pathのチェックサムを確認し、sha256を返す
+// sha256は、チェックサムが指定されていなくてもスナップショットの名前に利用するので計算する
+func verifyImageChecksum(spec *ImageUrlSpec, path string) (sha256Digest string, err error) {
+	type checksum struct {
+		name     string
+		expected string // 空の場合は確認しない
+		hash     hash.Hash
+	}
+	checksums := []checksum{{name: "sha256", expected: spec.Sha256, hash: sha256.New()}}
+	if spec.Sha512 != "" {
+		checksums = append(checksums, checksum{name: "sha512", expected: spec.Sha512, hash: sha512.New()})
+	}
+
+	var file *os.File
+	if file, err = os.Open(path); err != nil {
+		return
+	}
+	defer file.Close()
+
+	writers := []io.Writer{}
+	for _, c := range checksums {
+		writers = append(writers, c.hash)
+	}
+	if _, err = io.Copy(io.MultiWriter(writers...), file); err != nil {
+		return
+	}
+
+	for _, c := range checksums {
+		actual := hex.EncodeToString(c.hash.Sum(nil))
+		if c.expected != "" && actual != strings.ToLower(c.expected) {
+			err = fmt.Errorf("Invalid %s checksum: url=%s, expected=%s, actual=%s", c.name, spec.Url, c.expected, actual)
+			return
+		}
+	}
+	sha256Digest = hex.EncodeToString(checksums[0].hash.Sum(nil))
+	return
+}
+
+// downloadProgress は、書き込まれたバイト数を数えてintervalごとに進捗を表示する
+type downloadProgress struct {
+	w         io.Writer
+	name      string
+	current   int64
+	total     int64 // 不明な場合は-1
+	interval  time.Duration
+	printedAt time.Time
+}
+
+func (self *downloadProgress) Write(p []byte) (n int, err error) {
+	n = len(p)
+	self.current += int64(n)
+	if self.w != nil && time.Since(self.printedAt) >= self.interval {
+		self.print()
+	}
+	return
+}
+
+func (self *downloadProgress) done() {
+	if self.w != nil {
+		self.print()
+	}
+}
+
+func (self *downloadProgress) print() {
+	self.printedAt = time.Now()
+	if self.total > 0 {
+		fmt.Fprintf(self.w, "download %s: %sMB/%sMB (%d%%)\n", self.name,
+			formatMb(self.current), formatMb(self.total), self.current*100/self.total)
+	} else {
+		fmt.Fprintf(self.w, "download %s: %sMB\n", self.name, formatMb(self.current))
+	}
+}
+
+func formatMb(size int64) string {
+	return strconv.FormatFloat(float64(size)/1024/1024, 'f', 1, 64)
+}
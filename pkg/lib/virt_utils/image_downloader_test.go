@@ -0,0 +1,178 @@
+package virt_utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/syunkitada/goapp2/pkg/lib/errors"
+	"github.com/syunkitada/goapp2/pkg/lib/logger"
+)
+
+func TestImageDownloader(t *testing.T) {
+	a := assert.New(t)
+	logger.Init(&logger.Config{})
+	tctx := logger.NewTraceContext()
+
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	modTime := time.Now().Add(-time.Hour)
+	requests := 0
+	rangeRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests += 1
+		if r.Header.Get("Range") != "" {
+			rangeRequests += 1
+		}
+		// ServeContentは、RangeとIf-Modified-Sinceに対応している
+		http.ServeContent(w, r, "image.qcow2", modTime, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	sha256Sum := sha256.Sum256(content)
+	sha512Sum := sha512.Sum512(content)
+	spec := ImageUrlSpec{
+		Url:        server.URL + "/image.qcow2",
+		PullPolicy: PullPolicyIfNotPresent,
+		Sha256:     hex.EncodeToString(sha256Sum[:]),
+		Sha512:     hex.EncodeToString(sha512Sum[:]),
+	}
+
+	var progress bytes.Buffer
+	downloader := NewImageDownloader(&progress)
+	imagePath := filepath.Join(t.TempDir(), "images", "image1")
+
+	// 中断されたダウンロードの続きから再開する
+	a.NoError(os.MkdirAll(filepath.Dir(imagePath), 0755))
+	writePart := func(path string, data []byte, validatorTime time.Time) {
+		a.NoError(ioutil.WriteFile(path+imageDownloadingSuffix, data, 0644))
+		a.NoError(ioutil.WriteFile(path+imageValidatorSuffix, []byte(validatorTime.UTC().Format(http.TimeFormat)), 0644))
+	}
+	writePart(imagePath, content[:30000], modTime)
+	// ダウンロードした場合は、sha256を返す
+	digest, err := downloader.Pull(tctx, &spec, imagePath)
+	a.NoError(err)
+	a.Equal(hex.EncodeToString(sha256Sum[:]), digest)
+	a.Equal(1, rangeRequests)
+	data, err := ioutil.ReadFile(imagePath)
+	a.NoError(err)
+	a.Equal(content, data)
+	_, err = os.Stat(imagePath + imageDownloadingSuffix)
+	a.True(os.IsNotExist(err))
+	_, err = os.Stat(imagePath + imageValidatorSuffix)
+	a.True(os.IsNotExist(err))
+	a.Contains(progress.String(), "download image1: 0.1MB/0.1MB (100%)")
+
+	// IfNotPresentの場合は、存在すればダウンロードしない
+	digest, err = downloader.Pull(tctx, &spec, imagePath)
+	a.NoError(err)
+	a.Equal("", digest)
+	a.Equal(1, requests)
+
+	// Alwaysの場合は、更新されていなければダウンロードしない
+	spec.PullPolicy = PullPolicyAlways
+	digest, err = downloader.Pull(tctx, &spec, imagePath)
+	a.NoError(err)
+	a.Equal("", digest)
+	a.Equal(2, requests)
+	// 古いイメージは、更新されたものに置き換える
+	a.NoError(ioutil.WriteFile(imagePath, []byte("old"), 0644))
+	a.NoError(os.Chtimes(imagePath, modTime.Add(-time.Hour), modTime.Add(-time.Hour)))
+	_, err = downloader.Pull(tctx, &spec, imagePath)
+	a.NoError(err)
+	a.Equal(3, requests)
+	data, err = ioutil.ReadFile(imagePath)
+	a.NoError(err)
+	a.Equal(content, data)
+
+	{
+		// サーバ上のイメージが変わっていた場合は、If-Rangeが一致しないので最初からダウンロードする
+		path := imagePath + "-changed"
+		writePart(path, []byte("stale"), modTime.Add(-time.Hour))
+		_, err := downloader.Pull(tctx, &spec, path)
+		a.NoError(err)
+		data, err := ioutil.ReadFile(path)
+		a.NoError(err)
+		a.Equal(content, data)
+	}
+
+	{
+		// validatorがない.partからは再開しない
+		path := imagePath + "-novalidator"
+		a.NoError(ioutil.WriteFile(path+imageDownloadingSuffix, []byte("stale"), 0644))
+		rangeRequests = 0
+		_, err := downloader.Pull(tctx, &spec, path)
+		a.NoError(err)
+		a.Equal(0, rangeRequests)
+		data, err := ioutil.ReadFile(path)
+		a.NoError(err)
+		a.Equal(content, data)
+	}
+
+	{
+		// .partが全てダウンロード済みの場合(416)は、サイズが一致すれば完了とする
+		path := imagePath + "-complete"
+		writePart(path, content, modTime)
+		requests = 0
+		_, err := downloader.Pull(tctx, &spec, path)
+		a.NoError(err)
+		a.Equal(1, requests)
+		data, err := ioutil.ReadFile(path)
+		a.NoError(err)
+		a.Equal(content, data)
+
+		// .partがサーバ上のイメージより大きい場合は、最初からダウンロードし直す
+		path = imagePath + "-toolarge"
+		writePart(path, append(append([]byte{}, content...), "garbage"...), modTime)
+		requests = 0
+		_, err = downloader.Pull(tctx, &spec, path)
+		a.NoError(err)
+		a.Equal(2, requests)
+		data, err = ioutil.ReadFile(path)
+		a.NoError(err)
+		a.Equal(content, data)
+	}
+
+	{
+		// Neverの場合は、存在しなければエラー
+		spec := spec
+		spec.PullPolicy = PullPolicyNever
+		_, err := downloader.Pull(tctx, &spec, imagePath)
+		a.NoError(err)
+		_, err = downloader.Pull(tctx, &spec, imagePath+"2")
+		a.True(errors.IsNotFoundError(err))
+	}
+
+	{
+		// チェックサムが一致しない場合は、リネームせずに.partも削除する
+		spec := spec
+		spec.Sha256 = strings.Repeat("0", 64)
+		_, err := downloader.Pull(tctx, &spec, imagePath+"2")
+		a.Error(err)
+		a.Contains(err.Error(), "Invalid sha256 checksum")
+		_, err = os.Stat(imagePath + "2")
+		a.True(os.IsNotExist(err))
+		_, err = os.Stat(imagePath + "2" + imageDownloadingSuffix)
+		a.True(os.IsNotExist(err))
+	}
+
+	{
+		// ダウンロードに失敗した場合
+		spec := spec
+		spec.Url = server.URL + "/notfound"
+		server.Config.Handler = http.NotFoundHandler()
+		_, err := downloader.Pull(tctx, &spec, imagePath+"3")
+		a.Error(err)
+		_, err = os.Stat(imagePath + "3")
+		a.True(os.IsNotExist(err))
+	}
+}
@@ -0,0 +1,294 @@
+package virt_utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// ISO9660(ECMA-119)のイメージを作成する
+// cloud-initのNoCloudのシード用で、ルートディレクトリのファイルのみに対応する
+//
+// ファイル名は、プライマリボリューム記述子では8.3形式の大文字とし、
+// Joliet(補助ボリューム記述子)に元のファイル名(meta-dataなど)を記録する
+// LinuxのisofsはJolietがあればそちらのファイル名を利用する
+//
+// セクタの配置は以下とする
+// 0-15: システム領域
+// 16: プライマリボリューム記述子
+// 17: 補助ボリューム記述子(Joliet)
+// 18: ボリューム記述子集合終端子
+// 19-22: パステーブル(プライマリのL, M, JolietのL, M)
+// 23: ルートディレクトリ(プライマリ)
+// 24: ルートディレクトリ(Joliet)
+// 25-: ファイル
+const (
+	isoSectorSize        = 2048
+	isoPrimaryVdSector   = 16
+	isoJolietVdSector    = 17
+	isoTerminatorSector  = 18
+	isoPathTableSector   = 19
+	isoPrimaryRootSector = 23
+	isoJolietRootSector  = 24
+	isoFileSector        = 25
+)
+
+// IsoFile は、WriteIsoで書き込むファイル
+type IsoFile struct {
+	Name string
+	Data []byte
+}
+
+// WriteIso は、volumeIdをラベルとして、filesをルートディレクトリに持つISO9660のイメージを作成する
+func WriteIso(path string, volumeId string, files []IsoFile) (err error) {
+	files = append([]IsoFile{}, files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	now := time.Now().UTC()
+	sectors := make([]uint32, len(files))
+	totalSectors := uint32(isoFileSector)
+	for i, file := range files {
+		sectors[i] = totalSectors
+		totalSectors += uint32((len(file.Data) + isoSectorSize - 1) / isoSectorSize)
+	}
+
+	image := make([]byte, int(totalSectors)*isoSectorSize)
+
+	primaryNames := make([]string, len(files))
+	usedNames := map[string]bool{}
+	for i, file := range files {
+		name := isoPrimaryName(file.Name, 0)
+		for j := 1; usedNames[name]; j++ {
+			name = isoPrimaryName(file.Name, j)
+		}
+		usedNames[name] = true
+		primaryNames[i] = name
+	}
+
+	// プライマリのディレクトリレコードは名前順に並べる必要がある
+	primaryIndexes := make([]int, len(files))
+	for i := range primaryIndexes {
+		primaryIndexes[i] = i
+	}
+	sort.Slice(primaryIndexes, func(i, j int) bool {
+		return primaryNames[primaryIndexes[i]] < primaryNames[primaryIndexes[j]]
+	})
+
+	// ルートディレクトリ
+	for _, joliet := range []bool{false, true} {
+		rootSector := uint32(isoPrimaryRootSector)
+		if joliet {
+			rootSector = isoJolietRootSector
+		}
+		dir := image[rootSector*isoSectorSize : (rootSector+1)*isoSectorSize]
+		offset := 0
+		offset += putIsoDirRecord(dir[offset:], []byte{0}, rootSector, isoSectorSize, true, now)
+		offset += putIsoDirRecord(dir[offset:], []byte{1}, rootSector, isoSectorSize, true, now)
+		if joliet {
+			for i, file := range files {
+				if offset+isoDirRecordLen(len(isoJolietName(file.Name))) > isoSectorSize {
+					err = fmt.Errorf("Invalid iso files: too many files")
+					return
+				}
+				offset += putIsoDirRecord(dir[offset:], isoJolietName(file.Name), sectors[i], uint32(len(file.Data)), false, now)
+			}
+		} else {
+			for _, i := range primaryIndexes {
+				if offset+isoDirRecordLen(len(primaryNames[i])) > isoSectorSize {
+					err = fmt.Errorf("Invalid iso files: too many files")
+					return
+				}
+				offset += putIsoDirRecord(dir[offset:], []byte(primaryNames[i]), sectors[i], uint32(len(files[i].Data)), false, now)
+			}
+		}
+	}
+
+	// パステーブル(ルートディレクトリのみ)
+	for i, rootSector := range []uint32{isoPrimaryRootSector, isoPrimaryRootSector, isoJolietRootSector, isoJolietRootSector} {
+		pathTable := image[(isoPathTableSector+i)*isoSectorSize:]
+		pathTable[0] = 1
+		if i%2 == 0 {
+			binary.LittleEndian.PutUint32(pathTable[2:], rootSector)
+			binary.LittleEndian.PutUint16(pathTable[6:], 1)
+		} else {
+			binary.BigEndian.PutUint32(pathTable[2:], rootSector)
+			binary.BigEndian.PutUint16(pathTable[6:], 1)
+		}
+	}
+
+	// ボリューム記述子
+	putIsoVolumeDescriptor(image[isoPrimaryVdSector*isoSectorSize:], false, volumeId, totalSectors, now)
+	putIsoVolumeDescriptor(image[isoJolietVdSector*isoSectorSize:], true, volumeId, totalSectors, now)
+	terminator := image[isoTerminatorSector*isoSectorSize:]
+	terminator[0] = 255
+	copy(terminator[1:], "CD001")
+	terminator[6] = 1
+
+	for i, file := range files {
+		copy(image[sectors[i]*isoSectorSize:], file.Data)
+	}
+
+	tmpPath := path + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, image, 0644); err != nil {
+		return
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return
+	}
+	return
+}
+
+func putIsoVolumeDescriptor(vd []byte, joliet bool, volumeId string, totalSectors uint32, now time.Time) {
+	rootSector := uint32(isoPrimaryRootSector)
+	pathTableSector := uint32(isoPathTableSector)
+	if joliet {
+		vd[0] = 2
+		rootSector = isoJolietRootSector
+		pathTableSector += 2
+	} else {
+		vd[0] = 1
+	}
+	copy(vd[1:], "CD001")
+	vd[6] = 1
+
+	putIsoString(vd[8:40], "", joliet)
+	putIsoString(vd[40:72], volumeId, joliet)
+	putIsoBothUint32(vd[80:], totalSectors)
+	if joliet {
+		// UCS-2 Level 3
+		copy(vd[88:], "%/E")
+	}
+	putIsoBothUint16(vd[120:], 1)
+	putIsoBothUint16(vd[124:], 1)
+	putIsoBothUint16(vd[128:], isoSectorSize)
+	putIsoBothUint32(vd[132:], 10)
+	binary.LittleEndian.PutUint32(vd[140:], pathTableSector)
+	binary.BigEndian.PutUint32(vd[148:], pathTableSector+1)
+	putIsoDirRecord(vd[156:190], []byte{0}, rootSector, isoSectorSize, true, now)
+	putIsoString(vd[190:318], "", joliet)
+	putIsoString(vd[318:446], "", joliet)
+	putIsoString(vd[446:574], "", joliet)
+	putIsoString(vd[574:702], "GOAPP2", joliet)
+	putIsoString(vd[702:739], "", joliet)
+	putIsoString(vd[739:776], "", joliet)
+	putIsoString(vd[776:813], "", joliet)
+	putIsoDate(vd[813:830], now)
+	putIsoDate(vd[830:847], now)
+	putIsoDate(vd[847:864], time.Time{})
+	putIsoDate(vd[864:881], time.Time{})
+	vd[881] = 1
+}
+
+func isoDirRecordLen(nameLen int) int {
+	return 33 + nameLen + (nameLen+1)%2
+}
+
+// putIsoDirRecord は、ディレクトリレコードを書き込み、そのサイズを返す
+func putIsoDirRecord(buf []byte, name []byte, sector uint32, size uint32, isDir bool, now time.Time) int {
+	recordLen := isoDirRecordLen(len(name))
+	buf[0] = byte(recordLen)
+	putIsoBothUint32(buf[2:], sector)
+	putIsoBothUint32(buf[10:], size)
+	buf[18] = byte(now.Year() - 1900)
+	buf[19] = byte(now.Month())
+	buf[20] = byte(now.Day())
+	buf[21] = byte(now.Hour())
+	buf[22] = byte(now.Minute())
+	buf[23] = byte(now.Second())
+	if isDir {
+		buf[25] = 2
+	}
+	putIsoBothUint16(buf[28:], 1)
+	buf[32] = byte(len(name))
+	copy(buf[33:], name)
+	return recordLen
+}
+
+// putIsoString は、文字列をスペースで埋めて書き込む(JolietはUCS-2のビッグエンディアン)
+func putIsoString(buf []byte, str string, joliet bool) {
+	if joliet {
+		encoded := isoJolietName(str)
+		for i := 0; i+1 < len(buf); i += 2 {
+			buf[i] = 0
+			buf[i+1] = ' '
+		}
+		copy(buf, encoded)
+		return
+	}
+	for i := range buf {
+		buf[i] = ' '
+	}
+	copy(buf, str)
+}
+
+// putIsoDate は、ボリューム記述子の日時(YYYYMMDDHHMMSScc + GMTからのオフセット)を書き込む
+func putIsoDate(buf []byte, t time.Time) {
+	if t.IsZero() {
+		copy(buf, "0000000000000000")
+		buf[16] = 0
+		return
+	}
+	copy(buf, t.Format("20060102150405")+"00")
+	buf[16] = 0
+}
+
+func putIsoBothUint16(buf []byte, v uint16) {
+	binary.LittleEndian.PutUint16(buf[0:], v)
+	binary.BigEndian.PutUint16(buf[2:], v)
+}
+
+func putIsoBothUint32(buf []byte, v uint32) {
+	binary.LittleEndian.PutUint32(buf[0:], v)
+	binary.BigEndian.PutUint32(buf[4:], v)
+}
+
+// isoPrimaryName は、ファイル名をISO9660レベル1の8.3形式に変換する
+// meta-data -> META_DAT.;1
+// 変換後の名前が重複する場合は、seqで末尾を連番にする(META_D_1.;1)
+func isoPrimaryName(name string, seq int) string {
+	name = strings.ToUpper(name)
+	base, ext := name, ""
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		base, ext = name[:i], name[i+1:]
+	}
+	base = isoDChars(base, 8)
+	if seq > 0 {
+		suffix := fmt.Sprintf("_%d", seq)
+		if len(base)+len(suffix) > 8 {
+			base = base[:8-len(suffix)]
+		}
+		base += suffix
+	}
+	ext = isoDChars(ext, 3)
+	return base + "." + ext + ";1"
+}
+
+func isoDChars(str string, maxLen int) string {
+	var b strings.Builder
+	for _, c := range str {
+		if b.Len() >= maxLen {
+			break
+		}
+		if (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') {
+			b.WriteRune(c)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+func isoJolietName(name string) []byte {
+	encoded := utf16.Encode([]rune(name))
+	buf := make([]byte, len(encoded)*2)
+	for i, c := range encoded {
+		binary.BigEndian.PutUint16(buf[i*2:], c)
+	}
+	return buf
+}
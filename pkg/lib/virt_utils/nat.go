@@ -0,0 +1,250 @@
+package virt_utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/syunkitada/goapp2/pkg/lib/errors"
+	"github.com/syunkitada/goapp2/pkg/lib/logger"
+	"github.com/syunkitada/goapp2/pkg/lib/str_utils"
+)
+
+const (
+	// NATのルールは全てこのテーブルに作成し、適用のたびにテーブルごと作り直す
+	natTableName = "goapp2_virt"
+	natRulesFile = "nat.nft"
+
+	PortForwardProtocolTcp = "tcp"
+	PortForwardProtocolUdp = "udp"
+)
+
+type PortForwardResources []PortForwardResource
+
+func (self PortForwardResources) String() string {
+	tableString, table := str_utils.GetTable()
+	table.SetHeader([]string{"Kind", "Vm", "Network", "Protocol", "HostPort", "VmIp", "VmPort"})
+	for _, r := range self {
+		s := r.Spec
+		table.Append([]string{r.Kind, s.VmName, s.NetworkName, s.Protocol, strconv.Itoa(s.HostPort), s.VmIp, strconv.Itoa(s.VmPort)})
+	}
+	table.Render()
+	return tableString.String()
+}
+
+type PortForwardResource struct {
+	Kind string
+	Spec PortForwardDetail
+}
+
+// PortForwardSpec は、VMのネットワークごとに指定する、ホストから転送するポート
+type PortForwardSpec struct {
+	Protocol string `validate:"omitempty,oneof=tcp udp"` // 空の場合はtcp
+	Port     int    `validate:"required,min=1,max=65535"`
+}
+
+// PortForward は、ホストのポートからVMのポートへの転送
+// ホストのポートは、ネットワークのNat.Portsの範囲から割り当てる
+type PortForward struct {
+	Id        uint   `gorm:"not null;primaryKey;autoIncrement;"`
+	NetworkId uint   `gorm:"not null;"`
+	VmId      uint   `gorm:"not null;"`
+	Protocol  string `gorm:"not null;uniqueIndex:udx_host_port;"`
+	HostPort  int    `gorm:"not null;uniqueIndex:udx_host_port;"`
+	VmIp      string `gorm:"not null;"`
+	VmPort    int    `gorm:"not null;"`
+}
+
+type PortForwardDetail struct {
+	PortForward
+	VmName      string
+	NetworkName string
+}
+
+// AssignPortForwards は、portsのそれぞれに対して、detectSpecsのPortForwardsのホストのポートを割り当てる
+// portsとdetectSpecsは、AssignNetworkPortsと同じ順である必要がある
+func (self *VirtController) AssignPortForwards(tctx *logger.TraceContext, tx *gorm.DB,
+	ports []NetworkPort, detectSpecs []NetworkDetectSpec, networkMap map[uint]Network) (portForwards []PortForward, err error) {
+
+	// ホストのポートはネットワークをまたいで重複できないので、全ての割り当て済みのポートを除外する
+	var assignedPortForwards []PortForward
+	if err = tx.Table("port_forwards").Select("*").Find(&assignedPortForwards).Error; err != nil {
+		return
+	}
+	usedHostPorts := map[string]bool{}
+	for _, portForward := range assignedPortForwards {
+		usedHostPorts[portForward.Protocol+"/"+strconv.Itoa(portForward.HostPort)] = true
+	}
+
+	for i, spec := range detectSpecs {
+		if len(spec.PortForwards) == 0 {
+			continue
+		}
+		port := ports[i]
+		network := networkMap[port.NetworkId]
+		var networkLocalSpec NetworkLocalSpec
+		if err = json.Unmarshal([]byte(network.SpecStr), &networkLocalSpec); err != nil {
+			return
+		}
+		if !networkLocalSpec.Nat.Enable {
+			err = errors.NewBadInputErrorf("nat is not enabled: network=%s", network.Name)
+			return
+		}
+		hostPorts := str_utils.ParseRangeFormatStr(networkLocalSpec.Nat.Ports)
+
+		for _, portForwardSpec := range spec.PortForwards {
+			if err = self.validate.Struct(portForwardSpec); err != nil {
+				return
+			}
+			protocol := portForwardSpec.Protocol
+			if protocol == "" {
+				protocol = PortForwardProtocolTcp
+			}
+			hostPort := 0
+			for _, candidatePort := range hostPorts {
+				if !usedHostPorts[protocol+"/"+strconv.Itoa(candidatePort)] {
+					hostPort = candidatePort
+					break
+				}
+			}
+			if hostPort == 0 {
+				err = errors.NewConflictErrorf("available host port is not found: network=%s, ports=%s",
+					network.Name, networkLocalSpec.Nat.Ports)
+				return
+			}
+			usedHostPorts[protocol+"/"+strconv.Itoa(hostPort)] = true
+			portForwards = append(portForwards, PortForward{
+				NetworkId: port.NetworkId,
+				VmId:      port.VmId,
+				Protocol:  protocol,
+				HostPort:  hostPort,
+				VmIp:      port.Ip,
+				VmPort:    portForwardSpec.Port,
+			})
+		}
+	}
+
+	for i := range portForwards {
+		if err = tx.Create(&portForwards[i]).Error; err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (self *VirtController) GetPortForwardResources(tctx *logger.TraceContext, vmNames []string) (portForwardResources PortForwardResources, err error) {
+	var portForwards []PortForwardDetail
+	sql := self.sqlClient.DB.Table("port_forwards AS p").
+		Select("p.*, v.name as vm_name, n.name as network_name").
+		Joins("INNER JOIN vms AS v ON p.vm_id = v.id").
+		Joins("INNER JOIN networks AS n ON p.network_id = n.id").
+		Where("v.deleted_at IS NULL").Order("p.protocol, p.host_port")
+	if len(vmNames) > 0 {
+		sql = sql.Where("v.name in (?)", vmNames)
+	}
+	if err = sql.Scan(&portForwards).Error; err != nil {
+		return
+	}
+	for _, portForward := range portForwards {
+		portForwardResources = append(portForwardResources, PortForwardResource{
+			Kind: KindPortForward,
+			Spec: portForward,
+		})
+	}
+	return
+}
+
+// BuildNatRules は、nft -fで適用するルールセットを作成する
+// テーブルを削除してから作り直すので、何度適用しても同じ結果となり、不要になったルールも削除される
+func BuildNatRules(networks []Network, portForwards []PortForward) (rules string, err error) {
+	sortedNetworks := append([]Network{}, networks...)
+	sort.Slice(sortedNetworks, func(i, j int) bool { return sortedNetworks[i].Id < sortedNetworks[j].Id })
+	sortedPortForwards := append([]PortForward{}, portForwards...)
+	sort.Slice(sortedPortForwards, func(i, j int) bool {
+		if sortedPortForwards[i].Protocol != sortedPortForwards[j].Protocol {
+			return sortedPortForwards[i].Protocol < sortedPortForwards[j].Protocol
+		}
+		return sortedPortForwards[i].HostPort < sortedPortForwards[j].HostPort
+	})
+
+	dnatRules := []string{}
+	for _, portForward := range sortedPortForwards {
+		dnatRules = append(dnatRules, fmt.Sprintf("fib daddr type local %s dport %d dnat to %s:%d",
+			portForward.Protocol, portForward.HostPort, portForward.VmIp, portForward.VmPort))
+	}
+
+	// サブネット内の通信(同じネットワークのVM間)はそのままルーティングし、外への通信のみをマスカレードする
+	masqueradeRules := []string{}
+	for _, network := range sortedNetworks {
+		var networkLocalSpec NetworkLocalSpec
+		if err = json.Unmarshal([]byte(network.SpecStr), &networkLocalSpec); err != nil {
+			return
+		}
+		if !networkLocalSpec.Nat.Enable {
+			continue
+		}
+		masqueradeRules = append(masqueradeRules, fmt.Sprintf("ip saddr %s ip daddr != %s masquerade",
+			network.Subnet, network.Subnet))
+	}
+
+	var builder strings.Builder
+	// 存在しないテーブルは削除できないので、空のテーブルを宣言してから削除する
+	fmt.Fprintf(&builder, "table ip %s\n", natTableName)
+	fmt.Fprintf(&builder, "delete table ip %s\n", natTableName)
+	fmt.Fprintf(&builder, "table ip %s {\n", natTableName)
+	writeChain := func(name string, hook string, priority string, chainRules []string) {
+		fmt.Fprintf(&builder, "\tchain %s {\n", name)
+		fmt.Fprintf(&builder, "\t\ttype nat hook %s priority %s; policy accept;\n", hook, priority)
+		for _, rule := range chainRules {
+			fmt.Fprintf(&builder, "\t\t%s\n", rule)
+		}
+		fmt.Fprintf(&builder, "\t}\n")
+	}
+	writeChain("prerouting", "prerouting", "dstnat", dnatRules)
+	// ホスト自身からの転送ポートへの接続
+	writeChain("output", "output", "-100", dnatRules)
+	writeChain("postrouting", "postrouting", "srcnat", masqueradeRules)
+	fmt.Fprintf(&builder, "}\n")
+	rules = builder.String()
+	return
+}
+
+// ReconcileNat は、DBのネットワークと転送ポートからNATのルールを作成して適用する
+// 削除されたVMやネットワークのルールは、作り直す際に削除される
+func (self *VirtController) ReconcileNat(tctx *logger.TraceContext) (err error) {
+	var networks []Network
+	if err = self.sqlClient.DB.Table("networks").Select("*").
+		Where("deleted_at IS NULL").Where("kind = ?", KindNetworkLocal).Scan(&networks).Error; err != nil {
+		return
+	}
+	var portForwards []PortForward
+	if err = self.sqlClient.DB.Table("port_forwards AS p").Select("p.*").
+		Joins("INNER JOIN vms AS v ON p.vm_id = v.id").
+		Where("v.deleted_at IS NULL").Scan(&portForwards).Error; err != nil {
+		return
+	}
+
+	var rules string
+	if rules, err = BuildNatRules(networks, portForwards); err != nil {
+		return
+	}
+	rulesPath := filepath.Join(self.conf.VarDir, natRulesFile)
+	if err = os.MkdirAll(self.conf.VarDir, 0755); err != nil {
+		return
+	}
+	if err = ioutil.WriteFile(rulesPath, []byte(rules), 0644); err != nil {
+		return
+	}
+	if err = self.applyNftables(tctx, rulesPath); err != nil {
+		return
+	}
+	logger.Infof(tctx, "applied nat rules: path=%s, portForwards=%d", rulesPath, len(portForwards))
+	return
+}
@@ -0,0 +1,158 @@
+package virt_utils
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/syunkitada/goapp2/pkg/lib/errors"
+	"github.com/syunkitada/goapp2/pkg/lib/logger"
+)
+
+func TestBuildNatRules(t *testing.T) {
+	a := assert.New(t)
+	networks := []Network{
+		{Id: 2, NetworkSpec: NetworkSpec{Name: "local2", Subnet: "192.168.200.0/24"}, SpecStr: `{"Nat":{"Enable":false}}`},
+		{Id: 1, NetworkSpec: NetworkSpec{Name: "local1", Subnet: "192.168.100.0/24"}, SpecStr: `{"Nat":{"Enable":true,"Ports":"30000-40000"}}`},
+	}
+	portForwards := []PortForward{
+		{Protocol: "udp", HostPort: 30000, VmIp: "192.168.100.3", VmPort: 53},
+		{Protocol: "tcp", HostPort: 30001, VmIp: "192.168.100.3", VmPort: 22},
+		{Protocol: "tcp", HostPort: 30000, VmIp: "192.168.100.2", VmPort: 22},
+	}
+	rules, err := BuildNatRules(networks, portForwards)
+	a.NoError(err)
+	a.Equal(`table ip goapp2_virt
+delete table ip goapp2_virt
+table ip goapp2_virt {
+	chain prerouting {
+		type nat hook prerouting priority dstnat; policy accept;
+		fib daddr type local tcp dport 30000 dnat to 192.168.100.2:22
+		fib daddr type local tcp dport 30001 dnat to 192.168.100.3:22
+		fib daddr type local udp dport 30000 dnat to 192.168.100.3:53
+	}
+	chain output {
+		type nat hook output priority -100; policy accept;
+		fib daddr type local tcp dport 30000 dnat to 192.168.100.2:22
+		fib daddr type local tcp dport 30001 dnat to 192.168.100.3:22
+		fib daddr type local udp dport 30000 dnat to 192.168.100.3:53
+	}
+	chain postrouting {
+		type nat hook postrouting priority srcnat; policy accept;
+		ip saddr 192.168.100.0/24 ip daddr != 192.168.100.0/24 masquerade
+	}
+}
+`, rules)
+
+	// 入力の順序によらず、同じルールとなる
+	rules2, err := BuildNatRules([]Network{networks[1], networks[0]}, []PortForward{portForwards[2], portForwards[0], portForwards[1]})
+	a.NoError(err)
+	a.Equal(rules, rules2)
+
+	// ルールがない場合も、テーブルを作り直して古いルールを削除する
+	rules, err = BuildNatRules(nil, nil)
+	a.NoError(err)
+	a.Contains(rules, "delete table ip goapp2_virt\n")
+	a.NotContains(rules, "masquerade")
+}
+
+func TestAssignPortForwards(t *testing.T) {
+	a := assert.New(t)
+	virtController := newTestVirtController(t)
+	tctx := logger.NewTraceContext()
+
+	vmSpec := func(name string) []byte {
+		return []byte(`kind: vm
+spec:
+  name: ` + name + `
+  namespace: group1
+  kind: qemu
+  vcpus: 1
+  memoryMb: 1024
+  diskGb: 10
+  image:
+    name: centos8
+  networks:
+    - name: local1
+      portForwards:
+        - port: 22
+        - port: 53
+          protocol: udp
+  spec:
+    service:
+      restart: always
+`)
+	}
+	a.NoError(applyTestResources(tctx, virtController, [][]byte{vmSpec("vm2"), vmSpec("vm3")}))
+
+	result, err := virtController.Get(tctx, KindPortForward, nil)
+	a.NoError(err)
+	a.Len(result.PortForwards, 4)
+	hostPorts := map[string]int{}
+	for _, r := range result.PortForwards {
+		a.Equal("local1", r.Spec.NetworkName)
+		hostPorts[r.Spec.VmName+"/"+r.Spec.Protocol] = r.Spec.HostPort
+	}
+	// ホストのポートは、プロトコルごとに範囲の先頭から割り当てる
+	a.Equal(map[string]int{"vm2/tcp": 30000, "vm2/udp": 30000, "vm3/tcp": 30001, "vm3/udp": 30001}, hostPorts)
+
+	result, err = virtController.Get(tctx, KindPortForward, []string{"vm3"})
+	a.NoError(err)
+	a.Len(result.PortForwards, 2)
+	vm3, err := virtController.GetVm("vm3")
+	a.NoError(err)
+	for _, r := range result.PortForwards {
+		a.Equal(vm3.Id, r.Spec.VmId)
+		a.Contains([]int{22, 53}, r.Spec.VmPort)
+	}
+
+	{
+		// 不正なポート
+		err := applyTestResources(tctx, virtController, [][]byte{[]byte(`kind: vm
+spec:
+  name: vm4
+  namespace: group1
+  kind: qemu
+  vcpus: 1
+  memoryMb: 1024
+  diskGb: 10
+  image:
+    name: centos8
+  networks:
+    - name: local1
+      portForwards:
+        - port: 70000
+  spec:
+    service:
+      restart: always
+`)})
+		a.Error(err)
+		_, err = virtController.GetVm("vm4")
+		a.True(errors.IsNotFoundError(err))
+	}
+}
+
+func TestDeleteNatNetwork(t *testing.T) {
+	a := assert.New(t)
+	virtController := newTestVirtController(t)
+	tctx := logger.NewTraceContext()
+
+	var appliedRules []string
+	virtController.applyNftables = func(tctx *logger.TraceContext, path string) error {
+		rules, err := ioutil.ReadFile(path)
+		appliedRules = append(appliedRules, string(rules))
+		return err
+	}
+
+	_, err := virtController.DeleteVmResources(tctx, []string{"vm1"})
+	a.NoError(err)
+	a.NoError(virtController.ReconcileNat(tctx))
+	a.Contains(appliedRules[len(appliedRules)-1], "ip saddr 192.168.100.0/24")
+	appliedRules = nil
+
+	// NATが有効なネットワークを削除すると、masqueradeのルールも削除する
+	_, err = virtController.DeleteNetworkResources(tctx, []string{"local1"})
+	a.NoError(err)
+	a.Len(appliedRules, 1)
+	a.NotContains(appliedRules[0], "192.168.100.0/24")
+}
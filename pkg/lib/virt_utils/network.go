@@ -12,7 +12,7 @@ import (
 	"github.com/jinzhu/gorm"
 	"github.com/syunkitada/goapp2/pkg/lib/errors"
 	"github.com/syunkitada/goapp2/pkg/lib/logger"
-	"github.com/syunkitada/goapp2/pkg/lib/os_cmds"
+	"github.com/syunkitada/goapp2/pkg/lib/netlink_utils"
 	"github.com/syunkitada/goapp2/pkg/lib/str_utils"
 )
 
@@ -45,6 +45,7 @@ type Network struct {
 
 type NetworkDetectSpec struct {
 	Name                string
+	PortForwards        []PortForwardSpec
 	CandidateNetworkIds []uint `json:"-"`
 }
 
@@ -60,8 +61,25 @@ type NetworkSpec struct {
 }
 
 type NetworkLocalSpec struct {
-	Resolvers []Resolver `gorm:"-"`
-	Nat       NetworkNat `gorm:"-"`
+	Resolvers []Resolver   `gorm:"-"`
+	Nat       NetworkNat   `gorm:"-"`
+	Mtu       int          `gorm:"-" validate:"omitempty,min=576,max=9000"` // DHCPで渡すMTU、0の場合は1500
+	Netns     NetworkNetns `gorm:"-"`
+}
+
+type NetworkNetns struct {
+	// VMのnetnsでDNSなどのサービスを提供するIP、VMのネームサーバとなる
+	VmNetnsServiceIp string `validate:"omitempty,ipv4"`
+}
+
+const defaultVmNetnsServiceIp = "169.254.1.200"
+
+// ServiceIp は、VMのnetnsのサービスのIPを返す
+func (self *NetworkLocalSpec) ServiceIp() string {
+	if self.Netns.VmNetnsServiceIp != "" {
+		return self.Netns.VmNetnsServiceIp
+	}
+	return defaultVmNetnsServiceIp
 }
 
 type Resolver struct {
@@ -70,7 +88,7 @@ type Resolver struct {
 
 type NetworkNat struct {
 	Enable bool
-	Ports  string
+	Ports  string // ポートフォワードに利用するホストのポートの範囲(30000-40000)
 }
 
 type NetworkPort struct {
@@ -78,6 +96,7 @@ type NetworkPort struct {
 	VmId      uint   `gorm:"not null;"`
 	Ip        string `gorm:"not null;"`
 	Mac       string `gorm:"not null;"`
+	NetnsName string `gorm:"not null;default:''"` // PrepareNetworksで割り当てたnetns名(com-N)、削除時に利用する
 }
 
 type VmNetwork struct {
@@ -88,6 +107,7 @@ type VmNetwork struct {
 type VmNetworkPort struct {
 	*VmNetwork
 	NetworkPort
+	TapName string `gorm:"-"` // PrepareNetworksで割り当てる、VMに接続するtapデバイス名
 }
 
 const (
@@ -101,15 +121,72 @@ func (self *VirtController) BootstrapNetwork(tctx *logger.TraceContext) (err err
 	if err = self.sqlClient.DB.AutoMigrate(&NetworkPort{}).Error; err != nil {
 		return
 	}
+	if err = self.sqlClient.DB.AutoMigrate(&PortForward{}).Error; err != nil {
+		return
+	}
 	return
 }
 
 func (self *VirtController) CreateOrUpdateNetwork(tctx *logger.TraceContext, spec *NetworkSpec) (err error) {
+	var specBytes []byte
+	if specBytes, err = self.validateNetworkSpec(spec); err != nil {
+		return
+	}
+
+	var network *Network
+	if network, err = self.GetNetwork(spec.Name); err != nil {
+		if errors.IsNotFoundError(err) {
+			err = self.sqlClient.Transact(tctx, func(tx *gorm.DB) (err error) {
+				network := Network{
+					NetworkSpec: *spec,
+					SpecStr:     string(specBytes),
+				}
+				if err = tx.Create(&network).Error; err != nil {
+					return
+				}
+				return
+			})
+		}
+		return
+	}
+
+	// Kind、Subnet、Gatewayは、割り当て済みのポートやnetnsに影響するので更新しない
+	// StartIp、EndIpの変更は、以降のポートの割り当てから反映される
+	if string(specBytes) == network.SpecStr && spec.StartIp == network.StartIp && spec.EndIp == network.EndIp {
+		return
+	}
+	if err = self.sqlClient.DB.Table("networks").Where("id = ?", network.Id).Updates(map[string]interface{}{
+		"start_ip": spec.StartIp,
+		"end_ip":   spec.EndIp,
+		"spec":     string(specBytes),
+	}).Error; err != nil {
+		return
+	}
+
+	// NATの有効、無効を切り替えた場合は、ルールに反映する
+	var oldNetworkLocalSpec NetworkLocalSpec
+	var newNetworkLocalSpec NetworkLocalSpec
+	if err = json.Unmarshal([]byte(network.SpecStr), &oldNetworkLocalSpec); err != nil {
+		return
+	}
+	if err = json.Unmarshal(specBytes, &newNetworkLocalSpec); err != nil {
+		return
+	}
+	if oldNetworkLocalSpec.Nat.Enable != newNetworkLocalSpec.Nat.Enable {
+		if err = self.ReconcileNat(tctx); err != nil {
+			return
+		}
+	}
+	logger.Infof(tctx, "updated network: name=%s", spec.Name)
+	return
+}
+
+// validateNetworkSpec は、specを検証し、保存するSpecStrを返す
+func (self *VirtController) validateNetworkSpec(spec *NetworkSpec) (specBytes []byte, err error) {
 	if err = self.validate.Struct(spec); err != nil {
 		return
 	}
 
-	var specBytes []byte
 	if specBytes, err = json.Marshal(spec.Spec); err != nil {
 		return
 	}
@@ -123,6 +200,10 @@ func (self *VirtController) CreateOrUpdateNetwork(tctx *logger.TraceContext, spe
 		if err = self.validate.Struct(networkLocalSpec); err != nil {
 			return
 		}
+		if networkLocalSpec.Nat.Enable && len(str_utils.ParseRangeFormatStr(networkLocalSpec.Nat.Ports)) == 0 {
+			err = errors.NewBadInputErrorf("invalid nat ports: ports=%s", networkLocalSpec.Nat.Ports)
+			return
+		}
 	default:
 		err = errors.NewBadInputErrorf("invalid network kind: kind=%s", spec.Kind)
 		return
@@ -131,32 +212,12 @@ func (self *VirtController) CreateOrUpdateNetwork(tctx *logger.TraceContext, spe
 	if _, err = ParseNetwork(spec); err != nil {
 		return
 	}
-
-	var network *Network
-	if network, err = self.GetNetwork(spec.Name); err != nil {
-		if errors.IsNotFoundError(err) {
-			err = self.sqlClient.Transact(tctx, func(tx *gorm.DB) (err error) {
-				network := Network{
-					NetworkSpec: *spec,
-					SpecStr:     string(specBytes),
-				}
-				if err = tx.Create(&network).Error; err != nil {
-					return
-				}
-				return
-			})
-		}
-		return
-	} else {
-		fmt.Println("TODO update network", network)
-		return
-	}
 	return
 }
 
 func (self *VirtController) GetNetwork(name string) (network *Network, err error) {
 	var networks []Network
-	sql := self.sqlClient.DB.Table("networks").Select("*").Where("deleted_at IS NULL")
+	sql := self.sqlClient.DB.Table("networks").Select("*").Where("deleted_at IS NULL").Where("name = ?", name)
 	if err = sql.Scan(&networks).Error; err != nil {
 		return
 	}
@@ -295,26 +356,31 @@ func (self *VirtController) AssignNetworkPorts(tctx *logger.TraceContext, tx *go
 		}
 	}
 
+	if _, err = self.AssignPortForwards(tctx, tx, assignedPorts, detectSpecs, candidateNetworkMap); err != nil {
+		return
+	}
+
 	return
 }
 
 func (self *VirtController) PrepareNetworks(tctx *logger.TraceContext, vmResources VmResources) (err error) {
-	// TODO prepare networkPorts and set vmResources
-
-	for _, vm := range vmResources {
-		for _, port := range vm.Spec.NetworkPorts {
-			switch port.Kind {
-			case KindNetworkLocal:
-				fmt.Println("DEBUG local")
-			}
-		}
-	}
-
 	assignedNetnsIds := make([]bool, 4096)
-	var netnsSet map[string]bool
-	if netnsSet, err = os_cmds.GetNetnsSet(tctx); err != nil {
+	var netnsNames []string
+	if netnsNames, err = netlink_utils.ListNetns(); err != nil {
+		return
+	}
+	netnsSet := map[string]bool{}
+	for _, netnsName := range netnsNames {
+		netnsSet[netnsName] = true
+	}
+	// 停止中のVMのnetnsも再利用されないように、割り当て済みのnetns名も除外する
+	var assignedPorts []NetworkPort
+	if err = self.sqlClient.DB.Table("network_ports").Select("*").Where("netns_name != ''").Scan(&assignedPorts).Error; err != nil {
 		return
 	}
+	for _, port := range assignedPorts {
+		netnsSet[port.NetnsName] = true
+	}
 	for netns := range netnsSet {
 		splitedNetns := strings.Split(netns, "com-")
 		if len(splitedNetns) == 2 {
@@ -335,22 +401,40 @@ func (self *VirtController) PrepareNetworks(tctx *logger.TraceContext, vmResourc
 	// vmNetnsEndIp := "169.254.63.254"
 
 	computeNetnsPortsMap := map[uint][]netnsPort{}
-	for _, vm := range vmResources {
-		fmt.Println(vm)
+	for i := range vmResources {
+		vm := &vmResources[i]
 
 		// ポートごとにveth, netns名を割り当てる(NodeServiceないでユニーク)
 		netnsPorts := []netnsPort{}
 		for j, port := range vm.Spec.NetworkPorts {
 			// インターフェイスの最大文字数が15なので、ベース文字数は12とする
+			// 一度割り当てたnetnsは、VMを削除するまで同じものを使う
 			var netnsId uint
-			for id, assigned := range assignedNetnsIds {
-				if !assigned {
+			if port.NetnsName != "" {
+				if id, tmpErr := strconv.Atoi(strings.TrimPrefix(port.NetnsName, "com-")); tmpErr != nil {
+					err = fmt.Errorf("Invalid netns name: %s", port.NetnsName)
+					return
+				} else {
 					netnsId = uint(id)
-					assignedNetnsIds[netnsId] = true
-					break
 				}
+			} else {
+				for id, assigned := range assignedNetnsIds {
+					if !assigned {
+						netnsId = uint(id)
+						assignedNetnsIds[netnsId] = true
+						break
+					}
+				}
+				netnsName := fmt.Sprintf("com-%d", netnsId)
+				if err = self.sqlClient.DB.Table("network_ports").
+					Where("vm_id = ? AND network_id = ? AND ip = ?", port.VmId, port.NetworkId, port.Ip).
+					Updates(map[string]interface{}{"netns_name": netnsName}).Error; err != nil {
+					return
+				}
+				vm.Spec.NetworkPorts[j].NetnsName = netnsName
 			}
 			netnsName := fmt.Sprintf("com-%d", netnsId)
+			vm.Spec.NetworkPorts[j].TapName = netnsName + "-tap"
 			netnsGateway := AddIntToIp(parsedVmNetGatewayStartIp, uint(j))
 			netnsIp := AddIntToIp(parsedVmNetnsStartIp, netnsId)
 
@@ -362,6 +446,8 @@ func (self *VirtController) PrepareNetworks(tctx *logger.TraceContext, vmResourc
 				VmIp:         port.Ip,
 				VmMac:        port.Mac,
 				VmSubnet:     port.Subnet,
+				VmGateway:    port.Gateway,
+				ServiceIp:    port.networkLocalSpec.ServiceIp(),
 				Kind:         port.Kind,
 			}
 
@@ -370,6 +456,219 @@ func (self *VirtController) PrepareNetworks(tctx *logger.TraceContext, vmResourc
 		}
 	}
 
-	fmt.Println("DEBUG netnsPortsMap", computeNetnsPortsMap)
+	for i := range vmResources {
+		vm := &vmResources[i].Spec
+		for _, netnsPort := range computeNetnsPortsMap[vm.Id] {
+			switch netnsPort.Kind {
+			case KindNetworkLocal:
+				if err = ensureNetnsPort(&netnsPort); err != nil {
+					err = fmt.Errorf("Failed prepare network: vm=%s, netns=%s, err=%s", vm.Name, netnsPort.Name, err.Error())
+					return
+				}
+			default:
+				err = errors.NewBadInputErrorf("invalid network kind: kind=%s", netnsPort.Kind)
+				return
+			}
+			logger.Infof(tctx, "prepared network: vm=%s, netns=%s, ip=%s", vm.Name, netnsPort.Name, netnsPort.VmIp)
+		}
+	}
+
+	if err = self.ReconcileNat(tctx); err != nil {
+		return
+	}
+	return
+}
+
+// ensureNetnsPort は、ポートごとのnetnsを経由してVMとホストを接続する
+// 既に作成済みのものはそのまま利用し、途中で失敗した場合は今回作成したものを削除する
+//
+//	host: [name]-ex (NetnsGateway/32)
+//	  | veth
+//	netns [name]: [name]-in (NetnsIp/32) -- forward -- [name]-br (VmGateway/32, proxy_arp)
+//	              lo (ServiceIp/32)                        | bridge
+//	                                                      [name]-tap -- VM (VmIp)
+func ensureNetnsPort(port *netnsPort) (err error) {
+	exName := port.Name + "-ex"
+	inName := port.Name + "-in"
+	brName := port.Name + "-br"
+	tapName := port.Name + "-tap"
+	netnsGateway := net.ParseIP(port.NetnsGateway)
+	netnsIp := net.ParseIP(port.NetnsIp)
+	vmGateway := net.ParseIP(port.VmGateway)
+	vmIp := net.ParseIP(port.VmIp)
+	serviceIp := net.ParseIP(port.ServiceIp)
+	if netnsGateway == nil || netnsIp == nil || vmGateway == nil || vmIp == nil || serviceIp == nil {
+		err = fmt.Errorf("Invalid netns port: %v", port)
+		return
+	}
+
+	var host, netns *netlink_utils.Handle
+	if host, err = netlink_utils.NewHandle(""); err != nil {
+		return
+	}
+	defer host.Close()
+
+	rollback := &netlink_utils.Rollback{}
+	defer func() {
+		if err != nil {
+			rollback.Run()
+		}
+	}()
+
+	var created bool
+	if created, err = netlink_utils.EnsureNetns(port.Name); err != nil {
+		return
+	}
+	if created {
+		// netnsを削除すると、netns内のデバイスとvethの対向も削除される
+		rollback.Add(func() error { return netlink_utils.DeleteNetns(port.Name) })
+	}
+
+	if netns, err = netlink_utils.NewHandle(port.Name); err != nil {
+		return
+	}
+	defer netns.Close()
+
+	// ホスト側
+	if created, err = host.EnsureVeth(exName, inName, port.Name); err != nil {
+		return
+	}
+	if created {
+		rollback.Add(func() error { return host.DeleteLink(exName) })
+	}
+	if err = host.SetLinkUp(exName); err != nil {
+		return
+	}
+	if _, err = host.EnsureAddr(exName, hostIpNet(netnsGateway)); err != nil {
+		return
+	}
+	if err = host.EnsureRoute(&netlink_utils.Route{Dst: hostIpNet(netnsIp), LinkName: exName}); err != nil {
+		return
+	}
+	if err = host.EnsureRoute(&netlink_utils.Route{Dst: hostIpNet(vmIp), Gw: netnsIp, LinkName: exName}); err != nil {
+		return
+	}
+	if err = host.SetSysctl("net/ipv4/ip_forward", "1"); err != nil {
+		return
+	}
+
+	// netns側
+	for _, name := range []string{"lo", inName} {
+		if err = netns.SetLinkUp(name); err != nil {
+			return
+		}
+	}
+	if _, err = netns.EnsureAddr(inName, hostIpNet(netnsIp)); err != nil {
+		return
+	}
+	// VMからはゲートウェイ経由で到達し、netns内のDNSサーバが応答する
+	if _, err = netns.EnsureAddr("lo", hostIpNet(serviceIp)); err != nil {
+		return
+	}
+	if err = netns.EnsureRoute(&netlink_utils.Route{Dst: hostIpNet(netnsGateway), LinkName: inName}); err != nil {
+		return
+	}
+	if err = netns.EnsureRoute(&netlink_utils.Route{Gw: netnsGateway, LinkName: inName}); err != nil {
+		return
+	}
+
+	// VMのゲートウェイはブリッジに持たせ、サブネット内の他のIPへのARPにはproxy_arpで応答する
+	if _, err = netns.EnsureBridge(brName); err != nil {
+		return
+	}
+	if _, err = netns.EnsureTap(tapName); err != nil {
+		return
+	}
+	if err = netns.SetLinkMaster(tapName, brName); err != nil {
+		return
+	}
+	for _, name := range []string{brName, tapName} {
+		if err = netns.SetLinkUp(name); err != nil {
+			return
+		}
+	}
+	if _, err = netns.EnsureAddr(brName, hostIpNet(vmGateway)); err != nil {
+		return
+	}
+	if err = netns.EnsureRoute(&netlink_utils.Route{Dst: hostIpNet(vmIp), LinkName: brName}); err != nil {
+		return
+	}
+	if err = netns.SetSysctl("net/ipv4/conf/"+brName+"/proxy_arp", "1"); err != nil {
+		return
+	}
+	if err = netns.SetSysctl("net/ipv4/ip_forward", "1"); err != nil {
+		return
+	}
+	return
+}
+
+// hostIpNet は、ipの/32のIPNetを返す
+func hostIpNet(ip net.IP) *net.IPNet {
+	return &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}
+}
+
+// DeleteNetworkResources は、ネットワークを論理削除する
+// 削除されていないVMのportが存在するネットワークは削除できない
+func (self *VirtController) DeleteNetworkResources(tctx *logger.TraceContext, names []string) (networkResources NetworkResources, err error) {
+	if len(names) == 0 {
+		err = errors.NewBadInputErrorf("network names are required")
+		return
+	}
+	if networkResources, err = self.GetNetworkResources(tctx, names); err != nil {
+		return
+	}
+	foundNames := []string{}
+	for _, r := range networkResources {
+		foundNames = append(foundNames, r.Spec.Name)
+	}
+	if err = checkNotFoundNames(KindNetwork, names, foundNames); err != nil {
+		return
+	}
+
+	isNatEnabled := false
+	for _, r := range networkResources {
+		network := r.Spec
+		err = self.sqlClient.Transact(tctx, func(tx *gorm.DB) (err error) {
+			var vms []Vm
+			if err = tx.Table("network_ports AS p").Select("DISTINCT v.name").
+				Joins("INNER JOIN vms AS v ON p.vm_id = v.id").
+				Where("v.deleted_at IS NULL").Where("p.network_id = ?", network.Id).Scan(&vms).Error; err != nil {
+				return
+			}
+			if len(vms) > 0 {
+				vmNames := []string{}
+				for _, vm := range vms {
+					vmNames = append(vmNames, vm.Name)
+				}
+				err = errors.NewConflictErrorf("network is used by vms: name=%s, vms=%s", network.Name, strings.Join(vmNames, ","))
+				return
+			}
+			if err = tx.Table("networks").Where("id = ?", network.Id).Updates(map[string]interface{}{
+				"deleted_at": time.Now(),
+			}).Error; err != nil {
+				return
+			}
+			return
+		})
+		if err != nil {
+			return
+		}
+		logger.Infof(tctx, "deleted network: name=%s", network.Name)
+
+		if network.Kind == KindNetworkLocal {
+			var networkLocalSpec NetworkLocalSpec
+			if err = json.Unmarshal([]byte(network.SpecStr), &networkLocalSpec); err != nil {
+				return
+			}
+			isNatEnabled = isNatEnabled || networkLocalSpec.Nat.Enable
+		}
+	}
+
+	// NATのルールが残らないようにする
+	if isNatEnabled {
+		if err = self.ReconcileNat(tctx); err != nil {
+			return
+		}
+	}
 	return
 }
@@ -0,0 +1,252 @@
+package virt_utils
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syunkitada/goapp2/pkg/lib/dhcp_utils"
+	"github.com/syunkitada/goapp2/pkg/lib/dns_utils"
+	"github.com/syunkitada/goapp2/pkg/lib/logger"
+	"github.com/syunkitada/goapp2/pkg/lib/netlink_utils"
+	"github.com/syunkitada/goapp2/pkg/lib/runner"
+)
+
+type NetworkServiceControllerConfig struct {
+	runner.Config
+}
+
+// NetworkServiceController は、VMのnetnsごとにDHCPサーバとDNSサーバを起動する
+// 定期的にDBとnetnsを確認し、VMの起動、削除やDNSのレコードの変更を反映する
+type NetworkServiceController struct {
+	runner.Runner
+	networkServiceRunner *NetworkServiceRunner
+}
+
+func (self *VirtController) NewNetworkServiceController(conf *NetworkServiceControllerConfig) (networkServiceController *NetworkServiceController) {
+	networkServiceRunner := &NetworkServiceRunner{
+		virtController: self,
+		dhcpServers:    map[string]*netnsDhcpServer{},
+		dnsZone:        dns_utils.NewZone(self.conf.Dns.Suffix, self.conf.Dns.Ttl),
+		dnsServers:     map[string]*netnsDnsServer{},
+	}
+	networkServiceController = &NetworkServiceController{
+		Runner:               *runner.New(&conf.Config, networkServiceRunner),
+		networkServiceRunner: networkServiceRunner,
+	}
+	return
+}
+
+// Start は、起動時に一度反映してから、定期的な反映を開始する
+func (self *NetworkServiceController) Start() {
+	self.networkServiceRunner.Run(time.Now())
+	self.Runner.Start()
+	self.networkServiceRunner.Close()
+}
+
+type NetworkServiceRunner struct {
+	virtController *VirtController
+	mutex          sync.Mutex
+	dhcpServers    map[string]*netnsDhcpServer // キーはnetns名
+	// 全てのnetnsのDNSサーバで共有し、Reconcileのたびにレコードを入れ替える
+	dnsZone    *dns_utils.Zone
+	dnsServers map[string]*netnsDnsServer // キーはnetns名
+}
+
+type netnsDhcpServer struct {
+	conn     net.PacketConn
+	serverIp string
+}
+
+type netnsDnsServer struct {
+	conn net.PacketConn
+	// 変更を検知するための、サービスのIPと転送先
+	key string
+}
+
+func (self *NetworkServiceRunner) Run(runAt time.Time) {
+	tctx := logger.NewTraceContext()
+	if err := self.Reconcile(tctx); err != nil {
+		logger.Errorf(tctx, "Failed reconcile network services: err=%s", err.Error())
+	}
+}
+
+func (self *NetworkServiceRunner) StopTimeout() {
+	self.Close()
+}
+
+// Close は、全てのサーバを停止する
+func (self *NetworkServiceRunner) Close() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	for netnsName, dhcpServer := range self.dhcpServers {
+		dhcpServer.conn.Close()
+		delete(self.dhcpServers, netnsName)
+	}
+	for netnsName, dnsServer := range self.dnsServers {
+		dnsServer.conn.Close()
+		delete(self.dnsServers, netnsName)
+	}
+}
+
+// Reconcile は、ポートが割り当てられていて作成済みのnetnsでDHCPサーバとDNSサーバを起動し、不要になったサーバを停止する
+// DNSのレコードもDBから読み直し、変更があれば反映する
+func (self *NetworkServiceRunner) Reconcile(tctx *logger.TraceContext) (err error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	var records map[string][]net.IP
+	if records, err = self.virtController.GetDnsRecords(); err != nil {
+		return
+	}
+	if self.dnsZone.SetRecords(records) {
+		logger.Infof(tctx, "reloaded dns records: suffix=%s, records=%d", self.dnsZone.Suffix, len(records))
+	}
+
+	var ports []dhcpPort
+	if err = self.virtController.sqlClient.DB.Table("network_ports AS p").
+		Select("p.*, n.gateway as gateway, n.spec as network_spec_str").
+		Joins("INNER JOIN vms AS v ON p.vm_id = v.id").
+		Joins("INNER JOIN networks AS n ON p.network_id = n.id").
+		Where("v.deleted_at IS NULL").Where("n.kind = ?", KindNetworkLocal).
+		Where("p.netns_name != ''").Scan(&ports).Error; err != nil {
+		return
+	}
+	var netnsNames []string
+	if netnsNames, err = netlink_utils.ListNetns(); err != nil {
+		return
+	}
+	netnsSet := map[string]bool{}
+	for _, netnsName := range netnsNames {
+		netnsSet[netnsName] = true
+	}
+
+	// netnsごとのサーバのIP(VMのゲートウェイ)
+	netnsServerIpMap := map[string]string{}
+	// netnsごとのDNSサーバのIPと転送先(ネットワークのResolvers)
+	netnsDnsMap := map[string]netnsDns{}
+	for _, port := range ports {
+		if !netnsSet[port.NetnsName] {
+			continue
+		}
+		netnsServerIpMap[port.NetnsName] = port.Gateway
+		var networkLocalSpec NetworkLocalSpec
+		if err = json.Unmarshal([]byte(port.NetworkSpecStr), &networkLocalSpec); err != nil {
+			return
+		}
+		dns := netnsDns{serviceIp: networkLocalSpec.ServiceIp()}
+		for _, resolver := range networkLocalSpec.Resolvers {
+			dns.forwarders = append(dns.forwarders, resolver.Resolver)
+		}
+		netnsDnsMap[port.NetnsName] = dns
+	}
+
+	for netnsName, dhcpServer := range self.dhcpServers {
+		if serverIp, ok := netnsServerIpMap[netnsName]; ok && serverIp == dhcpServer.serverIp {
+			continue
+		}
+		dhcpServer.conn.Close()
+		delete(self.dhcpServers, netnsName)
+		logger.Infof(tctx, "stopped dhcp server: netns=%s", netnsName)
+	}
+
+	for netnsName, serverIp := range netnsServerIpMap {
+		if _, ok := self.dhcpServers[netnsName]; ok {
+			continue
+		}
+		// 他のnetnsのサーバの起動は続ける
+		if tmpErr := self.startDhcpServer(tctx, netnsName, serverIp); tmpErr != nil {
+			logger.Errorf(tctx, "Failed start dhcp server: netns=%s, err=%s", netnsName, tmpErr.Error())
+		}
+	}
+
+	for netnsName, dnsServer := range self.dnsServers {
+		if dns, ok := netnsDnsMap[netnsName]; ok && dns.key() == dnsServer.key {
+			continue
+		}
+		dnsServer.conn.Close()
+		delete(self.dnsServers, netnsName)
+		logger.Infof(tctx, "stopped dns server: netns=%s", netnsName)
+	}
+
+	for netnsName, dns := range netnsDnsMap {
+		if _, ok := self.dnsServers[netnsName]; ok {
+			continue
+		}
+		if tmpErr := self.startDnsServer(tctx, netnsName, dns); tmpErr != nil {
+			logger.Errorf(tctx, "Failed start dns server: netns=%s, err=%s", netnsName, tmpErr.Error())
+		}
+	}
+	return
+}
+
+type netnsDns struct {
+	serviceIp  string
+	forwarders []string
+}
+
+func (self netnsDns) key() string {
+	return self.serviceIp + "," + strings.Join(self.forwarders, ",")
+}
+
+// startDnsServer は、netns内のサービスのIPで問い合わせを受信するDNSサーバを起動する
+// 転送は、このプロセスのnetns(ホスト)から行う
+func (self *NetworkServiceRunner) startDnsServer(tctx *logger.TraceContext, netnsName string, dns netnsDns) (err error) {
+	var conn net.PacketConn
+	if err = netlink_utils.RunInNetns(netlink_utils.NetnsPath(netnsName), func() (err error) {
+		conn, err = net.ListenPacket("udp4", net.JoinHostPort(dns.serviceIp, strconv.Itoa(dns_utils.Port)))
+		return
+	}); err != nil {
+		return
+	}
+
+	server := &dns_utils.Server{
+		Zone:       self.dnsZone,
+		Forwarders: dns.forwarders,
+	}
+	self.dnsServers[netnsName] = &netnsDnsServer{
+		conn: conn,
+		key:  dns.key(),
+	}
+	go func() {
+		if err := server.Serve(tctx, conn); err != nil {
+			logger.Errorf(tctx, "Failed serve dns: netns=%s, err=%s", netnsName, err.Error())
+		}
+	}()
+	logger.Infof(tctx, "started dns server: netns=%s, serviceIp=%s, forwarders=%s",
+		netnsName, dns.serviceIp, strings.Join(dns.forwarders, ","))
+	return
+}
+
+// startDhcpServer は、netns内のブリッジでリクエストを受信するDHCPサーバを起動する
+// ソケットは作成したnetnsに属するので、受信はこのプロセスのnetnsに関わらず行える
+func (self *NetworkServiceRunner) startDhcpServer(tctx *logger.TraceContext, netnsName string, serverIp string) (err error) {
+	var conn net.PacketConn
+	if err = netlink_utils.RunInNetns(netlink_utils.NetnsPath(netnsName), func() (err error) {
+		conn, err = dhcp_utils.ListenInterface(netnsName + "-br")
+		return
+	}); err != nil {
+		return
+	}
+
+	server := &dhcp_utils.Server{
+		ServerIp: net.ParseIP(serverIp),
+		LeaseFunc: func(mac net.HardwareAddr) (*dhcp_utils.Lease, error) {
+			return self.virtController.GetDhcpLease(netnsName, mac)
+		},
+	}
+	self.dhcpServers[netnsName] = &netnsDhcpServer{
+		conn:     conn,
+		serverIp: serverIp,
+	}
+	go func() {
+		if err := server.Serve(tctx, conn); err != nil {
+			logger.Errorf(tctx, "Failed serve dhcp: netns=%s, err=%s", netnsName, err.Error())
+		}
+	}()
+	logger.Infof(tctx, "started dhcp server: netns=%s, serverIp=%s", netnsName, serverIp)
+	return
+}
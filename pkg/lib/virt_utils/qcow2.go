@@ -0,0 +1,259 @@
+package virt_utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// qcow2の仕様: https://gitlab.com/qemu-project/qemu/-/blob/master/docs/interop/qcow2.txt
+const (
+	qcow2Magic             = 0x514649fb // "QFI\xfb"
+	qcow2Version           = 3
+	qcow2ClusterBits       = 16 // 64KiB (qemu-imgのデフォルト)
+	qcow2ClusterSize       = 1 << qcow2ClusterBits
+	qcow2RefcountOrder     = 4 // 16bit
+	qcow2HeaderLength      = 104
+	qcow2ExtBackingFormat  = 0xe2792aca
+	qcow2ExtEnd            = 0
+	qcow2L2Entries         = qcow2ClusterSize / 8
+	qcow2RefcountBlockSize = qcow2ClusterSize * 8 / (1 << qcow2RefcountOrder)
+
+	ImageFormatQcow2 = "qcow2"
+	ImageFormatRaw   = "raw"
+)
+
+// Qcow2Header は、qcow2(version 3)のヘッダ、全てビッグエンディアン
+type Qcow2Header struct {
+	Magic                 uint32
+	Version               uint32
+	BackingFileOffset     uint64
+	BackingFileSize       uint32
+	ClusterBits           uint32
+	Size                  uint64 // 仮想ディスクのサイズ(バイト)
+	CryptMethod           uint32
+	L1Size                uint32
+	L1TableOffset         uint64
+	RefcountTableOffset   uint64
+	RefcountTableClusters uint32
+	NbSnapshots           uint32
+	SnapshotsOffset       uint64
+	IncompatibleFeatures  uint64
+	CompatibleFeatures    uint64
+	AutoclearFeatures     uint64
+	RefcountOrder         uint32
+	HeaderLength          uint32
+}
+
+// Qcow2Image は、ReadQcow2Imageで読み込んだヘッダとバッキングファイルの情報
+type Qcow2Image struct {
+	Header        Qcow2Header
+	BackingFile   string
+	BackingFormat string
+}
+
+// CreateQcow2Overlay は、backingPathをバッキングファイルとするqcow2のオーバーレイディスクを作成する
+// qemu-img create -f qcow2 -F [backingFormat] -b [backingPath] [path] [size] と同等
+//
+// クラスタの配置は、qemu-imgと同じく以下とする
+// 0: ヘッダ, ヘッダ拡張, バッキングファイル名
+// 1: リフカウントテーブル
+// 2: リフカウントブロック
+// 3-: L1テーブル(全て未割当)
+func CreateQcow2Overlay(path string, backingPath string, backingFormat string, size uint64) (err error) {
+	l1Size := (size + qcow2ClusterSize*qcow2L2Entries - 1) / (qcow2ClusterSize * qcow2L2Entries)
+	l1Clusters := (l1Size*8 + qcow2ClusterSize - 1) / qcow2ClusterSize
+	if l1Clusters == 0 {
+		l1Clusters = 1
+	}
+	totalClusters := 3 + l1Clusters
+	if totalClusters > qcow2RefcountBlockSize {
+		err = fmt.Errorf("Invalid qcow2 size: size=%d", size)
+		return
+	}
+
+	header := Qcow2Header{
+		Magic:                 qcow2Magic,
+		Version:               qcow2Version,
+		ClusterBits:           qcow2ClusterBits,
+		Size:                  size,
+		L1Size:                uint32(l1Size),
+		L1TableOffset:         3 * qcow2ClusterSize,
+		RefcountTableOffset:   1 * qcow2ClusterSize,
+		RefcountTableClusters: 1,
+		RefcountOrder:         qcow2RefcountOrder,
+		HeaderLength:          qcow2HeaderLength,
+	}
+
+	var exts bytes.Buffer
+	if backingFormat != "" {
+		writeQcow2Ext(&exts, qcow2ExtBackingFormat, []byte(backingFormat))
+	}
+	writeQcow2Ext(&exts, qcow2ExtEnd, nil)
+	if backingPath != "" {
+		header.BackingFileOffset = qcow2HeaderLength + uint64(exts.Len())
+		header.BackingFileSize = uint32(len(backingPath))
+	}
+
+	cluster0 := bytes.NewBuffer(make([]byte, 0, qcow2ClusterSize))
+	if err = binary.Write(cluster0, binary.BigEndian, &header); err != nil {
+		return
+	}
+	cluster0.Write(exts.Bytes())
+	cluster0.WriteString(backingPath)
+	if cluster0.Len() > qcow2ClusterSize {
+		err = fmt.Errorf("Invalid qcow2 backing file: too long path=%s", backingPath)
+		return
+	}
+
+	// リフカウントテーブルの最初のエントリのみ、クラスタ2のリフカウントブロックを指す
+	refcountTable := make([]byte, 8)
+	binary.BigEndian.PutUint64(refcountTable, 2*qcow2ClusterSize)
+
+	// メタデータのクラスタのみ参照されている
+	refcountBlock := make([]byte, totalClusters*2)
+	for i := uint64(0); i < totalClusters; i++ {
+		binary.BigEndian.PutUint16(refcountBlock[i*2:], 1)
+	}
+
+	// 途中で失敗したファイルを残さないように、一時ファイルに書き込んでからリネームする
+	tmpPath := path + ".tmp"
+	var file *os.File
+	if file, err = os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644); err != nil {
+		return
+	}
+	defer func() {
+		file.Close()
+		if err != nil {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err = file.WriteAt(cluster0.Bytes(), 0); err != nil {
+		return
+	}
+	if _, err = file.WriteAt(refcountTable, 1*qcow2ClusterSize); err != nil {
+		return
+	}
+	if _, err = file.WriteAt(refcountBlock, 2*qcow2ClusterSize); err != nil {
+		return
+	}
+	// L1テーブルは全て0なので、ファイルサイズを伸ばすだけでよい
+	if err = file.Truncate(int64(totalClusters * qcow2ClusterSize)); err != nil {
+		return
+	}
+	if err = file.Sync(); err != nil {
+		return
+	}
+	if err = file.Close(); err != nil {
+		return
+	}
+	err = os.Rename(tmpPath, path)
+	return
+}
+
+func writeQcow2Ext(buf *bytes.Buffer, extType uint32, data []byte) {
+	binary.Write(buf, binary.BigEndian, extType)
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.Write(data)
+	// データは8バイト境界までパディングする
+	if padding := (8 - len(data)%8) % 8; padding > 0 {
+		buf.Write(make([]byte, padding))
+	}
+}
+
+// ReadQcow2Image は、qcow2のヘッダとバッキングファイルの情報を読み込む
+func ReadQcow2Image(path string) (image *Qcow2Image, err error) {
+	var file *os.File
+	if file, err = os.Open(path); err != nil {
+		return
+	}
+	defer file.Close()
+
+	image = &Qcow2Image{}
+	header := &image.Header
+	if err = binary.Read(file, binary.BigEndian, &header.Magic); err != nil {
+		return
+	}
+	if header.Magic != qcow2Magic {
+		err = fmt.Errorf("Invalid qcow2 magic: path=%s", path)
+		return
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	if err = binary.Read(file, binary.BigEndian, header); err != nil {
+		return
+	}
+	if header.Version < 3 {
+		// version 2のヘッダは72バイトで、以降のフィールドはない
+		header.IncompatibleFeatures = 0
+		header.CompatibleFeatures = 0
+		header.AutoclearFeatures = 0
+		header.RefcountOrder = 4
+		header.HeaderLength = 72
+	}
+
+	// ヘッダ拡張は、ヘッダの直後からバッキングファイル名(または最初のクラスタの終わり)まで続く
+	offset := int64(header.HeaderLength)
+	for offset < int64(1)<<header.ClusterBits {
+		var ext [2]uint32
+		if _, err = file.Seek(offset, io.SeekStart); err != nil {
+			return
+		}
+		if err = binary.Read(file, binary.BigEndian, &ext); err != nil {
+			return
+		}
+		if ext[0] == qcow2ExtEnd {
+			break
+		}
+		data := make([]byte, ext[1])
+		if _, err = io.ReadFull(file, data); err != nil {
+			return
+		}
+		if ext[0] == qcow2ExtBackingFormat {
+			image.BackingFormat = string(data)
+		}
+		offset += 8 + int64((ext[1]+7)/8*8)
+	}
+
+	if header.BackingFileOffset > 0 {
+		data := make([]byte, header.BackingFileSize)
+		if _, err = file.ReadAt(data, int64(header.BackingFileOffset)); err != nil {
+			return
+		}
+		image.BackingFile = string(data)
+	}
+	return
+}
+
+// GetImageFormat は、イメージのフォーマット(qcow2かraw)と仮想ディスクのサイズを返す
+func GetImageFormat(path string) (format string, size uint64, err error) {
+	var file *os.File
+	if file, err = os.Open(path); err != nil {
+		return
+	}
+	var magic uint32
+	tmpErr := binary.Read(file, binary.BigEndian, &magic)
+	var stat os.FileInfo
+	stat, err = file.Stat()
+	file.Close()
+	if err != nil {
+		return
+	}
+
+	if tmpErr == nil && magic == qcow2Magic {
+		var image *Qcow2Image
+		if image, err = ReadQcow2Image(path); err != nil {
+			return
+		}
+		format = ImageFormatQcow2
+		size = image.Header.Size
+		return
+	}
+	format = ImageFormatRaw
+	size = uint64(stat.Size())
+	return
+}
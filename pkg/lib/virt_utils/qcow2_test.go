@@ -0,0 +1,81 @@
+package virt_utils
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateQcow2Overlay(t *testing.T) {
+	a := assert.New(t)
+	tmpDir := t.TempDir()
+
+	backingPath := filepath.Join(tmpDir, "image.raw")
+	a.NoError(ioutil.WriteFile(backingPath, make([]byte, 1024*1024), 0644))
+	format, size, err := GetImageFormat(backingPath)
+	a.NoError(err)
+	a.Equal(ImageFormatRaw, format)
+	a.Equal(uint64(1024*1024), size)
+
+	overlayPath := filepath.Join(tmpDir, "disk.qcow2")
+	a.NoError(CreateQcow2Overlay(overlayPath, backingPath, format, 10*gib))
+
+	image, err := ReadQcow2Image(overlayPath)
+	a.NoError(err)
+	a.Equal(Qcow2Header{
+		Magic:                 qcow2Magic,
+		Version:               3,
+		BackingFileOffset:     104 + 16 + 8, // ヘッダ, backing formatの拡張(8+8), 終端の拡張(8)
+		BackingFileSize:       uint32(len(backingPath)),
+		ClusterBits:           16,
+		Size:                  10 * gib,
+		L1Size:                20, // L1の1エントリで512MiB
+		L1TableOffset:         0x30000,
+		RefcountTableOffset:   0x10000,
+		RefcountTableClusters: 1,
+		RefcountOrder:         4,
+		HeaderLength:          104,
+	}, image.Header)
+	a.Equal(backingPath, image.BackingFile)
+	a.Equal(ImageFormatRaw, image.BackingFormat)
+
+	data, err := ioutil.ReadFile(overlayPath)
+	a.NoError(err)
+	a.Len(data, 4*qcow2ClusterSize)
+	a.Equal(uint64(0x20000), binary.BigEndian.Uint64(data[0x10000:]))
+	for i := 0; i < 4; i++ {
+		a.Equal(uint16(1), binary.BigEndian.Uint16(data[0x20000+i*2:]))
+	}
+	a.Equal(uint16(0), binary.BigEndian.Uint16(data[0x20000+4*2:]))
+	a.Equal(make([]byte, qcow2ClusterSize), data[0x30000:])
+	_, err = os.Stat(overlayPath + ".tmp")
+	a.True(os.IsNotExist(err))
+
+	// qcow2をバッキングファイルとする場合は、仮想ディスクのサイズを返す
+	format, size, err = GetImageFormat(overlayPath)
+	a.NoError(err)
+	a.Equal(ImageFormatQcow2, format)
+	a.Equal(uint64(10*gib), size)
+
+	{
+		// L1テーブルが複数のクラスタになる場合(1エントリ512MiB、1クラスタ8192エントリ)
+		overlayPath := filepath.Join(tmpDir, "large.qcow2")
+		a.NoError(CreateQcow2Overlay(overlayPath, overlayPath, ImageFormatQcow2, 8192*512*1024*1024+1))
+		image, err := ReadQcow2Image(overlayPath)
+		a.NoError(err)
+		a.Equal(uint32(8193), image.Header.L1Size)
+		stat, err := os.Stat(overlayPath)
+		a.NoError(err)
+		a.Equal(int64(5*qcow2ClusterSize), stat.Size())
+	}
+
+	{
+		// qcow2ではない
+		_, err := ReadQcow2Image(backingPath)
+		a.Error(err)
+	}
+}
@@ -1,31 +1,41 @@
 package virt_utils
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
-	"gopkg.in/yaml.v3"
 
 	"github.com/syunkitada/goapp2/pkg/lib/db_utils"
+	"github.com/syunkitada/goapp2/pkg/lib/errors"
 	"github.com/syunkitada/goapp2/pkg/lib/logger"
+	"github.com/syunkitada/goapp2/pkg/lib/os_cmds"
 	"github.com/syunkitada/goapp2/pkg/lib/str_utils"
+	"github.com/syunkitada/goapp2/pkg/lib/struct_utils"
 )
 
 type VirtController struct {
-	conf      VirtControllerConfig
-	sqlClient *db_utils.SqlClient
-	validate  *validator.Validate
+	conf       VirtControllerConfig
+	sqlClient  *db_utils.SqlClient
+	validate   *validator.Validate
+	hypervisor HypervisorDriver
 
 	imagesDir string
 	vmsDir    string
+
+	// テストでは、nftを実行しないように差し替える
+	applyNftables func(tctx *logger.TraceContext, path string) error
 }
 
 var virtControllerConf = VirtControllerConfig{
 	VarDir: "",
+	Dns: DnsConfig{
+		Suffix: "vm.internal",
+		Ttl:    30 * time.Second,
+	},
 }
 
 func init() {
@@ -34,22 +44,30 @@ func init() {
 }
 
 type VirtControllerConfig struct {
-	VarDir   string
-	Database db_utils.Config
+	VarDir     string `json:",omitempty"`
+	Database   db_utils.Config
+	Hypervisor HypervisorConfig
+	Dns        DnsConfig
 }
 
 func NewVirtContoller(conf *VirtControllerConfig) (virtController *VirtController) {
-	imagesDir := filepath.Join(virtControllerConf.VarDir, "images")
-	vmsDir := filepath.Join(virtControllerConf.VarDir, "vms")
+	// デフォルト値を共有しないように、コピーに指定された値をマージする
+	mergedConf := virtControllerConf
+	struct_utils.MergeStruct(&mergedConf, conf)
+
+	imagesDir := filepath.Join(mergedConf.VarDir, "images")
+	vmsDir := filepath.Join(mergedConf.VarDir, "vms")
 
-	sqlClient := db_utils.NewSqlClient(&virtControllerConf.Database)
+	sqlClient := db_utils.NewSqlClient(&mergedConf.Database)
 
 	return &VirtController{
-		conf:      virtControllerConf,
+		conf:      mergedConf,
 		sqlClient: sqlClient,
 		validate:  validator.New(),
 		imagesDir: imagesDir,
 		vmsDir:    vmsDir,
+
+		applyNftables: os_cmds.ApplyNftables,
 	}
 }
 
@@ -83,6 +101,8 @@ const (
 	KindVm      = "vm"
 	KindImage   = "image"
 	KindNetwork = "network"
+	// VMごとの転送ポート、getのみ
+	KindPortForward = "portforward"
 )
 
 type Resource struct {
@@ -91,66 +111,11 @@ type Resource struct {
 	Spec interface{}
 }
 
-func (self *VirtController) Create(tctx *logger.TraceContext, resourcesBytes [][]byte) (err error) {
-	var vms []VmSpec
-	var networks []NetworkSpec
-	var images []ImageSpec
-	for _, resourceBytes := range resourcesBytes {
-		var resource Resource
-		if err = yaml.Unmarshal(resourceBytes, &resource); err != nil {
-			return
-		}
-		var bytes []byte
-		if bytes, err = json.Marshal(resource.Spec); err != nil {
-			return
-		}
-		switch resource.Kind {
-		case KindVm:
-			var spec VmSpec
-			if err = json.Unmarshal(bytes, &spec); err != nil {
-				return
-			}
-			vms = append(vms, spec)
-		case KindNetwork:
-			var spec NetworkSpec
-			if err = json.Unmarshal(bytes, &spec); err != nil {
-				return
-			}
-			networks = append(networks, spec)
-		case KindImage:
-			var spec ImageSpec
-			if err = json.Unmarshal(bytes, &spec); err != nil {
-				return
-			}
-			images = append(images, spec)
-		}
-	}
-
-	for i := range networks {
-		if err = self.CreateOrUpdateNetwork(tctx, &networks[i]); err != nil {
-			return
-		}
-	}
-
-	for i := range images {
-		if err = self.CreateOrUpdateImage(tctx, &images[i]); err != nil {
-			return
-		}
-	}
-
-	for i := range vms {
-		if err = self.CreateOrUpdateVm(tctx, &vms[i]); err != nil {
-			return
-		}
-	}
-	return
-
-}
-
 type GetResult struct {
-	Vms      VmResources
-	Networks NetworkResources
-	Images   ImageResources
+	Vms          VmResources
+	Networks     NetworkResources
+	Images       ImageResources
+	PortForwards PortForwardResources
 }
 
 func (self *GetResult) Output(format string) {
@@ -166,6 +131,9 @@ func (self *GetResult) Output(format string) {
 		for _, data := range self.Networks {
 			outputs = str_utils.AppendOutputByFormat(outputs, data, format)
 		}
+		for _, data := range self.PortForwards {
+			outputs = str_utils.AppendOutputByFormat(outputs, data, format)
+		}
 		fmt.Println(strings.Join(outputs, "\n---\n\n"))
 	default:
 		if len(self.Vms) > 0 {
@@ -177,6 +145,9 @@ func (self *GetResult) Output(format string) {
 		if len(self.Networks) > 0 {
 			str_utils.OutputByFormat(self.Networks, format)
 		}
+		if len(self.PortForwards) > 0 {
+			str_utils.OutputByFormat(self.PortForwards, format)
+		}
 	}
 
 }
@@ -185,6 +156,7 @@ func (self *VirtController) Get(tctx *logger.TraceContext, kind string, args []s
 	var vms VmResources
 	var networks NetworkResources
 	var images ImageResources
+	var portForwards PortForwardResources
 
 	switch kind {
 	case KindAll:
@@ -209,12 +181,21 @@ func (self *VirtController) Get(tctx *logger.TraceContext, kind string, args []s
 		if images, err = self.GetImageResources(tctx, args); err != nil {
 			return
 		}
+	case KindPortForward:
+		// 引数は、VM名
+		if portForwards, err = self.GetPortForwardResources(tctx, args); err != nil {
+			return
+		}
+	default:
+		err = errors.NewBadInputErrorf("unsupported kind: %s", kind)
+		return
 	}
 
 	result = &GetResult{
-		Vms:      vms,
-		Networks: networks,
-		Images:   images,
+		Vms:          vms,
+		Networks:     networks,
+		Images:       images,
+		PortForwards: portForwards,
 	}
 	return
 }
@@ -227,6 +208,28 @@ func (self *VirtController) Start(tctx *logger.TraceContext, kind string, args [
 		if vms, err = self.StartVmResources(tctx, args); err != nil {
 			return
 		}
+	default:
+		err = errors.NewBadInputErrorf("unsupported kind: %s", kind)
+		return
+	}
+
+	result = &GetResult{
+		Vms: vms,
+	}
+	return
+}
+
+func (self *VirtController) Stop(tctx *logger.TraceContext, kind string, args []string) (result *GetResult, err error) {
+	var vms VmResources
+
+	switch kind {
+	case KindVm:
+		if vms, err = self.StopVmResources(tctx, args); err != nil {
+			return
+		}
+	default:
+		err = errors.NewBadInputErrorf("unsupported kind: %s", kind)
+		return
 	}
 
 	result = &GetResult{
@@ -234,3 +237,71 @@ func (self *VirtController) Start(tctx *logger.TraceContext, kind string, args [
 	}
 	return
 }
+
+func (self *VirtController) Restart(tctx *logger.TraceContext, kind string, args []string) (result *GetResult, err error) {
+	var vms VmResources
+
+	switch kind {
+	case KindVm:
+		if vms, err = self.RestartVmResources(tctx, args); err != nil {
+			return
+		}
+	default:
+		err = errors.NewBadInputErrorf("unsupported kind: %s", kind)
+		return
+	}
+
+	result = &GetResult{
+		Vms: vms,
+	}
+	return
+}
+
+func (self *VirtController) Delete(tctx *logger.TraceContext, kind string, args []string) (result *GetResult, err error) {
+	var vms VmResources
+	var networks NetworkResources
+	var images ImageResources
+
+	switch kind {
+	case KindVm:
+		if vms, err = self.DeleteVmResources(tctx, args); err != nil {
+			return
+		}
+	case KindNetwork:
+		if networks, err = self.DeleteNetworkResources(tctx, args); err != nil {
+			return
+		}
+	case KindImage:
+		if images, err = self.DeleteImageResources(tctx, args); err != nil {
+			return
+		}
+	default:
+		err = errors.NewBadInputErrorf("unsupported kind: %s", kind)
+		return
+	}
+
+	result = &GetResult{
+		Vms:      vms,
+		Networks: networks,
+		Images:   images,
+	}
+	return
+}
+
+// checkNotFoundNames は、namesのうちfoundNamesに含まれないものがあればNotFoundErrorを返す
+func checkNotFoundNames(kind string, names []string, foundNames []string) (err error) {
+	foundSet := map[string]bool{}
+	for _, name := range foundNames {
+		foundSet[name] = true
+	}
+	notFoundNames := []string{}
+	for _, name := range names {
+		if !foundSet[name] {
+			notFoundNames = append(notFoundNames, name)
+		}
+	}
+	if len(notFoundNames) > 0 {
+		err = errors.NewNotFoundErrorf("%s is not found: names=%s", kind, strings.Join(notFoundNames, ","))
+	}
+	return
+}
@@ -3,6 +3,8 @@ package virt_utils
 import (
 	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -11,6 +13,7 @@ import (
 
 	"github.com/syunkitada/goapp2/pkg/lib/errors"
 	"github.com/syunkitada/goapp2/pkg/lib/logger"
+	"github.com/syunkitada/goapp2/pkg/lib/netlink_utils"
 	"github.com/syunkitada/goapp2/pkg/lib/str_utils"
 )
 
@@ -50,6 +53,7 @@ type VmSpec struct {
 	DiskGb    uint                `gorm:"not null;" validate:"required"`
 	Image     ImageDetectSpec     `gorm:"-"`
 	Networks  []NetworkDetectSpec `gorm:"-"`
+	UserData  string              `gorm:"not null;default:''"` // cloud-initのuser-data、空の場合は#cloud-configのみとする
 	Spec      interface{}         `gorm:"-"`
 }
 
@@ -61,6 +65,8 @@ type Vm struct {
 	ImageId      uint            `gorm:"not null;`
 	SpecStr      string          `gorm:"not null;column:spec" json:"-"`
 	Status       string          `gorm:"not null;"`
+	DiskPath     string          `gorm:"not null;default:''"` // PrepareDisksで作成したオーバーレイディスク
+	DiskBytes    uint64          `gorm:"not null;default:0"`
 	NetworkPorts []VmNetworkPort `gorm:"-"`
 }
 
@@ -84,26 +90,8 @@ func (self *VirtController) BootstrapVm(tctx *logger.TraceContext) (err error) {
 }
 
 func (self *VirtController) CreateOrUpdateVm(tctx *logger.TraceContext, spec *VmSpec) (err error) {
-	if err = self.validate.Struct(spec); err != nil {
-		return
-	}
-
 	var specBytes []byte
-	if specBytes, err = json.Marshal(spec.Spec); err != nil {
-		return
-	}
-
-	var vmQemuSpec VmQemuSpec
-	switch spec.Kind {
-	case KindVmQemu:
-		if err = json.Unmarshal(specBytes, &vmQemuSpec); err != nil {
-			return
-		}
-		if err = self.validate.Struct(vmQemuSpec); err != nil {
-			return
-		}
-	default:
-		err = errors.NewBadInputErrorf("invalid image kind: kind=%s", spec.Kind)
+	if specBytes, err = self.validateVmSpec(spec); err != nil {
 		return
 	}
 
@@ -119,6 +107,7 @@ func (self *VirtController) CreateOrUpdateVm(tctx *logger.TraceContext, spec *Vm
 				vm := &Vm{
 					VmSpec:  *spec,
 					ImageId: image.Id,
+					SpecStr: string(specBytes),
 					Status:  StatusCreated,
 				}
 				if err = tx.Create(vm).Error; err != nil {
@@ -133,11 +122,51 @@ func (self *VirtController) CreateOrUpdateVm(tctx *logger.TraceContext, spec *Vm
 			})
 		}
 		return
-	} else {
-		if string(specBytes) != vm.Spec {
-			if err = self.sqlClient.DB.Table("vms").Where("id = ?", vm.Id).Updates(map[string]interface{}{
-				"spec": string(specBytes),
-			}).Error; err != nil {
+	}
+
+	// イメージ、ネットワーク、ディスクは作成時に割り当てるので更新しない
+	// 更新した値は、VMの再起動後に反映される
+	if string(specBytes) == vm.SpecStr && spec.Vcpus == vm.Vcpus && spec.MemoryMb == vm.MemoryMb && spec.UserData == vm.UserData {
+		return
+	}
+	if err = self.sqlClient.DB.Table("vms").Where("id = ?", vm.Id).Updates(map[string]interface{}{
+		"vcpus":     spec.Vcpus,
+		"memory_mb": spec.MemoryMb,
+		"spec":      string(specBytes),
+		"user_data": spec.UserData,
+	}).Error; err != nil {
+		return
+	}
+	logger.Infof(tctx, "updated vm: name=%s", spec.Name)
+	return
+}
+
+// validateVmSpec は、specを検証し、保存するSpecStrを返す
+func (self *VirtController) validateVmSpec(spec *VmSpec) (specBytes []byte, err error) {
+	if err = self.validate.Struct(spec); err != nil {
+		return
+	}
+
+	if specBytes, err = json.Marshal(spec.Spec); err != nil {
+		return
+	}
+
+	var vmQemuSpec VmQemuSpec
+	switch spec.Kind {
+	case KindVmQemu:
+		if err = json.Unmarshal(specBytes, &vmQemuSpec); err != nil {
+			return
+		}
+		if err = self.validate.Struct(vmQemuSpec); err != nil {
+			return
+		}
+	default:
+		err = errors.NewBadInputErrorf("invalid vm kind: kind=%s", spec.Kind)
+		return
+	}
+	for _, network := range spec.Networks {
+		for _, portForward := range network.PortForwards {
+			if err = self.validate.Struct(portForward); err != nil {
 				return
 			}
 		}
@@ -147,7 +176,7 @@ func (self *VirtController) CreateOrUpdateVm(tctx *logger.TraceContext, spec *Vm
 
 func (self *VirtController) GetVm(name string) (vm *Vm, err error) {
 	var vms []Vm
-	sql := self.sqlClient.DB.Table("vms").Select("*").Where("deleted_at IS NULL")
+	sql := self.sqlClient.DB.Table("vms").Select("*").Where("deleted_at IS NULL").Where("name = ?", name)
 	if err = sql.Scan(&vms).Error; err != nil {
 		return
 	}
@@ -164,11 +193,11 @@ func (self *VirtController) GetVm(name string) (vm *Vm, err error) {
 
 func (self *VirtController) GetVmResources(tctx *logger.TraceContext, names []string) (vmResources VmResources, err error) {
 	var vms []Vm
-	sql := self.sqlClient.DB.Table("vms AS v").Select("v.*, i.id as image_id, i.namespace as image_namespace, i.name as image_name, i.kind as image_kind, i.spec as image_spec_str").
+	sql := self.sqlClient.DB.Table("vms AS v").Select("v.*, i.id as image_id, i.namespace as image_namespace, i.name as image_name, i.kind as image_kind, i.spec as image_spec_str, i.digest as image_digest").
 		Joins("INNER JOIN images AS i ON v.image_id == i.id").
 		Where("v.deleted_at IS NULL")
 	if len(names) > 0 {
-		sql = sql.Where("name in (?)", names)
+		sql = sql.Where("v.name in (?)", names)
 	}
 	if err = sql.Scan(&vms).Error; err != nil {
 		return
@@ -247,6 +276,8 @@ type netnsPort struct {
 	VmIp         string
 	VmMac        string
 	VmSubnet     string
+	VmGateway    string
+	ServiceIp    string
 	Kind         string
 }
 
@@ -259,10 +290,227 @@ func (self *VirtController) StartVmResources(tctx *logger.TraceContext, names []
 		return
 	}
 
+	if err = self.PrepareDisks(tctx, vmResources); err != nil {
+		return
+	}
+
 	if err = self.PrepareNetworks(tctx, vmResources); err != nil {
 		return
 	}
 
-	// TODO start vm
+	if err = self.PrepareSeeds(tctx, vmResources); err != nil {
+		return
+	}
+
+	for i := range vmResources {
+		if err = self.startVm(tctx, &vmResources[i].Spec); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// startVm は、VMのプロセスが起動していなければ起動し、StatusをRunningにする
+func (self *VirtController) startVm(tctx *logger.TraceContext, vm *Vm) (err error) {
+	var hypervisor HypervisorDriver
+	if hypervisor, err = self.getHypervisor(); err != nil {
+		return
+	}
+
+	hypervisorVm := self.newHypervisorVm(vm)
+	var status string
+	if status, err = hypervisor.Status(tctx, hypervisorVm); err != nil {
+		return
+	}
+	if status != StatusRunning {
+		if err = hypervisor.Start(tctx, hypervisorVm); err != nil {
+			return
+		}
+	}
+
+	err = self.updateVmStatus(vm, StatusRunning)
+	return
+}
+
+func (self *VirtController) getHypervisor() (hypervisor HypervisorDriver, err error) {
+	if self.hypervisor == nil {
+		if self.hypervisor, err = NewHypervisorDriver(&self.conf.Hypervisor, self.conf.VarDir); err != nil {
+			return
+		}
+	}
+	hypervisor = self.hypervisor
+	return
+}
+
+func (self *VirtController) newHypervisorVm(vm *Vm) *HypervisorVm {
+	return &HypervisorVm{
+		Vm:       vm,
+		Dir:      filepath.Join(self.vmsDir, vm.Namespace, vm.Name),
+		DiskPath: filepath.Join(self.vmsDir, vm.Namespace, vm.Name, vmDiskFile),
+		SeedPath: filepath.Join(self.vmsDir, vm.Namespace, vm.Name, vmSeedFile),
+	}
+}
+
+func (self *VirtController) updateVmStatus(vm *Vm, status string) (err error) {
+	if err = self.sqlClient.DB.Table("vms").Where("id = ?", vm.Id).Updates(map[string]interface{}{
+		"status": status,
+	}).Error; err != nil {
+		return
+	}
+	vm.Status = status
+	return
+}
+
+func (self *VirtController) StopVmResources(tctx *logger.TraceContext, names []string) (vmResources VmResources, err error) {
+	if vmResources, err = self.GetVmResources(tctx, names); err != nil {
+		return
+	}
+
+	for i := range vmResources {
+		if err = self.stopVm(tctx, &vmResources[i].Spec); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (self *VirtController) RestartVmResources(tctx *logger.TraceContext, names []string) (vmResources VmResources, err error) {
+	if _, err = self.StopVmResources(tctx, names); err != nil {
+		return
+	}
+	vmResources, err = self.StartVmResources(tctx, names)
+	return
+}
+
+// DeleteVmResources は、VMを停止してから論理削除する
+// network_portsは削除してIPを解放し、割り当てていたnetnsとVMのディレクトリも削除する
+// netnsとディレクトリは論理削除の後に冪等に削除するので、途中で失敗した場合は再実行すれば削除を完了できる
+// (論理削除済みのVMを指定した場合は、netnsとディレクトリの削除のみを行う)
+func (self *VirtController) DeleteVmResources(tctx *logger.TraceContext, names []string) (vmResources VmResources, err error) {
+	if len(names) == 0 {
+		err = errors.NewBadInputErrorf("vm names are required")
+		return
+	}
+	if vmResources, err = self.GetVmResources(tctx, names); err != nil {
+		return
+	}
+	foundNames := []string{}
+	for _, r := range vmResources {
+		foundNames = append(foundNames, r.Spec.Name)
+	}
+	var deletedVms []Vm
+	if err = self.sqlClient.DB.Table("vms").Select("*").Where("deleted_at IS NOT NULL").Where("name in (?)", names).Scan(&deletedVms).Error; err != nil {
+		return
+	}
+	for _, vm := range deletedVms {
+		foundNames = append(foundNames, vm.Name)
+	}
+	if err = checkNotFoundNames(KindVm, names, foundNames); err != nil {
+		return
+	}
+
+	for i := range vmResources {
+		vm := &vmResources[i].Spec
+		// 停止は冪等なので、論理削除に失敗した場合は停止したVMが残るだけとなる
+		if err = self.stopVm(tctx, vm); err != nil {
+			return
+		}
+		var deletedPortForwards int64
+		if err = self.sqlClient.Transact(tctx, func(tx *gorm.DB) (err error) {
+			if err = tx.Table("network_ports").Where("vm_id = ?", vm.Id).Delete(&NetworkPort{}).Error; err != nil {
+				return
+			}
+			result := tx.Table("port_forwards").Where("vm_id = ?", vm.Id).Delete(&PortForward{})
+			if err = result.Error; err != nil {
+				return
+			}
+			deletedPortForwards = result.RowsAffected
+			if err = tx.Table("vms").Where("id = ?", vm.Id).Updates(map[string]interface{}{
+				"deleted_at": time.Now(),
+				"status":     StatusDeleted,
+			}).Error; err != nil {
+				return
+			}
+			return
+		}); err != nil {
+			return
+		}
+		vm.Status = StatusDeleted
+		// 転送ポートのルールが残らないようにする
+		if deletedPortForwards > 0 {
+			if err = self.ReconcileNat(tctx); err != nil {
+				return
+			}
+		}
+		logger.Infof(tctx, "deleted vm: name=%s", vm.Name)
+	}
+
+	err = self.cleanupDeletedVms(tctx)
+	return
+}
+
+// cleanupDeletedVms は、論理削除したVMのnetnsとディレクトリを削除する
+// network_portsは論理削除と同時に削除しているので、netnsはnetwork_portsから参照されていないcom-Nのものを削除する
+func (self *VirtController) cleanupDeletedVms(tctx *logger.TraceContext) (err error) {
+	var netnsNames []string
+	if netnsNames, err = netlink_utils.ListNetns(); err != nil {
+		return
+	}
+	var assignedPorts []NetworkPort
+	if err = self.sqlClient.DB.Table("network_ports").Select("*").Where("netns_name != ''").Scan(&assignedPorts).Error; err != nil {
+		return
+	}
+	assignedNetnsSet := map[string]bool{}
+	for _, port := range assignedPorts {
+		assignedNetnsSet[port.NetnsName] = true
+	}
+	// netnsを削除すると、netns内のデバイスとvethの対向も削除される
+	for _, netnsName := range netnsNames {
+		if !strings.HasPrefix(netnsName, "com-") || assignedNetnsSet[netnsName] {
+			continue
+		}
+		if err = netlink_utils.DeleteNetns(netnsName); err != nil {
+			return
+		}
+		logger.Infof(tctx, "deleted netns: name=%s", netnsName)
+	}
+
+	var vms []Vm
+	if err = self.sqlClient.DB.Table("vms").Select("*").Scan(&vms).Error; err != nil {
+		return
+	}
+	// 同じ名前で作り直したVMのディレクトリは削除しない
+	vmDirSet := map[string]bool{}
+	for i := range vms {
+		if vms[i].DeletedAt == nil {
+			vmDirSet[self.newHypervisorVm(&vms[i]).Dir] = true
+		}
+	}
+	for i := range vms {
+		vmDir := self.newHypervisorVm(&vms[i]).Dir
+		if vms[i].DeletedAt == nil || vmDirSet[vmDir] {
+			continue
+		}
+		if err = os.RemoveAll(vmDir); err != nil {
+			return
+		}
+	}
+
+	err = self.cleanupImageSnapshots(tctx)
+	return
+}
+
+// stopVm は、VMのプロセスが起動していれば停止し、StatusをStoppedにする
+func (self *VirtController) stopVm(tctx *logger.TraceContext, vm *Vm) (err error) {
+	var hypervisor HypervisorDriver
+	if hypervisor, err = self.getHypervisor(); err != nil {
+		return
+	}
+
+	if err = hypervisor.Stop(tctx, self.newHypervisorVm(vm)); err != nil {
+		return
+	}
+
+	err = self.updateVmStatus(vm, StatusStopped)
 	return
 }
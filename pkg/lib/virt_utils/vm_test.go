@@ -0,0 +1,219 @@
+package virt_utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/syunkitada/goapp2/pkg/lib/errors"
+	"github.com/syunkitada/goapp2/pkg/lib/logger"
+	"github.com/syunkitada/goapp2/pkg/lib/netlink_utils"
+)
+
+func TestStopAndDeleteVmResources(t *testing.T) {
+	a := assert.New(t)
+	virtController := newTestVirtController(t)
+	tctx := logger.NewTraceContext()
+
+	// 削除したVMのnetnsは、マウントしていないファイルで代用する
+	netnsDir := netlink_utils.NetnsDir
+	defer func() { netlink_utils.NetnsDir = netnsDir }()
+	netlink_utils.NetnsDir = t.TempDir()
+	for _, name := range []string{"com-9", "other"} {
+		a.NoError(ioutil.WriteFile(filepath.Join(netlink_utils.NetnsDir, name), []byte{}, 0644))
+	}
+
+	vmResources, err := virtController.GetVmResources(tctx, []string{"vm1"})
+	a.NoError(err)
+	a.Len(vmResources, 1)
+	a.NoError(virtController.startVm(tctx, &vmResources[0].Spec))
+	vmDir := virtController.newHypervisorVm(&vmResources[0].Spec).Dir
+
+	vmResources, err = virtController.StopVmResources(tctx, []string{"vm1"})
+	a.NoError(err)
+	a.Equal(StatusStopped, vmResources[0].Spec.Status)
+	vm, err := virtController.GetVm("vm1")
+	a.NoError(err)
+	a.Equal(StatusStopped, vm.Status)
+
+	{
+		// VMが利用しているイメージとネットワークは削除できない
+		_, err := virtController.DeleteImageResources(tctx, []string{"centos8"})
+		a.True(errors.IsConflictError(err))
+		_, err = virtController.DeleteNetworkResources(tctx, []string{"local1"})
+		a.True(errors.IsConflictError(err))
+	}
+
+	{
+		// 存在しないVM
+		_, err := virtController.DeleteVmResources(tctx, []string{"vm1", "vm2"})
+		a.True(errors.IsNotFoundError(err))
+	}
+
+	{
+		// VM以外は、停止や再起動できない
+		_, err := virtController.Stop(tctx, KindImage, []string{"centos8"})
+		a.True(errors.IsBadInputError(err))
+		_, err = virtController.Restart(tctx, KindNetwork, []string{"local1"})
+		a.True(errors.IsBadInputError(err))
+		_, err = virtController.Delete(tctx, KindPortForward, []string{"vm1"})
+		a.True(errors.IsBadInputError(err))
+	}
+
+	vmResources, err = virtController.DeleteVmResources(tctx, []string{"vm1"})
+	a.NoError(err)
+	a.Equal(StatusDeleted, vmResources[0].Spec.Status)
+	_, err = virtController.GetVm("vm1")
+	a.True(errors.IsNotFoundError(err))
+	_, err = os.Stat(vmDir)
+	a.True(os.IsNotExist(err))
+
+	// network_portsから参照されていないnetnsは削除する
+	netnsNames, err := netlink_utils.ListNetns()
+	a.NoError(err)
+	a.Equal([]string{"other"}, netnsNames)
+
+	{
+		// 削除が途中で失敗した場合は、再実行でnetnsとディレクトリの削除を完了する
+		a.NoError(os.MkdirAll(vmDir, 0755))
+		a.NoError(ioutil.WriteFile(filepath.Join(netlink_utils.NetnsDir, "com-8"), []byte{}, 0644))
+		vmResources, err := virtController.DeleteVmResources(tctx, []string{"vm1"})
+		a.NoError(err)
+		a.Len(vmResources, 0)
+		_, err = os.Stat(vmDir)
+		a.True(os.IsNotExist(err))
+		netnsNames, err := netlink_utils.ListNetns()
+		a.NoError(err)
+		a.Equal([]string{"other"}, netnsNames)
+	}
+
+	// network_portsも削除される
+	var ports []NetworkPort
+	a.NoError(virtController.sqlClient.DB.Table("network_ports").Select("*").Scan(&ports).Error)
+	a.Len(ports, 0)
+
+	// 論理削除なので、レコードは残る
+	var vms []Vm
+	a.NoError(virtController.sqlClient.DB.Table("vms").Select("*").Where("name = ?", "vm1").Scan(&vms).Error)
+	a.Len(vms, 1)
+	a.NotNil(vms[0].DeletedAt)
+
+	// VMを削除すると、イメージとネットワークも削除できる
+	_, err = virtController.DeleteImageResources(tctx, []string{"centos8"})
+	a.NoError(err)
+	_, err = virtController.DeleteNetworkResources(tctx, []string{"local1"})
+	a.NoError(err)
+	_, err = virtController.GetImage("centos8")
+	a.True(errors.IsNotFoundError(err))
+	_, err = virtController.GetNetwork("local1")
+	a.True(errors.IsNotFoundError(err))
+}
+
+func TestPrepareDisks(t *testing.T) {
+	a := assert.New(t)
+	virtController := newTestVirtController(t)
+	tctx := logger.NewTraceContext()
+
+	content := make([]byte, 1024*1024)
+	modTime := time.Now().Add(-time.Hour)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "centos8.qcow2", modTime, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	vmResources, err := virtController.GetVmResources(tctx, []string{"vm1"})
+	a.NoError(err)
+	vmResources[0].Spec.imageUrlSpec = ImageUrlSpec{
+		Url:        server.URL + "/centos8.qcow2",
+		PullPolicy: PullPolicyAlways,
+	}
+	imagePath := filepath.Join(virtController.imagesDir, "centos8")
+	a.NoError(virtController.PrepareImages(tctx, vmResources))
+
+	// ダウンロードしたイメージのsha256を保存する
+	contentSum := sha256.Sum256(content)
+	savedImage, err := virtController.GetImage("centos8")
+	a.NoError(err)
+	a.Equal(hex.EncodeToString(contentSum[:]), savedImage.Digest)
+
+	a.NoError(virtController.PrepareDisks(tctx, vmResources))
+
+	// イメージをバッキングファイルとして、DiskGbのディスクを作成する
+	vm, err := virtController.GetVm("vm1")
+	a.NoError(err)
+	a.Equal(filepath.Join(virtController.vmsDir, "group1", "vm1", "disk.qcow2"), vm.DiskPath)
+	a.Equal(uint64(10*gib), vm.DiskBytes)
+	image, err := ReadQcow2Image(vm.DiskPath)
+	a.NoError(err)
+	a.Equal(imagePath+"-"+hex.EncodeToString(contentSum[:]), image.BackingFile)
+	a.Equal(ImageFormatRaw, image.BackingFormat)
+	a.Equal(uint64(10*gib), image.Header.Size)
+
+	// 作成済みのディスクは作り直さない
+	stat, err := os.Stat(vm.DiskPath)
+	a.NoError(err)
+	vmResources, err = virtController.GetVmResources(tctx, []string{"vm1"})
+	a.NoError(err)
+	a.NoError(virtController.PrepareDisks(tctx, vmResources))
+	stat2, err := os.Stat(vm.DiskPath)
+	a.NoError(err)
+	a.Equal(stat.ModTime(), stat2.ModTime())
+
+	// Alwaysでイメージが置き換えられても、作成済みのディスクのバッキングファイルは変わらない
+	oldContent := content
+	content = bytes.Repeat([]byte{1}, 1024*1024)
+	modTime = time.Now().Add(time.Hour)
+	vmResources[0].Spec.imageUrlSpec = ImageUrlSpec{
+		Url:        server.URL + "/centos8.qcow2",
+		PullPolicy: PullPolicyAlways,
+	}
+	a.NoError(virtController.PrepareImages(tctx, vmResources))
+	newContentSum := sha256.Sum256(content)
+	savedImage, err = virtController.GetImage("centos8")
+	a.NoError(err)
+	a.Equal(hex.EncodeToString(newContentSum[:]), savedImage.Digest)
+	data, err := ioutil.ReadFile(imagePath)
+	a.NoError(err)
+	a.Equal(content, data)
+	data, err = ioutil.ReadFile(image.BackingFile)
+	a.NoError(err)
+	a.Equal(oldContent, data)
+
+	{
+		// イメージよりも小さいディスクは作成できない
+		// イメージは、ダウンロードと同じく別のファイルからリネームで置き換える
+		tmpPath := imagePath + ".tmp"
+		a.NoError(ioutil.WriteFile(tmpPath, make([]byte, 1024*1024), 0644))
+		a.NoError(os.Truncate(tmpPath, 11*gib))
+		a.NoError(os.Rename(tmpPath, imagePath))
+		vmResources := append(VmResources{}, vmResources...)
+		vmResources[0].Spec.Name = "vm2"
+		vmResources[0].Spec.DiskPath = ""
+		err := virtController.PrepareDisks(tctx, vmResources)
+		a.True(errors.IsBadInputError(err))
+		data, err := ioutil.ReadFile(image.BackingFile)
+		a.NoError(err)
+		a.Equal(oldContent, data)
+	}
+
+	// VMのディスクが参照しているスナップショットは削除しない
+	a.NoError(virtController.cleanupImageSnapshots(tctx))
+	_, err = os.Stat(image.BackingFile)
+	a.NoError(err)
+
+	// VMを削除すると、参照されなくなったスナップショットを削除する
+	_, err = virtController.DeleteVmResources(tctx, []string{"vm1"})
+	a.NoError(err)
+	_, err = os.Stat(image.BackingFile)
+	a.True(os.IsNotExist(err))
+	_, err = os.Stat(imagePath)
+	a.NoError(err)
+}
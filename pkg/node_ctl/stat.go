@@ -1,14 +1,21 @@
 package node_ctl
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/syunkitada/goapp2/pkg/lib/db_utils"
+	"github.com/syunkitada/goapp2/pkg/lib/logger"
 	"github.com/syunkitada/goapp2/pkg/lib/os_utils"
 	"github.com/syunkitada/goapp2/pkg/lib/runner"
+	"github.com/syunkitada/goapp2/pkg/lib/stat_history"
 )
 
 var target string
@@ -17,606 +24,1152 @@ var stopTimeout int
 var isStat bool
 var process string
 var pid int
+var isProcessDeep bool
+var sortKey string
+var top int
+var statRootDir string
+var isAnomaly bool
+var anomalyConfigPath string
+var captureOutput string
+var captureCount int
+var isHistory bool
+var historyDbPath string
+var historySince string
+var historyField string
+
+const (
+	colorRed   = "\x1b[31m"
+	colorReset = "\x1b[0m"
+)
 
 var statCmd = &cobra.Command{
 	Use:   "stat",
 	Short: "stat",
 	Run: func(cmd *cobra.Command, args []string) {
-		showCpu := strings.Contains(target, "c")
-		showCpuWide := strings.Contains(target, "C")
-		showMem := strings.Contains(target, "m")
-		showMemWide := strings.Contains(target, "m")
-		showBuddyinfo := strings.Contains(target, "b")
-		showDisk := strings.Contains(target, "d")
-		showDiskWide := strings.Contains(target, "D")
-		showFs := strings.Contains(target, "f")
-		showNet := strings.Contains(target, "n")
-		showUser := strings.Contains(target, "u")
-		// TODO 異常値をカラーリングできるようにする（設定値はファイルからも読み取れるようにする）
+		if err := applyStatProfile(cmd); err != nil {
+			fmt.Println("Failed applyStatProfile", err.Error())
+			return
+		}
+		analyzerConfig, err := loadAnalyzerConfig()
+		if err != nil {
+			fmt.Println("Failed loadAnalyzerConfig", err.Error())
+			return
+		}
+
+		handleStats := newHandleStats()
+		if isHistory {
+			handleStats = newHandleStatsWithHistory(handleStats)
+		}
 
 		conf := os_utils.StatControllerConfig{
 			Config: runner.Config{
 				Interval:    interval,
 				StopTimeout: stopTimeout,
 			},
-			HandleStats: func(runAt time.Time, stats *os_utils.Stats) {
-				fmt.Println("time:", runAt)
-				strs := []string{}
-				if showCpu || showCpuWide {
-					strs = append(strs,
-						"cpu:",
-						"run="+strconv.Itoa(stats.CpuStat.ProcsRunning),
-						"blocked="+strconv.Itoa(stats.CpuStat.ProcsBlocked),
-					)
-					if showCpuWide {
-						strs = append(strs,
-							"intr="+strconv.Itoa(stats.CpuStat.IntrPerSec),
-							"ctx="+strconv.Itoa(stats.CpuStat.CtxPerSec),
-							"btime="+strconv.Itoa(stats.CpuStat.BtimePerSec),
-							"process="+strconv.Itoa(stats.CpuStat.ProcessesPerSec),
-							"sirq="+strconv.Itoa(stats.CpuStat.SoftirqPerSec),
-						)
-					}
+			HandleStats:    handleStats,
+			IsProcessDeep:  isProcessDeep,
+			RootDir:        statRootDir,
+			AnalyzerConfig: analyzerConfig,
+			Collectors:     statCollectors,
+		}
+		statCtl := os_utils.NewStatController(&conf)
+		statCtl.Start()
+	},
+}
+
+// 異常検知の設定ファイルが指定された場合は、--anomalyがなくても異常検知を行う
+// プロファイルにanomalyがある場合は、その設定で異常検知を行う
+func loadAnalyzerConfig() (analyzerConfig *os_utils.AnalyzerConfig, err error) {
+	if anomalyConfigPath != "" {
+		analyzerConfig, err = os_utils.LoadAnalyzerConfig(anomalyConfigPath)
+	} else if isAnomaly {
+		analyzerConfig = &os_utils.AnalyzerConfig{}
+	} else if profileAnomaly != nil {
+		analyzerConfig = profileAnomaly
+	}
+	return
+}
+
+func newStatHistory() *stat_history.StatHistory {
+	return stat_history.NewStatHistory(&stat_history.Config{
+		Database: db_utils.Config{
+			Connection: historyDbPath,
+		},
+	})
+}
+
+// newHandleStatsWithHistory は、handleStatsに加えて履歴DBへの書き込みを行う
+func newHandleStatsWithHistory(handleStats func(runAt time.Time, stats *os_utils.Stats)) func(runAt time.Time, stats *os_utils.Stats) {
+	tctx := logger.NewTraceContext()
+	history := newStatHistory()
+	history.MustOpen(tctx)
+	return func(runAt time.Time, stats *os_utils.Stats) {
+		handleStats(runAt, stats)
+		if err := history.Write(tctx, runAt, stats); err != nil {
+			fmt.Println(colorRed + "Failed history.Write " + err.Error() + colorReset)
+		}
+	}
+}
+
+func newHandleStats() func(runAt time.Time, stats *os_utils.Stats) {
+	showCpu := strings.Contains(target, "c")
+	showCpuWide := strings.Contains(target, "C")
+	showMem := strings.Contains(target, "m")
+	showMemWide := strings.Contains(target, "M")
+	showBuddyinfo := strings.Contains(target, "b")
+	showDisk := strings.Contains(target, "d")
+	showDiskWide := strings.Contains(target, "D")
+	showFs := strings.Contains(target, "f")
+	showNet := strings.Contains(target, "n")
+	showNetns := strings.Contains(target, "N")
+	showUser := strings.Contains(target, "u")
+	showLoad := strings.Contains(target, "l")
+	showLoadWide := strings.Contains(target, "L")
+	showFd := strings.Contains(target, "o")
+	showFdWide := strings.Contains(target, "O")
+	showSensor := strings.Contains(target, "h")
+	showSensorWide := strings.Contains(target, "H")
+	showCollector := strings.Contains(target, "e")
+	showKmsg := strings.Contains(target, "k")
+	showSlab := strings.Contains(target, "s")
+	showConntrack := strings.Contains(target, "t")
+
+	if statOutputFormat == statOutputJson {
+		return func(runAt time.Time, stats *os_utils.Stats) {
+			tmpBytes, err := json.Marshal(map[string]interface{}{"time": runAt, "stats": stats})
+			if err != nil {
+				fmt.Println("Failed json.Marshal", err.Error())
+				return
+			}
+			fmt.Println(string(tmpBytes))
+		}
+	}
+
+	return func(runAt time.Time, stats *os_utils.Stats) {
+		fmt.Println("time:", runAt)
+		strs := []string{}
+		if (showCpu || showCpuWide) && stats.CpuStat != nil {
+			strs = append(strs,
+				"cpu:",
+				"run="+strconv.Itoa(stats.CpuStat.ProcsRunning),
+				"blocked="+strconv.Itoa(stats.CpuStat.ProcsBlocked),
+			)
+			if showCpuWide {
+				strs = append(strs,
+					"intr="+strconv.Itoa(stats.CpuStat.IntrPerSec),
+					"ctx="+strconv.Itoa(stats.CpuStat.CtxPerSec),
+					"btime="+strconv.Itoa(stats.CpuStat.BtimePerSec),
+					"process="+strconv.Itoa(stats.CpuStat.ProcessesPerSec),
+					"sirq="+strconv.Itoa(stats.CpuStat.SoftirqPerSec),
+				)
+			}
+		}
+		fmt.Println(strings.Join(strs, " "))
+
+		for _, anomaly := range stats.Anomalies {
+			strs := []string{
+				"anomaly:",
+				"metric=" + anomaly.Metric,
+				"value=" + strconv.FormatFloat(anomaly.Value, 'f', 2, 64),
+				"mean=" + strconv.FormatFloat(anomaly.Mean, 'f', 2, 64),
+				"stddev=" + strconv.FormatFloat(anomaly.Stddev, 'f', 2, 64),
+				"sigma=" + strconv.FormatFloat(anomaly.Deviation, 'f', 1, 64),
+			}
+			fmt.Println(colorRed + strings.Join(strs, " ") + colorReset)
+		}
+
+		for _, exceeded := range exceededThresholds(stats) {
+			strs := []string{
+				"threshold:",
+				"metric=" + exceeded.metric,
+				"value=" + strconv.FormatFloat(exceeded.value, 'f', 2, 64),
+				"threshold=" + strconv.FormatFloat(exceeded.threshold, 'f', 2, 64),
+			}
+			fmt.Println(colorRed + strings.Join(strs, " ") + colorReset)
+		}
+
+		// カーネルログのイベントは、見逃さないように常に表示する
+		if stats.KmsgStat != nil {
+			for _, event := range stats.KmsgStat.Events {
+				strs := []string{
+					"kmsg:",
+					"type=" + event.Type,
+					"uptime=" + event.SinceBoot.String(),
+				}
+				if event.Pid != 0 {
+					strs = append(strs, "pid="+strconv.Itoa(event.Pid), "comm="+event.Comm)
+				}
+				if event.Cgroup != "" {
+					strs = append(strs, "cgroup="+event.Cgroup)
+				}
+				if event.Type == os_utils.KmsgEventSoftLockup || event.Type == os_utils.KmsgEventHardLockup {
+					strs = append(strs, "cpu="+strconv.Itoa(event.Cpu))
+				}
+				if event.Device != "" {
+					strs = append(strs, "dev="+event.Device)
+				}
+				strs = append(strs, "msg="+event.Message)
+				fmt.Println(colorRed + strings.Join(strs, " ") + colorReset)
+			}
+			if showKmsg {
+				strs := []string{"kmsg:"}
+				for _, eventType := range os_utils.KmsgEventTypes {
+					strs = append(strs, eventType+"="+strconv.Itoa(stats.KmsgStat.EventCounts[eventType]))
 				}
 				fmt.Println(strings.Join(strs, " "))
+			}
+		}
 
-				if showMem || showMemWide {
-					for _, node := range stats.MemStat.Nodes {
-						strs := []string{
-							"mem:",
-							"node=" + strconv.Itoa(node.NodeId),
-							"tota=" + strconv.Itoa(node.MemTotal),
-							"free=" + strconv.Itoa(node.MemFree),
-							"used=" + strconv.Itoa(node.MemUsed),
-							"avai=" + strconv.Itoa(node.MemAvailable),
-						}
-						fmt.Println(strings.Join(strs, " "))
-						if showBuddyinfo {
-							strs := []string{
-								"buddyinfo:",
-								"node=" + strconv.Itoa(node.NodeId),
-								"4k=" + strconv.Itoa(node.Buddyinfo.M4K),
-								"8k=" + strconv.Itoa(node.Buddyinfo.M8K),
-								"16k=" + strconv.Itoa(node.Buddyinfo.M16K),
-								"32k=" + strconv.Itoa(node.Buddyinfo.M32K),
-								"64k=" + strconv.Itoa(node.Buddyinfo.M64K),
-								"128k=" + strconv.Itoa(node.Buddyinfo.M128K),
-								"256k=" + strconv.Itoa(node.Buddyinfo.M256K),
-								"512k=" + strconv.Itoa(node.Buddyinfo.M512K),
-								"1m=" + strconv.Itoa(node.Buddyinfo.M1M),
-								"2m=" + strconv.Itoa(node.Buddyinfo.M2M),
-								"4m=" + strconv.Itoa(node.Buddyinfo.M4M),
-							}
-							fmt.Println(strings.Join(strs, " "))
-						}
+		// 収集できなかったcollectorは、前回の値を表示していることがわかるように常に表示する
+		for _, stat := range stats.CollectorStats {
+			if !showCollector && !stat.IsStale {
+				continue
+			}
+			strs := []string{
+				"collector:",
+				"name=" + stat.Name,
+				"ms=" + strconv.FormatInt(stat.Duration.Milliseconds(), 10),
+				"errors=" + strconv.Itoa(stat.Errors),
+				"timeouts=" + strconv.Itoa(stat.Timeouts),
+			}
+			if stat.IsStale {
+				strs = append(strs, "STALE", "err="+stat.LastError)
+				fmt.Println(colorRed + strings.Join(strs, " ") + colorReset)
+				continue
+			}
+			fmt.Println(strings.Join(strs, " "))
+		}
+
+		if (showLoad || showLoadWide) && stats.LoadavgStat != nil {
+			strs := []string{
+				"load:",
+				"1m=" + strconv.FormatFloat(stats.LoadavgStat.Load1, 'f', 2, 64),
+				"5m=" + strconv.FormatFloat(stats.LoadavgStat.Load5, 'f', 2, 64),
+				"15m=" + strconv.FormatFloat(stats.LoadavgStat.Load15, 'f', 2, 64),
+				"1mpc=" + strconv.FormatFloat(stats.LoadavgStat.Load1PerCpu, 'f', 2, 64),
+				"run=" + strconv.Itoa(stats.LoadavgStat.Runnable),
+				"tasks=" + strconv.Itoa(stats.LoadavgStat.Tasks),
+				"rqwait=" + strconv.Itoa(stats.LoadavgStat.SchedWaitMsPerSec),
+				"rqwaitpc=" + strconv.Itoa(stats.LoadavgStat.SchedWaitMsPerSecPerCpu),
+			}
+			fmt.Println(strings.Join(strs, " "))
+			if showLoadWide {
+				for _, stat := range stats.LoadavgStat.SchedCpuStats {
+					strs := []string{
+						"rq:",
+						"cpu=" + strconv.Itoa(stat.Cpu),
+						"runms=" + strconv.Itoa(stat.SchedCpuMsPerSec),
+						"waitms=" + strconv.Itoa(stat.SchedWaitMsPerSec),
+						"slices=" + strconv.Itoa(stat.SchedTimeSlicesPerSec),
 					}
+					fmt.Println(strings.Join(strs, " "))
 				}
+			}
+		}
 
-				if showDisk || showDiskWide {
-					for name, stat := range stats.DiskStat.DiskDeviceStatMap {
-						// TODO FIME optionでフィルタリングを制御できるようにする
-						if strings.Contains(name, "loop") {
-							continue
-						}
-						strs := []string{
-							"disk:",
-							"device=" + name,
-							"rps=" + strconv.Itoa(stat.ReadsPerSec),
-							"rbps=" + strconv.Itoa(stat.ReadBytesPerSec),
-							"rmsps=" + strconv.Itoa(stat.ReadMsPerSec),
-							"wps=" + strconv.Itoa(stat.WritesPerSec),
-							"wbps=" + strconv.Itoa(stat.WriteBytesPerSec),
-							"wmsps=" + strconv.Itoa(stat.WriteMsPerSec),
-							"pios=" + strconv.Itoa(stat.ProgressIos),
-						}
-						fmt.Println(strings.Join(strs, " "))
-					}
+		if (showMem || showMemWide) && stats.MemStat != nil {
+			for _, node := range stats.MemStat.Nodes {
+				strs := []string{
+					"mem:",
+					"node=" + strconv.Itoa(node.NodeId),
+					"tota=" + strconv.Itoa(node.MemTotal),
+					"free=" + strconv.Itoa(node.MemFree),
+					"used=" + strconv.Itoa(node.MemUsed),
+					"avai=" + strconv.Itoa(node.MemAvailable),
 				}
-				if showFs {
-					for name, stat := range stats.DiskStat.DiskFsStatMap {
-						// TODO FIME optionでフィルタリングを制御できるようにする
-						if strings.Contains(name, "loop") {
-							continue
-						}
-						if !strings.Contains(stat.Type, "ext") {
-							continue
-						}
-						strs := []string{
-							"fs:",
-							"path=" + name,
-							"mount=" + stat.MountPath,
-							"type=" + stat.Type,
-							"total=" + strconv.Itoa(stat.TotalSize),
-							"free=" + strconv.Itoa(stat.FreeSize),
-							"used=" + strconv.Itoa(stat.UsedSize),
-							"files=" + strconv.Itoa(stat.Files),
-						}
-						fmt.Println(strings.Join(strs, " "))
+				fmt.Println(strings.Join(strs, " "))
+				if showBuddyinfo {
+					strs := []string{
+						"buddyinfo:",
+						"node=" + strconv.Itoa(node.NodeId),
+						"4k=" + strconv.Itoa(node.Buddyinfo.M4K),
+						"8k=" + strconv.Itoa(node.Buddyinfo.M8K),
+						"16k=" + strconv.Itoa(node.Buddyinfo.M16K),
+						"32k=" + strconv.Itoa(node.Buddyinfo.M32K),
+						"64k=" + strconv.Itoa(node.Buddyinfo.M64K),
+						"128k=" + strconv.Itoa(node.Buddyinfo.M128K),
+						"256k=" + strconv.Itoa(node.Buddyinfo.M256K),
+						"512k=" + strconv.Itoa(node.Buddyinfo.M512K),
+						"1m=" + strconv.Itoa(node.Buddyinfo.M1M),
+						"2m=" + strconv.Itoa(node.Buddyinfo.M2M),
+						"4m=" + strconv.Itoa(node.Buddyinfo.M4M),
 					}
+					fmt.Println(strings.Join(strs, " "))
 				}
+			}
+		}
 
-				if showNet {
-					for name, stat := range stats.NetStat.NetDevStatMap {
-						// TODO optionでフィルタリングを制御できるようにする
-						strs := []string{
-							"net:",
-							"dev=" + name,
-							"rbps=" + strconv.Itoa(stat.ReceiveBytesPerSec),
-							"rpps=" + strconv.Itoa(stat.ReceivePacketsPerSec),
-							"reps=" + strconv.Itoa(stat.ReceiveErrorsPerSec),
-							"rdps=" + strconv.Itoa(stat.ReceiveDropsPerSec),
-							"tbps=" + strconv.Itoa(stat.TransmitBytesPerSec),
-							"tpps=" + strconv.Itoa(stat.TransmitPacketsPerSec),
-							"teps=" + strconv.Itoa(stat.TransmitErrorsPerSec),
-							"tdps=" + strconv.Itoa(stat.TransmitDropsPerSec),
-						}
-						fmt.Println(strings.Join(strs, " "))
-					}
-					strs := []string{"tcpExt:"}
-					if stats.NetStat.TcpExtStat.SyncookiesSentPerSec != 0 {
-						strs = append(strs, "SyncookiesSent="+strconv.Itoa(stats.NetStat.TcpExtStat.SyncookiesSentPerSec))
-					}
-					if stats.NetStat.TcpExtStat.SyncookiesRecvPerSec != 0 {
-						strs = append(strs, "SyncookiesRecv="+strconv.Itoa(stats.NetStat.TcpExtStat.SyncookiesRecvPerSec))
-					}
-					if stats.NetStat.TcpExtStat.SyncookiesFailedPerSec != 0 {
-						strs = append(strs, "SyncookiesFailed="+strconv.Itoa(stats.NetStat.TcpExtStat.SyncookiesFailedPerSec))
-					}
-					if stats.NetStat.TcpExtStat.EmbryonicRstsPerSec != 0 {
-						strs = append(strs, "EmbryonicRsts="+strconv.Itoa(stats.NetStat.TcpExtStat.EmbryonicRstsPerSec))
-					}
-					if stats.NetStat.TcpExtStat.PruneCalledPerSec != 0 {
-						strs = append(strs, "PruneCalled="+strconv.Itoa(stats.NetStat.TcpExtStat.PruneCalledPerSec))
-					}
-					if stats.NetStat.TcpExtStat.RcvPrunedPerSec != 0 {
-						strs = append(strs, "RcvPruned="+strconv.Itoa(stats.NetStat.TcpExtStat.RcvPrunedPerSec))
-					}
-					if stats.NetStat.TcpExtStat.OfoPrunedPerSec != 0 {
-						strs = append(strs, "OfoPruned="+strconv.Itoa(stats.NetStat.TcpExtStat.OfoPrunedPerSec))
-					}
-					if stats.NetStat.TcpExtStat.OutOfWindowIcmpsPerSec != 0 {
-						strs = append(strs, "OutOfWindowIcmps="+strconv.Itoa(stats.NetStat.TcpExtStat.OutOfWindowIcmpsPerSec))
-					}
-					if stats.NetStat.TcpExtStat.LockDroppedIcmpsPerSec != 0 {
-						strs = append(strs, "LockDroppedIcmps="+strconv.Itoa(stats.NetStat.TcpExtStat.LockDroppedIcmpsPerSec))
-					}
-					if stats.NetStat.TcpExtStat.ArpFilterPerSec != 0 {
-						strs = append(strs, "ArpFilter="+strconv.Itoa(stats.NetStat.TcpExtStat.ArpFilterPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TwPerSec != 0 {
-						strs = append(strs, "Tw="+strconv.Itoa(stats.NetStat.TcpExtStat.TwPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TwRecycledPerSec != 0 {
-						strs = append(strs, "TwRecycled="+strconv.Itoa(stats.NetStat.TcpExtStat.TwRecycledPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TwKilledPerSec != 0 {
-						strs = append(strs, "TwKilled="+strconv.Itoa(stats.NetStat.TcpExtStat.TwKilledPerSec))
-					}
-					if stats.NetStat.TcpExtStat.PawsActivePerSec != 0 {
-						strs = append(strs, "PawsActive="+strconv.Itoa(stats.NetStat.TcpExtStat.PawsActivePerSec))
-					}
-					if stats.NetStat.TcpExtStat.PawsEstabPerSec != 0 {
-						strs = append(strs, "PawsEstab="+strconv.Itoa(stats.NetStat.TcpExtStat.PawsEstabPerSec))
-					}
-					if stats.NetStat.TcpExtStat.DelayedAcksPerSec != 0 {
-						strs = append(strs, "DelayedAcks="+strconv.Itoa(stats.NetStat.TcpExtStat.DelayedAcksPerSec))
-					}
-					if stats.NetStat.TcpExtStat.DelayedAckLockedPerSec != 0 {
-						strs = append(strs, "DelayedAckLocked="+strconv.Itoa(stats.NetStat.TcpExtStat.DelayedAckLockedPerSec))
-					}
-					if stats.NetStat.TcpExtStat.DelayedAckLostPerSec != 0 {
-						strs = append(strs, "DelayedAckLost="+strconv.Itoa(stats.NetStat.TcpExtStat.DelayedAckLostPerSec))
-					}
-					if stats.NetStat.TcpExtStat.ListenOverflowsPerSec != 0 {
-						strs = append(strs, "ListenOverflows="+strconv.Itoa(stats.NetStat.TcpExtStat.ListenOverflowsPerSec))
-					}
-					if stats.NetStat.TcpExtStat.ListenDropsPerSec != 0 {
-						strs = append(strs, "ListenDrops="+strconv.Itoa(stats.NetStat.TcpExtStat.ListenDropsPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpHpHitsPerSec != 0 {
-						strs = append(strs, "TcpHpHits="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpHpHitsPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpPureAcksPerSec != 0 {
-						strs = append(strs, "TcpPureAcks="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpPureAcksPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpHpAcksPerSec != 0 {
-						strs = append(strs, "TcpHpAcks="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpHpAcksPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpRenoRecoveryPerSec != 0 {
-						strs = append(strs, "TcpRenoRecovery="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpRenoRecoveryPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpSackRecoveryPerSec != 0 {
-						strs = append(strs, "TcpSackRecovery="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpSackRecoveryPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpSackRenegingPerSec != 0 {
-						strs = append(strs, "TcpSackReneging="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpSackRenegingPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpSackReorderPerSec != 0 {
-						strs = append(strs, "TcpSackReorder="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpSackReorderPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpRenoReorderPerSec != 0 {
-						strs = append(strs, "TcpRenoReorder="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpRenoReorderPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpTsReorderPerSec != 0 {
-						strs = append(strs, "TcpTsReorder="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpTsReorderPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpFullUndoPerSec != 0 {
-						strs = append(strs, "TcpFullUndo="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpFullUndoPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpPartialUndoPerSec != 0 {
-						strs = append(strs, "TcpPartialUndo="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpPartialUndoPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpDsackUndoPerSec != 0 {
-						strs = append(strs, "TcpDsackUndo="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpDsackUndoPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpLossUndoPerSec != 0 {
-						strs = append(strs, "TcpLossUndo="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpLossUndoPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpLostRetransmitPerSec != 0 {
-						strs = append(strs, "TcpLostRetransmit="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpLostRetransmitPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpRenoFailuresPerSec != 0 {
-						strs = append(strs, "TcpRenoFailures="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpRenoFailuresPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpSackFailuresPerSec != 0 {
-						strs = append(strs, "TcpSackFailures="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpSackFailuresPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpLossFailuresPerSec != 0 {
-						strs = append(strs, "TcpLossFailures="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpLossFailuresPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpFastRetransPerSec != 0 {
-						strs = append(strs, "TcpFastRetrans="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpFastRetransPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpSlowStartRetransPerSec != 0 {
-						strs = append(strs, "TcpSlowStartRetrans="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpSlowStartRetransPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpTimeoutsPerSec != 0 {
-						strs = append(strs, "TcpTimeouts="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpTimeoutsPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpLossProbesPerSec != 0 {
-						strs = append(strs, "TcpLossProbes="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpLossProbesPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpLossProbeRecoveryPerSec != 0 {
-						strs = append(strs, "TcpLossProbeRecovery="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpLossProbeRecoveryPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpRenoRecoveryFailPerSec != 0 {
-						strs = append(strs, "TcpRenoRecoveryFail="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpRenoRecoveryFailPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpSackRecoveryFailPerSec != 0 {
-						strs = append(strs, "TcpSackRecoveryFail="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpSackRecoveryFailPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpRcvCollapsedPerSec != 0 {
-						strs = append(strs, "TcpRcvCollapsed="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpRcvCollapsedPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpBacklogCoalescePerSec != 0 {
-						strs = append(strs, "TcpBacklogCoalesce="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpBacklogCoalescePerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpDsackOldSentPerSec != 0 {
-						strs = append(strs, "TcpDsackOldSent="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpDsackOldSentPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpDsackOfoSentPerSec != 0 {
-						strs = append(strs, "TcpDsackOfoSent="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpDsackOfoSentPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpDsackRecvPerSec != 0 {
-						strs = append(strs, "TcpDsackRecv="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpDsackRecvPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpDsackOfoRecvPerSec != 0 {
-						strs = append(strs, "TcpDsackOfoRecv="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpDsackOfoRecvPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpAbortOnDataPerSec != 0 {
-						strs = append(strs, "TcpAbortOnData="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpAbortOnDataPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpAbortOnClosePerSec != 0 {
-						strs = append(strs, "TcpAbortOnClose="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpAbortOnClosePerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpAbortOnMemoryPerSec != 0 {
-						strs = append(strs, "TcpAbortOnMemory="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpAbortOnMemoryPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpAbortOnTimeoutPerSec != 0 {
-						strs = append(strs, "TcpAbortOnTimeout="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpAbortOnTimeoutPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpAbortOnLingerPerSec != 0 {
-						strs = append(strs, "TcpAbortOnLinger="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpAbortOnLingerPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpAbortFailedPerSec != 0 {
-						strs = append(strs, "TcpAbortFailed="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpAbortFailedPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpMemoryPressuresPerSec != 0 {
-						strs = append(strs, "TcpMemoryPressures="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpMemoryPressuresPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpMemoryPressuresChronoPerSec != 0 {
-						strs = append(strs, "TcpMemoryPressuresChrono="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpMemoryPressuresChronoPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpSackDiscardPerSec != 0 {
-						strs = append(strs, "TcpSackDiscard="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpSackDiscardPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpDsackIgnoredOldPerSec != 0 {
-						strs = append(strs, "TcpDsackIgnoredOld="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpDsackIgnoredOldPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpDsackIgnoredNoUndoPerSec != 0 {
-						strs = append(strs, "TcpDsackIgnoredNoUndo="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpDsackIgnoredNoUndoPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpSpuriousRTOsPerSec != 0 {
-						strs = append(strs, "TcpSpuriousRTOs="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpSpuriousRTOsPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpMd5NotFoundPerSec != 0 {
-						strs = append(strs, "TcpMd5NotFound="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpMd5NotFoundPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpMd5UnexpectedPerSec != 0 {
-						strs = append(strs, "TcpMd5Unexpected="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpMd5UnexpectedPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpMd5FailurePerSec != 0 {
-						strs = append(strs, "TcpMd5Failure="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpMd5FailurePerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpSackShiftedPerSec != 0 {
-						strs = append(strs, "TcpSackShifted="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpSackShiftedPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpSackMergedPerSec != 0 {
-						strs = append(strs, "TcpSackMerged="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpSackMergedPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpSackShiftFallbackPerSec != 0 {
-						strs = append(strs, "TcpSackShiftFallback="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpSackShiftFallbackPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpBacklogDropPerSec != 0 {
-						strs = append(strs, "TcpBacklogDrop="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpBacklogDropPerSec))
-					}
-					if stats.NetStat.TcpExtStat.PfMemallocDropPerSec != 0 {
-						strs = append(strs, "PfMemallocDrop="+strconv.Itoa(stats.NetStat.TcpExtStat.PfMemallocDropPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpMinTtlDropPerSec != 0 {
-						strs = append(strs, "TcpMinTtlDrop="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpMinTtlDropPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpDeferAcceptDropPerSec != 0 {
-						strs = append(strs, "TcpDeferAcceptDrop="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpDeferAcceptDropPerSec))
-					}
-					if stats.NetStat.TcpExtStat.IpReversePathFilterPerSec != 0 {
-						strs = append(strs, "IpReversePathFilter="+strconv.Itoa(stats.NetStat.TcpExtStat.IpReversePathFilterPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpTimeWaitOverflowPerSec != 0 {
-						strs = append(strs, "TcpTimeWaitOverflow="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpTimeWaitOverflowPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpReqQFullDoCookiesPerSec != 0 {
-						strs = append(strs, "TcpReqQFullDoCookies="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpReqQFullDoCookiesPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpReqQFullDropPerSec != 0 {
-						strs = append(strs, "TcpReqQFullDrop="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpReqQFullDropPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpRetransFailPerSec != 0 {
-						strs = append(strs, "TcpRetransFail="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpRetransFailPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpRcvCoalescePerSec != 0 {
-						strs = append(strs, "TcpRcvCoalesce="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpRcvCoalescePerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpOfoQueuePerSec != 0 {
-						strs = append(strs, "TcpOfoQueue="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpOfoQueuePerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpOfoDropPerSec != 0 {
-						strs = append(strs, "TcpOfoDrop="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpOfoDropPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpOfoMergePerSec != 0 {
-						strs = append(strs, "TcpOfoMerge="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpOfoMergePerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpChallengeACKPerSec != 0 {
-						strs = append(strs, "TcpChallengeACK="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpChallengeACKPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpSynChallengePerSec != 0 {
-						strs = append(strs, "TcpSynChallenge="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpSynChallengePerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpFastOpenActivePerSec != 0 {
-						strs = append(strs, "TcpFastOpenActive="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpFastOpenActivePerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpFastOpenActiveFailPerSec != 0 {
-						strs = append(strs, "TcpFastOpenActiveFail="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpFastOpenActiveFailPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpFastOpenPassivePerSec != 0 {
-						strs = append(strs, "TcpFastOpenPassive="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpFastOpenPassivePerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpFastOpenPassiveFailPerSec != 0 {
-						strs = append(strs, "TcpFastOpenPassiveFail="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpFastOpenPassiveFailPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpFastOpenListenOverflowPerSec != 0 {
-						strs = append(strs, "TcpFastOpenListenOverflow="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpFastOpenListenOverflowPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpFastOpenCookieReqdPerSec != 0 {
-						strs = append(strs, "TcpFastOpenCookieReqd="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpFastOpenCookieReqdPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpFastOpenBlackholePerSec != 0 {
-						strs = append(strs, "TcpFastOpenBlackhole="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpFastOpenBlackholePerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpSpuriousRtxHostQueuesPerSec != 0 {
-						strs = append(strs, "TcpSpuriousRtxHostQueues="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpSpuriousRtxHostQueuesPerSec))
-					}
-					if stats.NetStat.TcpExtStat.BusyPollRxPacketsPerSec != 0 {
-						strs = append(strs, "BusyPollRxPackets="+strconv.Itoa(stats.NetStat.TcpExtStat.BusyPollRxPacketsPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpAutoCorkingPerSec != 0 {
-						strs = append(strs, "TcpAutoCorking="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpAutoCorkingPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpFromZeroWindowAdvPerSec != 0 {
-						strs = append(strs, "TcpFromZeroWindowAdv="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpFromZeroWindowAdvPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpToZeroWindowAdvPerSec != 0 {
-						strs = append(strs, "TcpToZeroWindowAdv="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpToZeroWindowAdvPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpWantZeroWindowAdvPerSec != 0 {
-						strs = append(strs, "TcpWantZeroWindowAdv="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpWantZeroWindowAdvPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpSynRetransPerSec != 0 {
-						strs = append(strs, "TcpSynRetrans="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpSynRetransPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpOrigDataSentPerSec != 0 {
-						strs = append(strs, "TcpOrigDataSent="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpOrigDataSentPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpHystartTrainDetectPerSec != 0 {
-						strs = append(strs, "TcpHystartTrainDetect="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpHystartTrainDetectPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpHystartTrainCwndPerSec != 0 {
-						strs = append(strs, "TcpHystartTrainCwnd="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpHystartTrainCwndPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpHystartDelayDetectPerSec != 0 {
-						strs = append(strs, "TcpHystartDelayDetect="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpHystartDelayDetectPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpHystartDelayCwndPerSec != 0 {
-						strs = append(strs, "TcpHystartDelayCwnd="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpHystartDelayCwndPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpAckSkippedSynRecvPerSec != 0 {
-						strs = append(strs, "TcpAckSkippedSynRecv="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpAckSkippedSynRecvPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpAckSkippedPAWSPerSec != 0 {
-						strs = append(strs, "TcpAckSkippedPAWS="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpAckSkippedPAWSPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpAckSkippedSeqPerSec != 0 {
-						strs = append(strs, "TcpAckSkippedSeq="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpAckSkippedSeqPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpAckSkippedFinWait2PerSec != 0 {
-						strs = append(strs, "TcpAckSkippedFinWait2="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpAckSkippedFinWait2PerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpAckSkippedTimeWaitPerSec != 0 {
-						strs = append(strs, "TcpAckSkippedTimeWait="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpAckSkippedTimeWaitPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpAckSkippedChallengePerSec != 0 {
-						strs = append(strs, "TcpAckSkippedChallenge="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpAckSkippedChallengePerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpWinProbePerSec != 0 {
-						strs = append(strs, "TcpWinProbe="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpWinProbePerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpKeepAlivePerSec != 0 {
-						strs = append(strs, "TcpKeepAlive="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpKeepAlivePerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpMtupFailPerSec != 0 {
-						strs = append(strs, "TcpMtupFail="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpMtupFailPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpMtupSuccessPerSec != 0 {
-						strs = append(strs, "TcpMtupSuccess="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpMtupSuccessPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpDeliveredPerSec != 0 {
-						strs = append(strs, "TcpDelivered="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpDeliveredPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpDeliveredCEPerSec != 0 {
-						strs = append(strs, "TcpDeliveredCE="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpDeliveredCEPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpAckCompressedPerSec != 0 {
-						strs = append(strs, "TcpAckCompressed="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpAckCompressedPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpZeroWindowDropPerSec != 0 {
-						strs = append(strs, "TcpZeroWindowDrop="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpZeroWindowDropPerSec))
-					}
-					if stats.NetStat.TcpExtStat.TcpRcvQDropPerSec != 0 {
-						strs = append(strs, "TcpRcvQDrop="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpRcvQDropPerSec))
+		// カーネルのメモリリークやhugepageの割り当て失敗の調査用に、slabとゾーンの状態を表示する
+		// サイズの単位は、memと同じくkB
+		if showSlab {
+			if stats.SlabStat != nil {
+				strs := []string{
+					"slab:",
+					"total=" + strconv.Itoa(stats.SlabStat.TotalBytes/1024),
+					"growth=" + strconv.Itoa(stats.SlabStat.TotalBytesPerSec/1024),
+				}
+				fmt.Println(strings.Join(strs, " "))
+				for _, stat := range stats.SlabStat.TopCaches(os_utils.SlabTopCaches) {
+					strs := []string{
+						"slab:",
+						"name=" + stat.Name,
+						"size=" + strconv.Itoa(stat.Bytes/1024),
+						"growth=" + strconv.Itoa(stat.BytesPerSec/1024),
+						"objs=" + strconv.Itoa(stat.ActiveObjs) + "/" + strconv.Itoa(stat.NumObjs),
+						"objsize=" + strconv.Itoa(stat.ObjSize),
 					}
-					if stats.NetStat.TcpExtStat.TcpWqueueTooBigPerSec != 0 {
-						strs = append(strs, "TcpWqueueTooBig="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpWqueueTooBigPerSec))
+					fmt.Println(strings.Join(strs, " "))
+				}
+			}
+			if stats.ZoneStat != nil {
+				for _, zone := range stats.ZoneStat.Zones {
+					// 単位はページ
+					strs := []string{
+						"zone:",
+						"node=" + strconv.Itoa(zone.NodeId),
+						"zone=" + zone.Zone,
+						"free=" + strconv.Itoa(zone.Free),
+						"min=" + strconv.Itoa(zone.Min),
+						"low=" + strconv.Itoa(zone.Low),
+						"high=" + strconv.Itoa(zone.High),
+						"managed=" + strconv.Itoa(zone.Managed),
+					}
+					if zone.IsBelowLow {
+						fmt.Println(colorRed + strings.Join(strs, " ") + colorReset)
+						continue
 					}
-					if stats.NetStat.TcpExtStat.TcpFastOpenPassiveAltKeyPerSec != 0 {
-						strs = append(strs, "TcpFastOpenPassiveAltKey="+strconv.Itoa(stats.NetStat.TcpExtStat.TcpFastOpenPassiveAltKeyPerSec))
+					fmt.Println(strings.Join(strs, " "))
+				}
+			}
+			if stats.MemStat != nil {
+				for _, node := range stats.MemStat.Nodes {
+					strs := []string{
+						"frag:",
+						"node=" + strconv.Itoa(node.NodeId),
+						"2m=" + strconv.FormatFloat(node.Buddyinfo.FragIndex2M, 'f', 3, 64),
 					}
-
 					fmt.Println(strings.Join(strs, " "))
+				}
+			}
+		}
 
-					strs = []string{"ipExt:"}
-
-					if stats.NetStat.IpExtStat.InNoRoutesPerSec != 0 {
-						strs = append(strs, "InNoRoutes="+strconv.Itoa(stats.NetStat.IpExtStat.InNoRoutesPerSec))
-					}
-					if stats.NetStat.IpExtStat.InTruncatedPktsPerSec != 0 {
-						strs = append(strs, "InTruncatedPkts="+strconv.Itoa(stats.NetStat.IpExtStat.InTruncatedPktsPerSec))
-					}
-					if stats.NetStat.IpExtStat.InCsumErrorsPerSec != 0 {
-						strs = append(strs, "InCsumErrors="+strconv.Itoa(stats.NetStat.IpExtStat.InCsumErrorsPerSec))
-					}
-					if stats.NetStat.IpExtStat.InNoRoutesPerSec != 0 {
-						strs = append(strs, "InNoRoutes="+strconv.Itoa(stats.NetStat.IpExtStat.InNoRoutesPerSec))
-					}
-					if stats.NetStat.IpExtStat.InTruncatedPktsPerSec != 0 {
-						strs = append(strs, "InTruncatedPkts="+strconv.Itoa(stats.NetStat.IpExtStat.InTruncatedPktsPerSec))
-					}
-					if stats.NetStat.IpExtStat.InMcastPktsPerSec != 0 {
-						strs = append(strs, "InMcastPkts="+strconv.Itoa(stats.NetStat.IpExtStat.InMcastPktsPerSec))
-					}
-					if stats.NetStat.IpExtStat.OutMcastPktsPerSec != 0 {
-						strs = append(strs, "OutMcastPkts="+strconv.Itoa(stats.NetStat.IpExtStat.OutMcastPktsPerSec))
-					}
-					if stats.NetStat.IpExtStat.InBcastPktsPerSec != 0 {
-						strs = append(strs, "InBcastPkts="+strconv.Itoa(stats.NetStat.IpExtStat.InBcastPktsPerSec))
-					}
-					if stats.NetStat.IpExtStat.OutBcastPktsPerSec != 0 {
-						strs = append(strs, "OutBcastPkts="+strconv.Itoa(stats.NetStat.IpExtStat.OutBcastPktsPerSec))
-					}
-					if stats.NetStat.IpExtStat.InOctetsPerSec != 0 {
-						strs = append(strs, "InOctets="+strconv.Itoa(stats.NetStat.IpExtStat.InOctetsPerSec))
-					}
-					if stats.NetStat.IpExtStat.OutOctetsPerSec != 0 {
-						strs = append(strs, "OutOctets="+strconv.Itoa(stats.NetStat.IpExtStat.OutOctetsPerSec))
-					}
-					if stats.NetStat.IpExtStat.InMcastOctetsPerSec != 0 {
-						strs = append(strs, "InMcastOctets="+strconv.Itoa(stats.NetStat.IpExtStat.InMcastOctetsPerSec))
-					}
-					if stats.NetStat.IpExtStat.OutMcastOctetsPerSec != 0 {
-						strs = append(strs, "OutMcastOctets="+strconv.Itoa(stats.NetStat.IpExtStat.OutMcastOctetsPerSec))
-					}
-					if stats.NetStat.IpExtStat.InBcastOctetsPerSec != 0 {
-						strs = append(strs, "InBcastOctets="+strconv.Itoa(stats.NetStat.IpExtStat.InBcastOctetsPerSec))
-					}
-					if stats.NetStat.IpExtStat.OutBcastOctetsPerSec != 0 {
-						strs = append(strs, "OutBcastOctets="+strconv.Itoa(stats.NetStat.IpExtStat.OutBcastOctetsPerSec))
-					}
-					if stats.NetStat.IpExtStat.InCsumErrorsPerSec != 0 {
-						strs = append(strs, "InCsumErrors="+strconv.Itoa(stats.NetStat.IpExtStat.InCsumErrorsPerSec))
-					}
-					if stats.NetStat.IpExtStat.InNoECTPktsPerSec != 0 {
-						strs = append(strs, "InNoECTPkts="+strconv.Itoa(stats.NetStat.IpExtStat.InNoECTPktsPerSec))
-					}
-					if stats.NetStat.IpExtStat.InECT1PktsPerSec != 0 {
-						strs = append(strs, "InECT1Pkts="+strconv.Itoa(stats.NetStat.IpExtStat.InECT1PktsPerSec))
-					}
-					if stats.NetStat.IpExtStat.InECT0PktsPerSec != 0 {
-						strs = append(strs, "InECT0Pkts="+strconv.Itoa(stats.NetStat.IpExtStat.InECT0PktsPerSec))
-					}
-					if stats.NetStat.IpExtStat.InCEPktsPerSec != 0 {
-						strs = append(strs, "InCEPkts="+strconv.Itoa(stats.NetStat.IpExtStat.InCEPktsPerSec))
+		if (showDisk || showDiskWide) && stats.DiskStat != nil {
+			for name, stat := range stats.DiskStat.DiskDeviceStatMap {
+				// フィルタが指定されていない場合は、loopデバイスを除く
+				if !matchFilter(statFilter.disk, name, !strings.Contains(name, "loop")) {
+					continue
+				}
+				strs := []string{
+					"disk:",
+					"device=" + name,
+					"rps=" + strconv.Itoa(stat.ReadsPerSec),
+					"rbps=" + strconv.Itoa(stat.ReadBytesPerSec),
+					"rmsps=" + strconv.Itoa(stat.ReadMsPerSec),
+					"wps=" + strconv.Itoa(stat.WritesPerSec),
+					"wbps=" + strconv.Itoa(stat.WriteBytesPerSec),
+					"wmsps=" + strconv.Itoa(stat.WriteMsPerSec),
+					"pios=" + strconv.Itoa(stat.ProgressIos),
+				}
+				if showDiskWide {
+					strs = append(strs,
+						"rot="+strconv.FormatBool(stat.Rotational),
+						"sched="+stat.Scheduler,
+						"qd="+strconv.Itoa(stat.QueueDepth),
+					)
+					if len(stat.Slaves) > 0 {
+						strs = append(strs, "slaves="+strings.Join(stat.Slaves, ","))
 					}
-					if stats.NetStat.IpExtStat.ReasmOverlapsPerSec != 0 {
-						strs = append(strs, "ReasmOverlaps="+strconv.Itoa(stats.NetStat.IpExtStat.ReasmOverlapsPerSec))
+				}
+				fmt.Println(strings.Join(strs, " "))
+			}
+		}
+		if showFs && stats.DiskStat != nil {
+			for name, stat := range stats.DiskStat.DiskFsStatMap {
+				// フィルタが指定されていない場合は、loopデバイスを除いたext系のファイルシステムのみ表示する
+				if !matchFilter(statFilter.fs, stat.MountPath, !strings.Contains(name, "loop") && strings.Contains(stat.Type, "ext")) {
+					continue
+				}
+				strs := []string{
+					"fs:",
+					"path=" + name,
+					"mount=" + stat.MountPath,
+					"type=" + stat.Type,
+					"total=" + strconv.Itoa(stat.TotalSize),
+					"free=" + strconv.Itoa(stat.FreeSize),
+					"used=" + strconv.Itoa(stat.UsedSize),
+					"files=" + strconv.Itoa(stat.Files),
+				}
+				// ファイルシステムのIOとして、下位のデバイスのIOを表示する
+				if devName, devStat, ok := stats.DiskStat.BackingDevice(&stat); ok {
+					strs = append(strs,
+						"dev="+strings.Join(stat.Devices, ">"),
+						"rbps="+strconv.Itoa(devStat.ReadBytesPerSec),
+						"wbps="+strconv.Itoa(devStat.WriteBytesPerSec),
+						"pios="+strconv.Itoa(devStat.ProgressIos),
+					)
+					if devName != stat.Devices[0] {
+						strs = append(strs, "iodev="+devName)
 					}
+				}
+				fmt.Println(strings.Join(strs, " "))
+			}
+		}
+
+		if showNet && stats.NetStat != nil {
+			printNetStat("", stats.NetStat)
+		}
+		if showNetns && stats.NetnsStat != nil {
+			names := make([]string, 0, len(stats.NetnsStat.NetStatMap))
+			for name := range stats.NetnsStat.NetStatMap {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if !matchFilter(statFilter.netns, name, true) {
+					continue
+				}
+				printNetStat(name, stats.NetnsStat.NetStatMap[name])
+			}
+		}
 
+		// conntrackのテーブルが溢れるとVMの通信が何も通知されずに破棄されるので、溢れそうな場合は常に表示する
+		if stat := stats.ConntrackStat; stat != nil {
+			isDropping := stat.IsNearLimit || stat.InsertFailedPerSec > 0 || stat.DropPerSec > 0 || stat.EarlyDropPerSec > 0
+			if showConntrack || isDropping {
+				strs := []string{
+					"conntrack:",
+					"count=" + strconv.Itoa(stat.Count),
+					"max=" + strconv.Itoa(stat.Max),
+					"util=" + strconv.Itoa(stat.Util),
+					"insert_failed=" + strconv.Itoa(stat.InsertFailedPerSec),
+					"drop=" + strconv.Itoa(stat.DropPerSec),
+					"early_drop=" + strconv.Itoa(stat.EarlyDropPerSec),
+					"search_restart=" + strconv.Itoa(stat.SearchRestartPerSec),
+				}
+				if isDropping {
+					fmt.Println(colorRed + strings.Join(strs, " ") + colorReset)
+				} else {
 					fmt.Println(strings.Join(strs, " "))
+				}
+			}
+		}
 
+		if (showFd || showFdWide) && stats.FdStat != nil {
+			strs := []string{
+				"fd:",
+				"file=" + strconv.Itoa(stats.FdStat.FileAllocated),
+				"filemax=" + strconv.Itoa(stats.FdStat.FileMax),
+				"fileutil=" + strconv.Itoa(stats.FdStat.FileUtil),
+				"inode=" + strconv.Itoa(stats.FdStat.InodeAllocated),
+				"inodefree=" + strconv.Itoa(stats.FdStat.InodeFree),
+				"tasks=" + strconv.Itoa(stats.FdStat.Tasks),
+				"pidmax=" + strconv.Itoa(stats.FdStat.PidMax),
+				"pidutil=" + strconv.Itoa(stats.FdStat.PidUtil),
+			}
+			fmt.Println(strings.Join(strs, " "))
+			for _, stat := range stats.FdStat.ProcessFdStats {
+				// 上限に近いプロセスのみ表示し、wideの場合はすべて表示する
+				if !stat.IsNearLimit && !showFdWide {
+					continue
+				}
+				strs := []string{
+					"fdproc:",
+					"pid=" + strconv.Itoa(stat.Pid),
+					"name=" + stat.Name,
+					"open=" + strconv.Itoa(stat.OpenFds),
+					"limit=" + strconv.Itoa(stat.SoftLimit),
+					"util=" + strconv.Itoa(stat.Util),
+					"openps=" + strconv.Itoa(stat.OpenFdsPerSec),
+				}
+				if stat.IsNearLimit {
+					strs = append(strs, "NEAR_LIMIT")
 				}
+				fmt.Println(strings.Join(strs, " "))
+			}
+		}
 
-				if showUser {
-					for name, stat := range stats.LoginUserStat.UserStatMap {
+		if (showSensor || showSensorWide) && stats.SensorStat != nil {
+			for _, stat := range stats.SensorStat.TempStats {
+				strs := []string{
+					"temp:",
+					"chip=" + stat.Chip,
+					"label=" + stat.Label,
+					"temp=" + strconv.FormatFloat(stat.Temp, 'f', 1, 64),
+					"max=" + strconv.FormatFloat(stat.Max, 'f', 1, 64),
+					"crit=" + strconv.FormatFloat(stat.Crit, 'f', 1, 64),
+				}
+				fmt.Println(strings.Join(strs, " "))
+			}
+			for _, stat := range stats.SensorStat.FanStats {
+				strs := []string{
+					"fan:",
+					"chip=" + stat.Chip,
+					"label=" + stat.Label,
+					"rpm=" + strconv.Itoa(stat.Rpm),
+				}
+				fmt.Println(strings.Join(strs, " "))
+			}
+			for _, stat := range stats.SensorStat.ThermalZoneStats {
+				strs := []string{
+					"thermal:",
+					"zone=" + stat.Zone,
+					"type=" + stat.Type,
+					"temp=" + strconv.FormatFloat(stat.Temp, 'f', 1, 64),
+				}
+				fmt.Println(strings.Join(strs, " "))
+			}
+			for _, stat := range stats.SensorStat.RaplStats {
+				strs := []string{
+					"rapl:",
+					"zone=" + stat.Zone,
+					"name=" + stat.Name,
+					"watts=" + strconv.FormatFloat(stat.Watts, 'f', 1, 64),
+				}
+				fmt.Println(strings.Join(strs, " "))
+			}
+			if len(stats.SensorStat.CpuFreqStats) > 0 {
+				totalKhz := 0
+				throttles := 0
+				for _, stat := range stats.SensorStat.CpuFreqStats {
+					totalKhz += stat.CurKhz
+					throttles += stat.CoreThrottlePerSec + stat.PackageThrottlePerSec
+					if showSensorWide {
 						strs := []string{
-							"user:",
-							"name=" + name,
-							"durationSec=" + strconv.Itoa(stat.LoginDuration),
+							"freq:",
+							"cpu=" + strconv.Itoa(stat.Cpu),
+							"cur=" + strconv.Itoa(stat.CurKhz),
+							"max=" + strconv.Itoa(stat.MaxKhz),
+							"cthrottle=" + strconv.Itoa(stat.CoreThrottlePerSec),
+							"pthrottle=" + strconv.Itoa(stat.PackageThrottlePerSec),
 						}
 						fmt.Println(strings.Join(strs, " "))
 					}
 				}
+				strs := []string{
+					"freq:",
+					"avg=" + strconv.Itoa(totalKhz/len(stats.SensorStat.CpuFreqStats)),
+					"throttle=" + strconv.Itoa(throttles),
+				}
+				fmt.Println(strings.Join(strs, " "))
+			}
+		}
 
-				if pid != 0 {
-					for _, p := range stats.Processes {
-						if p.Pid != pid {
-							continue
-						}
-						fmt.Println(strconv.Itoa(p.Pid), p.Name, strconv.Itoa(p.Stat.UserUtil), strconv.Itoa(p.Stat.WaitUtil))
-					}
+		if showUser && stats.LoginUserStat != nil {
+			for name, stat := range stats.LoginUserStat.UserStatMap {
+				strs := []string{
+					"user:",
+					"name=" + name,
+					"durationSec=" + strconv.Itoa(stat.LoginDuration),
 				}
-				if process != "" {
-					for _, p := range stats.Processes {
-						if !strings.Contains(p.Name, process) {
-							continue
-						}
-						fmt.Println(strconv.Itoa(p.Pid), p.Name, strconv.Itoa(p.Stat.UserUtil), strconv.Itoa(p.Stat.WaitUtil))
-					}
+				fmt.Println(strings.Join(strs, " "))
+			}
+		}
+
+		processes := stats.Processes
+		if sortKey != "" {
+			var err error
+			if processes, err = os_utils.SortProcesses(stats.Processes, sortKey); err != nil {
+				fmt.Println("Failed", err.Error())
+				return
+			}
+		}
+		if pid != 0 {
+			for _, p := range processes {
+				if p.Pid != pid {
+					continue
 				}
-			},
+				printProcess(&p)
+			}
 		}
-		statCtl := os_utils.NewStatController(&conf)
-		statCtl.Start()
+		if process != "" {
+			for _, p := range processes {
+				if !strings.Contains(p.Name, process) {
+					continue
+				}
+				printProcess(&p)
+			}
+		}
+		if sortKey != "" && pid == 0 && process == "" {
+			for i := 0; i < top && i < len(processes); i++ {
+				printProcess(&processes[i])
+			}
+		}
+	}
+}
+
+var statCaptureCmd = &cobra.Command{
+	Use:   "capture",
+	Short: "capture files read by stat collectors into tar.gz for offline analysis",
+	Run: func(cmd *cobra.Command, args []string) {
+		clkTck, err := os_utils.GetClkTck()
+		if err != nil {
+			fmt.Println("Failed GetClkTck", err.Error())
+			return
+		}
+		if err = os_utils.CaptureStatFiles(statRootDir, captureOutput, interval, captureCount, clkTck); err != nil {
+			fmt.Println("Failed CaptureStatFiles", err.Error())
+			return
+		}
+		fmt.Println("captured:", captureOutput)
 	},
 }
 
+var statAnalyzeCmd = &cobra.Command{
+	Use:   "analyze [capture file]",
+	Short: "run stat collectors against a file created by capture",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := applyStatProfile(cmd); err != nil {
+			fmt.Println("Failed applyStatProfile", err.Error())
+			return
+		}
+		analyzerConfig, err := loadAnalyzerConfig()
+		if err != nil {
+			fmt.Println("Failed loadAnalyzerConfig", err.Error())
+			return
+		}
+
+		conf := os_utils.StatControllerConfig{
+			HandleStats:    newHandleStats(),
+			IsProcessDeep:  isProcessDeep,
+			AnalyzerConfig: analyzerConfig,
+			Collectors:     statCollectors,
+		}
+		meta, err := os_utils.AnalyzeCapture(args[0], &conf)
+		if err != nil {
+			fmt.Println("Failed AnalyzeCapture", err.Error())
+			return
+		}
+		fmt.Println("host:", meta.Hostname, "interval="+strconv.Itoa(meta.Interval), "snapshots="+strconv.Itoa(len(meta.Snapshots)))
+	},
+}
+
+var statHistoryCmd = &cobra.Command{
+	Use:   "history",
+	Short: "show metrics recorded by stat --history",
+	Run: func(cmd *cobra.Command, args []string) {
+		sinceAt, err := stat_history.ParseSince(time.Now(), historySince)
+		if err != nil {
+			fmt.Println("Failed ParseSince", err.Error())
+			return
+		}
+
+		tctx := logger.NewTraceContext()
+		history := newStatHistory()
+		history.MustOpen(tctx)
+		defer history.MustClose(tctx)
+
+		samples, err := history.Query(historyField, sinceAt)
+		if err != nil {
+			fmt.Println("Failed Query", err.Error())
+			return
+		}
+		for _, sample := range samples {
+			strs := []string{
+				"time: " + sample.Time.Format(time.RFC3339),
+				"metric=" + sample.Metric,
+			}
+			if sample.IsRollup {
+				strs = append(strs,
+					"avg="+strconv.FormatFloat(sample.Avg, 'f', 2, 64),
+					"min="+strconv.FormatFloat(sample.Min, 'f', 2, 64),
+					"max="+strconv.FormatFloat(sample.Max, 'f', 2, 64),
+					"count="+strconv.Itoa(sample.Count),
+				)
+			} else {
+				strs = append(strs, "value="+strconv.FormatFloat(sample.Avg, 'f', 2, 64))
+			}
+			fmt.Println(strings.Join(strs, " "))
+		}
+	},
+}
+
+// printNetStat は、NetStatを表示する
+// netnsごとのNetStatの場合は、netns=[name]を付けて表示する
+func printNetStat(netns string, netStat *os_utils.NetStat) {
+	for name, stat := range netStat.NetDevStatMap {
+		if !matchFilter(statFilter.net, name, true) {
+			continue
+		}
+		strs := append(netStrs(netns, "net:"),
+			"dev="+name,
+			"rbps="+strconv.Itoa(stat.ReceiveBytesPerSec),
+			"rpps="+strconv.Itoa(stat.ReceivePacketsPerSec),
+			"reps="+strconv.Itoa(stat.ReceiveErrorsPerSec),
+			"rdps="+strconv.Itoa(stat.ReceiveDropsPerSec),
+			"tbps="+strconv.Itoa(stat.TransmitBytesPerSec),
+			"tpps="+strconv.Itoa(stat.TransmitPacketsPerSec),
+			"teps="+strconv.Itoa(stat.TransmitErrorsPerSec),
+			"tdps="+strconv.Itoa(stat.TransmitDropsPerSec),
+		)
+		fmt.Println(strings.Join(strs, " "))
+	}
+	strs := netStrs(netns, "tcpExt:")
+	if netStat.TcpExtStat.SyncookiesSentPerSec != 0 {
+		strs = append(strs, "SyncookiesSent="+strconv.Itoa(netStat.TcpExtStat.SyncookiesSentPerSec))
+	}
+	if netStat.TcpExtStat.SyncookiesRecvPerSec != 0 {
+		strs = append(strs, "SyncookiesRecv="+strconv.Itoa(netStat.TcpExtStat.SyncookiesRecvPerSec))
+	}
+	if netStat.TcpExtStat.SyncookiesFailedPerSec != 0 {
+		strs = append(strs, "SyncookiesFailed="+strconv.Itoa(netStat.TcpExtStat.SyncookiesFailedPerSec))
+	}
+	if netStat.TcpExtStat.EmbryonicRstsPerSec != 0 {
+		strs = append(strs, "EmbryonicRsts="+strconv.Itoa(netStat.TcpExtStat.EmbryonicRstsPerSec))
+	}
+	if netStat.TcpExtStat.PruneCalledPerSec != 0 {
+		strs = append(strs, "PruneCalled="+strconv.Itoa(netStat.TcpExtStat.PruneCalledPerSec))
+	}
+	if netStat.TcpExtStat.RcvPrunedPerSec != 0 {
+		strs = append(strs, "RcvPruned="+strconv.Itoa(netStat.TcpExtStat.RcvPrunedPerSec))
+	}
+	if netStat.TcpExtStat.OfoPrunedPerSec != 0 {
+		strs = append(strs, "OfoPruned="+strconv.Itoa(netStat.TcpExtStat.OfoPrunedPerSec))
+	}
+	if netStat.TcpExtStat.OutOfWindowIcmpsPerSec != 0 {
+		strs = append(strs, "OutOfWindowIcmps="+strconv.Itoa(netStat.TcpExtStat.OutOfWindowIcmpsPerSec))
+	}
+	if netStat.TcpExtStat.LockDroppedIcmpsPerSec != 0 {
+		strs = append(strs, "LockDroppedIcmps="+strconv.Itoa(netStat.TcpExtStat.LockDroppedIcmpsPerSec))
+	}
+	if netStat.TcpExtStat.ArpFilterPerSec != 0 {
+		strs = append(strs, "ArpFilter="+strconv.Itoa(netStat.TcpExtStat.ArpFilterPerSec))
+	}
+	if netStat.TcpExtStat.TwPerSec != 0 {
+		strs = append(strs, "Tw="+strconv.Itoa(netStat.TcpExtStat.TwPerSec))
+	}
+	if netStat.TcpExtStat.TwRecycledPerSec != 0 {
+		strs = append(strs, "TwRecycled="+strconv.Itoa(netStat.TcpExtStat.TwRecycledPerSec))
+	}
+	if netStat.TcpExtStat.TwKilledPerSec != 0 {
+		strs = append(strs, "TwKilled="+strconv.Itoa(netStat.TcpExtStat.TwKilledPerSec))
+	}
+	if netStat.TcpExtStat.PawsActivePerSec != 0 {
+		strs = append(strs, "PawsActive="+strconv.Itoa(netStat.TcpExtStat.PawsActivePerSec))
+	}
+	if netStat.TcpExtStat.PawsEstabPerSec != 0 {
+		strs = append(strs, "PawsEstab="+strconv.Itoa(netStat.TcpExtStat.PawsEstabPerSec))
+	}
+	if netStat.TcpExtStat.DelayedAcksPerSec != 0 {
+		strs = append(strs, "DelayedAcks="+strconv.Itoa(netStat.TcpExtStat.DelayedAcksPerSec))
+	}
+	if netStat.TcpExtStat.DelayedAckLockedPerSec != 0 {
+		strs = append(strs, "DelayedAckLocked="+strconv.Itoa(netStat.TcpExtStat.DelayedAckLockedPerSec))
+	}
+	if netStat.TcpExtStat.DelayedAckLostPerSec != 0 {
+		strs = append(strs, "DelayedAckLost="+strconv.Itoa(netStat.TcpExtStat.DelayedAckLostPerSec))
+	}
+	if netStat.TcpExtStat.ListenOverflowsPerSec != 0 {
+		strs = append(strs, "ListenOverflows="+strconv.Itoa(netStat.TcpExtStat.ListenOverflowsPerSec))
+	}
+	if netStat.TcpExtStat.ListenDropsPerSec != 0 {
+		strs = append(strs, "ListenDrops="+strconv.Itoa(netStat.TcpExtStat.ListenDropsPerSec))
+	}
+	if netStat.TcpExtStat.TcpHpHitsPerSec != 0 {
+		strs = append(strs, "TcpHpHits="+strconv.Itoa(netStat.TcpExtStat.TcpHpHitsPerSec))
+	}
+	if netStat.TcpExtStat.TcpPureAcksPerSec != 0 {
+		strs = append(strs, "TcpPureAcks="+strconv.Itoa(netStat.TcpExtStat.TcpPureAcksPerSec))
+	}
+	if netStat.TcpExtStat.TcpHpAcksPerSec != 0 {
+		strs = append(strs, "TcpHpAcks="+strconv.Itoa(netStat.TcpExtStat.TcpHpAcksPerSec))
+	}
+	if netStat.TcpExtStat.TcpRenoRecoveryPerSec != 0 {
+		strs = append(strs, "TcpRenoRecovery="+strconv.Itoa(netStat.TcpExtStat.TcpRenoRecoveryPerSec))
+	}
+	if netStat.TcpExtStat.TcpSackRecoveryPerSec != 0 {
+		strs = append(strs, "TcpSackRecovery="+strconv.Itoa(netStat.TcpExtStat.TcpSackRecoveryPerSec))
+	}
+	if netStat.TcpExtStat.TcpSackRenegingPerSec != 0 {
+		strs = append(strs, "TcpSackReneging="+strconv.Itoa(netStat.TcpExtStat.TcpSackRenegingPerSec))
+	}
+	if netStat.TcpExtStat.TcpSackReorderPerSec != 0 {
+		strs = append(strs, "TcpSackReorder="+strconv.Itoa(netStat.TcpExtStat.TcpSackReorderPerSec))
+	}
+	if netStat.TcpExtStat.TcpRenoReorderPerSec != 0 {
+		strs = append(strs, "TcpRenoReorder="+strconv.Itoa(netStat.TcpExtStat.TcpRenoReorderPerSec))
+	}
+	if netStat.TcpExtStat.TcpTsReorderPerSec != 0 {
+		strs = append(strs, "TcpTsReorder="+strconv.Itoa(netStat.TcpExtStat.TcpTsReorderPerSec))
+	}
+	if netStat.TcpExtStat.TcpFullUndoPerSec != 0 {
+		strs = append(strs, "TcpFullUndo="+strconv.Itoa(netStat.TcpExtStat.TcpFullUndoPerSec))
+	}
+	if netStat.TcpExtStat.TcpPartialUndoPerSec != 0 {
+		strs = append(strs, "TcpPartialUndo="+strconv.Itoa(netStat.TcpExtStat.TcpPartialUndoPerSec))
+	}
+	if netStat.TcpExtStat.TcpDsackUndoPerSec != 0 {
+		strs = append(strs, "TcpDsackUndo="+strconv.Itoa(netStat.TcpExtStat.TcpDsackUndoPerSec))
+	}
+	if netStat.TcpExtStat.TcpLossUndoPerSec != 0 {
+		strs = append(strs, "TcpLossUndo="+strconv.Itoa(netStat.TcpExtStat.TcpLossUndoPerSec))
+	}
+	if netStat.TcpExtStat.TcpLostRetransmitPerSec != 0 {
+		strs = append(strs, "TcpLostRetransmit="+strconv.Itoa(netStat.TcpExtStat.TcpLostRetransmitPerSec))
+	}
+	if netStat.TcpExtStat.TcpRenoFailuresPerSec != 0 {
+		strs = append(strs, "TcpRenoFailures="+strconv.Itoa(netStat.TcpExtStat.TcpRenoFailuresPerSec))
+	}
+	if netStat.TcpExtStat.TcpSackFailuresPerSec != 0 {
+		strs = append(strs, "TcpSackFailures="+strconv.Itoa(netStat.TcpExtStat.TcpSackFailuresPerSec))
+	}
+	if netStat.TcpExtStat.TcpLossFailuresPerSec != 0 {
+		strs = append(strs, "TcpLossFailures="+strconv.Itoa(netStat.TcpExtStat.TcpLossFailuresPerSec))
+	}
+	if netStat.TcpExtStat.TcpFastRetransPerSec != 0 {
+		strs = append(strs, "TcpFastRetrans="+strconv.Itoa(netStat.TcpExtStat.TcpFastRetransPerSec))
+	}
+	if netStat.TcpExtStat.TcpSlowStartRetransPerSec != 0 {
+		strs = append(strs, "TcpSlowStartRetrans="+strconv.Itoa(netStat.TcpExtStat.TcpSlowStartRetransPerSec))
+	}
+	if netStat.TcpExtStat.TcpTimeoutsPerSec != 0 {
+		strs = append(strs, "TcpTimeouts="+strconv.Itoa(netStat.TcpExtStat.TcpTimeoutsPerSec))
+	}
+	if netStat.TcpExtStat.TcpLossProbesPerSec != 0 {
+		strs = append(strs, "TcpLossProbes="+strconv.Itoa(netStat.TcpExtStat.TcpLossProbesPerSec))
+	}
+	if netStat.TcpExtStat.TcpLossProbeRecoveryPerSec != 0 {
+		strs = append(strs, "TcpLossProbeRecovery="+strconv.Itoa(netStat.TcpExtStat.TcpLossProbeRecoveryPerSec))
+	}
+	if netStat.TcpExtStat.TcpRenoRecoveryFailPerSec != 0 {
+		strs = append(strs, "TcpRenoRecoveryFail="+strconv.Itoa(netStat.TcpExtStat.TcpRenoRecoveryFailPerSec))
+	}
+	if netStat.TcpExtStat.TcpSackRecoveryFailPerSec != 0 {
+		strs = append(strs, "TcpSackRecoveryFail="+strconv.Itoa(netStat.TcpExtStat.TcpSackRecoveryFailPerSec))
+	}
+	if netStat.TcpExtStat.TcpRcvCollapsedPerSec != 0 {
+		strs = append(strs, "TcpRcvCollapsed="+strconv.Itoa(netStat.TcpExtStat.TcpRcvCollapsedPerSec))
+	}
+	if netStat.TcpExtStat.TcpBacklogCoalescePerSec != 0 {
+		strs = append(strs, "TcpBacklogCoalesce="+strconv.Itoa(netStat.TcpExtStat.TcpBacklogCoalescePerSec))
+	}
+	if netStat.TcpExtStat.TcpDsackOldSentPerSec != 0 {
+		strs = append(strs, "TcpDsackOldSent="+strconv.Itoa(netStat.TcpExtStat.TcpDsackOldSentPerSec))
+	}
+	if netStat.TcpExtStat.TcpDsackOfoSentPerSec != 0 {
+		strs = append(strs, "TcpDsackOfoSent="+strconv.Itoa(netStat.TcpExtStat.TcpDsackOfoSentPerSec))
+	}
+	if netStat.TcpExtStat.TcpDsackRecvPerSec != 0 {
+		strs = append(strs, "TcpDsackRecv="+strconv.Itoa(netStat.TcpExtStat.TcpDsackRecvPerSec))
+	}
+	if netStat.TcpExtStat.TcpDsackOfoRecvPerSec != 0 {
+		strs = append(strs, "TcpDsackOfoRecv="+strconv.Itoa(netStat.TcpExtStat.TcpDsackOfoRecvPerSec))
+	}
+	if netStat.TcpExtStat.TcpAbortOnDataPerSec != 0 {
+		strs = append(strs, "TcpAbortOnData="+strconv.Itoa(netStat.TcpExtStat.TcpAbortOnDataPerSec))
+	}
+	if netStat.TcpExtStat.TcpAbortOnClosePerSec != 0 {
+		strs = append(strs, "TcpAbortOnClose="+strconv.Itoa(netStat.TcpExtStat.TcpAbortOnClosePerSec))
+	}
+	if netStat.TcpExtStat.TcpAbortOnMemoryPerSec != 0 {
+		strs = append(strs, "TcpAbortOnMemory="+strconv.Itoa(netStat.TcpExtStat.TcpAbortOnMemoryPerSec))
+	}
+	if netStat.TcpExtStat.TcpAbortOnTimeoutPerSec != 0 {
+		strs = append(strs, "TcpAbortOnTimeout="+strconv.Itoa(netStat.TcpExtStat.TcpAbortOnTimeoutPerSec))
+	}
+	if netStat.TcpExtStat.TcpAbortOnLingerPerSec != 0 {
+		strs = append(strs, "TcpAbortOnLinger="+strconv.Itoa(netStat.TcpExtStat.TcpAbortOnLingerPerSec))
+	}
+	if netStat.TcpExtStat.TcpAbortFailedPerSec != 0 {
+		strs = append(strs, "TcpAbortFailed="+strconv.Itoa(netStat.TcpExtStat.TcpAbortFailedPerSec))
+	}
+	if netStat.TcpExtStat.TcpMemoryPressuresPerSec != 0 {
+		strs = append(strs, "TcpMemoryPressures="+strconv.Itoa(netStat.TcpExtStat.TcpMemoryPressuresPerSec))
+	}
+	if netStat.TcpExtStat.TcpMemoryPressuresChronoPerSec != 0 {
+		strs = append(strs, "TcpMemoryPressuresChrono="+strconv.Itoa(netStat.TcpExtStat.TcpMemoryPressuresChronoPerSec))
+	}
+	if netStat.TcpExtStat.TcpSackDiscardPerSec != 0 {
+		strs = append(strs, "TcpSackDiscard="+strconv.Itoa(netStat.TcpExtStat.TcpSackDiscardPerSec))
+	}
+	if netStat.TcpExtStat.TcpDsackIgnoredOldPerSec != 0 {
+		strs = append(strs, "TcpDsackIgnoredOld="+strconv.Itoa(netStat.TcpExtStat.TcpDsackIgnoredOldPerSec))
+	}
+	if netStat.TcpExtStat.TcpDsackIgnoredNoUndoPerSec != 0 {
+		strs = append(strs, "TcpDsackIgnoredNoUndo="+strconv.Itoa(netStat.TcpExtStat.TcpDsackIgnoredNoUndoPerSec))
+	}
+	if netStat.TcpExtStat.TcpSpuriousRTOsPerSec != 0 {
+		strs = append(strs, "TcpSpuriousRTOs="+strconv.Itoa(netStat.TcpExtStat.TcpSpuriousRTOsPerSec))
+	}
+	if netStat.TcpExtStat.TcpMd5NotFoundPerSec != 0 {
+		strs = append(strs, "TcpMd5NotFound="+strconv.Itoa(netStat.TcpExtStat.TcpMd5NotFoundPerSec))
+	}
+	if netStat.TcpExtStat.TcpMd5UnexpectedPerSec != 0 {
+		strs = append(strs, "TcpMd5Unexpected="+strconv.Itoa(netStat.TcpExtStat.TcpMd5UnexpectedPerSec))
+	}
+	if netStat.TcpExtStat.TcpMd5FailurePerSec != 0 {
+		strs = append(strs, "TcpMd5Failure="+strconv.Itoa(netStat.TcpExtStat.TcpMd5FailurePerSec))
+	}
+	if netStat.TcpExtStat.TcpSackShiftedPerSec != 0 {
+		strs = append(strs, "TcpSackShifted="+strconv.Itoa(netStat.TcpExtStat.TcpSackShiftedPerSec))
+	}
+	if netStat.TcpExtStat.TcpSackMergedPerSec != 0 {
+		strs = append(strs, "TcpSackMerged="+strconv.Itoa(netStat.TcpExtStat.TcpSackMergedPerSec))
+	}
+	if netStat.TcpExtStat.TcpSackShiftFallbackPerSec != 0 {
+		strs = append(strs, "TcpSackShiftFallback="+strconv.Itoa(netStat.TcpExtStat.TcpSackShiftFallbackPerSec))
+	}
+	if netStat.TcpExtStat.TcpBacklogDropPerSec != 0 {
+		strs = append(strs, "TcpBacklogDrop="+strconv.Itoa(netStat.TcpExtStat.TcpBacklogDropPerSec))
+	}
+	if netStat.TcpExtStat.PfMemallocDropPerSec != 0 {
+		strs = append(strs, "PfMemallocDrop="+strconv.Itoa(netStat.TcpExtStat.PfMemallocDropPerSec))
+	}
+	if netStat.TcpExtStat.TcpMinTtlDropPerSec != 0 {
+		strs = append(strs, "TcpMinTtlDrop="+strconv.Itoa(netStat.TcpExtStat.TcpMinTtlDropPerSec))
+	}
+	if netStat.TcpExtStat.TcpDeferAcceptDropPerSec != 0 {
+		strs = append(strs, "TcpDeferAcceptDrop="+strconv.Itoa(netStat.TcpExtStat.TcpDeferAcceptDropPerSec))
+	}
+	if netStat.TcpExtStat.IpReversePathFilterPerSec != 0 {
+		strs = append(strs, "IpReversePathFilter="+strconv.Itoa(netStat.TcpExtStat.IpReversePathFilterPerSec))
+	}
+	if netStat.TcpExtStat.TcpTimeWaitOverflowPerSec != 0 {
+		strs = append(strs, "TcpTimeWaitOverflow="+strconv.Itoa(netStat.TcpExtStat.TcpTimeWaitOverflowPerSec))
+	}
+	if netStat.TcpExtStat.TcpReqQFullDoCookiesPerSec != 0 {
+		strs = append(strs, "TcpReqQFullDoCookies="+strconv.Itoa(netStat.TcpExtStat.TcpReqQFullDoCookiesPerSec))
+	}
+	if netStat.TcpExtStat.TcpReqQFullDropPerSec != 0 {
+		strs = append(strs, "TcpReqQFullDrop="+strconv.Itoa(netStat.TcpExtStat.TcpReqQFullDropPerSec))
+	}
+	if netStat.TcpExtStat.TcpRetransFailPerSec != 0 {
+		strs = append(strs, "TcpRetransFail="+strconv.Itoa(netStat.TcpExtStat.TcpRetransFailPerSec))
+	}
+	if netStat.TcpExtStat.TcpRcvCoalescePerSec != 0 {
+		strs = append(strs, "TcpRcvCoalesce="+strconv.Itoa(netStat.TcpExtStat.TcpRcvCoalescePerSec))
+	}
+	if netStat.TcpExtStat.TcpOfoQueuePerSec != 0 {
+		strs = append(strs, "TcpOfoQueue="+strconv.Itoa(netStat.TcpExtStat.TcpOfoQueuePerSec))
+	}
+	if netStat.TcpExtStat.TcpOfoDropPerSec != 0 {
+		strs = append(strs, "TcpOfoDrop="+strconv.Itoa(netStat.TcpExtStat.TcpOfoDropPerSec))
+	}
+	if netStat.TcpExtStat.TcpOfoMergePerSec != 0 {
+		strs = append(strs, "TcpOfoMerge="+strconv.Itoa(netStat.TcpExtStat.TcpOfoMergePerSec))
+	}
+	if netStat.TcpExtStat.TcpChallengeACKPerSec != 0 {
+		strs = append(strs, "TcpChallengeACK="+strconv.Itoa(netStat.TcpExtStat.TcpChallengeACKPerSec))
+	}
+	if netStat.TcpExtStat.TcpSynChallengePerSec != 0 {
+		strs = append(strs, "TcpSynChallenge="+strconv.Itoa(netStat.TcpExtStat.TcpSynChallengePerSec))
+	}
+	if netStat.TcpExtStat.TcpFastOpenActivePerSec != 0 {
+		strs = append(strs, "TcpFastOpenActive="+strconv.Itoa(netStat.TcpExtStat.TcpFastOpenActivePerSec))
+	}
+	if netStat.TcpExtStat.TcpFastOpenActiveFailPerSec != 0 {
+		strs = append(strs, "TcpFastOpenActiveFail="+strconv.Itoa(netStat.TcpExtStat.TcpFastOpenActiveFailPerSec))
+	}
+	if netStat.TcpExtStat.TcpFastOpenPassivePerSec != 0 {
+		strs = append(strs, "TcpFastOpenPassive="+strconv.Itoa(netStat.TcpExtStat.TcpFastOpenPassivePerSec))
+	}
+	if netStat.TcpExtStat.TcpFastOpenPassiveFailPerSec != 0 {
+		strs = append(strs, "TcpFastOpenPassiveFail="+strconv.Itoa(netStat.TcpExtStat.TcpFastOpenPassiveFailPerSec))
+	}
+	if netStat.TcpExtStat.TcpFastOpenListenOverflowPerSec != 0 {
+		strs = append(strs, "TcpFastOpenListenOverflow="+strconv.Itoa(netStat.TcpExtStat.TcpFastOpenListenOverflowPerSec))
+	}
+	if netStat.TcpExtStat.TcpFastOpenCookieReqdPerSec != 0 {
+		strs = append(strs, "TcpFastOpenCookieReqd="+strconv.Itoa(netStat.TcpExtStat.TcpFastOpenCookieReqdPerSec))
+	}
+	if netStat.TcpExtStat.TcpFastOpenBlackholePerSec != 0 {
+		strs = append(strs, "TcpFastOpenBlackhole="+strconv.Itoa(netStat.TcpExtStat.TcpFastOpenBlackholePerSec))
+	}
+	if netStat.TcpExtStat.TcpSpuriousRtxHostQueuesPerSec != 0 {
+		strs = append(strs, "TcpSpuriousRtxHostQueues="+strconv.Itoa(netStat.TcpExtStat.TcpSpuriousRtxHostQueuesPerSec))
+	}
+	if netStat.TcpExtStat.BusyPollRxPacketsPerSec != 0 {
+		strs = append(strs, "BusyPollRxPackets="+strconv.Itoa(netStat.TcpExtStat.BusyPollRxPacketsPerSec))
+	}
+	if netStat.TcpExtStat.TcpAutoCorkingPerSec != 0 {
+		strs = append(strs, "TcpAutoCorking="+strconv.Itoa(netStat.TcpExtStat.TcpAutoCorkingPerSec))
+	}
+	if netStat.TcpExtStat.TcpFromZeroWindowAdvPerSec != 0 {
+		strs = append(strs, "TcpFromZeroWindowAdv="+strconv.Itoa(netStat.TcpExtStat.TcpFromZeroWindowAdvPerSec))
+	}
+	if netStat.TcpExtStat.TcpToZeroWindowAdvPerSec != 0 {
+		strs = append(strs, "TcpToZeroWindowAdv="+strconv.Itoa(netStat.TcpExtStat.TcpToZeroWindowAdvPerSec))
+	}
+	if netStat.TcpExtStat.TcpWantZeroWindowAdvPerSec != 0 {
+		strs = append(strs, "TcpWantZeroWindowAdv="+strconv.Itoa(netStat.TcpExtStat.TcpWantZeroWindowAdvPerSec))
+	}
+	if netStat.TcpExtStat.TcpSynRetransPerSec != 0 {
+		strs = append(strs, "TcpSynRetrans="+strconv.Itoa(netStat.TcpExtStat.TcpSynRetransPerSec))
+	}
+	if netStat.TcpExtStat.TcpOrigDataSentPerSec != 0 {
+		strs = append(strs, "TcpOrigDataSent="+strconv.Itoa(netStat.TcpExtStat.TcpOrigDataSentPerSec))
+	}
+	if netStat.TcpExtStat.TcpHystartTrainDetectPerSec != 0 {
+		strs = append(strs, "TcpHystartTrainDetect="+strconv.Itoa(netStat.TcpExtStat.TcpHystartTrainDetectPerSec))
+	}
+	if netStat.TcpExtStat.TcpHystartTrainCwndPerSec != 0 {
+		strs = append(strs, "TcpHystartTrainCwnd="+strconv.Itoa(netStat.TcpExtStat.TcpHystartTrainCwndPerSec))
+	}
+	if netStat.TcpExtStat.TcpHystartDelayDetectPerSec != 0 {
+		strs = append(strs, "TcpHystartDelayDetect="+strconv.Itoa(netStat.TcpExtStat.TcpHystartDelayDetectPerSec))
+	}
+	if netStat.TcpExtStat.TcpHystartDelayCwndPerSec != 0 {
+		strs = append(strs, "TcpHystartDelayCwnd="+strconv.Itoa(netStat.TcpExtStat.TcpHystartDelayCwndPerSec))
+	}
+	if netStat.TcpExtStat.TcpAckSkippedSynRecvPerSec != 0 {
+		strs = append(strs, "TcpAckSkippedSynRecv="+strconv.Itoa(netStat.TcpExtStat.TcpAckSkippedSynRecvPerSec))
+	}
+	if netStat.TcpExtStat.TcpAckSkippedPAWSPerSec != 0 {
+		strs = append(strs, "TcpAckSkippedPAWS="+strconv.Itoa(netStat.TcpExtStat.TcpAckSkippedPAWSPerSec))
+	}
+	if netStat.TcpExtStat.TcpAckSkippedSeqPerSec != 0 {
+		strs = append(strs, "TcpAckSkippedSeq="+strconv.Itoa(netStat.TcpExtStat.TcpAckSkippedSeqPerSec))
+	}
+	if netStat.TcpExtStat.TcpAckSkippedFinWait2PerSec != 0 {
+		strs = append(strs, "TcpAckSkippedFinWait2="+strconv.Itoa(netStat.TcpExtStat.TcpAckSkippedFinWait2PerSec))
+	}
+	if netStat.TcpExtStat.TcpAckSkippedTimeWaitPerSec != 0 {
+		strs = append(strs, "TcpAckSkippedTimeWait="+strconv.Itoa(netStat.TcpExtStat.TcpAckSkippedTimeWaitPerSec))
+	}
+	if netStat.TcpExtStat.TcpAckSkippedChallengePerSec != 0 {
+		strs = append(strs, "TcpAckSkippedChallenge="+strconv.Itoa(netStat.TcpExtStat.TcpAckSkippedChallengePerSec))
+	}
+	if netStat.TcpExtStat.TcpWinProbePerSec != 0 {
+		strs = append(strs, "TcpWinProbe="+strconv.Itoa(netStat.TcpExtStat.TcpWinProbePerSec))
+	}
+	if netStat.TcpExtStat.TcpKeepAlivePerSec != 0 {
+		strs = append(strs, "TcpKeepAlive="+strconv.Itoa(netStat.TcpExtStat.TcpKeepAlivePerSec))
+	}
+	if netStat.TcpExtStat.TcpMtupFailPerSec != 0 {
+		strs = append(strs, "TcpMtupFail="+strconv.Itoa(netStat.TcpExtStat.TcpMtupFailPerSec))
+	}
+	if netStat.TcpExtStat.TcpMtupSuccessPerSec != 0 {
+		strs = append(strs, "TcpMtupSuccess="+strconv.Itoa(netStat.TcpExtStat.TcpMtupSuccessPerSec))
+	}
+	if netStat.TcpExtStat.TcpDeliveredPerSec != 0 {
+		strs = append(strs, "TcpDelivered="+strconv.Itoa(netStat.TcpExtStat.TcpDeliveredPerSec))
+	}
+	if netStat.TcpExtStat.TcpDeliveredCEPerSec != 0 {
+		strs = append(strs, "TcpDeliveredCE="+strconv.Itoa(netStat.TcpExtStat.TcpDeliveredCEPerSec))
+	}
+	if netStat.TcpExtStat.TcpAckCompressedPerSec != 0 {
+		strs = append(strs, "TcpAckCompressed="+strconv.Itoa(netStat.TcpExtStat.TcpAckCompressedPerSec))
+	}
+	if netStat.TcpExtStat.TcpZeroWindowDropPerSec != 0 {
+		strs = append(strs, "TcpZeroWindowDrop="+strconv.Itoa(netStat.TcpExtStat.TcpZeroWindowDropPerSec))
+	}
+	if netStat.TcpExtStat.TcpRcvQDropPerSec != 0 {
+		strs = append(strs, "TcpRcvQDrop="+strconv.Itoa(netStat.TcpExtStat.TcpRcvQDropPerSec))
+	}
+	if netStat.TcpExtStat.TcpWqueueTooBigPerSec != 0 {
+		strs = append(strs, "TcpWqueueTooBig="+strconv.Itoa(netStat.TcpExtStat.TcpWqueueTooBigPerSec))
+	}
+	if netStat.TcpExtStat.TcpFastOpenPassiveAltKeyPerSec != 0 {
+		strs = append(strs, "TcpFastOpenPassiveAltKey="+strconv.Itoa(netStat.TcpExtStat.TcpFastOpenPassiveAltKeyPerSec))
+	}
+
+	fmt.Println(strings.Join(strs, " "))
+
+	strs = netStrs(netns, "ipExt:")
+
+	if netStat.IpExtStat.InNoRoutesPerSec != 0 {
+		strs = append(strs, "InNoRoutes="+strconv.Itoa(netStat.IpExtStat.InNoRoutesPerSec))
+	}
+	if netStat.IpExtStat.InTruncatedPktsPerSec != 0 {
+		strs = append(strs, "InTruncatedPkts="+strconv.Itoa(netStat.IpExtStat.InTruncatedPktsPerSec))
+	}
+	if netStat.IpExtStat.InCsumErrorsPerSec != 0 {
+		strs = append(strs, "InCsumErrors="+strconv.Itoa(netStat.IpExtStat.InCsumErrorsPerSec))
+	}
+	if netStat.IpExtStat.InNoRoutesPerSec != 0 {
+		strs = append(strs, "InNoRoutes="+strconv.Itoa(netStat.IpExtStat.InNoRoutesPerSec))
+	}
+	if netStat.IpExtStat.InTruncatedPktsPerSec != 0 {
+		strs = append(strs, "InTruncatedPkts="+strconv.Itoa(netStat.IpExtStat.InTruncatedPktsPerSec))
+	}
+	if netStat.IpExtStat.InMcastPktsPerSec != 0 {
+		strs = append(strs, "InMcastPkts="+strconv.Itoa(netStat.IpExtStat.InMcastPktsPerSec))
+	}
+	if netStat.IpExtStat.OutMcastPktsPerSec != 0 {
+		strs = append(strs, "OutMcastPkts="+strconv.Itoa(netStat.IpExtStat.OutMcastPktsPerSec))
+	}
+	if netStat.IpExtStat.InBcastPktsPerSec != 0 {
+		strs = append(strs, "InBcastPkts="+strconv.Itoa(netStat.IpExtStat.InBcastPktsPerSec))
+	}
+	if netStat.IpExtStat.OutBcastPktsPerSec != 0 {
+		strs = append(strs, "OutBcastPkts="+strconv.Itoa(netStat.IpExtStat.OutBcastPktsPerSec))
+	}
+	if netStat.IpExtStat.InOctetsPerSec != 0 {
+		strs = append(strs, "InOctets="+strconv.Itoa(netStat.IpExtStat.InOctetsPerSec))
+	}
+	if netStat.IpExtStat.OutOctetsPerSec != 0 {
+		strs = append(strs, "OutOctets="+strconv.Itoa(netStat.IpExtStat.OutOctetsPerSec))
+	}
+	if netStat.IpExtStat.InMcastOctetsPerSec != 0 {
+		strs = append(strs, "InMcastOctets="+strconv.Itoa(netStat.IpExtStat.InMcastOctetsPerSec))
+	}
+	if netStat.IpExtStat.OutMcastOctetsPerSec != 0 {
+		strs = append(strs, "OutMcastOctets="+strconv.Itoa(netStat.IpExtStat.OutMcastOctetsPerSec))
+	}
+	if netStat.IpExtStat.InBcastOctetsPerSec != 0 {
+		strs = append(strs, "InBcastOctets="+strconv.Itoa(netStat.IpExtStat.InBcastOctetsPerSec))
+	}
+	if netStat.IpExtStat.OutBcastOctetsPerSec != 0 {
+		strs = append(strs, "OutBcastOctets="+strconv.Itoa(netStat.IpExtStat.OutBcastOctetsPerSec))
+	}
+	if netStat.IpExtStat.InCsumErrorsPerSec != 0 {
+		strs = append(strs, "InCsumErrors="+strconv.Itoa(netStat.IpExtStat.InCsumErrorsPerSec))
+	}
+	if netStat.IpExtStat.InNoECTPktsPerSec != 0 {
+		strs = append(strs, "InNoECTPkts="+strconv.Itoa(netStat.IpExtStat.InNoECTPktsPerSec))
+	}
+	if netStat.IpExtStat.InECT1PktsPerSec != 0 {
+		strs = append(strs, "InECT1Pkts="+strconv.Itoa(netStat.IpExtStat.InECT1PktsPerSec))
+	}
+	if netStat.IpExtStat.InECT0PktsPerSec != 0 {
+		strs = append(strs, "InECT0Pkts="+strconv.Itoa(netStat.IpExtStat.InECT0PktsPerSec))
+	}
+	if netStat.IpExtStat.InCEPktsPerSec != 0 {
+		strs = append(strs, "InCEPkts="+strconv.Itoa(netStat.IpExtStat.InCEPktsPerSec))
+	}
+	if netStat.IpExtStat.ReasmOverlapsPerSec != 0 {
+		strs = append(strs, "ReasmOverlaps="+strconv.Itoa(netStat.IpExtStat.ReasmOverlapsPerSec))
+	}
+
+	fmt.Println(strings.Join(strs, " "))
+
+	strs = append(netStrs(netns, "sock:"),
+		"used="+strconv.Itoa(netStat.SockStat.SocketsUsed),
+		"tcp="+strconv.Itoa(netStat.SockStat.TcpInuse),
+		"orphan="+strconv.Itoa(netStat.SockStat.TcpOrphan),
+		"tw="+strconv.Itoa(netStat.SockStat.TcpTw),
+		"alloc="+strconv.Itoa(netStat.SockStat.TcpAlloc),
+		"mem="+strconv.Itoa(netStat.SockStat.TcpMemPages),
+		"udp="+strconv.Itoa(netStat.SockStat.UdpInuse),
+		"raw="+strconv.Itoa(netStat.SockStat.RawInuse),
+		"frag="+strconv.Itoa(netStat.SockStat.FragInuse),
+	)
+	fmt.Println(strings.Join(strs, " "))
+}
+
+func netStrs(netns string, prefix string) []string {
+	if netns == "" {
+		return []string{prefix}
+	}
+	return []string{prefix, "netns=" + netns}
+}
+
+func printProcess(p *os_utils.Process) {
+	strs := []string{strconv.Itoa(p.Pid), p.Name, strconv.Itoa(p.Stat.UserUtil), strconv.Itoa(p.Stat.WaitUtil)}
+	if isProcessDeep || sortKey != "" {
+		strs = append(strs,
+			"rss="+strconv.Itoa(p.Stat.VmRssKb),
+			"pss="+strconv.Itoa(p.Stat.PssKb),
+			"uss="+strconv.Itoa(p.Stat.UssKb),
+			"swap="+strconv.Itoa(p.Stat.SwapKb),
+			"anonhuge="+strconv.Itoa(p.Stat.AnonHugePagesKb),
+			"oom="+strconv.Itoa(p.Stat.OomScore),
+			"oomadj="+strconv.Itoa(p.Stat.OomScoreAdj),
+		)
+	}
+	fmt.Println(strings.Join(strs, " "))
+}
+
 func init() {
 	statCmd.PersistentFlags().IntVarP(&interval, "interval", "i", 1, "interval")
 	statCmd.PersistentFlags().BoolVarP(&isStat, "stat", "s", false, "stat")
@@ -624,6 +1177,27 @@ func init() {
 	statCmd.PersistentFlags().IntVarP(&pid, "process pid", "p", 0, "timeout for stopping process")
 	statCmd.PersistentFlags().StringVarP(&process, "process", "P", "", "timeout for stopping process")
 	statCmd.PersistentFlags().StringVarP(&target, "target", "t", "", "stat target")
+	statCmd.PersistentFlags().BoolVar(&isProcessDeep, "deep", false, "read smaps_rollup of processes (pss, uss, swap)")
+	statCmd.PersistentFlags().StringVar(&sortKey, "sort", "", "sort processes by key (rss, pss, uss, swap, anonhuge, oom, cpu), pss, uss, swap and anonhuge enable --deep")
+	statCmd.PersistentFlags().IntVar(&top, "top", 10, "number of processes shown with --sort")
+	statCmd.PersistentFlags().StringVar(&statRootDir, "root-dir", "/", "root directory of /proc and /sys")
+	statCmd.PersistentFlags().BoolVar(&isAnomaly, "anomaly", false, "detect anomalies with default settings")
+	statCmd.PersistentFlags().StringVar(&anomalyConfigPath, "anomaly-config", "", "yaml file of anomaly detection settings (alpha, sigma, warmUp, metrics)")
+
+	statCmd.PersistentFlags().BoolVar(&isHistory, "history", false, "record metrics to the history db (1s raw for 1h, 1m rollups for 1 week)")
+	statCmd.PersistentFlags().StringVar(&historyDbPath, "history-db", filepath.Join(os.Getenv("HOME"), ".cache/goapp2/stat_history.db"), "sqlite db file of the history")
+
+	statCmd.PersistentFlags().StringVar(&statConfigPath, "config", "", "yaml file of stat profiles (default ~/.config/goapp2/node-ctl.yaml)")
+	statCmd.PersistentFlags().StringVar(&statProfileName, "profile", "", "profile name in the --config file")
+	statCmd.PersistentFlags().StringVar(&statOutputFormat, "format", statOutputText, "output format (text, json)")
+
+	statCaptureCmd.Flags().StringVarP(&captureOutput, "output", "o", "node.tar.gz", "output file")
+	statCaptureCmd.Flags().IntVar(&captureCount, "count", 2, "number of snapshots taken at --interval seconds apart")
+	statCmd.AddCommand(statCaptureCmd)
+	statCmd.AddCommand(statAnalyzeCmd)
+	statHistoryCmd.Flags().StringVar(&historySince, "since", "1h", "show metrics since this duration ago (e.g. 30m, 2h, 7d)")
+	statHistoryCmd.Flags().StringVar(&historyField, "field", "cpu.ctx", "metric name, glob patterns are allowed (e.g. disk.*.rbps)")
+	statCmd.AddCommand(statHistoryCmd)
 
 	rootCmd.AddCommand(statCmd)
 }
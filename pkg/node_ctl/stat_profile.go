@@ -0,0 +1,314 @@
+package node_ctl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/syunkitada/goapp2/pkg/lib/os_utils"
+	"gopkg.in/yaml.v3"
+)
+
+// StatConfig は、node-ctl statの設定ファイル(--config)
+type StatConfig struct {
+	Profiles map[string]StatProfile `yaml:"profiles"`
+}
+
+// StatProfile は、--profileで指定する表示設定
+// コマンドラインで明示的に指定したフラグは、プロファイルよりも優先される
+type StatProfile struct {
+	Interval   int                      `yaml:"interval"`
+	Collectors []string                 `yaml:"collectors"` // 空の場合は全てのcollectorを実行する
+	Fields     []string                 `yaml:"fields"`     // 表示する項目(statFieldTargetsのキー)
+	Filters    StatFilters              `yaml:"filters"`
+	Thresholds map[string]float64       `yaml:"thresholds"` // メトリクス名(filepath.Matchのパターン)ごとの上限
+	Anomaly    *os_utils.AnalyzerConfig `yaml:"anomaly"`
+	Process    StatProcessProfile       `yaml:"process"`
+	Output     string                   `yaml:"output"` // text(デフォルト), json (--formatに対応)
+	Sinks      StatSinks                `yaml:"sinks"`
+}
+
+// StatFilters は、表示するデバイスなどを正規表現で絞り込む
+type StatFilters struct {
+	Disk  string `yaml:"disk"`  // デバイス名
+	Fs    string `yaml:"fs"`    // マウントパス
+	Net   string `yaml:"net"`   // デバイス名
+	Netns string `yaml:"netns"` // netns名
+}
+
+type StatProcessProfile struct {
+	Name string `yaml:"name"`
+	Sort string `yaml:"sort"`
+	Top  int    `yaml:"top"`
+	Deep bool   `yaml:"deep"`
+}
+
+type StatSinks struct {
+	History *StatHistorySink `yaml:"history"`
+}
+
+type StatHistorySink struct {
+	Db string `yaml:"db"` // 空の場合は--history-dbのデフォルト
+}
+
+const (
+	statOutputText = "text"
+	statOutputJson = "json"
+)
+
+// statFieldTargets は、fieldsの項目と-tの文字の対応
+var statFieldTargets = map[string]string{
+	"cpu":         "c",
+	"cpu-wide":    "C",
+	"mem":         "m",
+	"mem-wide":    "M",
+	"buddyinfo":   "b",
+	"disk":        "d",
+	"disk-wide":   "D",
+	"fs":          "f",
+	"net":         "n",
+	"netns":       "N",
+	"user":        "u",
+	"load":        "l",
+	"load-wide":   "L",
+	"fd":          "o",
+	"fd-wide":     "O",
+	"sensor":      "h",
+	"sensor-wide": "H",
+	"collector":   "e",
+	"kmsg":        "k",
+	"slab":        "s",
+	"conntrack":   "t",
+}
+
+// statFilterRegexps は、StatFiltersをコンパイルしたもの(nilの場合は絞り込まない)
+type statFilterRegexps struct {
+	disk  *regexp.Regexp
+	fs    *regexp.Regexp
+	net   *regexp.Regexp
+	netns *regexp.Regexp
+}
+
+var statConfigPath string
+var statProfileName string
+var statCollectors []string
+var statFilter statFilterRegexps
+var statThresholds map[string]float64
+var statOutputFormat string
+var profileAnomaly *os_utils.AnalyzerConfig
+
+func defaultStatConfigPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".config/goapp2/node-ctl.yaml")
+}
+
+func loadStatConfig(path string) (conf *StatConfig, err error) {
+	// $ cat ~/.config/goapp2/node-ctl.yaml
+	// profiles:
+	//   vmhost:
+	//     interval: 2
+	//     collectors: [cpu, mem, net, netns, loadavg, kmsg, process]
+	//     fields: [cpu-wide, mem, net, netns, load, kmsg]
+	//     filters:
+	//       net: ^(en|com-)
+	//       netns: ^com-
+	//     thresholds:
+	//       load.1mpc: 2
+	//       netns.*.tcpext.ListenDrops: 1
+	//     anomaly:
+	//       sigma: 4
+	//     process:
+	//       name: qemu
+	//       sort: rss
+	//       top: 5
+	//     output: text
+	//     sinks:
+	//       history: {}
+	var tmpBytes []byte
+	if tmpBytes, err = ioutil.ReadFile(path); err != nil {
+		return
+	}
+	conf = &StatConfig{}
+	if err = yaml.Unmarshal(tmpBytes, conf); err != nil {
+		err = fmt.Errorf("Failed parse %s: %s", path, err.Error())
+		return
+	}
+	return
+}
+
+// applyStatProfile は、--profileのプロファイルをフラグの値に反映する
+func applyStatProfile(cmd *cobra.Command) (err error) {
+	if statProfileName == "" {
+		if statOutputFormat != statOutputText && statOutputFormat != statOutputJson {
+			err = fmt.Errorf("Invalid format: %s", statOutputFormat)
+			return
+		}
+		err = applyProcessDeep(cmd)
+		return
+	}
+
+	configPath := statConfigPath
+	if configPath == "" {
+		configPath = defaultStatConfigPath()
+	}
+	var conf *StatConfig
+	if conf, err = loadStatConfig(configPath); err != nil {
+		return
+	}
+	profile, ok := conf.Profiles[statProfileName]
+	if !ok {
+		names := make([]string, 0, len(conf.Profiles))
+		for name := range conf.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		err = fmt.Errorf("Profile Not Found: profile=%s, available=%s", statProfileName, strings.Join(names, ","))
+		return
+	}
+
+	flags := cmd.Flags()
+	if profile.Interval > 0 && !flags.Changed("interval") {
+		interval = profile.Interval
+	}
+
+	for _, collector := range profile.Collectors {
+		if !containsString(os_utils.CollectorNames, collector) {
+			err = fmt.Errorf("Invalid collector: %s, available=%s", collector, strings.Join(os_utils.CollectorNames, ","))
+			return
+		}
+	}
+	statCollectors = profile.Collectors
+
+	if len(profile.Fields) > 0 && !flags.Changed("target") {
+		targets := []string{}
+		for _, field := range profile.Fields {
+			t, ok := statFieldTargets[field]
+			if !ok {
+				err = fmt.Errorf("Invalid field: %s", field)
+				return
+			}
+			targets = append(targets, t)
+		}
+		target = strings.Join(targets, "")
+	}
+
+	if statFilter.disk, err = compileFilter(profile.Filters.Disk); err != nil {
+		return
+	}
+	if statFilter.fs, err = compileFilter(profile.Filters.Fs); err != nil {
+		return
+	}
+	if statFilter.net, err = compileFilter(profile.Filters.Net); err != nil {
+		return
+	}
+	if statFilter.netns, err = compileFilter(profile.Filters.Netns); err != nil {
+		return
+	}
+
+	statThresholds = profile.Thresholds
+
+	if profile.Process.Name != "" && !flags.Changed("process") {
+		process = profile.Process.Name
+	}
+	if profile.Process.Sort != "" && !flags.Changed("sort") {
+		sortKey = profile.Process.Sort
+	}
+	if profile.Process.Top > 0 && !flags.Changed("top") {
+		top = profile.Process.Top
+	}
+	if profile.Process.Deep && !flags.Changed("deep") {
+		isProcessDeep = true
+	}
+
+	if profile.Output != "" && !flags.Changed("format") {
+		statOutputFormat = profile.Output
+	}
+	if statOutputFormat != statOutputText && statOutputFormat != statOutputJson {
+		err = fmt.Errorf("Invalid format: %s", statOutputFormat)
+		return
+	}
+
+	if profile.Sinks.History != nil {
+		if !flags.Changed("history") {
+			isHistory = true
+		}
+		if profile.Sinks.History.Db != "" && !flags.Changed("history-db") {
+			historyDbPath = profile.Sinks.History.Db
+		}
+	}
+
+	if profile.Anomaly != nil && !flags.Changed("anomaly") && !flags.Changed("anomaly-config") {
+		profileAnomaly = profile.Anomaly
+	}
+
+	err = applyProcessDeep(cmd)
+	return
+}
+
+// applyProcessDeep は、sortKeyがsmaps_rollupの値(pss, uss, swap, anonhuge)の場合に--deepを有効にする
+// --deepを有効にしないと全て0で並べ替えることになるので、--deep=falseを明示的に指定した場合はエラーとする
+func applyProcessDeep(cmd *cobra.Command) (err error) {
+	if isProcessDeep || !os_utils.IsDeepProcessSortKey(sortKey) {
+		return
+	}
+	if cmd.Flags().Changed("deep") {
+		err = fmt.Errorf("Invalid sort: sort=%s requires --deep", sortKey)
+		return
+	}
+	isProcessDeep = true
+	return
+}
+
+func compileFilter(expr string) (re *regexp.Regexp, err error) {
+	if expr == "" {
+		return
+	}
+	if re, err = regexp.Compile(expr); err != nil {
+		err = fmt.Errorf("Invalid filter: %s, err=%s", expr, err.Error())
+	}
+	return
+}
+
+// matchFilter は、フィルタが指定されていない場合はdefaultMatchedを返す
+func matchFilter(re *regexp.Regexp, name string, defaultMatched bool) bool {
+	if re == nil {
+		return defaultMatched
+	}
+	return re.MatchString(name)
+}
+
+type exceededThreshold struct {
+	metric    string
+	value     float64
+	threshold float64
+}
+
+// exceededThresholds は、thresholdsを超えたメトリクスを名前順に返す
+func exceededThresholds(stats *os_utils.Stats) (exceeded []exceededThreshold) {
+	if len(statThresholds) == 0 {
+		return
+	}
+	for name, value := range os_utils.GetStatsMetrics(stats) {
+		for pattern, threshold := range statThresholds {
+			if matched, _ := filepath.Match(pattern, name); matched && value > threshold {
+				exceeded = append(exceeded, exceededThreshold{metric: name, value: value, threshold: threshold})
+				break
+			}
+		}
+	}
+	sort.Slice(exceeded, func(i, j int) bool { return exceeded[i].metric < exceeded[j].metric })
+	return
+}
+
+func containsString(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
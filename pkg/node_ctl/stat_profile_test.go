@@ -0,0 +1,248 @@
+package node_ctl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/syunkitada/goapp2/pkg/lib/os_utils"
+)
+
+const testStatConfig = `profiles:
+  vmhost:
+    interval: 2
+    collectors: [cpu, mem, net, netns, loadavg]
+    fields: [cpu-wide, mem, netns]
+    filters:
+      netns: ^com-
+    thresholds:
+      load.1mpc: 2
+    process:
+      name: qemu
+      sort: rss
+      top: 5
+      deep: true
+    output: json
+    sinks:
+      history:
+        db: /tmp/history.db
+  badfield:
+    fields: [cpu, unknown]
+  badcollector:
+    collectors: [unknown]
+  badfilter:
+    filters:
+      net: "("
+  pss:
+    process:
+      sort: pss
+`
+
+// resetStatFlags は、statCmdのフラグと、プロファイルで設定される値を初期状態に戻す
+func resetStatFlags(t *testing.T) {
+	for _, name := range []string{"interval", "target", "process", "sort", "top", "deep", "format",
+		"history", "history-db", "anomaly", "anomaly-config", "config", "profile"} {
+		flag := statCmd.PersistentFlags().Lookup(name)
+		if err := flag.Value.Set(flag.DefValue); err != nil {
+			t.Fatal(err)
+		}
+		flag.Changed = false
+	}
+	statCollectors = nil
+	statFilter = statFilterRegexps{}
+	statThresholds = nil
+	profileAnomaly = nil
+}
+
+func TestApplyStatProfile(t *testing.T) {
+	a := assert.New(t)
+
+	// --configを省略した場合は、~/.config/goapp2/node-ctl.yamlを読む
+	homeDir := t.TempDir()
+	t.Setenv("HOME", homeDir)
+	configPath := filepath.Join(homeDir, ".config/goapp2/node-ctl.yaml")
+	a.NoError(os.MkdirAll(filepath.Dir(configPath), 0755))
+	a.NoError(ioutil.WriteFile(configPath, []byte(testStatConfig), 0644))
+
+	tests := []struct {
+		name  string
+		args  []string
+		err   string
+		check func()
+	}{
+		{
+			name: "profile",
+			args: []string{"--profile", "vmhost"},
+			check: func() {
+				a.Equal(2, interval)
+				a.Equal("CmN", target)
+				a.Equal([]string{"cpu", "mem", "net", "netns", "loadavg"}, statCollectors)
+				a.Equal(map[string]float64{"load.1mpc": 2}, statThresholds)
+				a.True(matchFilter(statFilter.netns, "com-0", false))
+				a.False(matchFilter(statFilter.netns, "default", false))
+				a.True(matchFilter(statFilter.net, "eth0", true))
+				a.Equal("qemu", process)
+				a.Equal("rss", sortKey)
+				a.Equal(5, top)
+				a.True(isProcessDeep)
+				a.Equal(statOutputJson, statOutputFormat)
+				a.True(isHistory)
+				a.Equal("/tmp/history.db", historyDbPath)
+			},
+		},
+		{
+			name: "explicit flags beat profile",
+			args: []string{"--config", configPath, "--profile", "vmhost", "-i", "5", "-t", "c", "--top", "3", "--format", "text"},
+			check: func() {
+				a.Equal(5, interval)
+				a.Equal("c", target)
+				a.Equal(3, top)
+				a.Equal(statOutputText, statOutputFormat)
+				// 指定していないフラグは、プロファイルの値
+				a.Equal("qemu", process)
+				a.Equal("rss", sortKey)
+			},
+		},
+		{
+			name: "no profile",
+			args: []string{},
+			check: func() {
+				a.Equal(1, interval)
+				a.Equal("", target)
+				a.Nil(statThresholds)
+				a.Equal(statOutputText, statOutputFormat)
+			},
+		},
+		{
+			// smaps_rollupの値で並べ替える場合は、--deepを有効にする
+			name: "sort requires deep",
+			args: []string{"--sort", "uss"},
+			check: func() {
+				a.Equal("uss", sortKey)
+				a.True(isProcessDeep)
+			},
+		},
+		{
+			name: "profile sort requires deep",
+			args: []string{"--profile", "pss"},
+			check: func() {
+				a.Equal("pss", sortKey)
+				a.True(isProcessDeep)
+			},
+		},
+		{
+			name: "sort without deep",
+			args: []string{"--sort", "rss"},
+			check: func() {
+				a.Equal("rss", sortKey)
+				a.False(isProcessDeep)
+			},
+		},
+		{
+			name: "sort with deep disabled",
+			args: []string{"--sort", "swap", "--deep=false"},
+			err:  "Invalid sort: sort=swap requires --deep",
+		},
+		{
+			name: "unknown profile",
+			args: []string{"--profile", "unknown"},
+			err:  "Profile Not Found: profile=unknown, available=badcollector,badfield,badfilter,pss,vmhost",
+		},
+		{
+			name: "config not found",
+			args: []string{"--config", filepath.Join(homeDir, "notfound.yaml"), "--profile", "vmhost"},
+			err:  "no such file or directory",
+		},
+		{
+			name: "invalid field",
+			args: []string{"--profile", "badfield"},
+			err:  "Invalid field: unknown",
+		},
+		{
+			name: "invalid collector",
+			args: []string{"--profile", "badcollector"},
+			err:  "Invalid collector: unknown",
+		},
+		{
+			name: "invalid filter",
+			args: []string{"--profile", "badfilter"},
+			err:  "Invalid filter: (",
+		},
+		{
+			name: "invalid format",
+			args: []string{"--format", "yaml"},
+			err:  "Invalid format: yaml",
+		},
+	}
+
+	for _, test := range tests {
+		resetStatFlags(t)
+		a.NoError(statCmd.ParseFlags(test.args), test.name)
+		err := applyStatProfile(statCmd)
+		if test.err != "" {
+			if a.Error(err, test.name) {
+				a.Contains(err.Error(), test.err, test.name)
+			}
+			continue
+		}
+		a.NoError(err, test.name)
+		test.check()
+	}
+	resetStatFlags(t)
+}
+
+func TestExceededThresholds(t *testing.T) {
+	a := assert.New(t)
+	defer func() { statThresholds = nil }()
+
+	stats := &os_utils.Stats{
+		LoadavgStat: &os_utils.LoadavgStat{
+			Load1:       6,
+			Load5:       3,
+			Load15:      1,
+			Load1PerCpu: 1.5,
+
+			SchedWaitMsPerSecPerCpu: 3,
+		},
+	}
+
+	tests := []struct {
+		name       string
+		thresholds map[string]float64
+		exceeded   []exceededThreshold
+	}{
+		{
+			name:       "no thresholds",
+			thresholds: nil,
+			exceeded:   nil,
+		},
+		{
+			name:       "exact metric",
+			thresholds: map[string]float64{"load.1m": 4, "load.1mpc": 2},
+			exceeded: []exceededThreshold{
+				{metric: "load.1m", value: 6, threshold: 4},
+			},
+		},
+		{
+			// 閾値と同じ値は超えていない
+			name:       "equal to threshold",
+			thresholds: map[string]float64{"load.5m": 3},
+			exceeded:   nil,
+		},
+		{
+			name:       "pattern",
+			thresholds: map[string]float64{"load.*pc": 0.5},
+			exceeded: []exceededThreshold{
+				{metric: "load.1mpc", value: 1.5, threshold: 0.5},
+				{metric: "load.rqwaitpc", value: 3, threshold: 0.5},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		statThresholds = test.thresholds
+		a.Equal(test.exceeded, exceededThresholds(stats), test.name)
+	}
+}
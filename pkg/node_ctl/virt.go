@@ -6,15 +6,27 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/syunkitada/goapp2/pkg/lib/file_utils"
 	"github.com/syunkitada/goapp2/pkg/lib/logger"
+	"github.com/syunkitada/goapp2/pkg/lib/runner"
 	"github.com/syunkitada/goapp2/pkg/lib/virt_utils"
 )
 
 var files []string
 var outputFormat string
 
+var hypervisorDriver string
+
 var virtCmd = &cobra.Command{
 	Use:   "virt",
 	Short: "control virt",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		conf := virt_utils.VirtControllerConfig{
+			Hypervisor: virt_utils.HypervisorConfig{
+				Driver: hypervisorDriver,
+			},
+		}
+		virtController = virt_utils.NewVirtContoller(&conf)
+		virtController.MustInit()
+	},
 }
 
 var virtController *virt_utils.VirtController
@@ -28,9 +40,12 @@ var bootstrapCmd = &cobra.Command{
 	},
 }
 
-var createCmd = &cobra.Command{
-	Use:   "create",
-	Short: "create",
+var dryRun bool
+var prune bool
+
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "apply resources in files, and show the diff",
 	Run: func(cmd *cobra.Command, args []string) {
 		var err error
 		var resources [][]byte
@@ -41,16 +56,33 @@ var createCmd = &cobra.Command{
 
 		logger.Init(&logger.Config{})
 		tctx := logger.NewTraceContext()
-		if err = virtController.Create(tctx, resources); err != nil {
+		var plan *virt_utils.ApplyPlan
+		if plan, err = virtController.PlanApply(tctx, resources, prune); err != nil {
+			fmt.Println("Failed", err.Error())
+			return
+		}
+		plan.Output()
+		if dryRun {
+			return
+		}
+		if err = virtController.Apply(tctx, plan); err != nil {
 			fmt.Println("Failed", err.Error())
 			return
 		}
 	},
 }
 
+// showHelp は、リソースのサブコマンドのみを持つコマンドで利用する
+// Runがないと未対応のリソースを指定した場合もヘルプを表示して正常終了するので、cobra.NoArgsでエラーにする
+func showHelp(cmd *cobra.Command, args []string) error {
+	return cmd.Help()
+}
+
 var getCmd = &cobra.Command{
 	Use:   "get",
 	Short: "get",
+	Args:  cobra.NoArgs,
+	RunE:  showHelp,
 }
 
 func getResource(kind string, args []string) {
@@ -69,6 +101,8 @@ func getResource(kind string, args []string) {
 var startCmd = &cobra.Command{
 	Use:   "start",
 	Short: "start",
+	Args:  cobra.NoArgs,
+	RunE:  showHelp,
 }
 
 func startResource(kind string, args []string) {
@@ -84,20 +118,80 @@ func startResource(kind string, args []string) {
 	result.Output(outputFormat)
 }
 
+var stopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "stop",
+	Args:  cobra.NoArgs,
+	RunE:  showHelp,
+}
+
+var restartCmd = &cobra.Command{
+	Use:   "restart",
+	Short: "restart",
+	Args:  cobra.NoArgs,
+	RunE:  showHelp,
+}
+
+var deleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "delete",
+	Args:  cobra.NoArgs,
+	RunE:  showHelp,
+}
+
+var serveInterval int
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "serve dhcp and dns for vms on this node",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.Init(&logger.Config{})
+		networkServiceCtl := virtController.NewNetworkServiceController(&virt_utils.NetworkServiceControllerConfig{
+			Config: runner.Config{
+				Interval:    serveInterval,
+				StopTimeout: 10,
+			},
+		})
+		networkServiceCtl.Start()
+	},
+}
+
+type ctlResourceFunc func(tctx *logger.TraceContext, kind string, args []string) (*virt_utils.GetResult, error)
+
+func ctlResource(f ctlResourceFunc, kind string, args []string) {
+	var err error
+	logger.Init(&logger.Config{})
+	tctx := logger.NewTraceContext()
+	var result *virt_utils.GetResult
+	if result, err = f(tctx, kind, args); err != nil {
+		fmt.Println("Failed", err.Error())
+		return
+	}
+
+	result.Output(outputFormat)
+}
+
 func init() {
-	createCmd.PersistentFlags().StringSliceVarP(&files, "files", "f", []string{}, "source file")
-	createCmd.MarkPersistentFlagRequired("files")
+	applyCmd.PersistentFlags().StringSliceVarP(&files, "files", "f", []string{}, "source file or directory")
+	applyCmd.MarkPersistentFlagRequired("files")
+	applyCmd.Flags().BoolVar(&dryRun, "dry-run", false, "show the diff without applying")
+	applyCmd.Flags().BoolVar(&prune, "prune", false, "delete resources that are not in the files")
 
 	resources := []string{
 		"all",
 		"vm",
 		"image",
 		"network",
+		"portforward",
 	}
 	for i := range resources {
 		resource := resources[i]
+		use := resource + " [name]..."
+		if resource == virt_utils.KindPortForward {
+			use = resource + " [vm name]..."
+		}
 		var getResourceCmd = &cobra.Command{
-			Use:   resource + " [name]...",
+			Use:   use,
 			Short: "get " + resource + " information",
 			Run: func(cmd *cobra.Command, args []string) {
 				getResource(resource, args)
@@ -106,6 +200,7 @@ func init() {
 		getCmd.AddCommand(getResourceCmd)
 	}
 
+	// start, stop, restartは、VMのみが対象
 	ctlResources := []string{
 		"vm",
 	}
@@ -119,16 +214,55 @@ func init() {
 			},
 		}
 		startCmd.AddCommand(startResourceCmd)
+
+		var stopResourceCmd = &cobra.Command{
+			Use:   resource + " [name]...",
+			Short: "stop " + resource,
+			Run: func(cmd *cobra.Command, args []string) {
+				ctlResource(virtController.Stop, resource, args)
+			},
+		}
+		stopCmd.AddCommand(stopResourceCmd)
+
+		var restartResourceCmd = &cobra.Command{
+			Use:   resource + " [name]...",
+			Short: "restart " + resource,
+			Run: func(cmd *cobra.Command, args []string) {
+				ctlResource(virtController.Restart, resource, args)
+			},
+		}
+		restartCmd.AddCommand(restartResourceCmd)
 	}
 
+	deleteResources := []string{
+		"vm",
+		"image",
+		"network",
+	}
+	for i := range deleteResources {
+		resource := deleteResources[i]
+		var deleteResourceCmd = &cobra.Command{
+			Use:   resource + " name...",
+			Short: "delete " + resource,
+			Args:  cobra.MinimumNArgs(1),
+			Run: func(cmd *cobra.Command, args []string) {
+				ctlResource(virtController.Delete, resource, args)
+			},
+		}
+		deleteCmd.AddCommand(deleteResourceCmd)
+	}
+
+	serveCmd.Flags().IntVarP(&serveInterval, "interval", "i", 10, "interval seconds to reflect vm changes")
+
 	virtCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "", "output format")
+	virtCmd.PersistentFlags().StringVar(&hypervisorDriver, "hypervisor", "", "hypervisor driver (qemu, fake)")
 	virtCmd.AddCommand(getCmd)
 	virtCmd.AddCommand(startCmd)
+	virtCmd.AddCommand(stopCmd)
+	virtCmd.AddCommand(restartCmd)
+	virtCmd.AddCommand(deleteCmd)
 	virtCmd.AddCommand(bootstrapCmd)
-	virtCmd.AddCommand(createCmd)
+	virtCmd.AddCommand(applyCmd)
+	virtCmd.AddCommand(serveCmd)
 	rootCmd.AddCommand(virtCmd)
-
-	conf := virt_utils.VirtControllerConfig{}
-	virtController = virt_utils.NewVirtContoller(&conf)
-	virtController.MustInit()
 }